@@ -6,5 +6,5 @@ import (
 )
 
 func main() {
-	initwirepod.StartFromProgramInit(stt.Init, stt.STT, stt.Name)
+	initwirepod.StartFromProgramInit(stt.Name)
 }
\ No newline at end of file
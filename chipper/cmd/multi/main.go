@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/kercre123/wire-pod/chipper/pkg/initwirepod"
+	_ "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt/houndify"
+	_ "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt/vosk"
+	_ "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt/whisper"
+)
+
+// Carries several STT engines. The one in the config (STT_SERVICE) is used, or vosk if that isn't one of them.
+// The engine can be switched from the web interface without a restart (set_stt_info with a provider).
+// Any other engine under pkg/wirepod/stt can be added with another blank import.
+
+func main() {
+	initwirepod.StartFromProgramInit("vosk")
+}
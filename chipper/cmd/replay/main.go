@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/replay"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
+	_ "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt/houndify"
+	_ "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt/vosk"
+	_ "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt/whisper"
+)
+
+// Replays a folder of recorded utterances through an STT engine (vosk by default) and the intent matcher, then prints accuracy.
+// Run it from the chipper directory, like the server itself.
+//
+//	go run cmd/replay/main.go -dir ./replay-data -engine whisper
+//
+// Any other engine under pkg/wirepod/stt can be replayed against by adding another blank import.
+// manifest.json in that folder is a list of {"file": "weather.ogg", "intent": "intent_weather_extend", "language": "en-US"}.
+// Files can be Ogg/Opus (as recorded from the robot), WAV, or raw PCM. Audio must be 16000 Hz mono 16-bit.
+
+func main() {
+	dir := flag.String("dir", ".", "folder containing the recorded utterances")
+	engine := flag.String("engine", "vosk", "STT engine to transcribe the utterances with (available: "+strings.Join(stt.Names(), ", ")+")")
+	manifest := flag.String("manifest", "", "expected-intent manifest (default: <dir>/manifest.json)")
+	esn := flag.String("esn", "00000000", "serial number to process the requests as (used for robot settings like location)")
+	jsonOut := flag.String("json", "", "also write the full report as json to this file")
+	minAccuracy := flag.Float64("min", 0, "exit with status 1 if overall accuracy (percent) is below this")
+	flag.Parse()
+
+	if _, ok := stt.Get(*engine); !ok {
+		fmt.Println("STT engine " + *engine + " isn't in this build (available: " + strings.Join(stt.Names(), ", ") + ")")
+		os.Exit(1)
+	}
+	if *manifest == "" {
+		*manifest = filepath.Join(*dir, "manifest.json")
+	}
+	logger.Init()
+	vars.Init()
+
+	entries, err := replay.LoadManifest(*manifest)
+	if err != nil {
+		fmt.Println("Error loading manifest: " + err.Error())
+		os.Exit(1)
+	}
+	report, err := replay.Run(*dir, entries, *engine, *esn)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	report.Print(os.Stdout)
+	if *jsonOut != "" {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		if err := os.WriteFile(*jsonOut, out, 0644); err != nil {
+			fmt.Println("Error writing json report: " + err.Error())
+		}
+	}
+	if report.Total.Accuracy() < *minAccuracy {
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/kercre123/wire-pod/chipper
 
-go 1.18
+go 1.21
 
 require (
 	github.com/Picovoice/leopard/binding/go/v2 v2.0.2
@@ -26,6 +26,7 @@ require (
 	github.com/soheilhy/cmux v0.1.5
 	github.com/soundhound/houndify-sdk-go v0.3.5
 	github.com/wlynxg/anet v0.0.1
+	go.etcd.io/bbolt v1.3.8
 	golang.org/x/crypto v0.16.0
 	google.golang.org/grpc v1.60.0
 	gopkg.in/ini.v1 v1.67.0
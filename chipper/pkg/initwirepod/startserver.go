@@ -20,7 +20,10 @@ import (
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
 	wpweb "github.com/kercre123/wire-pod/chipper/pkg/wirepod/config-ws"
 	wp "github.com/kercre123/wire-pod/chipper/pkg/wirepod/preqs"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/scheduler"
 	sdkWeb "github.com/kercre123/wire-pod/chipper/pkg/wirepod/sdkapp"
+	botsetup "github.com/kercre123/wire-pod/chipper/pkg/wirepod/setup"
+	ttr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/ttr"
 	"github.com/soheilhy/cmux"
 
 	//	grpclog "github.com/digital-dream-labs/hugh/grpc/interceptors/logger"
@@ -47,6 +50,7 @@ func httpServe(l net.Listener) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ok:80", serveOk)
 	mux.HandleFunc("/ok", serveOk)
+	mux.HandleFunc("/.well-known/jwks.json", tokenserver.ServeJWKS)
 	s := &http.Server{
 		Handler: mux,
 	}
@@ -58,6 +62,8 @@ func grpcServe(l net.Listener, p *wp.Server) error {
 		grpcserver.WithViper(),
 		grpcserver.WithReflectionService(),
 		grpcserver.WithInsecureSkipVerify(),
+		grpcserver.WithUnaryServerInterceptors(tokenserver.UnaryInterceptor),
+		grpcserver.WithStreamServerInterceptors(tokenserver.StreamInterceptor),
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -67,6 +73,7 @@ func grpcServe(l net.Listener, p *wp.Server) error {
 		chipperserver.WithIntentProcessor(p),
 		chipperserver.WithKnowledgeGraphProcessor(p),
 		chipperserver.WithIntentGraphProcessor(p),
+		chipperserver.WithTextProcessor(p),
 	)
 
 	tokenServer := tokenserver.NewTokenServer()
@@ -80,28 +87,33 @@ func grpcServe(l net.Listener, p *wp.Server) error {
 	return srv.Transport().Serve(l)
 }
 
-func BeginWirepodSpecific(sttInitFunc func() error, sttHandlerFunc interface{}, voiceProcessorName string) error {
+// voiceProcessorName is the STT engine to use if the config doesn't name one this build has registered
+func BeginWirepodSpecific(voiceProcessorName string) error {
 	logger.Init()
 
 	// begin wirepod stuff
 	vars.Init()
 	var err error
-	voiceProcessor, err = wp.New(sttInitFunc, sttHandlerFunc, voiceProcessorName)
-	wpweb.SttInitFunc = sttInitFunc
+	voiceProcessor, err = wp.New(voiceProcessorName)
+	wpweb.SttInitFunc = vars.SttInitFunc
 	go sdkWeb.BeginServer()
+	// the jobs only need the robots, not the STT
+	scheduler.Start(ttr.ScheduleRunner{})
 	http.HandleFunc("/api-chipper/", ChipperHTTPApi)
+	http.HandleFunc("/.well-known/jwks.json", tokenserver.ServeJWKS)
 	if err != nil {
 		return err
 	}
+	wp.WatchIntents()
 	return nil
 }
 
-func StartFromProgramInit(sttInitFunc func() error, sttHandlerFunc interface{}, voiceProcessorName string) {
+func StartFromProgramInit(voiceProcessorName string) {
 	if runtime.GOOS == "android" || runtime.GOOS == "ios" {
 		os.Setenv("DEBUG_LOGGING", "true")
 		os.Setenv("STT_SERVICE", "vosk")
 	}
-	err := BeginWirepodSpecific(sttInitFunc, sttHandlerFunc, voiceProcessorName)
+	err := BeginWirepodSpecific(voiceProcessorName)
 	if err != nil {
 		logger.Println("\033[33m\033[1mWire-pod is not setup. Use the webserver at port 8080 to set up wire-pod.\033[0m")
 	} else if !vars.APIConfig.PastInitialSetup {
@@ -141,50 +153,40 @@ func StartChipper() {
 	if vars.APIConfig.Server.EPConfig && runtime.GOOS != "android" {
 		go mdnshandler.PostmDNS()
 	}
-	var certPub []byte
-	var certPriv []byte
-	if runtime.GOOS == "android" || runtime.GOOS == "ios" {
-		if vars.APIConfig.Server.EPConfig {
+	tlsConfig := &tls.Config{}
+	if vars.APIConfig.Server.EPConfig {
+		var certPub []byte
+		var certPriv []byte
+		if runtime.GOOS == "android" || runtime.GOOS == "ios" {
 			certPub, _ = os.ReadFile(vars.AndroidPath + "/static/epod/ep.crt")
 			certPriv, _ = os.ReadFile(vars.AndroidPath + "/static/epod/ep.key")
 		} else {
-			var err error
-			certPub, _ = os.ReadFile(vars.AndroidPath + "/wire-pod/certs/cert.crt")
-			certPriv, err = os.ReadFile(vars.AndroidPath + "/wire-pod/certs/cert.key")
-			if err != nil {
-				logger.Println("wire-pod is not setup.")
-				return
-			}
-		}
-	} else {
-		if vars.APIConfig.Server.EPConfig {
 			certPub, _ = os.ReadFile("./epod/ep.crt")
 			certPriv, _ = os.ReadFile("./epod/ep.key")
-		} else {
-			var err error
-			certPub, _ = os.ReadFile("../certs/cert.crt")
-			certPriv, err = os.ReadFile("../certs/cert.key")
-			if err != nil {
-				logger.Println("wire-pod is not setup.")
-				return
-			}
 		}
+		cert, err := tls.X509KeyPair(certPub, certPriv)
+		if err != nil {
+			logger.Println(err)
+			os.Exit(1)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else {
+		// the server cert can be reissued while chipper is running (when the IP changes), so it's asked for on every connection
+		if _, err := botsetup.EnsureCerts(); err != nil {
+			logger.Println("wire-pod is not setup: " + err.Error())
+			return
+		}
+		botsetup.StartCertMonitor()
+		tlsConfig.GetCertificate = botsetup.ServerCertificate
 	}
 
 	logger.Println("Initiating TLS listener, cmux, gRPC handler, and REST handler")
-	cert, err := tls.X509KeyPair(certPub, certPriv)
-	if err != nil {
-		logger.Println(err)
-		os.Exit(1)
-	}
+	var err error
 	if runtime.GOOS == "android" && vars.APIConfig.Server.Port == "443" {
 		logger.Println("not starting chipper at port 443 because android")
 	} else {
 		logger.Println("Starting chipper server at port " + vars.APIConfig.Server.Port)
-		listenerOne, err = tls.Listen("tcp", ":"+vars.APIConfig.Server.Port, &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			CipherSuites: nil,
-		})
+		listenerOne, err = tls.Listen("tcp", ":"+vars.APIConfig.Server.Port, tlsConfig)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -198,10 +200,7 @@ func StartChipper() {
 
 	if vars.APIConfig.Server.EPConfig && os.Getenv("NO8084") != "true" {
 		logger.Println("Starting chipper server at port 8084 for 2.0.1 compatibility")
-		listenerTwo, err = tls.Listen("tcp", ":8084", &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			CipherSuites: nil,
-		})
+		listenerTwo, err = tls.Listen("tcp", ":8084", tlsConfig)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// the log file. every line is written to it as JSON, and it's rotated when it gets to maxFileSize:
+// wire-pod.log becomes wire-pod.log.1, wire-pod.log.1 becomes wire-pod.log.2, and so on up to fileBackups
+
+const (
+	maxFileSize = 10 << 20
+	fileBackups = 5
+)
+
+var fileMu sync.Mutex
+var logFile *os.File
+var logFilePath string
+var logFileSize int64
+
+// SetFile starts writing the log to path. lines logged before this aren't in it
+func SetFile(path string) error {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	logFile = file
+	logFilePath = path
+	logFileSize = info.Size()
+	return nil
+}
+
+// fileMu has to be held
+func rotateFile() error {
+	logFile.Close()
+	logFile = nil
+	for i := fileBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", logFilePath, i), fmt.Sprintf("%s.%d", logFilePath, i+1))
+	}
+	if err := os.Rename(logFilePath, logFilePath+".1"); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	logFile = file
+	logFileSize = 0
+	return nil
+}
+
+func writeToFile(entry Entry) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	if logFile == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if logFileSize+int64(len(line)) > maxFileSize {
+		if err := rotateFile(); err != nil {
+			// can't log this, it'd come straight back here
+			fmt.Println("Couldn't rotate the log file: " + err.Error())
+			return
+		}
+	}
+	n, _ := logFile.Write(line)
+	logFileSize += int64(n)
+}
@@ -1,61 +1,323 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-var debugLogging bool = true
-var LogList string
-var LogArray []string
+// wire-pod's log. everything goes through log/slog, Println and LogUI included, and ends up in:
+// the last lines in memory (for get_logs, get_debug_logs and the tray app), anyone streaming the log (stream_logs),
+// the console if it's at least consoleLevel, and the log file (SetFile) as JSON lines.
+//
+// fields for the same things should use the same keys, so the log can be filtered by them
+
+const (
+	KeyESN     = "esn"
+	KeySession = "session"
+	KeyIntent  = "intent"
+	KeySTT     = "stt"
+	// milliseconds
+	KeyLatency = "latency_ms"
+
+	uiKey = "ui"
+)
+
+// UI marks a line as one for the web interface's log, like LogUI does
+var UI = slog.Bool(uiKey, true)
+
+// Entry is a logged line
+type Entry struct {
+	Time  time.Time         `json:"time"`
+	Level string            `json:"level"`
+	Msg   string            `json:"msg"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+	// shown in the web interface's log without "show all logs"
+	UI bool `json:"ui,omitempty"`
+
+	level slog.Level
+}
+
+// the console used to only get anything with DEBUG_LOGGING=true, so Println (info) still needs it
+var consoleLevel = slog.LevelWarn
 
-var LogTrayList string
-var LogTrayArray []string
 var LogTrayChan chan string
 
+// the last lines logged, for the web interface. every goroutine logs
+type logBuffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	max     int
+}
+
+func (b *logBuffer) add(entry Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = append(b.entries, entry)
+	if len(b.entries) > b.max {
+		b.entries = b.entries[len(b.entries)-b.max:]
+	}
+}
+
+func (b *logBuffer) filter(f Filter) []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []Entry
+	for _, entry := range b.entries {
+		if f.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+var uiLogs = &logBuffer{max: 100}
+var trayLogs = &logBuffer{max: 1000}
+
+var log = slog.New(&handler{})
+
 func GetLogTrayChan() chan string {
 	return LogTrayChan
 }
 
+// Init sets the console's level from LOG_LEVEL (debug, info, warn or error), or DEBUG_LOGGING=true for debug
 func Init() {
 	LogTrayChan = make(chan string)
 	if os.Getenv("DEBUG_LOGGING") == "true" {
-		debugLogging = true
+		consoleLevel = slog.LevelDebug
 	} else {
-		debugLogging = false
+		consoleLevel = slog.LevelWarn
+	}
+	if env := os.Getenv("LOG_LEVEL"); env != "" {
+		if level, err := ParseLevel(env); err == nil {
+			consoleLevel = level
+		} else {
+			fmt.Println("LOG_LEVEL is invalid: " + err.Error())
+		}
 	}
 }
 
+// ParseLevel reads debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+// Logger is the structured logger, for With
+func Logger() *slog.Logger {
+	return log
+}
+
+// With returns a logger which adds the fields to everything it logs, like logger.With(logger.KeyESN, esn)
+func With(args ...any) *slog.Logger {
+	return log.With(args...)
+}
+
+func Debug(msg string, args ...any) {
+	log.Debug(msg, args...)
+}
+
+func Info(msg string, args ...any) {
+	log.Info(msg, args...)
+}
+
+func Warn(msg string, args ...any) {
+	log.Warn(msg, args...)
+}
+
+func Error(msg string, args ...any) {
+	log.Error(msg, args...)
+}
+
+// Println logs at info
 func Println(a ...any) {
-	LogTray(a...)
-	if debugLogging {
-		fmt.Println(a...)
-	}
+	log.Info(strings.TrimSuffix(fmt.Sprintln(a...), "\n"))
 }
 
+// LogUI logs at info, and shows the line in the web interface's log
 func LogUI(a ...any) {
-	LogArray = append(LogArray, time.Now().Format("2006.01.02 15:04:05")+": "+fmt.Sprint(a...)+"\n")
-	if len(LogArray) >= 50 {
-		LogArray = LogArray[1:]
+	log.Info(fmt.Sprint(a...), UI)
+}
+
+// LogTray logs at debug
+func LogTray(a ...any) {
+	log.Debug(fmt.Sprint(a...))
+}
+
+// LogList returns what has been logged with LogUI
+func LogList() string {
+	return Format(uiLogs.filter(Filter{}))
+}
+
+// LogTrayList returns the debug logs
+func LogTrayList() string {
+	return Format(trayLogs.filter(Filter{}))
+}
+
+// Filter picks which lines Entries returns. the zero value matches everything
+type Filter struct {
+	// only lines with this esn field
+	ESN string
+	// only lines at this level or above. nil for every level
+	Level slog.Leveler
+	// only lines for the web interface's log
+	UIOnly bool
+}
+
+func (f Filter) Match(entry Entry) bool {
+	if f.Level != nil && entry.level < f.Level.Level() {
+		return false
+	}
+	if f.UIOnly && !entry.UI {
+		return false
 	}
-	LogList = ""
-	for _, b := range LogArray {
-		LogList = LogList + b
+	if f.ESN != "" && !strings.EqualFold(entry.Attrs[KeyESN], f.ESN) {
+		return false
 	}
+	return true
 }
 
-func LogTray(a ...any) {
-	LogTrayArray = append(LogTrayArray, time.Now().Format("2006.01.02 15:04:05")+": "+fmt.Sprint(a...)+"\n")
-	if len(LogTrayArray) >= 200 {
-		LogTrayArray = LogTrayArray[1:]
+// Entries returns the last lines logged which match the filter, oldest first
+func Entries(f Filter) []Entry {
+	if f.UIOnly {
+		return uiLogs.filter(f)
+	}
+	return trayLogs.filter(f)
+}
+
+// String is how a line looks in the web interface's log and the console
+func (e Entry) String() string {
+	line := e.Time.Format("2006.01.02 15:04:05") + ": "
+	if e.level != slog.LevelInfo {
+		line += e.Level + " "
+	}
+	line += e.Msg
+	var keys []string
+	for key := range e.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		line += " " + key + "=" + e.Attrs[key]
+	}
+	return line
+}
+
+// Format puts the lines together, one per line
+func Format(entries []Entry) string {
+	var sb strings.Builder
+	for _, entry := range entries {
+		sb.WriteString(entry.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+var subsMu sync.Mutex
+var subs = make(map[chan Entry]struct{})
+
+// Subscribe gets every line logged from now on, until cancel is called. lines are dropped if the channel is full
+func Subscribe() (entries <-chan Entry, cancel func()) {
+	ch := make(chan Entry, 64)
+	subsMu.Lock()
+	subs[ch] = struct{}{}
+	subsMu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			subsMu.Lock()
+			delete(subs, ch)
+			subsMu.Unlock()
+		})
+	}
+}
+
+func record(entry Entry) {
+	trayLogs.add(entry)
+	if entry.UI {
+		uiLogs.add(entry)
 	}
-	LogTrayList = ""
-	for _, b := range LogTrayArray {
-		LogTrayList = LogTrayList + b
+	line := entry.String()
+	if entry.level >= consoleLevel {
+		fmt.Println(line)
 	}
 	select {
-	case LogTrayChan <- time.Now().Format("2006.01.02 15:04:05") + ": " + fmt.Sprint(a...) + "\n":
+	case LogTrayChan <- line + "\n":
 	default:
 	}
+	writeToFile(entry)
+	subsMu.Lock()
+	for ch := range subs {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+	subsMu.Unlock()
+}
+
+// the slog.Handler behind everything. it takes every level, record decides where each line goes
+type handler struct {
+	// from With, with the group already in their keys
+	attrs map[string]string
+	group string
+}
+
+func (h *handler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *handler) key(a slog.Attr) string {
+	if h.group == "" {
+		return a.Key
+	}
+	return h.group + "." + a.Key
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	entry := Entry{Time: r.Time, Level: r.Level.String(), Msg: r.Message, level: r.Level}
+	if len(h.attrs) > 0 || r.NumAttrs() > 0 {
+		entry.Attrs = make(map[string]string, len(h.attrs)+r.NumAttrs())
+	}
+	for key, value := range h.attrs {
+		entry.Attrs[key] = value
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == uiKey && h.group == "" && a.Value.Kind() == slog.KindBool {
+			entry.UI = a.Value.Bool()
+		} else {
+			entry.Attrs[h.key(a)] = a.Value.Resolve().String()
+		}
+		return true
+	})
+	if _, ok := h.attrs[uiKey]; ok {
+		entry.UI = true
+		delete(entry.Attrs, uiKey)
+	}
+	record(entry)
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	newAttrs := make(map[string]string, len(h.attrs)+len(attrs))
+	for key, value := range h.attrs {
+		newAttrs[key] = value
+	}
+	for _, a := range attrs {
+		newAttrs[h.key(a)] = a.Value.Resolve().String()
+	}
+	return &handler{attrs: newAttrs, group: h.group}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &handler{attrs: h.attrs, group: h.key(slog.Attr{Key: name})}
 }
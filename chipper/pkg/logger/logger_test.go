@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// run with -race. every robot's request logs from its own goroutine while the web interface reads the logs
+
+func TestLoggingFromManyGoroutines(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				Println("Bot 00e20000 Intent Sent: intent_system_noaudio")
+				LogUI("New bot being associated with wire-pod")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				LogList()
+				LogTrayList()
+			}
+		}()
+	}
+	wg.Wait()
+	if lines := strings.Count(LogTrayList(), "\n"); lines != trayLogs.max {
+		t.Errorf("%d debug log lines, want %d", lines, trayLogs.max)
+	}
+	if lines := strings.Count(LogList(), "\n"); lines != uiLogs.max {
+		t.Errorf("%d UI log lines, want %d", lines, uiLogs.max)
+	}
+}
+
+func TestFieldsFiltersAndFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wire-pod.log")
+	if err := SetFile(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		fileMu.Lock()
+		logFile.Close()
+		logFile = nil
+		fileMu.Unlock()
+	}()
+	entries, cancel := Subscribe()
+	defer cancel()
+
+	robot := With(KeyESN, "00e40001", KeySession, "abc")
+	robot.Debug("Intent sent", KeyIntent, "intent_weather_extend")
+	robot.Error("LLM error", UI, "error", "timeout")
+	Info("Request served", KeyESN, "00e40002", KeyLatency, 120)
+
+	select {
+	case entry := <-entries:
+		if entry.Msg != "Intent sent" || entry.Attrs[KeySession] != "abc" {
+			t.Errorf("streamed %+v first", entry)
+		}
+	default:
+		t.Error("nothing was streamed")
+	}
+
+	robotLines := Entries(Filter{ESN: "00E40001"})
+	if len(robotLines) < 2 || robotLines[len(robotLines)-1].Msg != "LLM error" {
+		t.Errorf("robot's lines: %v", robotLines)
+	}
+	for _, entry := range robotLines {
+		if entry.Attrs[KeyESN] != "00e40001" {
+			t.Errorf("%+v isn't for 00e40001", entry)
+		}
+	}
+	errors := Entries(Filter{Level: slog.LevelError})
+	if len(errors) == 0 || errors[len(errors)-1].Attrs["error"] != "timeout" {
+		t.Errorf("errors: %v", errors)
+	}
+	if ui := Entries(Filter{UIOnly: true}); len(ui) == 0 || ui[len(ui)-1].Msg != "LLM error" {
+		t.Errorf("UI lines: %v", ui)
+	}
+	if !strings.Contains(LogList(), "LLM error error=timeout esn=00e40001 session=abc") {
+		t.Errorf("LogList doesn't have the LLM error with its fields:\n%s", LogList())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d lines in the log file, want 3", len(lines))
+	}
+	var served Entry
+	if err := json.Unmarshal([]byte(lines[2]), &served); err != nil {
+		t.Fatal(err)
+	}
+	if served.Level != "INFO" || served.Attrs[KeyLatency] != "120" {
+		t.Errorf("log file has %+v", served)
+	}
+
+	// rotation
+	fileMu.Lock()
+	logFileSize = maxFileSize
+	fileMu.Unlock()
+	Info("After rotating")
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("log wasn't rotated: %v", err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "After rotating") || strings.Contains(string(data), "Request served") {
+		t.Errorf("new log file has %s", data)
+	}
+}
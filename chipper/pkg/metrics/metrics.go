@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counters and histograms, served at /metrics in Prometheus' text format so a dashboard can scrape every wire-pod.
+// it's only what wire-pod needs, so it doesn't pull in the whole Prometheus client
+
+// DefaultBuckets are in seconds, from a VAD check to a long LLM answer
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var registryMu sync.Mutex
+var registry = make(map[string]collector)
+
+func register(name string, c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic("metrics: " + name + " registered twice")
+	}
+	registry[name] = c
+}
+
+// one set of label values
+type series struct {
+	values []string
+	// counters only use value
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+type metric struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (m *metric) key(values []string) string {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", m.name, len(m.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (m *metric) get(values []string) *series {
+	key := m.key(values)
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		m.series[key] = s
+	}
+	return s
+}
+
+// the series for these values, without adding one. nil if nothing has been counted for them
+func (m *metric) lookup(values []string) *series {
+	return m.series[m.key(values)]
+}
+
+// sorted, so the output doesn't jump around between scrapes
+func (m *metric) sorted() []*series {
+	list := make([]*series, 0, len(m.series))
+	for _, s := range m.series {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].values, "\xff") < strings.Join(list[j].values, "\xff")
+	})
+	return list
+}
+
+func (m *metric) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, kind)
+}
+
+func (m *metric) labelString(values []string, extra ...string) string {
+	var pairs []string
+	for i, label := range m.labels {
+		pairs = append(pairs, label+`="`+escape(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter only goes up
+type Counter struct {
+	metric
+}
+
+// NewCounter registers a counter. values for the labels are given, in the same order, to Inc
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{metric{name: name, help: help, labels: labels, series: make(map[string]*series)}}
+	register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(n float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(values).value += n
+}
+
+// Value is what the counter is at for these label values
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s := c.lookup(values); s != nil {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, "counter")
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(s.values), formatFloat(s.value))
+	}
+}
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	metric
+	bounds []float64
+}
+
+// NewHistogram registers a histogram with buckets' upper bounds (sorted, +Inf is added)
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		metric: metric{name: name, help: help, labels: labels, series: make(map[string]*series)},
+		bounds: append([]float64{}, buckets...),
+	}
+	sort.Float64s(h.bounds)
+	register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(values)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+	for i, bound := range h.bounds {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// ObserveDuration observes d in seconds
+func (h *Histogram) ObserveDuration(d time.Duration, values ...string) {
+	h.Observe(d.Seconds(), values...)
+}
+
+// Count is how many observations there have been for these label values
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s := h.lookup(values); s != nil {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, "histogram")
+	for _, s := range h.sorted() {
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s.values, "le", formatFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(s.values), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(s.values), s.count)
+	}
+}
+
+// Write writes every metric, sorted by name
+func Write(w io.Writer) {
+	registryMu.Lock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, registry[name])
+	}
+	registryMu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves /metrics
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	Write(w)
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistogramText(t *testing.T) {
+	h := NewHistogram("test_stage_seconds", "Test stages.", []float64{0.1, 1}, "stage", "robot")
+	h.Observe(0.05, "stt", "00e20000")
+	h.Observe(0.5, "stt", "00e20000")
+	h.ObserveDuration(2*time.Second, "stt", "00e20000")
+	h.Observe(0.05, "tts", `a"b\c`)
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	want := `# HELP test_stage_seconds Test stages.
+# TYPE test_stage_seconds histogram
+test_stage_seconds_bucket{stage="stt",robot="00e20000",le="0.1"} 1
+test_stage_seconds_bucket{stage="stt",robot="00e20000",le="1"} 2
+test_stage_seconds_bucket{stage="stt",robot="00e20000",le="+Inf"} 3
+test_stage_seconds_sum{stage="stt",robot="00e20000"} 2.55
+test_stage_seconds_count{stage="stt",robot="00e20000"} 3
+test_stage_seconds_bucket{stage="tts",robot="a\"b\\c",le="0.1"} 1
+test_stage_seconds_bucket{stage="tts",robot="a\"b\\c",le="1"} 1
+test_stage_seconds_bucket{stage="tts",robot="a\"b\\c",le="+Inf"} 1
+test_stage_seconds_sum{stage="tts",robot="a\"b\\c"} 0.05
+test_stage_seconds_count{stage="tts",robot="a\"b\\c"} 1
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	if n := h.Count("stt", "00e20000"); n != 3 {
+		t.Errorf("count %d, want 3", n)
+	}
+	if n := h.Count("stt", "nobody"); n != 0 {
+		t.Errorf("count for an unseen robot %d, want 0", n)
+	}
+}
+
+// run with -race. every robot's request records from its own goroutine while /metrics is scraped
+func TestCounterFromManyGoroutines(t *testing.T) {
+	c := NewCounter("test_requests_total", "Test requests.", "engine", "robot")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				c.Inc("openai", "00e20000")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 10; n++ {
+				Write(&bytes.Buffer{})
+			}
+		}()
+	}
+	wg.Wait()
+	if v := c.Value("openai", "00e20000"); v != 800 {
+		t.Errorf("counter is %v, want 800", v)
+	}
+	var buf bytes.Buffer
+	Write(&buf)
+	if !strings.Contains(buf.String(), "# TYPE test_requests_total counter\ntest_requests_total{engine=\"openai\",robot=\"00e20000\"} 800\n") {
+		t.Errorf("counter missing from the output:\n%s", buf.String())
+	}
+}
+
+func TestObserveStageSkipsStagesWhichDidntHappen(t *testing.T) {
+	ObserveStage(StageAudio, "vosk", "", "00e20001", 0)
+	ObserveStage(StageSTT, "vosk", "", "00e20001", 300*time.Millisecond)
+	if n := StageDuration.Count(StageAudio, "vosk", "", "00e20001"); n != 0 {
+		t.Errorf("audio stage observed %d times, want 0", n)
+	}
+	if n := StageDuration.Count(StageSTT, "vosk", "", "00e20001"); n != 1 {
+		t.Errorf("stt stage observed %d times, want 1", n)
+	}
+}
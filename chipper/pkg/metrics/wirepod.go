@@ -0,0 +1,47 @@
+package metrics
+
+import "time"
+
+// what wire-pod measures. robot is the ESN, engine is whatever did the stage (the STT engine, the LLM provider or the voice),
+// intent is only set for the stages which know it
+
+const (
+	// from the robot starting to send audio until the end of speech was detected
+	StageAudio = "audio"
+	// time spent in DetectEndOfSpeech
+	StageEndOfSpeech = "end_of_speech"
+	// from the end of speech until the engine returned text (or an intent)
+	StageSTT = "stt"
+	// ProcessTextAll, including sending the intent
+	StageIntent = "intent_match"
+	// from the LLM request until its first token
+	StageLLMFirstToken = "llm_first_token"
+	// the robot saying something
+	StageTTS = "tts"
+	// the whole request, from the robot starting to send audio
+	StageTotal = "total"
+)
+
+var StageDuration = NewHistogram("wirepod_stage_duration_seconds",
+	"How long each stage of a request took.",
+	DefaultBuckets, "stage", "engine", "intent", "robot")
+
+var Intents = NewCounter("wirepod_intents_total",
+	"Intents sent to robots.",
+	"intent", "robot")
+
+var LLMRequests = NewCounter("wirepod_llm_requests_total",
+	"Requests made to the LLM.",
+	"engine", "robot")
+
+var LLMErrors = NewCounter("wirepod_llm_errors_total",
+	"LLM requests which failed, by why.",
+	"engine", "robot", "reason")
+
+// ObserveStage records how long a stage took. nothing is recorded for a zero or negative duration, which means the stage didn't happen
+func ObserveStage(stage, engine, intent, robot string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	StageDuration.ObserveDuration(d, stage, engine, intent, robot)
+}
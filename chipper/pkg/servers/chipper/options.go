@@ -7,6 +7,7 @@ type options struct {
 	intent      intentProcessor
 	kg          kgProcessor
 	intentGraph intentGraphProcessor
+	text        textProcessor
 }
 
 // Option is the list of options
@@ -39,3 +40,10 @@ func WithIntentGraphProcessor(s intentGraphProcessor) Option {
 		o.intentGraph = s
 	}
 }
+
+// WithTextProcessor sets the text intent processor
+func WithTextProcessor(s textProcessor) Option {
+	return func(o *options) {
+		o.text = s
+	}
+}
@@ -17,11 +17,16 @@ type intentGraphProcessor interface {
 	ProcessIntentGraph(*vtt.IntentGraphRequest) (*vtt.IntentGraphResponse, error)
 }
 
+type textProcessor interface {
+	ProcessTextIntent(*vtt.TextRequest) (*vtt.TextResponse, error)
+}
+
 // Server defines the service used.
 type Server struct {
 	intent      intentProcessor
 	kg          kgProcessor
 	intentGraph intentGraphProcessor
+	text        textProcessor
 
 	pb.UnimplementedChipperGrpcServer
 }
@@ -40,6 +45,7 @@ func New(opts ...Option) (*Server, error) {
 		intent:      cfg.intent,
 		kg:          cfg.kg,
 		intentGraph: cfg.intentGraph,
+		text:        cfg.text,
 	}
 
 	return &s, nil
@@ -2,13 +2,41 @@ package server
 
 import (
 	"context"
+	"time"
 
 	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 // TextIntent handles text-based request/responses from the device
 func (s *Server) TextIntent(ctx context.Context, req *pb.TextRequest) (*pb.IntentResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "")
+	if s.text == nil {
+		return nil, status.Errorf(codes.Unimplemented, "")
+	}
+	recvTime := time.Now()
+
+	resp, err := s.text.ProcessTextIntent(
+		&vtt.TextRequest{
+			Time:       recvTime,
+			Device:     req.DeviceId,
+			Session:    req.Session,
+			LangString: req.LanguageCode.String(),
+			Text:       req.TextInput,
+			FirstReq:   req,
+			Mode:       req.Mode,
+		},
+	)
+	if err != nil {
+		logger.Println("Text intent error")
+		logger.Println(err)
+		return nil, err
+	}
+	if resp == nil || resp.Intent == nil {
+		return nil, status.Errorf(codes.Internal, "no intent response")
+	}
+
+	return resp.Intent, nil
 }
@@ -3,7 +3,6 @@ package jdocsserver
 import (
 	"context"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
 	"io"
 	"net/http"
@@ -17,18 +16,16 @@ import (
 )
 
 func IsBotInInfo(esn string) bool {
-	for _, robot := range vars.BotInfo.Robots {
-		if esn == strings.TrimSpace(strings.ToLower(robot.Esn)) {
-			return true
-		}
-	}
-	return false
+	_, ok := vars.BotInfo.Get(esn)
+	return ok
 }
 
 // This function write a bot name, esn, guid, and target to sdk_config.ini
 // Should only be used for primary auth
 // IP should just be "xxx.xxx.xxx.xxx", no port
 func WriteToIniPrimary(botName, esn, guid, ip string) {
+	vars.SDKIniMu.Lock()
+	defer vars.SDKIniMu.Unlock()
 	userIniData, err := ini.Load(vars.SDKIniPath + "sdk_config.ini")
 	if err != nil {
 		logger.Println("Creating " + vars.SDKIniPath + " directory")
@@ -67,6 +64,8 @@ func WriteToIniSecondary(esn, guid, ip string) {
 	certPath := ""
 	botName := ""
 	certExists := false
+	vars.SDKIniMu.Lock()
+	defer vars.SDKIniMu.Unlock()
 	userIniData, err := ini.Load(vars.SDKIniPath + "sdk_config.ini")
 	if err != nil {
 		logger.Println("Creating " + vars.SDKIniPath + " directory")
@@ -128,26 +127,14 @@ func WriteToIniSecondary(esn, guid, ip string) {
 }
 
 func StoreBotInfo(ctx context.Context, thing string) {
-	var appendNew bool = true
 	p, _ := peer.FromContext(ctx)
 	ipAddr := strings.TrimSpace(strings.Split(p.Addr.String(), ":")[0])
 	botEsn := strings.TrimSpace(strings.Split(thing, ":")[1])
-	vars.BotInfo.GlobalGUID = "tni1TRsTRTaNSapjo0Y+Sw=="
-	for num, robot := range vars.BotInfo.Robots {
-		if robot.Esn == botEsn {
-			appendNew = false
-			vars.BotInfo.Robots[num].IPAddress = ipAddr
-		}
-	}
-	if appendNew {
+	vars.BotInfo.SetGlobalGUID("tni1TRsTRTaNSapjo0Y+Sw==")
+	if vars.BotInfo.Add(vars.RobotInfo{Esn: botEsn, IPAddress: ipAddr, GUID: "", Activated: false}) {
 		logger.Println("Adding " + botEsn + " to bot info store")
-		vars.BotInfo.Robots = append(vars.BotInfo.Robots, struct {
-			Esn       string `json:"esn"`
-			IPAddress string `json:"ip_address"`
-			GUID      string `json:"guid"`
-			Activated bool   `json:"activated"`
-		}{Esn: botEsn, IPAddress: ipAddr, GUID: "", Activated: false})
+	} else {
+		vars.BotInfo.SetIP(botEsn, ipAddr)
 	}
-	finalJsonBytes, _ := json.Marshal(vars.BotInfo)
-	os.WriteFile(vars.BotInfoPath, finalJsonBytes, 0644)
+	vars.SaveBotInfo()
 }
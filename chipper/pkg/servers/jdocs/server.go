@@ -2,7 +2,6 @@ package jdocsserver
 
 import (
 	"context"
-	"encoding/json"
 	"os"
 	"strings"
 	"path/filepath"
@@ -26,19 +25,14 @@ func (s *JdocServer) WriteDoc(ctx context.Context, req *jdocspb.WriteDocReq) (*j
 	ajdoc.FmtVersion = req.Doc.FmtVersion
 	ajdoc.JsonDoc = req.Doc.JsonDoc
 	latestVersion := vars.AddJdoc(req.Thing, req.DocName, ajdoc)
-	vars.WriteJdocs()
 
 	esn := strings.Split(req.Thing, ":")[1]
 	p, _ := peer.FromContext(ctx)
 	ipAddr := strings.Split(p.Addr.String(), ":")[0]
 
-	for ind, bot := range vars.BotInfo.Robots {
-		if bot.Esn == esn && bot.IPAddress != ipAddr {
-			logger.Println(esn + "'s IP address has changed to " + ipAddr + ", noting")
-			vars.BotInfo.Robots[ind].IPAddress = ipAddr
-			writeBytes, _ := json.Marshal(vars.BotInfo)
-			os.WriteFile(vars.BotInfoPath, writeBytes, 0644)
-		}
+	if vars.BotInfo.SetIP(esn, ipAddr) {
+		logger.Println(esn + "'s IP address has changed to " + ipAddr + ", noting")
+		vars.SaveBotInfo()
 	}
 
 	return &jdocspb.WriteDocResp{
@@ -58,20 +52,13 @@ func (s *JdocServer) ReadDocs(ctx context.Context, req *jdocspb.ReadDocsReq) (*j
 	p, _ := peer.FromContext(ctx)
 	ipAddr := strings.Split(p.Addr.String(), ":")[0]
 
-	for ind, bot := range vars.BotInfo.Robots {
-		if bot.Esn == esn && bot.IPAddress != ipAddr {
-			logger.Println(esn + "'s IP address has changed to " + ipAddr + ", noting")
-			vars.BotInfo.Robots[ind].IPAddress = ipAddr
-			writeBytes, _ := json.Marshal(vars.BotInfo)
-			os.WriteFile(vars.BotInfoPath, writeBytes, 0644)
-		}
+	if vars.BotInfo.SetIP(esn, ipAddr) {
+		logger.Println(esn + "'s IP address has changed to " + ipAddr + ", noting")
+		vars.SaveBotInfo()
 	}
 
-	for _, pair := range tokenserver.SessionWriteStoreNames {
-		if ipAddr == strings.Split(pair[0], ":")[0] {
-			vars.DeleteData(req.Thing)
-			break
-		}
+	if tokenserver.HasSessionCert(ipAddr) {
+		vars.DeleteData(req.Thing)
 	}
 	if strings.Contains(req.Items[0].DocName, "vic.AppTokens") {
 		StoreBotInfo(ctx, req.Thing)
@@ -80,51 +67,43 @@ func (s *JdocServer) ReadDocs(ctx context.Context, req *jdocspb.ReadDocsReq) (*j
 			logger.Println("App tokens jdoc not found for this bot, trying bots in TokenHashStore")
 			matched := false
 			botGUID := ""
-			for num, pair := range tokenserver.TokenHashStore {
-				if strings.EqualFold(pair[0], ipAddr) {
-					err := tokenserver.WriteTokenHash(strings.ToLower(strings.TrimSpace(esn)), pair[2])
-					if err != nil {
-						logger.Println("Error writing token hash to vic.AppTokens")
-						logger.Println(err)
-					}
-					err = tokenserver.SetBotGUID(esn, pair[1], pair[2])
-					botGUID = pair[1]
-					if err != nil {
-						logger.Println("Error writing token hash to " + vars.BotInfoPath)
-						logger.Println(err)
-					}
-					logger.Println("ReadJdocs: bot " + esn + " matched with IP " + ipAddr + " in token store")
-					matched = true
-					tokenserver.RemoveFromPrimaryStore(num)
+			for _, pending := range tokenserver.TakePrimaryTokens(ipAddr) {
+				err := tokenserver.WriteTokenHash(strings.ToLower(strings.TrimSpace(esn)), pending.Hash)
+				if err != nil {
+					logger.Println("Error writing token hash to vic.AppTokens")
+					logger.Println(err)
+				}
+				err = tokenserver.SetBotGUID(esn, pending.GUID, pending.Hash)
+				botGUID = pending.GUID
+				if err != nil {
+					logger.Println("Error writing token hash to bot info")
+					logger.Println(err)
 				}
+				logger.Println("ReadJdocs: bot " + esn + " matched with IP " + ipAddr + " in token store")
+				matched = true
 			}
-			sessionMatched := false
-			for num, pair := range tokenserver.SessionWriteStoreNames {
-				if strings.EqualFold(ipAddr, strings.Split(pair[0], ":")[0]) {
-					sessionMatched = true
-					fullPath := filepath.Join(vars.SDKIniPath, pair[1] + "-" + esn + ".cert")
-					if _, err := os.Stat(vars.SDKIniPath); err != nil {
-						logger.Println("Creating " + vars.SDKIniPath + " directory")
-						os.Mkdir(vars.SDKIniPath, 0755)
-					}
-					logger.Println("Outputting session cert to " + fullPath)
-					// export to ~/.anki_vector
-					os.WriteFile(fullPath, tokenserver.SessionWriteStoreCerts[num], 0755)
-					// export to ./session-certs
-					os.WriteFile(vars.SessionCertPath+"/"+esn, tokenserver.SessionWriteStoreCerts[num], 0755)
-					WriteToIniPrimary(pair[1], esn, botGUID, ipAddr)
-					vars.AddToRInfo(esn, pair[1], ipAddr)
-					tokenserver.RemoveFromSessionStore(num)
-					logger.Println("Session certificate successfully output")
-					break
+			session, sessionMatched := tokenserver.TakeSessionCert(ipAddr)
+			if sessionMatched {
+				fullPath := filepath.Join(vars.SDKIniPath, session.Name+"-"+esn+".cert")
+				if _, err := os.Stat(vars.SDKIniPath); err != nil {
+					logger.Println("Creating " + vars.SDKIniPath + " directory")
+					os.Mkdir(vars.SDKIniPath, 0755)
 				}
+				logger.Println("Outputting session cert to " + fullPath)
+				// export to ~/.anki_vector
+				os.WriteFile(fullPath, session.Cert, 0755)
+				// export to ./session-certs
+				os.WriteFile(vars.SessionCertPath+"/"+esn, session.Cert, 0755)
+				WriteToIniPrimary(session.Name, esn, botGUID, ipAddr)
+				vars.AddToRInfo(esn, session.Name, ipAddr)
+				logger.Println("Session certificate successfully output")
 			}
 			logger.LogUI("New bot being associated with wire-pod. ESN: " + esn + ", IP: " + ipAddr)
 			if !matched {
 				if !isAlreadyKnown {
 					logger.Println("Bot was not known to wire-pod, creating token and hash (in ReadDocs)")
 					guid, hash, _ := tokenserver.CreateTokenAndHashedToken()
-					tokenserver.SecondaryTokenStore = append(tokenserver.SecondaryTokenStore, [4]string{esn, ipAddr, guid, hash})
+					tokenserver.AddSecondaryToken(tokenserver.SecondaryToken{ESN: esn, Target: ipAddr, GUID: guid, Hash: hash})
 					// creates apptoken jdoc file
 					tokenserver.WriteTokenHash(esn, hash)
 					if !sessionMatched {
@@ -137,7 +116,7 @@ func (s *JdocServer) ReadDocs(ctx context.Context, req *jdocspb.ReadDocsReq) (*j
 					truejdoc.DocVersion = tokenJdoc.DocVersion
 					truejdoc.FmtVersion = tokenJdoc.FmtVersion
 					truejdoc.JsonDoc = tokenJdoc.JsonDoc
-					tokenserver.RemoveFromSecondStore(len(tokenserver.SecondaryTokenStore) - 1)
+					tokenserver.TakeSecondaryToken(esn)
 					return &jdocspb.ReadDocsResp{
 						Items: []*jdocspb.ReadDocsResp_Item{
 							{
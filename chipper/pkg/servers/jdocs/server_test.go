@@ -0,0 +1,160 @@
+package jdocsserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/api/go/jdocspb"
+	"github.com/digital-dream-labs/api/go/tokenpb"
+	tokenserver "github.com/kercre123/wire-pod/chipper/pkg/servers/token"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"google.golang.org/grpc/peer"
+)
+
+// run with -race. every robot goes through the whole first-time authentication at once:
+// AssociatePrimaryUser, then ReadDocs for vic.AppTokens, then a token refresh and a jdoc write
+
+func sessionCert(t *testing.T, name string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func robotContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000},
+	})
+}
+
+func TestRobotsAuthenticatingAtOnce(t *testing.T) {
+	dir := t.TempDir()
+	vars.SDKIniPath = dir + "/"
+	vars.SessionCertPath = filepath.Join(dir, "session-certs")
+	vars.JWTKeyPath = filepath.Join(dir, "jwt.key")
+	if err := os.Mkdir(vars.SessionCertPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	vars.DB = vars.NewMemoryStore()
+
+	tokens := tokenserver.NewTokenServer()
+	jdocs := NewJdocsServer()
+
+	const count = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			esn := fmt.Sprintf("00e2%04x", i)
+			name := fmt.Sprintf("Vector-T%03d", i)
+			ctx := robotContext(fmt.Sprintf("10.0.0.%d", i+1))
+			if _, err := tokens.AssociatePrimaryUser(ctx, &tokenpb.AssociatePrimaryUserRequest{
+				SessionCertificate: sessionCert(t, name),
+			}); err != nil {
+				errs <- err
+				return
+			}
+			resp, err := jdocs.ReadDocs(ctx, &jdocspb.ReadDocsReq{
+				Thing: "vic:" + esn,
+				Items: []*jdocspb.ReadDocsReq_Item{{DocName: "vic.AppTokens"}},
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if len(resp.Items) != 1 || !strings.Contains(resp.Items[0].Doc.JsonDoc, "client_tokens") {
+				errs <- fmt.Errorf("%s: no app tokens jdoc in %v", esn, resp.Items)
+				return
+			}
+			if _, err := tokens.RefreshToken(ctx, &tokenpb.RefreshTokenRequest{}); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := jdocs.WriteDoc(ctx, &jdocspb.WriteDocReq{
+				Thing:   "vic:" + esn,
+				DocName: "vic.RobotSettings",
+				Doc:     &jdocspb.Jdoc{DocVersion: 1, FmtVersion: 1, JsonDoc: "{}"},
+			}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if robots := vars.BotInfo.Robots(); len(robots) != count {
+		t.Fatalf("%d robots in bot info, want %d", len(robots), count)
+	}
+	ids := make(map[string]string)
+	for _, rinfo := range vars.GetRInfo() {
+		ids[rinfo.ESN] = rinfo.ID
+	}
+	ini, err := os.ReadFile(vars.SDKIniPath + "sdk_config.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		esn := fmt.Sprintf("00e2%04x", i)
+		robot, ok := vars.BotInfo.Get(esn)
+		if !ok {
+			t.Errorf("%s isn't in bot info", esn)
+			continue
+		}
+		if !robot.Activated || robot.GUID == "" {
+			t.Errorf("%s wasn't activated: %+v", esn, robot)
+		}
+		if robot.IPAddress != fmt.Sprintf("10.0.0.%d", i+1) {
+			t.Errorf("%s has IP %s", esn, robot.IPAddress)
+		}
+		if _, ok := vars.GetJdoc("vic:"+esn, "vic.RobotSettings"); !ok {
+			t.Errorf("%s's settings jdoc wasn't saved", esn)
+		}
+		if _, err := os.Stat(filepath.Join(vars.SessionCertPath, esn)); err != nil {
+			t.Errorf("%s's session cert wasn't written: %v", esn, err)
+		}
+		if want := fmt.Sprintf("Vector-T%03d", i); ids[esn] != want {
+			t.Errorf("%s has ID %q, want %q", esn, ids[esn], want)
+		}
+		if !strings.Contains(string(ini), "["+esn+"]") {
+			t.Errorf("%s isn't in sdk_config.ini", esn)
+		}
+		// everything waiting for the robot should have been used up
+		if left := tokenserver.TakePrimaryTokens(robot.IPAddress); len(left) > 0 {
+			t.Errorf("%s left %d primary tokens behind", esn, len(left))
+		}
+		if tokenserver.HasSessionCert(robot.IPAddress) {
+			t.Errorf("%s left its session cert behind", esn)
+		}
+	}
+}
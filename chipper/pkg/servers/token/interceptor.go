@@ -0,0 +1,224 @@
+package tokenserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// checks the token robots send with their gRPC requests (vic-cloud puts it in anki-access-token).
+// a valid token tells us which robot is asking, so GetEsn doesn't have to guess from the IP.
+// tokens are only required if APIConfig.Server.RequireRobotTokens is on, because robots set up before wire-pod signed its tokens
+// have ones nothing can verify. they get a signed one the next time they refresh (every 21 hours).
+
+// robots don't have a token yet when they call these. a valid token still tells us the ESN
+var noTokenMethods = map[string]bool{
+	"/tokenpb.Token/AssociatePrimaryUser":     true,
+	"/tokenpb.Token/AssociateSecondaryClient": true,
+	"/tokenpb.Token/ReassociatePrimaryUser":   true,
+}
+
+// robots call this with an expired token if they've been off for a while, or with one from before wire-pod signed them,
+// which is how they get a signed one. it still needs a robot token, but its signature isn't checked
+const refreshMethod = "/tokenpb.Token/RefreshToken"
+
+// a robot's first token is made before wire-pod knows its ESN (see CreateJWT), and the first thing the robot
+// does with it is read its own jdocs. so a token without an ESN belongs to the robot whose jdocs it reads first,
+// and can't be used for anything else until it has
+const readDocsMethod = "/jdocspb.Jdocs/ReadDocs"
+
+type boundToken struct {
+	esn     string
+	expires time.Time
+}
+
+var (
+	boundTokensMu sync.Mutex
+	// token ID -> the ESN the token was bound to
+	boundTokens = make(map[string]boundToken)
+)
+
+func boundESN(claims *RobotClaims) (string, bool) {
+	boundTokensMu.Lock()
+	defer boundTokensMu.Unlock()
+	bound, ok := boundTokens[claims.TokenID]
+	return bound.esn, ok
+}
+
+// binds a token to the robot whose jdocs it read, unless it already was
+func bindToken(claims *RobotClaims, esn string) string {
+	boundTokensMu.Lock()
+	defer boundTokensMu.Unlock()
+	if bound, ok := boundTokens[claims.TokenID]; ok {
+		return bound.esn
+	}
+	for id, bound := range boundTokens {
+		if time.Now().After(bound.expires) {
+			delete(boundTokens, id)
+		}
+	}
+	boundTokens[claims.TokenID] = boundToken{esn: esn, expires: claims.ExpiresAt}
+	logger.Println("Token " + claims.TokenID + " is for " + esn)
+	return esn
+}
+
+type esnKey struct{}
+
+// a token without an ESN reading jdocs for the first time
+type unboundKey struct{}
+
+func tokenFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get("anki-access-token"); len(values) > 0 {
+		return values[0]
+	}
+	if values := md.Get("authorization"); len(values) > 0 {
+		return strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+	}
+	return ""
+}
+
+func authorize(ctx context.Context, method string) (context.Context, error) {
+	if strings.HasPrefix(method, "/grpc.reflection.") {
+		return ctx, nil
+	}
+	required := vars.RobotTokensRequired() && !noTokenMethods[method]
+	tokenString := tokenFromContext(ctx)
+	if tokenString == "" {
+		if required {
+			return nil, status.Error(codes.Unauthenticated, "no token")
+		}
+		return ctx, nil
+	}
+	claims, err := ParseRobotToken(tokenString)
+	if err == ErrTokenExpired && method == refreshMethod {
+		err = nil
+	}
+	if err != nil && method == refreshMethod && isRobotToken(tokenString) {
+		// nothing in it can be trusted, so the robot is found by its IP
+		return ctx, nil
+	}
+	if err != nil {
+		if !required {
+			return ctx, nil
+		}
+		logger.Println("Rejecting " + method + " from " + peerIP(ctx) + ": " + err.Error())
+		if err == ErrTokenExpired {
+			// vic-cloud refreshes its token when it gets this
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if esn := claims.ESN(); esn != "" {
+		return context.WithValue(ctx, esnKey{}, esn), nil
+	}
+	if esn, ok := boundESN(claims); ok {
+		return context.WithValue(ctx, esnKey{}, esn), nil
+	}
+	if method == readDocsMethod {
+		return context.WithValue(ctx, unboundKey{}, claims), nil
+	}
+	if required && method != refreshMethod {
+		logger.Println("Rejecting " + method + " from " + peerIP(ctx) + ": its token isn't for a robot yet")
+		return nil, status.Error(codes.PermissionDenied, "token isn't for a robot")
+	}
+	return ctx, nil
+}
+
+// whether a token looks like one wire-pod (or Anki) gave a robot, without checking its signature
+func isRobotToken(tokenString string) bool {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return false
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	requestor, _ := claims["requestor_id"].(string)
+	return strings.HasPrefix(requestor, "vic:")
+}
+
+// a robot's token has to be for the robot its jdocs belong to
+func checkThing(ctx context.Context, req interface{}) (context.Context, error) {
+	thingReq, ok := req.(interface{ GetThing() string })
+	if !ok {
+		return ctx, nil
+	}
+	thing := strings.ToLower(thingReq.GetThing())
+	esn, ok := ctx.Value(esnKey{}).(string)
+	if claims, unbound := ctx.Value(unboundKey{}).(*RobotClaims); unbound && strings.HasPrefix(thing, "vic:") {
+		esn = bindToken(claims, strings.TrimPrefix(thing, "vic:"))
+		ctx = context.WithValue(ctx, esnKey{}, esn)
+		ok = true
+	}
+	if !vars.RobotTokensRequired() {
+		return ctx, nil
+	}
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "token isn't for a robot")
+	}
+	if thing != "vic:"+esn {
+		return nil, status.Error(codes.PermissionDenied, "token is for a different robot")
+	}
+	return ctx, nil
+}
+
+func UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = checkThing(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func StreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: stream, ctx: ctx})
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(strings.Split(p.Addr.String(), ":")[0])
+}
+
+// GetEsn finds out which robot a request is from: from its token if it has a valid one, or from its IP if not
+func GetEsn(ctx context.Context) (string, error) {
+	if esn, ok := ctx.Value(esnKey{}).(string); ok {
+		return esn, nil
+	}
+	if esn, err := GetEsnFromTarget(peerIP(ctx)); err == nil {
+		return esn, nil
+	}
+	return "", fmt.Errorf("bot not found")
+}
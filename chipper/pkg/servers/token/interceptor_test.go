@@ -0,0 +1,154 @@
+package tokenserver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/api/go/jdocspb"
+	"github.com/digital-dream-labs/api/go/tokenpb"
+	"github.com/golang-jwt/jwt"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func robotToken(t *testing.T, requestor string, expires time.Time) string {
+	t.Helper()
+	token, err := signToken(jwt.MapClaims{
+		"expires":      expires.UTC().Format(TimeFormat),
+		"iat":          time.Now().UTC().Format(TimeFormat),
+		"requestor_id": requestor,
+		"token_id":     GenerateUUID(),
+		"token_type":   "user+robot",
+		"user_id":      UserId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func requireTokens(t *testing.T, require bool) {
+	t.Helper()
+	if err := vars.UpdateConfig(func() { vars.APIConfig.Server.RequireRobotTokens = require }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRobotTokens(t *testing.T) {
+	vars.JWTKeyPath = filepath.Join(t.TempDir(), "jwt.key")
+	signingKey = nil
+	t.Cleanup(func() { requireTokens(t, false) })
+
+	// a token from before wire-pod kept its key
+	unverifiable := robotToken(t, "vic:00e20001", time.Now().Add(ExpirationTime))
+	signingKey = nil
+	vars.JWTKeyPath = filepath.Join(t.TempDir(), "jwt.key")
+
+	valid := robotToken(t, "vic:00e20001", time.Now().Add(ExpirationTime))
+	expired := robotToken(t, "vic:00e20001", time.Now().Add(-time.Minute))
+	firstAuth := robotToken(t, unknownRequestor, time.Now().Add(ExpirationTime))
+
+	// the key has to survive a restart
+	signingKey = nil
+	if _, err := ParseRobotToken(valid); err != nil {
+		t.Fatalf("token didn't verify after reloading the key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		require bool
+		method  string
+		token   string
+		thing   string
+		code    codes.Code
+		esn     string
+	}{
+		{"valid token", true, "/jdocspb.Jdocs/ReadDocs", valid, "vic:00e20001", codes.OK, "00e20001"},
+		{"another robot's jdocs", true, "/jdocspb.Jdocs/ReadDocs", valid, "vic:00e20002", codes.PermissionDenied, ""},
+		{"no token", true, "/jdocspb.Jdocs/ReadDocs", "", "vic:00e20001", codes.Unauthenticated, ""},
+		{"no token, not required", false, "/jdocspb.Jdocs/ReadDocs", "", "vic:00e20001", codes.OK, ""},
+		{"no token, first auth", true, "/tokenpb.Token/AssociatePrimaryUser", "", "", codes.OK, ""},
+		{"unverifiable token", true, "/jdocspb.Jdocs/WriteDoc", unverifiable, "vic:00e20001", codes.Unauthenticated, ""},
+		{"unverifiable token, not required", false, "/jdocspb.Jdocs/WriteDoc", unverifiable, "vic:00e20001", codes.OK, ""},
+		{"expired token", true, "/jdocspb.Jdocs/WriteDoc", expired, "vic:00e20001", codes.PermissionDenied, ""},
+		{"expired token, refreshing", true, refreshMethod, expired, "", codes.OK, "00e20001"},
+		{"unverifiable token, refreshing", true, refreshMethod, unverifiable, "", codes.OK, ""},
+		{"no token, refreshing", true, refreshMethod, "", "", codes.Unauthenticated, ""},
+		{"not a token, refreshing", true, refreshMethod, "x", "", codes.Unauthenticated, ""},
+		{"unverifiable token, listing revoked tokens", true, "/tokenpb.Token/ListRevokedTokens", unverifiable, "", codes.Unauthenticated, ""},
+		{"token from before the ESN was known", true, refreshMethod, firstAuth, "", codes.OK, ""},
+	}
+	for _, test := range tests {
+		requireTokens(t, test.require)
+		ctx := context.Background()
+		if test.token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("anki-access-token", test.token))
+		}
+		var req interface{} = &tokenpb.RefreshTokenRequest{}
+		if test.thing != "" {
+			req = &jdocspb.ReadDocsReq{Thing: test.thing}
+		}
+		var esn string
+		_, err := UnaryInterceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: test.method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				esn, _ = ctx.Value(esnKey{}).(string)
+				return nil, nil
+			})
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%s: got %v (%v), want %v", test.name, code, err, test.code)
+		}
+		if esn != test.esn {
+			t.Errorf("%s: ESN %q, want %q", test.name, esn, test.esn)
+		}
+	}
+}
+
+func TestTokenWithoutESN(t *testing.T) {
+	vars.JWTKeyPath = filepath.Join(t.TempDir(), "jwt.key")
+	signingKey = nil
+	requireTokens(t, true)
+	t.Cleanup(func() {
+		requireTokens(t, false)
+		boundTokensMu.Lock()
+		boundTokens = make(map[string]boundToken)
+		boundTokensMu.Unlock()
+	})
+	// what anyone can get from AssociatePrimaryUser
+	firstAuth := robotToken(t, unknownRequestor, time.Now().Add(ExpirationTime))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("anki-access-token", firstAuth))
+
+	tests := []struct {
+		name   string
+		method string
+		req    interface{}
+		code   codes.Code
+		esn    string
+	}{
+		{"writing jdocs before reading any", "/jdocspb.Jdocs/WriteDoc", &jdocspb.WriteDocReq{Thing: "vic:00e20002"}, codes.PermissionDenied, ""},
+		{"another method before reading jdocs", "/tokenpb.Token/ListRevokedTokens", &tokenpb.ListRevokedTokensRequest{}, codes.PermissionDenied, ""},
+		{"reading its own jdocs", "/jdocspb.Jdocs/ReadDocs", &jdocspb.ReadDocsReq{Thing: "vic:00E20001"}, codes.OK, "00e20001"},
+		{"reading another robot's jdocs", "/jdocspb.Jdocs/ReadDocs", &jdocspb.ReadDocsReq{Thing: "vic:00e20002"}, codes.PermissionDenied, ""},
+		{"writing another robot's jdocs", "/jdocspb.Jdocs/WriteDoc", &jdocspb.WriteDocReq{Thing: "vic:00e20002"}, codes.PermissionDenied, ""},
+		{"writing its own jdocs", "/jdocspb.Jdocs/WriteDoc", &jdocspb.WriteDocReq{Thing: "vic:00e20001"}, codes.OK, "00e20001"},
+		{"another method after reading jdocs", "/tokenpb.Token/ListRevokedTokens", &tokenpb.ListRevokedTokensRequest{}, codes.OK, "00e20001"},
+	}
+	for _, test := range tests {
+		var esn string
+		_, err := UnaryInterceptor(ctx, test.req, &grpc.UnaryServerInfo{FullMethod: test.method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				esn, _ = ctx.Value(esnKey{}).(string)
+				return nil, nil
+			})
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%s: got %v (%v), want %v", test.name, code, err, test.code)
+		}
+		if esn != test.esn {
+			t.Errorf("%s: ESN %q, want %q", test.name, esn, test.esn)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package tokenserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// the key robot tokens are signed with. it's kept in vars.JWTKeyPath, so tokens stay valid when wire-pod restarts,
+// and the public half is published at /.well-known/jwks.json so anything can check a token came from this wire-pod.
+
+const signingKeyBits = 2048
+
+// what robots call themselves before wire-pod knows their ESN (see CreateJWT)
+const unknownRequestor = "vic:00601b50"
+
+var (
+	ErrTokenExpired = errors.New("token has expired")
+
+	signingMu  sync.Mutex
+	signingKey *rsa.PrivateKey
+	signingKid string
+)
+
+// RobotClaims is what's in a token wire-pod gave a robot
+type RobotClaims struct {
+	TokenID     string
+	RequestorID string
+	UserID      string
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+}
+
+// ESN is the robot's ESN, or "" if the token was made before wire-pod knew it
+func (c RobotClaims) ESN() string {
+	if c.RequestorID == unknownRequestor || !strings.HasPrefix(c.RequestorID, "vic:") {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(c.RequestorID, "vic:"))
+}
+
+func loadSigningKey() (*rsa.PrivateKey, string, error) {
+	signingMu.Lock()
+	defer signingMu.Unlock()
+	if signingKey != nil {
+		return signingKey, signingKid, nil
+	}
+	key, err := readSigningKey()
+	if os.IsNotExist(err) {
+		key, err = createSigningKey()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	signingKey = key
+	signingKid = thumbprint(&key.PublicKey)
+	return signingKey, signingKid, nil
+}
+
+func readSigningKey() (*rsa.PrivateKey, error) {
+	keyPEM, err := os.ReadFile(vars.JWTKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the token signing key from %s: %w", vars.JWTKeyPath, err)
+	}
+	return key, nil
+}
+
+func createSigningKey() (*rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	os.MkdirAll(filepath.Dir(vars.JWTKeyPath), 0755)
+	if err := os.WriteFile(vars.JWTKeyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	logger.Println("Created a new token signing key at " + vars.JWTKeyPath)
+	return key, nil
+}
+
+// the RFC 7638 thumbprint, used as the key ID
+func thumbprint(key *rsa.PublicKey) string {
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	sum := sha256.Sum256([]byte(`{"e":"` + e + `","kty":"RSA","n":"` + n + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func signToken(claims jwt.MapClaims) (string, error) {
+	key, kid, err := loadSigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// ParseRobotToken checks a token was signed by this wire-pod. if it has expired, the claims are returned with ErrTokenExpired
+func ParseRobotToken(tokenString string) (*RobotClaims, error) {
+	key, kid, err := loadSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	// the Anki claims have iat as a string, which the standard checks don't like. expiry is checked below instead
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS512.Alg()}, SkipClaimsValidation: true}
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if tokenKid, _ := token.Header["kid"].(string); tokenKid != kid {
+			return nil, errors.New("token wasn't signed by this wire-pod")
+		}
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("token has no claims")
+	}
+	claims := &RobotClaims{}
+	claims.TokenID, _ = mapClaims["token_id"].(string)
+	claims.RequestorID, _ = mapClaims["requestor_id"].(string)
+	claims.UserID, _ = mapClaims["user_id"].(string)
+	issuedAt, _ := mapClaims["iat"].(string)
+	expires, _ := mapClaims["expires"].(string)
+	if claims.IssuedAt, err = time.Parse(time.RFC3339, issuedAt); err != nil {
+		return nil, errors.New("token has an invalid iat")
+	}
+	if claims.ExpiresAt, err = time.Parse(time.RFC3339, expires); err != nil {
+		return nil, errors.New("token has an invalid expiry")
+	}
+	if time.Now().After(claims.ExpiresAt) {
+		return claims, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// ServeJWKS publishes the public half of the signing key
+func ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	key, kid, err := loadSigningKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": jwt.SigningMethodRS512.Alg(),
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
@@ -0,0 +1,120 @@
+package tokenserver
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+)
+
+// what the token server hands over to the jdocs server while a robot is being authenticated.
+// robots authenticate at the same time as each other, so these are only used through the functions below.
+
+// PrimaryToken is a token made for a robot whose ESN isn't known yet, so it's kept by IP until ReadDocs finds out the ESN
+type PrimaryToken struct {
+	// 192.168.1.150
+	Target string
+	GUID   string
+	Hash   string
+}
+
+// SecondaryToken is a token made in ReadDocs for a robot which hasn't been authenticated, waiting for the robot to ask for it
+type SecondaryToken struct {
+	ESN    string
+	Target string
+	GUID   string
+	Hash   string
+}
+
+// SessionCert is the session certificate from AssociatePrimaryUser, waiting to be written out once the ESN is known
+type SessionCert struct {
+	// 192.168.1.150:51234
+	Target string
+	// Vector-R2D2
+	Name string
+	Cert []byte
+}
+
+var pendingMu sync.Mutex
+var primaryTokens []PrimaryToken
+var secondaryTokens []SecondaryToken
+var sessionCerts []SessionCert
+
+func ipOf(target string) string {
+	return strings.TrimSpace(strings.Split(target, ":")[0])
+}
+
+func AddPrimaryToken(t PrimaryToken) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	primaryTokens = append(primaryTokens, t)
+}
+
+// TakePrimaryTokens removes and returns every primary token made for the IP
+func TakePrimaryTokens(ip string) []PrimaryToken {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	var taken, kept []PrimaryToken
+	for _, t := range primaryTokens {
+		if strings.EqualFold(ipOf(t.Target), ipOf(ip)) {
+			logger.Println("Removing " + t.Target + " from temporary token-hash store")
+			taken = append(taken, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	primaryTokens = kept
+	return taken
+}
+
+func AddSecondaryToken(t SecondaryToken) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	secondaryTokens = append(secondaryTokens, t)
+}
+
+// TakeSecondaryToken removes and returns the oldest secondary token for the ESN
+func TakeSecondaryToken(esn string) (SecondaryToken, bool) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for i, t := range secondaryTokens {
+		if t.ESN == esn {
+			logger.Println("Removing " + t.ESN + " from temporary token-hash store")
+			secondaryTokens = append(secondaryTokens[:i:i], secondaryTokens[i+1:]...)
+			return t, true
+		}
+	}
+	return SecondaryToken{}, false
+}
+
+func AddSessionCert(c SessionCert) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	sessionCerts = append(sessionCerts, c)
+}
+
+// HasSessionCert is true if a session cert is waiting for the IP
+func HasSessionCert(ip string) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for _, c := range sessionCerts {
+		if strings.EqualFold(ipOf(c.Target), ipOf(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// TakeSessionCert removes and returns the oldest session cert waiting for the IP
+func TakeSessionCert(ip string) (SessionCert, bool) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for i, c := range sessionCerts {
+		if strings.EqualFold(ipOf(c.Target), ipOf(ip)) {
+			logger.Println("Removing " + c.Target + " from cert-write store")
+			sessionCerts = append(sessionCerts[:i:i], sessionCerts[i+1:]...)
+			return c, true
+		}
+	}
+	return SessionCert{}, false
+}
@@ -2,13 +2,10 @@ package tokenserver
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
@@ -32,18 +29,6 @@ var (
 	GlobalGUID     = "tni1TRsTRTaNSapjo0Y+Sw=="
 )
 
-// array of {"target", "guid", "guidhash"}
-// for primary user auth
-var TokenHashStore [][3]string
-
-// array of {"esn", "target", "guid", "guidhash"}
-// for secondary user auth
-var SecondaryTokenStore [][4]string
-
-// {"target", "name"}
-var SessionWriteStoreNames [][2]string
-var SessionWriteStoreCerts [][]byte
-
 type RobotInfoStore struct {
 	GlobalGUID string `json:"global_guid"`
 	Robots     []struct {
@@ -56,44 +41,22 @@ type RobotInfoStore struct {
 }
 
 func GetEsnFromTarget(target string) (string, error) {
-	jsonBytes, err := os.ReadFile(vars.BotInfoPath)
-	if err != nil {
-		return "", err
-	}
-	var robotInfo RobotInfoStore
-	err = json.Unmarshal(jsonBytes, &robotInfo)
-	if err != nil {
-		return "", err
-	}
-	for _, robot := range robotInfo.Robots {
-		if strings.TrimSpace(target) == strings.TrimSpace(robot.IPAddress) {
-			return robot.Esn, nil
-		}
+	if robot, ok := vars.BotInfo.GetByIP(target); ok {
+		return robot.Esn, nil
 	}
 	return "", fmt.Errorf("bot not found")
 }
 
 func SetBotGUID(esn string, guid string, guidHash string) error {
-	matched := false
-	for num, robot := range vars.BotInfo.Robots {
-		if strings.EqualFold(esn, robot.Esn) {
-			vars.BotInfo.Robots[num].GUID = guid
-			vars.BotInfo.Robots[num].Activated = true
-			logger.Println("GUID and hash successfully written for " + robot.Esn)
-			matched = true
-			break
-		}
-	}
+	matched := vars.BotInfo.Update(esn, func(robot *vars.RobotInfo) {
+		robot.GUID = guid
+		robot.Activated = true
+		logger.Println("GUID and hash successfully written for " + robot.Esn)
+	})
 	if !matched {
 		return fmt.Errorf("bot not found")
 	}
-	writeBytes, err := json.Marshal(vars.BotInfo)
-	if err != nil {
-		logger.Println(err)
-		return err
-	}
-	os.WriteFile(vars.BotInfoPath, writeBytes, 0644)
-	return nil
+	return vars.SaveBotInfo()
 }
 
 func WriteTokenHash(esn string, tokenHash string) error {
@@ -124,28 +87,9 @@ func WriteTokenHash(esn string, tokenHash string) error {
 	ajdoc.FmtVersion = jdoc.FmtVersion
 	ajdoc.JsonDoc = jdoc.JsonDoc
 	vars.AddJdoc("vic:"+esn, "vic.AppTokens", ajdoc)
-	vars.WriteJdocs()
 	return nil
 }
 
-func RemoveFromSecondStore(index int) {
-	logger.Println("Removing " + SecondaryTokenStore[index][0] + " from temporary token-hash store")
-	SecondaryTokenStore = append(SecondaryTokenStore[:index], SecondaryTokenStore[index+1:]...)
-}
-
-func RemoveFromPrimaryStore(index int) {
-	logger.Println("Removing " + TokenHashStore[index][0] + " from temporary token-hash store")
-	TokenHashStore = append(TokenHashStore[:index], TokenHashStore[index+1:]...)
-}
-
-func RemoveFromSessionStore(index int) {
-	//var SessionWriteStoreNames [][2]string
-	//var SessionWriteStoreCerts [][]byte
-	logger.Println("Removing " + SessionWriteStoreNames[index][0] + " from cert-write store")
-	SessionWriteStoreNames = append(SessionWriteStoreNames[:index], SessionWriteStoreNames[index+1:]...)
-	SessionWriteStoreCerts = append(SessionWriteStoreCerts[:index], SessionWriteStoreCerts[index+1:]...)
-}
-
 func ChangeGUIDInIni(esn string) {
 	// 	[008060ec]
 	// cert = /home/kerigan/.anki_vector/Vector-B6H9-008060ec.cert
@@ -153,19 +97,22 @@ func ChangeGUIDInIni(esn string) {
 	// name = Vector-B6H9
 	// guid = 1YbXk1yrS9C1I78snYy8xA==
 
+	vars.SDKIniMu.Lock()
+	defer vars.SDKIniMu.Unlock()
 	userIniData, err := ini.Load(vars.SDKIniPath + "sdk_config.ini")
 	if err != nil {
 		logger.Println(err)
 		return
 	}
-	for _, robot := range vars.BotInfo.Robots {
+	globalGUID := vars.BotInfo.GlobalGUID()
+	for _, robot := range vars.BotInfo.Robots() {
 		matched := false
 		for _, section := range userIniData.Sections() {
 			if strings.EqualFold(section.Name(), esn) {
 				matched = true
 				section.Key("ip").SetValue(robot.IPAddress)
 				if robot.GUID == "" {
-					section.Key("guid").SetValue(vars.BotInfo.GlobalGUID)
+					section.Key("guid").SetValue(globalGUID)
 				} else {
 					section.Key("guid").SetValue(robot.GUID)
 				}
@@ -192,28 +139,24 @@ func CreateJWT(ctx context.Context, skipGuid bool, isPrimary bool) *tokenpb.Toke
 	secondaryGUID := ""
 	secondaryHash := ""
 
-	// figure out current time and the time in one month
-	currentTime := time.Now().Format(TimeFormat)
-	expiresAt := time.Now().AddDate(0, 1, 0).Format(TimeFormat)
+	// robots refresh their token 3 hours before it expires
+	now := time.Now().UTC()
+	currentTime := now.Format(TimeFormat)
+	expiresAt := now.Add(ExpirationTime).Format(TimeFormat)
 	logger.Println("Current time: " + currentTime)
 	logger.Println("Token expires: " + expiresAt)
 
-	// get esn using ip address of request
-	p, _ := peer.FromContext(ctx)
-	ipAddr := strings.TrimSpace(strings.Split(p.Addr.String(), ":")[0])
-	esn, err := GetEsnFromTarget(ipAddr)
+	// get esn from the robot's token, or using ip address of request
+	ipAddr := peerIP(ctx)
+	esn, err := GetEsn(ctx)
 
 	// secondary handler
 	if err == nil {
-		for num, robot := range SecondaryTokenStore {
-			if robot[0] == esn {
-				skipGuid = true
-				secondary = true
-				secondaryGUID = robot[2]
-				secondaryHash = robot[3]
-				RemoveFromSecondStore(num)
-				break
-			}
+		if robot, ok := TakeSecondaryToken(esn); ok {
+			skipGuid = true
+			secondary = true
+			secondaryGUID = robot.GUID
+			secondaryHash = robot.Hash
 		}
 	}
 
@@ -234,7 +177,7 @@ func CreateJWT(ctx context.Context, skipGuid bool, isPrimary bool) *tokenpb.Toke
 		if !skipGuid {
 			logger.Println("Adding " + ipAddr + " to TokenHashStore")
 			guid, tokenHash, _ := CreateTokenAndHashedToken()
-			TokenHashStore = append(TokenHashStore, [3]string{ipAddr, guid, tokenHash})
+			AddPrimaryToken(PrimaryToken{Target: ipAddr, GUID: guid, Hash: tokenHash})
 			clientToken = guid
 		}
 	}
@@ -252,7 +195,7 @@ func CreateJWT(ctx context.Context, skipGuid bool, isPrimary bool) *tokenpb.Toke
 	logger.Println("UUID for this token request: " + requestUUID)
 
 	// create actual JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
+	tokenString, err := signToken(jwt.MapClaims{
 		"expires":     expiresAt,
 		"iat":         currentTime,
 		"permissions": nil,
@@ -264,8 +207,9 @@ func CreateJWT(ctx context.Context, skipGuid bool, isPrimary bool) *tokenpb.Toke
 		"token_type":   "user+robot",
 		"user_id":      UserId,
 	})
-	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
-	tokenString, _ := token.SignedString(rsaKey)
+	if err != nil {
+		logger.Println("Error signing token: " + err.Error())
+	}
 	bundle.Token = tokenString
 	return bundle
 }
@@ -274,9 +218,8 @@ func (s *TokenServer) AssociatePrimaryUser(ctx context.Context, req *tokenpb.Ass
 	logger.Println("Token: Incoming Associate Primary User request")
 	pemBytes, _ := pem.Decode(req.SessionCertificate)
 	cert, _ := x509.ParseCertificate(pemBytes.Bytes)
-	SessionWriteStoreCerts = append(SessionWriteStoreCerts, req.SessionCertificate)
 	p, _ := peer.FromContext(ctx)
-	SessionWriteStoreNames = append(SessionWriteStoreNames, [2]string{p.Addr.String(), cert.Issuer.CommonName})
+	AddSessionCert(SessionCert{Target: p.Addr.String(), Name: cert.Issuer.CommonName, Cert: req.SessionCertificate})
 	return &tokenpb.AssociatePrimaryUserResponse{
 		Data: CreateJWT(ctx, false, true),
 	}, nil
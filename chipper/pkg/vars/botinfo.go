@@ -0,0 +1,240 @@
+package vars
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+)
+
+// the robots wire-pod knows about, and the IDs it has seen them use.
+// the gRPC servers, the jdocs pinger, the SDK app and the web server all use these at the same time,
+// so they're only touched through the methods here. everything returned is a copy.
+
+// BotInfoRegistry is the list of authenticated robots (what used to be botSdkInfo.json)
+type BotInfoRegistry struct {
+	mu   sync.RWMutex
+	info RobotInfoStore
+}
+
+// BotInfo is every robot wire-pod knows about. it's saved with SaveBotInfo
+var BotInfo = &BotInfoRegistry{}
+
+func sameESN(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// Robots returns every known robot
+func (b *BotInfoRegistry) Robots() []RobotInfo {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]RobotInfo{}, b.info.Robots...)
+}
+
+// Get returns the robot with the ESN
+func (b *BotInfoRegistry) Get(esn string) (RobotInfo, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, robot := range b.info.Robots {
+		if sameESN(robot.Esn, esn) {
+			return robot, true
+		}
+	}
+	return RobotInfo{}, false
+}
+
+// GetByIP returns the robot last seen at the IP address (no port)
+func (b *BotInfoRegistry) GetByIP(ip string) (RobotInfo, bool) {
+	ip = strings.TrimSpace(ip)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, robot := range b.info.Robots {
+		if strings.EqualFold(strings.TrimSpace(robot.IPAddress), ip) {
+			return robot, true
+		}
+	}
+	return RobotInfo{}, false
+}
+
+func (b *BotInfoRegistry) GlobalGUID() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.info.GlobalGUID
+}
+
+func (b *BotInfoRegistry) SetGlobalGUID(guid string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.info.GlobalGUID = guid
+}
+
+// Add adds a robot. false if one with the same ESN is already there
+func (b *BotInfoRegistry) Add(robot RobotInfo) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, known := range b.info.Robots {
+		if sameESN(known.Esn, robot.Esn) {
+			return false
+		}
+	}
+	b.info.Robots = append(b.info.Robots, robot)
+	return true
+}
+
+// Update changes the robot with the ESN in place. false if there isn't one
+func (b *BotInfoRegistry) Update(esn string, fn func(robot *RobotInfo)) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.info.Robots {
+		if sameESN(b.info.Robots[i].Esn, esn) {
+			fn(&b.info.Robots[i])
+			return true
+		}
+	}
+	return false
+}
+
+// SetIP notes a robot's new IP address. true if the robot is known and the address changed
+func (b *BotInfoRegistry) SetIP(esn string, ip string) bool {
+	changed := false
+	b.Update(esn, func(robot *RobotInfo) {
+		if robot.IPAddress != ip {
+			robot.IPAddress = ip
+			changed = true
+		}
+	})
+	return changed
+}
+
+// Snapshot returns everything, in the format botSdkInfo.json had
+func (b *BotInfoRegistry) Snapshot() RobotInfoStore {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return RobotInfoStore{
+		GlobalGUID: b.info.GlobalGUID,
+		Robots:     append([]RobotInfo{}, b.info.Robots...),
+	}
+}
+
+func (b *BotInfoRegistry) set(info RobotInfoStore) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.info = info
+}
+
+// MarshalJSON keeps get_sdk_info returning what it always has
+func (b *BotInfoRegistry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.Snapshot())
+}
+
+// LoadBotInfo loads BotInfo from the store
+func LoadBotInfo() error {
+	return DB.View(func(tx StoreTx) error {
+		var info RobotInfoStore
+		if guid := tx.Get(bucketBotInfo, keyGlobalGUID); guid != nil {
+			info.GlobalGUID = string(guid)
+		}
+		err := tx.ForEach(bucketBotInfo, func(key string, value []byte) error {
+			if key == keyGlobalGUID {
+				return nil
+			}
+			var robot RobotInfo
+			if err := json.Unmarshal(value, &robot); err != nil {
+				return err
+			}
+			info.Robots = append(info.Robots, robot)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		BotInfo.set(info)
+		return nil
+	})
+}
+
+// SaveBotInfo saves BotInfo to the store. robots which aren't in it anymore get removed
+func SaveBotInfo() error {
+	return DB.Update(func(tx StoreTx) error {
+		// taken inside the transaction, so when two saves race the last one to commit has the newest info
+		return putBotInfo(tx, BotInfo.Snapshot())
+	})
+}
+
+func putBotInfo(tx StoreTx, info RobotInfoStore) error {
+	var old []string
+	tx.ForEach(bucketBotInfo, func(key string, value []byte) error {
+		old = append(old, key)
+		return nil
+	})
+	for _, key := range old {
+		if err := tx.Delete(bucketBotInfo, key); err != nil {
+			return err
+		}
+	}
+	if err := tx.Put(bucketBotInfo, keyGlobalGUID, []byte(info.GlobalGUID)); err != nil {
+		return err
+	}
+	for _, robot := range info.Robots {
+		if err := StorePutJSON(tx, bucketBotInfo, robot.Esn, robot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the robot IDs (Vector-R2D2), which come from the session certs and mDNS
+var recurringInfo []RecurringInfoStore
+var recurringInfoMu sync.Mutex
+
+// GetRInfo returns every robot ID wire-pod has seen
+func GetRInfo() []RecurringInfoStore {
+	recurringInfoMu.Lock()
+	defer recurringInfoMu.Unlock()
+	return append([]RecurringInfoStore{}, recurringInfo...)
+}
+
+// LoadRInfo loads the robot IDs from the store
+func LoadRInfo() {
+	var loaded []RecurringInfoStore
+	err := DB.View(func(tx StoreTx) error {
+		return tx.ForEach(bucketRecurringInfo, func(key string, value []byte) error {
+			var rinfo RecurringInfoStore
+			if err := json.Unmarshal(value, &rinfo); err != nil {
+				return err
+			}
+			loaded = append(loaded, rinfo)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Println("Failed to load robot IDs: " + err.Error())
+	}
+	recurringInfoMu.Lock()
+	recurringInfo = loaded
+	recurringInfoMu.Unlock()
+}
+
+func AddToRInfo(esn string, id string, ip string) {
+	var rinfo RecurringInfoStore
+	rinfo.ESN = esn
+	rinfo.ID = id
+	rinfo.IP = ip
+	recurringInfoMu.Lock()
+	defer recurringInfoMu.Unlock()
+	err := DB.Update(func(tx StoreTx) error {
+		return StorePutJSON(tx, bucketRecurringInfo, esn, rinfo)
+	})
+	if err != nil {
+		logger.Println("Failed to save the ID of " + esn + ": " + err.Error())
+	}
+	// the only bot constant is ESN
+	for i := range recurringInfo {
+		if recurringInfo[i].ESN == esn {
+			recurringInfo[i] = rinfo
+			return
+		}
+	}
+	recurringInfo = append(recurringInfo, rinfo)
+}
@@ -0,0 +1,94 @@
+package vars
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// run with -race. robots get added and move around while the voice server looks them up and the web server lists them
+
+func TestBotInfoFromManyGoroutines(t *testing.T) {
+	DB = NewMemoryStore()
+	BotInfo.set(RobotInfoStore{})
+	const count = 10
+	var wg sync.WaitGroup
+	// only one Add for each ESN should work
+	var added int
+	var addedMu sync.Mutex
+	add := func(robot RobotInfo) {
+		if BotInfo.Add(robot) {
+			addedMu.Lock()
+			added++
+			addedMu.Unlock()
+		}
+	}
+	for i := 0; i < count; i++ {
+		esn := fmt.Sprintf("00e1%04x", i)
+		wg.Add(3)
+		// ReadDocs adding the robot, then it changing IP and being activated
+		go func(i int) {
+			defer wg.Done()
+			add(RobotInfo{Esn: esn, IPAddress: "10.0.1.1"})
+			for n := 0; n < 20; n++ {
+				BotInfo.SetIP(esn, fmt.Sprintf("10.0.%d.%d", i, n))
+				SaveBotInfo()
+			}
+			BotInfo.Update(esn, func(robot *RobotInfo) {
+				robot.GUID = "guid-" + esn
+				robot.Activated = true
+			})
+			AddToRInfo(esn, "Vector-"+esn, fmt.Sprintf("10.0.%d.19", i))
+			if err := SaveBotInfo(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		// the same robot connecting again at the same time
+		go func() {
+			defer wg.Done()
+			add(RobotInfo{Esn: esn})
+		}()
+		// intent requests and the web interface
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				BotInfo.Get(esn)
+				BotInfo.GetByIP("10.0.1.1")
+				// it's a copy, so changing it can't race with anything
+				robots := BotInfo.Robots()
+				for i := range robots {
+					robots[i].IPAddress = ""
+				}
+				GetRInfo()
+				json.Marshal(BotInfo)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if added != count {
+		t.Errorf("%d robots were added, want %d", added, count)
+	}
+	robots := BotInfo.Robots()
+	if len(robots) != count {
+		t.Fatalf("%d robots, want %d", len(robots), count)
+	}
+	if len(GetRInfo()) != count {
+		t.Errorf("%d robot IDs, want %d", len(GetRInfo()), count)
+	}
+	if err := LoadBotInfo(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		esn := fmt.Sprintf("00e1%04x", i)
+		robot, ok := BotInfo.Get(esn)
+		if !ok {
+			t.Errorf("%s wasn't saved", esn)
+			continue
+		}
+		if want := fmt.Sprintf("10.0.%d.19", i); robot.IPAddress != want || !robot.Activated {
+			t.Errorf("%s saved as %+v, want IP %s and activated", esn, robot, want)
+		}
+	}
+}
@@ -3,16 +3,27 @@ package vars
 import (
 	"encoding/json"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 )
 
-// a way to create a JSON configuration for wire-pod, rather than the use of env vars
+// a way to create a JSON configuration for wire-pod, rather than the use of env vars.
+// it's kept in the store (store.go). apiConfig.json only gets imported from
 
 var ApiConfigPath = "./apiConfig.json"
 
 var APIConfig apiConfig
 
+// APIConfig.Server.RequireRobotTokens, which the gRPC servers check on every request while the web interface may be changing APIConfig
+var robotTokensRequired atomic.Bool
+
+// RobotTokensRequired is whether robots need a token signed by wire-pod for their gRPC requests
+func RobotTokensRequired() bool {
+	return robotTokensRequired.Load()
+}
+
 type apiConfig struct {
 	Weather struct {
 		Enable   bool   `json:"enable"`
@@ -21,7 +32,8 @@ type apiConfig struct {
 		Unit     string `json:"unit"`
 	} `json:"weather"`
 	Knowledge struct {
-		Enable         bool   `json:"enable"`
+		Enable bool `json:"enable"`
+		// houndify, openai, together, custom (any OpenAI-compatible API), ollama or llamacpp
 		Provider       string `json:"provider"`
 		Key            string `json:"key"`
 		ID             string `json:"id"`
@@ -33,24 +45,90 @@ type apiConfig struct {
 		SaveChat       bool   `json:"save_chat"`
 		CommandsEnable bool   `json:"commands_enable"`
 		Endpoint       string `json:"endpoint"`
+		// let the LLM call tools (plugins, custom intents, moving the robot) through the tool calling API
+		ToolsEnable bool `json:"tools_enable"`
+		// robots (by ESN) which listen again after the LLM answers, so the user doesn't have to say the wake word
+		ConversationRobots []string `json:"conversation_robots,omitempty"`
+		// seconds of silence which end a conversation, 0 means the default
+		ConversationTimeout int `json:"conversation_timeout,omitempty"`
 	} `json:"knowledge"`
 	STT struct {
 		Service  string `json:"provider"`
 		Language string `json:"language"`
+		// minimum score (0-1) for an intent match, 0 means the default
+		MatchThreshold float64 `json:"match_threshold,omitempty"`
+		// seconds a follow-up or the answer to a question is routed back to the last intent, 0 means the default
+		DialogTimeout int `json:"dialog_timeout,omitempty"`
 	} `json:"STT"`
 	Server struct {
 		// false for ip, true for escape pod
 		EPConfig bool   `json:"epconfig"`
 		Port     string `json:"port"`
+		// extra hostnames and IPs for the server cert, on top of this machine's IPs and hostname
+		CertSANs []string `json:"cert_sans,omitempty"`
+		// reject gRPC requests from robots which don't have a valid token signed by wire-pod
+		RequireRobotTokens bool `json:"require_robot_tokens,omitempty"`
 	} `json:"server"`
+	// the request history (wirepod/history)
+	History struct {
+		// voice requests are only recorded if this is set
+		Enable bool `json:"enable,omitempty"`
+		// record what was said and matched, but not the audio
+		NoAudio bool `json:"no_audio,omitempty"`
+		// days requests are kept for, 0 means the default (7)
+		MaxDays int `json:"max_days,omitempty"`
+		// requests kept in total, 0 means the default (200)
+		MaxRequests int `json:"max_requests,omitempty"`
+	} `json:"history"`
+	// text-to-speech for spoken responses (ttr/tts.go)
+	TTS struct {
+		// vector (the robot's own voice), piper, http or openai. empty keeps the old behavior: OpenAI's voice
+		// for languages other than English if the knowledge provider is OpenAI, the robot's otherwise
+		Provider string `json:"provider,omitempty"`
+		// the voice: a Piper model (.onnx), an OpenAI voice (fable), or whatever the HTTP server takes
+		Voice string `json:"voice,omitempty"`
+		// the piper binary, piper (on the PATH) if it's empty
+		PiperPath string `json:"piper_path,omitempty"`
+		// an HTTP server which returns a WAV for ?text=&voice=&language=
+		Endpoint string `json:"endpoint,omitempty"`
+		// the OpenAI key, the knowledge one if it's empty
+		Key string `json:"key,omitempty"`
+		// voices for an STT language ("de-DE"), over the ones above
+		LanguageVoices map[string]TTSVoice `json:"language_voices,omitempty"`
+		// voices for a robot (by ESN), over the language ones
+		RobotVoices map[string]TTSVoice `json:"robot_voices,omitempty"`
+		// phrases kept synthesized, 0 means the default (100)
+		CacheSize int `json:"cache_size,omitempty"`
+	} `json:"tts"`
+	// the schema version (configschema.go), 0 for configs from before there was one
+	Version          int  `json:"version"`
 	HasReadFromEnv   bool `json:"hasreadfromenv"`
 	PastInitialSetup bool `json:"pastinitialsetup"`
 }
 
+// TTSVoice is a TTS provider and one of its voices. an empty voice is the provider's default
+type TTSVoice struct {
+	Provider string `json:"provider"`
+	Voice    string `json:"voice,omitempty"`
+}
+
 func WriteConfigToDisk() {
 	logger.Println("Configuration changed, writing to disk")
-	writeBytes, _ := json.Marshal(APIConfig)
-	os.WriteFile(ApiConfigPath, writeBytes, 0644)
+	robotTokensRequired.Store(APIConfig.Server.RequireRobotTokens)
+	if err := saveConfig(); err != nil {
+		logger.Println("Failed to save the API config: " + err.Error())
+	}
+}
+
+func saveConfig() error {
+	config := withoutEnvOverrides(APIConfig)
+	err := DB.Update(func(tx StoreTx) error {
+		return StorePutJSON(tx, bucketConfig, keyAPIConfig, config)
+	})
+	if err == nil {
+		storedConfig = config
+	}
+	return err
 }
 
 func CreateConfigFromEnv() {
@@ -75,8 +153,8 @@ func CreateConfigFromEnv() {
 	}
 	WriteSTT()
 	APIConfig.HasReadFromEnv = true
-	writeBytes, _ := json.Marshal(APIConfig)
-	os.WriteFile(ApiConfigPath, writeBytes, 0644)
+	// a new config doesn't need any migrations
+	APIConfig.Version = ConfigVersion
 }
 
 func WriteSTT() {
@@ -89,45 +167,56 @@ func WriteSTT() {
 }
 
 func ReadConfig() {
-	if _, err := os.Stat(ApiConfigPath); err != nil {
+	APIConfig = apiConfig{}
+	configLoadErrors = nil
+	var configBytes []byte
+	DB.View(func(tx StoreTx) error {
+		configBytes = tx.Get(bucketConfig, keyAPIConfig)
+		return nil
+	})
+	// the stored config doesn't get replaced with one wire-pod couldn't read properly
+	save := true
+	if configBytes == nil {
 		CreateConfigFromEnv()
-		logger.Println("API config JSON created")
+		logger.Println("API config created")
+	} else if err := json.Unmarshal(configBytes, &APIConfig); err != nil {
+		APIConfig.Knowledge.Enable = false
+		APIConfig.Weather.Enable = false
+		save = false
+		// saving a setting in the web interface replaces it, so a copy is kept
+		DB.Update(func(tx StoreTx) error {
+			return tx.Put(bucketConfig, keyAPIConfigUnreadable, configBytes)
+		})
+		logger.Error("Failed to read the API config, the knowledge graph and weather are off until they're set up again", logger.UI, "error", err)
+		configLoadErrors = append(configLoadErrors, ConfigError{Message: "the stored config couldn't be read, so the knowledge graph and weather were turned off: " + err.Error()})
 	} else {
-		// read config
-		configBytes, err := os.ReadFile(ApiConfigPath)
+		done, err := migrateConfig(&APIConfig)
 		if err != nil {
-			APIConfig.Knowledge.Enable = false
-			APIConfig.Weather.Enable = false
-			logger.Println("Failed to read API config file")
-			logger.Println(err)
-			return
+			save = false
+			logger.Warn("Using the API config as it is", logger.UI, "error", err)
+			configLoadErrors = append(configLoadErrors, ConfigError{Message: err.Error()})
 		}
-		err = json.Unmarshal(configBytes, &APIConfig)
-		if err != nil {
-			APIConfig.Knowledge.Enable = false
-			APIConfig.Weather.Enable = false
-			logger.Println("Failed to unmarshal API config JSON")
-			logger.Println(err)
-			return
-		}
-		// stt service is the only thing controlled by shell
-		if APIConfig.STT.Service != os.Getenv("STT_SERVICE") {
-			WriteSTT()
-		}
-		if !APIConfig.HasReadFromEnv {
-			if APIConfig.Server.Port != os.Getenv("DDL_RPC_PORT") {
-				APIConfig.HasReadFromEnv = true
-				APIConfig.PastInitialSetup = true
-			}
-		}
-
-		if APIConfig.Knowledge.Model == "meta-llama/Llama-2-70b-chat-hf" {
-			logger.Println("Setting Together model to Llama3")
-			APIConfig.Knowledge.Model = "meta-llama/Llama-3-70b-chat-hf"
+		for _, migration := range done {
+			logger.Println("Migrated the API config: " + migration)
 		}
-
-		writeBytes, _ := json.Marshal(APIConfig)
-		os.WriteFile(ApiConfigPath, writeBytes, 0644)
-		logger.Println("API config successfully read")
 	}
+	// (if STT_SERVICE isn't set, keep whatever was picked in the web interface)
+	if os.Getenv("STT_SERVICE") != "" && APIConfig.STT.Service != os.Getenv("STT_SERVICE") {
+		WriteSTT()
+	}
+	storedConfig = APIConfig
+	var envErrs []ConfigError
+	envOverrides, envErrs = applyEnvOverrides(&APIConfig)
+	configLoadErrors = append(configLoadErrors, envErrs...)
+	if len(envOverrides) > 0 {
+		logger.Println("API config settings from the environment: " + strings.Join(envOverrides, ", "))
+	}
+	for _, err := range append(envErrs, ValidateConfig(APIConfig)...) {
+		logger.Warn("Invalid API config setting", logger.UI, "setting", err.Field, "error", err.Message)
+	}
+	robotTokensRequired.Store(APIConfig.Server.RequireRobotTokens)
+	if save {
+		saveConfig()
+	}
+	logger.Println("API config successfully read")
 }
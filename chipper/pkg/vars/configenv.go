@@ -0,0 +1,179 @@
+package vars
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// every setting in apiConfig can be set with an env var, which wins over what's stored (see CONFIG.md).
+// it isn't saved, see storedConfig.
+// the name comes from the setting's JSON path: WIREPOD_ + the section + _ + the field, upper case, with anything
+// which isn't a letter or number turned into _. so knowledge.openai_prompt is WIREPOD_KNOWLEDGE_OPENAI_PROMPT,
+// and STT.match_threshold is WIREPOD_STT_MATCH_THRESHOLD.
+// bools are true/false (or 1/0), lists are comma separated.
+//
+// the old env vars (STT_SERVICE, WEATHERAPI_*, KNOWLEDGE_*) still work the way they always have
+
+const envPrefix = "WIREPOD_"
+
+// settings which aren't settings
+var envSkip = map[string]bool{"version": true, "hasreadfromenv": true}
+
+var envNameRegex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// ConfigEnvVar is a setting and the env var which sets it
+type ConfigEnvVar struct {
+	Name  string `json:"name"`
+	Field string `json:"field"`
+	Type  string `json:"type"`
+}
+
+type envField struct {
+	ConfigEnvVar
+	index []int
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func envName(path ...string) string {
+	return envPrefix + strings.Trim(envNameRegex.ReplaceAllString(strings.ToUpper(strings.Join(path, "_")), "_"), "_")
+}
+
+func envFields() []envField {
+	var fields []envField
+	var walk func(t reflect.Type, path []string, index []int)
+	walk = func(t reflect.Type, path []string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonName(field)
+			if len(path) == 0 && envSkip[name] {
+				continue
+			}
+			fieldPath := append(append([]string{}, path...), name)
+			fieldIndex := append(append([]int{}, index...), i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, fieldPath, fieldIndex)
+				continue
+			}
+			// like tts.robot_voices, there's no sensible way to write those in one env var
+			if field.Type.Kind() == reflect.Map {
+				continue
+			}
+			typ := field.Type.Kind().String()
+			switch field.Type.Kind() {
+			case reflect.Float64:
+				typ = "number"
+			case reflect.Slice:
+				typ = "list"
+			}
+			fields = append(fields, envField{
+				ConfigEnvVar: ConfigEnvVar{
+					Name:  envName(fieldPath...),
+					Field: strings.Join(fieldPath, "."),
+					Type:  typ,
+				},
+				index: fieldIndex,
+			})
+		}
+	}
+	walk(reflect.TypeOf(apiConfig{}), nil, nil)
+	return fields
+}
+
+// ConfigEnvVars lists the env var for every setting
+func ConfigEnvVars() []ConfigEnvVar {
+	var list []ConfigEnvVar
+	for _, field := range envFields() {
+		list = append(list, field.ConfigEnvVar)
+	}
+	return list
+}
+
+func setFromEnv(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q isn't true or false", value)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q isn't a whole number", value)
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", value)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("can't be set from an env var")
+	}
+	return nil
+}
+
+// the env vars applyEnvOverrides used
+var envOverrides []string
+
+// the config as it was stored, before the env vars were applied. env vars only change the config wire-pod runs with,
+// so a key from one isn't saved in wirepod.db or exported, and removing the env var brings the stored setting back
+var storedConfig apiConfig
+
+// withoutEnvOverrides is c with the settings which came from env vars set back to the stored ones
+func withoutEnvOverrides(c apiConfig) apiConfig {
+	used := make(map[string]bool)
+	for _, name := range envOverrides {
+		used[name] = true
+	}
+	config := reflect.ValueOf(&c).Elem()
+	stored := reflect.ValueOf(storedConfig)
+	for _, field := range envFields() {
+		if used[field.Name] {
+			config.FieldByIndex(field.index).Set(stored.FieldByIndex(field.index))
+		}
+	}
+	return c
+}
+
+// applyEnvOverrides sets everything which has a WIREPOD_ env var. it returns the env vars it used,
+// and the ones which had a value that didn't fit the setting
+func applyEnvOverrides(c *apiConfig) ([]string, []ConfigError) {
+	var used []string
+	var errs []ConfigError
+	config := reflect.ValueOf(c).Elem()
+	for _, field := range envFields() {
+		value, ok := os.LookupEnv(field.Name)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(config.FieldByIndex(field.index), value); err != nil {
+			errs = append(errs, ConfigError{Field: field.Field, Message: field.Name + ": " + err.Error()})
+			continue
+		}
+		used = append(used, field.Name)
+	}
+	return used, errs
+}
@@ -0,0 +1,88 @@
+package vars
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// moving wire-pod's whole configuration to another machine. session certs and the server's certs aren't part of it,
+// robots get new ones when they're set up with the new machine
+
+// ConfigExport is everything export_config gives and import_config takes
+type ConfigExport struct {
+	Exported      time.Time      `json:"exported"`
+	Config        apiConfig      `json:"config"`
+	CustomIntents IntentsStruct  `json:"custom_intents"`
+	BotInfo       RobotInfoStore `json:"bot_info"`
+}
+
+// ExportConfig returns the whole configuration
+func ExportConfig() ConfigExport {
+	intents := CustomIntents()
+	if intents == nil {
+		intents = IntentsStruct{}
+	}
+	return ConfigExport{
+		Exported:      time.Now(),
+		Config:        withoutEnvOverrides(APIConfig),
+		CustomIntents: intents,
+		BotInfo:       BotInfo.Snapshot(),
+	}
+}
+
+// ImportConfig replaces the whole configuration with an exported one. nothing is changed unless all of it is valid
+func ImportConfig(data []byte) error {
+	var raw struct {
+		Config        json.RawMessage `json:"config"`
+		CustomIntents IntentsStruct   `json:"custom_intents"`
+		BotInfo       RobotInfoStore  `json:"bot_info"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return errors.New("not an exported config: " + err.Error())
+	}
+	if len(raw.Config) == 0 {
+		return errors.New("not an exported config: there's no config in it")
+	}
+	config, _, err := parseConfig(raw.Config)
+	if err != nil {
+		return errors.New("the config couldn't be read: " + err.Error())
+	}
+	// env vars win over an imported config the same way they do over a stored one
+	running := config
+	used, envErrs := applyEnvOverrides(&running)
+	errs := append(envErrs, ValidateConfig(running)...)
+	for _, err := range ValidateCustomIntents(raw.CustomIntents) {
+		errs = append(errs, ConfigError{Field: "custom_intents", Message: err.Error()})
+	}
+	if len(errs) > 0 {
+		return &ConfigValidationError{Errors: errs}
+	}
+	for _, robot := range raw.BotInfo.Robots {
+		if robot.Esn == "" || robot.Esn == keyGlobalGUID {
+			return errors.New("the bot info has a robot without a valid ESN")
+		}
+	}
+	customIntentsUpdateMu.Lock()
+	defer customIntentsUpdateMu.Unlock()
+	err = DB.Update(func(tx StoreTx) error {
+		if err := StorePutJSON(tx, bucketConfig, keyAPIConfig, config); err != nil {
+			return err
+		}
+		if err := StorePutJSON(tx, bucketCustomIntents, keyCustomIntents, raw.CustomIntents); err != nil {
+			return err
+		}
+		return putBotInfo(tx, raw.BotInfo)
+	})
+	if err != nil {
+		return err
+	}
+	storedConfig = config
+	APIConfig = running
+	robotTokensRequired.Store(APIConfig.Server.RequireRobotTokens)
+	envOverrides = used
+	setCustomIntents(raw.CustomIntents)
+	BotInfo.set(raw.BotInfo)
+	configLoadErrors = nil
+	return nil
+}
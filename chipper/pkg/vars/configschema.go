@@ -0,0 +1,301 @@
+package vars
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the config's schema version, and the steps which bring an older config up to it.
+// a change to apiConfig which needs more than a new field with a working zero value gets a migration at the end of
+// configMigrations. ConfigVersion follows from the list, so it can't be forgotten
+
+type configMigration struct {
+	description string
+	migrate     func(c *apiConfig)
+}
+
+// configMigrations[i] takes a config from version i to i+1. never remove or reorder them
+var configMigrations = []configMigration{
+	{
+		// configs from before the web interface had HasReadFromEnv false, and their port came from DDL_RPC_PORT
+		description: "mark configs from before the web interface as past the initial setup",
+		migrate: func(c *apiConfig) {
+			if !c.HasReadFromEnv && c.Server.Port != os.Getenv("DDL_RPC_PORT") {
+				c.HasReadFromEnv = true
+				c.PastInitialSetup = true
+			}
+		},
+	},
+	{
+		description: "move the Together model off Llama 2, which isn't served anymore",
+		migrate: func(c *apiConfig) {
+			if c.Knowledge.Model == "meta-llama/Llama-2-70b-chat-hf" {
+				c.Knowledge.Model = "meta-llama/Llama-3-70b-chat-hf"
+			}
+		},
+	},
+}
+
+// ConfigVersion is the version of the config wire-pod writes
+var ConfigVersion = len(configMigrations)
+
+// migrateConfig runs every migration newer than the config. it returns what was done, for the log
+func migrateConfig(c *apiConfig) ([]string, error) {
+	if c.Version > ConfigVersion {
+		return nil, fmt.Errorf("the config is version %d, which is newer than this wire-pod (%d)", c.Version, ConfigVersion)
+	}
+	var done []string
+	for i := c.Version; i < ConfigVersion; i++ {
+		configMigrations[i].migrate(c)
+		done = append(done, configMigrations[i].description)
+	}
+	c.Version = ConfigVersion
+	return done, nil
+}
+
+// ConfigError is a problem with one setting. Field is the setting's JSON path (knowledge.key),
+// or empty if the problem is with the whole config
+type ConfigError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ConfigError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+var (
+	weatherProviders   = []string{"weatherapi.com", "openweathermap.org", "open-meteo.com"}
+	knowledgeProviders = []string{"houndify", "openai", "together", "custom", "ollama", "llamacpp"}
+	ttsProviders       = []string{"vector", "piper", "http", "openai"}
+)
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateConfig checks the settings which wire-pod would otherwise only find out about when a robot uses them
+func ValidateConfig(c apiConfig) []ConfigError {
+	var errs []ConfigError
+	add := func(field, format string, a ...any) {
+		errs = append(errs, ConfigError{Field: field, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if c.Weather.Enable {
+		if !oneOf(c.Weather.Provider, weatherProviders) {
+			add("weather.provider", "must be one of %s", strings.Join(weatherProviders, ", "))
+		}
+		// Open-Meteo is free without one
+		if strings.TrimSpace(c.Weather.Key) == "" && c.Weather.Provider != "open-meteo.com" {
+			add("weather.key", "an API key is needed for the weather")
+		}
+	}
+	if c.Weather.Unit != "" && c.Weather.Unit != "F" && c.Weather.Unit != "C" {
+		add("weather.unit", "must be F or C")
+	}
+
+	if c.Knowledge.Enable {
+		switch c.Knowledge.Provider {
+		case "houndify":
+			if c.Knowledge.ID == "" {
+				add("knowledge.id", "Houndify needs a client ID")
+			}
+			fallthrough
+		case "openai", "together":
+			if strings.TrimSpace(c.Knowledge.Key) == "" {
+				add("knowledge.key", "%s needs an API key", c.Knowledge.Provider)
+			}
+		case "custom", "ollama", "llamacpp":
+			// ollama has a default endpoint. llama.cpp's server would be on 8080, which is where the web interface is
+			if c.Knowledge.Provider != "ollama" && strings.TrimSpace(c.Knowledge.Endpoint) == "" {
+				add("knowledge.endpoint", "an endpoint is needed for %s", c.Knowledge.Provider)
+			}
+		default:
+			add("knowledge.provider", "must be one of %s", strings.Join(knowledgeProviders, ", "))
+		}
+	}
+	if c.Knowledge.Endpoint != "" && !strings.HasPrefix(c.Knowledge.Endpoint, "http://") && !strings.HasPrefix(c.Knowledge.Endpoint, "https://") {
+		add("knowledge.endpoint", "must start with http:// or https://")
+	} else if IsWebServer(c.Knowledge.Endpoint) {
+		add("knowledge.endpoint", "is wire-pod's own web interface (port %s), the LLM server has to be on another port", WebPort)
+	}
+	if c.Knowledge.ConversationTimeout < 0 {
+		add("knowledge.conversation_timeout", "can't be negative")
+	}
+
+	if c.STT.MatchThreshold < 0 || c.STT.MatchThreshold > 1 {
+		add("STT.match_threshold", "must be between 0 and 1")
+	}
+	if c.STT.DialogTimeout < 0 {
+		add("STT.dialog_timeout", "can't be negative")
+	}
+
+	if c.Server.Port != "" {
+		if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+			add("server.port", "must be a port number")
+		}
+	}
+	for _, san := range c.Server.CertSANs {
+		if strings.TrimSpace(san) == "" || (net.ParseIP(san) == nil && strings.ContainsAny(san, " /:*")) {
+			add("server.cert_sans", "%q isn't a hostname or IP", san)
+		}
+	}
+
+	checkTTS := func(field string, provider string) {
+		if !oneOf(provider, ttsProviders) {
+			add(field, "must be one of %s", strings.Join(ttsProviders, ", "))
+			return
+		}
+		switch provider {
+		case "http":
+			if !strings.HasPrefix(c.TTS.Endpoint, "http://") && !strings.HasPrefix(c.TTS.Endpoint, "https://") {
+				add("tts.endpoint", "the HTTP TTS server needs an endpoint starting with http:// or https://")
+			}
+		case "openai":
+			if strings.TrimSpace(c.TTS.Key) == "" && (c.Knowledge.Provider != "openai" || strings.TrimSpace(c.Knowledge.Key) == "") {
+				add("tts.key", "OpenAI's voices need an API key")
+			}
+		}
+	}
+	if c.TTS.Provider != "" {
+		checkTTS("tts.provider", c.TTS.Provider)
+	}
+	for language, voice := range c.TTS.LanguageVoices {
+		checkTTS("tts.language_voices."+language, voice.Provider)
+	}
+	for esn, voice := range c.TTS.RobotVoices {
+		checkTTS("tts.robot_voices."+esn, voice.Provider)
+	}
+	if c.TTS.CacheSize < 0 {
+		add("tts.cache_size", "can't be negative")
+	}
+
+	if c.History.MaxDays < 0 {
+		add("history.max_days", "can't be negative")
+	}
+	if c.History.MaxRequests < 0 {
+		add("history.max_requests", "can't be negative")
+	}
+	return errs
+}
+
+// ConfigValidationError is a config which can't be used
+type ConfigValidationError struct {
+	Errors []ConfigError
+}
+
+func (e *ConfigValidationError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return "the config isn't valid: " + strings.Join(msgs, "; ")
+}
+
+// UpdateConfig makes a change to APIConfig and saves it. if the change makes a setting invalid, it's undone.
+// settings which were invalid before don't stop other ones from being changed
+func UpdateConfig(change func()) error {
+	old := APIConfig
+	before := make(map[ConfigError]bool)
+	for _, err := range ValidateConfig(old) {
+		before[err] = true
+	}
+	change()
+	var errs []ConfigError
+	for _, err := range ValidateConfig(APIConfig) {
+		if !before[err] {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		APIConfig = old
+		return &ConfigValidationError{Errors: errs}
+	}
+	WriteConfigToDisk()
+	return nil
+}
+
+// problems found while reading the config at startup, which ValidateConfig can't see afterwards
+// (the stored config not parsing, env vars with invalid values)
+var configLoadErrors []ConfigError
+
+// ConfigStatus is what the web interface shows about the config
+type ConfigStatus struct {
+	Version int           `json:"version"`
+	Errors  []ConfigError `json:"errors"`
+	// env vars which set a setting at startup (configenv.go). changing those settings in the web interface isn't saved
+	EnvOverrides []string `json:"env_overrides"`
+}
+
+// GetConfigStatus validates the config as it is now
+func GetConfigStatus() ConfigStatus {
+	errs := append([]ConfigError{}, configLoadErrors...)
+	errs = append(errs, ValidateConfig(APIConfig)...)
+	return ConfigStatus{
+		Version:      APIConfig.Version,
+		Errors:       errs,
+		EnvOverrides: append([]string{}, envOverrides...),
+	}
+}
+
+// IsWebServer says if the URL is wire-pod's web interface, on this machine and WebPort
+func IsWebServer(endpoint string) bool {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if port != WebPort {
+		return false
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	if hostname, err := os.Hostname(); err == nil && (strings.EqualFold(host, hostname) || strings.EqualFold(host, hostname+".local")) {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfig reads a stored or exported config and brings it up to ConfigVersion
+func parseConfig(data []byte) (apiConfig, []string, error) {
+	var c apiConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, nil, err
+	}
+	done, err := migrateConfig(&c)
+	return c, done, err
+}
@@ -0,0 +1,328 @@
+package vars
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func useMemoryConfig(t *testing.T) {
+	t.Helper()
+	config := APIConfig
+	intents := CustomIntents()
+	t.Cleanup(func() {
+		APIConfig = config
+		setCustomIntents(intents)
+		BotInfo.set(RobotInfoStore{})
+		envOverrides = nil
+		storedConfig = apiConfig{}
+		configLoadErrors = nil
+	})
+	DB = NewMemoryStore()
+	APIConfig = apiConfig{}
+	setCustomIntents(nil)
+}
+
+func storeConfig(t *testing.T, config string) {
+	t.Helper()
+	err := DB.Update(func(tx StoreTx) error {
+		return tx.Put(bucketConfig, keyAPIConfig, []byte(config))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigMigrations(t *testing.T) {
+	useMemoryConfig(t)
+	t.Setenv("STT_SERVICE", "")
+	t.Setenv("DDL_RPC_PORT", "443")
+	// a config from before it was versioned
+	storeConfig(t, `{"knowledge":{"enable":true,"provider":"together","key":"k","model":"meta-llama/Llama-2-70b-chat-hf"},"server":{"port":"8084"}}`)
+	ReadConfig()
+	if APIConfig.Version != ConfigVersion {
+		t.Errorf("config is version %d, want %d", APIConfig.Version, ConfigVersion)
+	}
+	if APIConfig.Knowledge.Model != "meta-llama/Llama-3-70b-chat-hf" {
+		t.Errorf("model wasn't migrated: %s", APIConfig.Knowledge.Model)
+	}
+	if !APIConfig.HasReadFromEnv || !APIConfig.PastInitialSetup {
+		t.Error("a config from before the web interface should be past the initial setup")
+	}
+	var stored apiConfig
+	DB.View(func(tx StoreTx) error {
+		_, err := StoreGetJSON(tx, bucketConfig, keyAPIConfig, &stored)
+		return err
+	})
+	if stored.Version != ConfigVersion {
+		t.Error("the migrated config wasn't saved")
+	}
+
+	// migrations which already ran don't run again
+	storeConfig(t, `{"version":1,"knowledge":{"model":"meta-llama/Llama-2-70b-chat-hf"},"server":{"port":"8084"}}`)
+	ReadConfig()
+	if APIConfig.HasReadFromEnv {
+		t.Error("the first migration ran on a version 1 config")
+	}
+	if APIConfig.Knowledge.Model != "meta-llama/Llama-3-70b-chat-hf" {
+		t.Error("the second migration didn't run on a version 1 config")
+	}
+
+	// a config from a newer wire-pod is used, but not saved over
+	newer := `{"version":1000,"weather":{"enable":true,"provider":"weatherapi.com","key":"k"}}`
+	storeConfig(t, newer)
+	ReadConfig()
+	if !APIConfig.Weather.Enable || len(GetConfigStatus().Errors) != 1 {
+		t.Errorf("newer config: weather enabled %v, errors %v", APIConfig.Weather.Enable, GetConfigStatus().Errors)
+	}
+	DB.View(func(tx StoreTx) error {
+		if string(tx.Get(bucketConfig, keyAPIConfig)) != newer {
+			t.Error("a config from a newer version was saved over")
+		}
+		return nil
+	})
+}
+
+func TestUnreadableConfig(t *testing.T) {
+	useMemoryConfig(t)
+	t.Setenv("STT_SERVICE", "")
+	broken := `{"weather":{"enable":true,`
+	storeConfig(t, broken)
+	ReadConfig()
+	if APIConfig.Weather.Enable || APIConfig.Knowledge.Enable {
+		t.Error("weather and the knowledge graph should be off")
+	}
+	errs := GetConfigStatus().Errors
+	if len(errs) == 0 || !strings.Contains(errs[0].Message, "couldn't be read") {
+		t.Errorf("no error for the web interface: %v", errs)
+	}
+	DB.View(func(tx StoreTx) error {
+		if string(tx.Get(bucketConfig, keyAPIConfigUnreadable)) != broken || string(tx.Get(bucketConfig, keyAPIConfig)) != broken {
+			t.Error("the unreadable config wasn't kept")
+		}
+		return nil
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	var c apiConfig
+	if errs := ValidateConfig(c); len(errs) != 0 {
+		t.Errorf("the empty config should be valid, got %v", errs)
+	}
+	// the only provider without a key
+	c.Weather.Enable = true
+	c.Weather.Provider = "open-meteo.com"
+	c.Knowledge.Enable = true
+	c.Knowledge.Provider = "houndify"
+	c.Knowledge.Endpoint = "localhost:11434"
+	c.STT.MatchThreshold = 1.5
+	c.Server.Port = "http"
+	c.TTS.Provider = "http"
+	c.TTS.RobotVoices = map[string]TTSVoice{"00e20000": {Provider: "espeak"}}
+	c.History.MaxDays = -1
+	want := []string{"knowledge.id", "knowledge.key", "knowledge.endpoint", "STT.match_threshold", "server.port", "tts.endpoint", "tts.robot_voices.00e20000", "history.max_days"}
+	errs := ValidateConfig(c)
+	if len(errs) != len(want) {
+		t.Fatalf("got %v, want errors for %v", errs, want)
+	}
+	for i, err := range errs {
+		if err.Field != want[i] {
+			t.Errorf("error %d is for %s, want %s", i, err.Field, want[i])
+		}
+	}
+
+	// llama.cpp has no default endpoint, and its own default is the web interface
+	port := WebPort
+	t.Cleanup(func() { WebPort = port })
+	WebPort = "8080"
+	for endpoint, ok := range map[string]bool{
+		"":                        false,
+		"http://localhost:8080":   false,
+		"http://0.0.0.0:8080/v1":  false,
+		"http://localhost:8081":   true,
+		"http://192.0.2.10:8080/": true,
+	} {
+		c := apiConfig{}
+		c.Knowledge.Enable = true
+		c.Knowledge.Provider = "llamacpp"
+		c.Knowledge.Endpoint = endpoint
+		if errs := ValidateConfig(c); (len(errs) == 0) != ok {
+			t.Errorf("llama.cpp at %q: got %v", endpoint, errs)
+		}
+	}
+}
+
+func TestConfigEnvOverrides(t *testing.T) {
+	useMemoryConfig(t)
+	t.Setenv("STT_SERVICE", "")
+	storeConfig(t, `{"version":2,"knowledge":{"enable":true,"provider":"openai","key":"stored"}}`)
+	t.Setenv("WIREPOD_KNOWLEDGE_KEY", "from-env")
+	t.Setenv("WIREPOD_KNOWLEDGE_OPENAI_PROMPT", "be brief")
+	t.Setenv("WIREPOD_SERVER_CERT_SANS", "vector.local, 10.0.0.2")
+	t.Setenv("WIREPOD_STT_MATCH_THRESHOLD", "0.7")
+	t.Setenv("WIREPOD_HISTORY_ENABLE", "true")
+	t.Setenv("WIREPOD_HISTORY_MAX_DAYS", "soon")
+	ReadConfig()
+	if APIConfig.Knowledge.Key != "from-env" || APIConfig.Knowledge.OpenAIPrompt != "be brief" || APIConfig.STT.MatchThreshold != 0.7 || !APIConfig.History.Enable {
+		t.Errorf("env vars weren't applied: %+v", APIConfig)
+	}
+	if sans := APIConfig.Server.CertSANs; len(sans) != 2 || sans[1] != "10.0.0.2" {
+		t.Errorf("cert_sans = %v", sans)
+	}
+	status := GetConfigStatus()
+	if len(status.EnvOverrides) != 5 {
+		t.Errorf("overrides = %v, want 5", status.EnvOverrides)
+	}
+	if len(status.Errors) != 1 || status.Errors[0].Field != "history.max_days" {
+		t.Errorf("errors = %v, want one for history.max_days", status.Errors)
+	}
+
+	// the key from the env var isn't saved or exported, even after another change is
+	if err := UpdateConfig(func() { APIConfig.Knowledge.Model = "gpt-4o" }); err != nil {
+		t.Fatal(err)
+	}
+	var stored apiConfig
+	DB.View(func(tx StoreTx) error {
+		_, err := StoreGetJSON(tx, bucketConfig, keyAPIConfig, &stored)
+		return err
+	})
+	if stored.Knowledge.Key != "stored" || stored.Knowledge.OpenAIPrompt != "" || stored.Knowledge.Model != "gpt-4o" {
+		t.Errorf("stored knowledge = %+v", stored.Knowledge)
+	}
+	if exported := ExportConfig().Config; exported.Knowledge.Key != "stored" || exported.History.Enable {
+		t.Errorf("exported knowledge = %+v", exported.Knowledge)
+	}
+	// without the env var, it's the stored key again
+	os.Unsetenv("WIREPOD_KNOWLEDGE_KEY")
+	ReadConfig()
+	if APIConfig.Knowledge.Key != "stored" {
+		t.Errorf("key = %q after the env var was removed", APIConfig.Knowledge.Key)
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	useMemoryConfig(t)
+	// already invalid, which shouldn't stop the weather from being set up
+	APIConfig.Knowledge.Enable = true
+	APIConfig.Knowledge.Provider = "openai"
+	err := UpdateConfig(func() {
+		APIConfig.Weather.Enable = true
+		APIConfig.Weather.Provider = "weatherapi.com"
+	})
+	var invalid *ConfigValidationError
+	if !errors.As(err, &invalid) || len(invalid.Errors) != 1 || invalid.Errors[0].Field != "weather.key" {
+		t.Fatalf("got %v, want an error for weather.key", err)
+	}
+	if APIConfig.Weather.Enable {
+		t.Error("the invalid change wasn't undone")
+	}
+	err = UpdateConfig(func() {
+		APIConfig.Weather.Enable = true
+		APIConfig.Weather.Provider = "weatherapi.com"
+		APIConfig.Weather.Key = "k"
+	})
+	if err != nil || !APIConfig.Weather.Enable {
+		t.Errorf("a valid change didn't work: %v", err)
+	}
+}
+
+// CONFIG.md documents every env var
+func TestConfigEnvVarsDocumented(t *testing.T) {
+	doc, err := os.ReadFile("../../CONFIG.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, env := range ConfigEnvVars() {
+		if seen[env.Name] {
+			t.Errorf("%s is used for more than one setting", env.Name)
+		}
+		seen[env.Name] = true
+		if !strings.Contains(string(doc), "`"+env.Name+"`") {
+			t.Errorf("%s (%s) isn't in CONFIG.md", env.Name, env.Field)
+		}
+	}
+	// and nothing which isn't one, like the tts maps
+	for _, name := range regexp.MustCompile("`(WIREPOD_[A-Z0-9_]+)`").FindAllStringSubmatch(string(doc), -1) {
+		if !seen[name[1]] {
+			t.Errorf("%s is in CONFIG.md but isn't an env var", name[1])
+		}
+	}
+}
+
+func TestConfigExportImport(t *testing.T) {
+	useMemoryConfig(t)
+	APIConfig.Version = ConfigVersion
+	APIConfig.Weather.Enable = true
+	APIConfig.Weather.Provider = "openweathermap.org"
+	APIConfig.Weather.Key = "k"
+	setCustomIntents(IntentsStruct{{Name: "lights", Utterances: []string{"lights on"}, Intent: "intent_imperative_lights"}})
+	BotInfo.set(RobotInfoStore{GlobalGUID: "guid", Robots: []RobotInfo{{Esn: "00e20145", IPAddress: "10.0.0.5"}}})
+	exported, err := json.Marshal(ExportConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new machine
+	useMemoryConfig(t)
+	BotInfo.set(RobotInfoStore{})
+	if err := ImportConfig(exported); err != nil {
+		t.Fatal(err)
+	}
+	if APIConfig.Weather.Provider != "openweathermap.org" || len(CustomIntents()) != 1 {
+		t.Errorf("config or intents weren't imported: %+v %+v", APIConfig.Weather, CustomIntents())
+	}
+	if robots := BotInfo.Robots(); len(robots) != 1 || robots[0].IPAddress != "10.0.0.5" {
+		t.Errorf("bot info wasn't imported: %+v", robots)
+	}
+	LoadBotInfo()
+	if BotInfo.Snapshot().GlobalGUID != "guid" {
+		t.Error("bot info wasn't saved")
+	}
+
+	// an invalid config changes nothing
+	invalid := []byte(`{"config":{"weather":{"enable":true,"provider":"yahoo"}},"custom_intents":[]}`)
+	err = ImportConfig(invalid)
+	var importErr *ConfigValidationError
+	if !errors.As(err, &importErr) || len(importErr.Errors) != 2 {
+		t.Fatalf("got %v, want errors for weather.provider and weather.key", err)
+	}
+	if APIConfig.Weather.Provider != "openweathermap.org" || len(CustomIntents()) != 1 {
+		t.Error("an invalid import changed the config")
+	}
+	if err := ImportConfig([]byte(`{"custom_intents":[]}`)); err == nil {
+		t.Error("an import without a config worked")
+	}
+}
+
+func TestUpdateCustomIntents(t *testing.T) {
+	useMemoryConfig(t)
+	lights := CustomIntent{Name: "lights", Utterances: []string{"lights on"}, Intent: "intent_imperative_lights"}
+	err := UpdateCustomIntents(func(intents IntentsStruct) (IntentsStruct, error) {
+		return append(intents, lights), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := CustomIntents()
+
+	// an empty utterance would match everything
+	broken := CustomIntent{Name: "broken", Utterances: []string{" "}, Intent: "intent_imperative_lights"}
+	err = UpdateCustomIntents(func(intents IntentsStruct) (IntentsStruct, error) {
+		intents[0].Name = "changed"
+		return append(intents, broken), nil
+	})
+	if err == nil {
+		t.Fatal("an invalid custom intent was added")
+	}
+	if len(CustomIntents()) != 1 || CustomIntents()[0].Name != "lights" || before[0].Name != "lights" {
+		t.Errorf("a failed update changed the custom intents: %+v", CustomIntents())
+	}
+	LoadCustomIntents()
+	if len(CustomIntents()) != 1 {
+		t.Errorf("the custom intents weren't saved: %+v", CustomIntents())
+	}
+}
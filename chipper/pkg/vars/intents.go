@@ -0,0 +1,112 @@
+package vars
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// the intent data (intent-data/<lang>.json) and the custom intents. requests use them while they're being reloaded
+// or edited (preqs/reload.go), so they're never changed in place: a change makes new ones and swaps them in,
+// and a request which got the old ones keeps using them until it's done
+
+var (
+	intentList    []JsonIntent
+	customIntents IntentsStruct
+	intentsMu     sync.RWMutex
+	// held while the custom intents are being changed, so two changes can't start from the same ones
+	customIntentsUpdateMu sync.Mutex
+)
+
+// Intents returns the intent data in use. it mustn't be changed
+func Intents() []JsonIntent {
+	intentsMu.RLock()
+	defer intentsMu.RUnlock()
+	return intentList
+}
+
+// SetIntents swaps in new intent data
+func SetIntents(intents []JsonIntent) {
+	intentsMu.Lock()
+	defer intentsMu.Unlock()
+	intentList = intents
+}
+
+// CustomIntents returns the custom intents in use. they mustn't be changed, see UpdateCustomIntents
+func CustomIntents() IntentsStruct {
+	intentsMu.RLock()
+	defer intentsMu.RUnlock()
+	return customIntents
+}
+
+func setCustomIntents(intents IntentsStruct) {
+	intentsMu.Lock()
+	defer intentsMu.Unlock()
+	customIntents = intents
+}
+
+// UpdateCustomIntents gives fn a copy of the custom intents to change. what it returns is checked, saved and swapped in.
+// if fn returns an error or the custom intents aren't valid, nothing changes
+func UpdateCustomIntents(fn func(intents IntentsStruct) (IntentsStruct, error)) error {
+	customIntentsUpdateMu.Lock()
+	defer customIntentsUpdateMu.Unlock()
+	intents, err := fn(append(IntentsStruct{}, CustomIntents()...))
+	if err != nil {
+		return err
+	}
+	return ReplaceCustomIntents(intents)
+}
+
+// ReplaceCustomIntents checks, saves and swaps in a whole new set of custom intents
+func ReplaceCustomIntents(intents IntentsStruct) error {
+	if errs := ValidateCustomIntents(intents); len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	err := DB.Update(func(tx StoreTx) error {
+		return StorePutJSON(tx, bucketCustomIntents, keyCustomIntents, intents)
+	})
+	if err != nil {
+		return err
+	}
+	setCustomIntents(intents)
+	return nil
+}
+
+// ValidateCustomIntents finds custom intents which can't work
+func ValidateCustomIntents(intents IntentsStruct) []error {
+	var errs []error
+	for i, intent := range intents {
+		name := intent.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+			errs = append(errs, fmt.Errorf("custom intent %s has no name", name))
+		}
+		if len(intent.Utterances) == 0 {
+			errs = append(errs, fmt.Errorf("custom intent %s has no utterances", name))
+		}
+		for _, utterance := range intent.Utterances {
+			// an empty one would match everything
+			if strings.TrimSpace(utterance) == "" {
+				errs = append(errs, fmt.Errorf("custom intent %s has an empty utterance", name))
+				break
+			}
+		}
+		if intent.IsSystemIntent {
+			// system intents say which intent to send when they run
+			if intent.Exec == "" {
+				errs = append(errs, fmt.Errorf("custom intent %s is a system intent without anything to run", name))
+			}
+		} else if intent.Intent == "" {
+			errs = append(errs, fmt.Errorf("custom intent %s has no intent to send", name))
+		}
+	}
+	return errs
+}
+
+func joinErrors(errs []error) error {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
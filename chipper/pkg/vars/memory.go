@@ -0,0 +1,324 @@
+package vars
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+)
+
+// what the LLM remembers about each robot's conversations. every robot gets a directory in MemoryDir with:
+//
+//	history.jsonl - every message, one per line. only appended to, unless the history is edited in the web interface
+//	memory.json   - the summary of the older messages, and the facts
+//
+// ttr decides what gets summarized and when (ttr/memory.go).
+
+const (
+	memoryHistoryName = "history.jsonl"
+	memoryInfoName    = "memory.json"
+)
+
+// MemoryMessage is one message in a robot's history
+type MemoryMessage struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+}
+
+// RobotMemory is everything remembered about a robot's conversations
+type RobotMemory struct {
+	ESN string `json:"esn"`
+	// written by the LLM, covers the first Summarized messages of the history
+	Summary    string `json:"summary"`
+	Summarized int    `json:"summarized"`
+	// named long-term facts ("owner_name": "Sam")
+	Facts   map[string]string `json:"facts"`
+	Updated time.Time         `json:"updated"`
+	// kept in history.jsonl, not memory.json
+	History []MemoryMessage `json:"history,omitempty"`
+}
+
+var memories = make(map[string]*RobotMemory)
+var memoriesMu sync.Mutex
+
+var esnRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+func memoryDir(esn string) (string, error) {
+	if !esnRegex.MatchString(esn) {
+		return "", errors.New("invalid esn: " + esn)
+	}
+	return filepath.Join(MemoryDir, esn), nil
+}
+
+func copyMemory(mem *RobotMemory) RobotMemory {
+	c := *mem
+	c.Facts = make(map[string]string)
+	for name, value := range mem.Facts {
+		c.Facts[name] = value
+	}
+	c.History = append([]MemoryMessage{}, mem.History...)
+	return c
+}
+
+// loads a robot's memory from disk if it isn't cached. memoriesMu has to be held
+func loadMemory(esn string) (*RobotMemory, error) {
+	if mem, ok := memories[esn]; ok {
+		return mem, nil
+	}
+	dir, err := memoryDir(esn)
+	if err != nil {
+		return nil, err
+	}
+	mem := &RobotMemory{
+		ESN:   esn,
+		Facts: make(map[string]string),
+	}
+	if file, err := os.ReadFile(filepath.Join(dir, memoryInfoName)); err == nil {
+		if err := json.Unmarshal(file, mem); err != nil {
+			logger.Println("Memory for " + esn + " is corrupted, starting over: " + err.Error())
+		}
+		mem.ESN = esn
+		if mem.Facts == nil {
+			mem.Facts = make(map[string]string)
+		}
+	}
+	mem.History = nil
+	if file, err := os.Open(filepath.Join(dir, memoryHistoryName)); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var msg MemoryMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				// probably a line which was being written when wire-pod stopped
+				continue
+			}
+			mem.History = append(mem.History, msg)
+		}
+		file.Close()
+	}
+	if mem.Summarized > len(mem.History) {
+		mem.Summarized = len(mem.History)
+	}
+	memories[esn] = mem
+	return mem, nil
+}
+
+func saveMemoryInfo(mem *RobotMemory) error {
+	dir, err := memoryDir(mem.ESN)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	info := *mem
+	info.History = nil
+	marshalled, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// write then rename, so memory.json is never half-written
+	tmp := filepath.Join(dir, memoryInfoName+".tmp")
+	if err := os.WriteFile(tmp, marshalled, 0777); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, memoryInfoName))
+}
+
+func writeMemoryHistory(mem *RobotMemory) error {
+	dir, err := memoryDir(mem.ESN)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	var lines []byte
+	for _, msg := range mem.History {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	tmp := filepath.Join(dir, memoryHistoryName+".tmp")
+	if err := os.WriteFile(tmp, lines, 0777); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, memoryHistoryName))
+}
+
+// GetMemory returns a copy of a robot's memory. it's empty if nothing has been remembered yet
+func GetMemory(esn string) (RobotMemory, error) {
+	memoriesMu.Lock()
+	defer memoriesMu.Unlock()
+	mem, err := loadMemory(esn)
+	if err != nil {
+		return RobotMemory{}, err
+	}
+	return copyMemory(mem), nil
+}
+
+// AppendMemory adds messages to the end of a robot's history
+func AppendMemory(esn string, msgs ...MemoryMessage) error {
+	memoriesMu.Lock()
+	defer memoriesMu.Unlock()
+	mem, err := loadMemory(esn)
+	if err != nil {
+		return err
+	}
+	dir, _ := memoryDir(esn)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, memoryHistoryName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, msg := range msgs {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		mem.History = append(mem.History, msg)
+	}
+	mem.Updated = time.Now()
+	return saveMemoryInfo(mem)
+}
+
+// SetMemorySummary replaces the summary, which now covers the first summarized messages of the history
+func SetMemorySummary(esn string, summary string, summarized int) error {
+	memoriesMu.Lock()
+	defer memoriesMu.Unlock()
+	mem, err := loadMemory(esn)
+	if err != nil {
+		return err
+	}
+	if summarized > len(mem.History) {
+		return errors.New("the summary can't cover more messages than there are")
+	}
+	mem.Summary = strings.TrimSpace(summary)
+	mem.Summarized = summarized
+	mem.Updated = time.Now()
+	return saveMemoryInfo(mem)
+}
+
+// SetMemoryFact remembers a fact about a robot's owner or surroundings. an empty value forgets it
+func SetMemoryFact(esn string, name string, value string) error {
+	memoriesMu.Lock()
+	defer memoriesMu.Unlock()
+	mem, err := loadMemory(esn)
+	if err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("a fact needs a name")
+	}
+	if strings.TrimSpace(value) == "" {
+		delete(mem.Facts, name)
+	} else {
+		mem.Facts[name] = strings.TrimSpace(value)
+	}
+	mem.Updated = time.Now()
+	return saveMemoryInfo(mem)
+}
+
+// ReplaceMemory overwrites everything remembered about mem.ESN, for edits from the web interface
+func ReplaceMemory(mem RobotMemory) error {
+	memoriesMu.Lock()
+	defer memoriesMu.Unlock()
+	if _, err := memoryDir(mem.ESN); err != nil {
+		return err
+	}
+	newMem := copyMemory(&mem)
+	if newMem.Summarized > len(newMem.History) || newMem.Summarized < 0 {
+		newMem.Summarized = len(newMem.History)
+	}
+	newMem.Updated = time.Now()
+	if err := writeMemoryHistory(&newMem); err != nil {
+		return err
+	}
+	if err := saveMemoryInfo(&newMem); err != nil {
+		return err
+	}
+	memories[mem.ESN] = &newMem
+	return nil
+}
+
+// DeleteMemory forgets everything about a robot's conversations
+func DeleteMemory(esn string) error {
+	memoriesMu.Lock()
+	defer memoriesMu.Unlock()
+	dir, err := memoryDir(esn)
+	if err != nil {
+		return err
+	}
+	delete(memories, esn)
+	return os.RemoveAll(dir)
+}
+
+// DeleteAllMemory forgets every robot's conversations
+func DeleteAllMemory() error {
+	memoriesMu.Lock()
+	defer memoriesMu.Unlock()
+	memories = make(map[string]*RobotMemory)
+	return os.RemoveAll(MemoryDir)
+}
+
+// MemoryRobots returns the ESNs of every robot with something remembered, sorted
+func MemoryRobots() []string {
+	var esns []string
+	entries, _ := os.ReadDir(MemoryDir)
+	for _, entry := range entries {
+		if entry.IsDir() && esnRegex.MatchString(entry.Name()) {
+			esns = append(esns, entry.Name())
+		}
+	}
+	sort.Strings(esns)
+	return esns
+}
+
+// moves the chats from openaiChats.json (from before there was a memory store) into the store
+func importRememberedChats() {
+	file, err := os.ReadFile(SavedChatsPath)
+	if err != nil {
+		return
+	}
+	var chats []RememberedChat
+	if err := json.Unmarshal(file, &chats); err != nil {
+		logger.Println("Couldn't import " + SavedChatsPath + ": " + err.Error())
+		return
+	}
+	for _, chat := range chats {
+		if mem, err := GetMemory(chat.ESN); err != nil || len(mem.History) > 0 {
+			continue
+		}
+		var msgs []MemoryMessage
+		for _, msg := range chat.Chats {
+			msgs = append(msgs, MemoryMessage{
+				Role:    msg.Role,
+				Content: msg.Content,
+			})
+		}
+		if err := AppendMemory(chat.ESN, msgs...); err != nil {
+			logger.Println("Couldn't import the chats for " + chat.ESN + ": " + err.Error())
+			return
+		}
+	}
+	logger.Println("Imported saved chats into the memory store")
+	os.Rename(SavedChatsPath, SavedChatsPath+".imported")
+}
@@ -0,0 +1,346 @@
+package vars
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	bolt "go.etcd.io/bbolt"
+)
+
+// where wire-pod keeps its state: jdocs, bot info, custom intents and the API config.
+// everything goes through the Store interface. the real one is a bbolt database at DatabasePath,
+// so every change is one transaction and a crash can't leave a half-written file.
+// until OpenStore is called (and if the database can't be opened) it's an in-memory store.
+//
+// the globals (APIConfig, BotInfo, CustomIntents) are still what everything reads, the store is what they get saved to.
+//
+// the first time the database is opened, the old JSON files get imported and renamed to *.imported.
+
+// buckets
+const (
+	bucketMeta          = "meta"
+	bucketJdocs         = "jdocs"
+	bucketBotInfo       = "botinfo"
+	bucketRecurringInfo = "rinfo"
+	bucketConfig        = "config"
+	bucketCustomIntents = "customintents"
+)
+
+// keys
+const (
+	keyImported   = "json_imported"
+	keyGlobalGUID = "global_guid"
+	keyAPIConfig  = "api"
+	// a stored config which couldn't be read, kept so it can be fixed by hand
+	keyAPIConfigUnreadable = "api_unreadable"
+	keyCustomIntents       = "intents"
+)
+
+// StoreTx is a transaction. from View it can only read
+type StoreTx interface {
+	// nil if the key doesn't exist
+	Get(bucket, key string) []byte
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	// in key order
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+}
+
+// Store is a transactional key-value store, split up into buckets
+type Store interface {
+	View(fn func(tx StoreTx) error) error
+	// everything fn does is saved if it returns nil, and thrown away if it doesn't
+	Update(fn func(tx StoreTx) error) error
+	Close() error
+}
+
+// DB is the store everything is saved in
+var DB Store = NewMemoryStore()
+
+// OpenStore opens the database at DatabasePath and imports the JSON files if it's new
+func OpenStore() {
+	store, err := NewBoltStore(DatabasePath)
+	if err != nil {
+		logger.Println("Couldn't open " + DatabasePath + ", nothing will be saved until it can be: " + err.Error())
+		return
+	}
+	DB = store
+	importJSONFiles()
+}
+
+// StoreGetJSON unmarshals a value into v. false if it doesn't exist
+func StoreGetJSON(tx StoreTx, bucket, key string, v interface{}) (bool, error) {
+	value := tx.Get(bucket, key)
+	if value == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+// StorePutJSON marshals v and puts it
+func StorePutJSON(tx StoreTx, bucket, key string, v interface{}) error {
+	marshalled, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Put(bucket, key, marshalled)
+}
+
+// bbolt
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// NewBoltStore opens (or creates) a bbolt database
+func NewBoltStore(path string) (Store, error) {
+	// if another wire-pod has it open, fail instead of waiting forever
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (t *boltTx) Get(bucket, key string) []byte {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	value := b.Get([]byte(key))
+	if value == nil {
+		return nil
+	}
+	// bbolt's slices are only good until the transaction ends
+	return append([]byte{}, value...)
+}
+
+func (t *boltTx) Put(bucket, key string, value []byte) error {
+	b, err := t.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), value)
+}
+
+func (t *boltTx) Delete(bucket, key string) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
+}
+
+func (t *boltTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, v []byte) error {
+		return fn(string(k), append([]byte{}, v...))
+	})
+}
+
+// in memory, for before the database is opened and for tests
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// changes are made to a copy of the buckets they touch, and only replace the real ones if the transaction succeeds
+type memoryTx struct {
+	store    *memoryStore
+	writable bool
+	changed  map[string]map[string][]byte
+}
+
+// NewMemoryStore makes a store which only lives as long as wire-pod runs
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) View(fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) Update(fn func(tx StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &memoryTx{store: s, writable: true, changed: make(map[string]map[string][]byte)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for name, bucket := range tx.changed {
+		s.buckets[name] = bucket
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (t *memoryTx) bucket(name string) map[string][]byte {
+	if b, ok := t.changed[name]; ok {
+		return b
+	}
+	return t.store.buckets[name]
+}
+
+// returns a copy of the bucket which can be changed
+func (t *memoryTx) writeBucket(name string) (map[string][]byte, error) {
+	if !t.writable {
+		return nil, os.ErrPermission
+	}
+	if b, ok := t.changed[name]; ok {
+		return b, nil
+	}
+	b := make(map[string][]byte)
+	for k, v := range t.store.buckets[name] {
+		b[k] = v
+	}
+	t.changed[name] = b
+	return b, nil
+}
+
+func (t *memoryTx) Get(bucket, key string) []byte {
+	value, ok := t.bucket(bucket)[key]
+	if !ok {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+func (t *memoryTx) Put(bucket, key string, value []byte) error {
+	b, err := t.writeBucket(bucket)
+	if err != nil {
+		return err
+	}
+	b[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (t *memoryTx) Delete(bucket, key string) error {
+	b, err := t.writeBucket(bucket)
+	if err != nil {
+		return err
+	}
+	delete(b, key)
+	return nil
+}
+
+func (t *memoryTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	b := t.bucket(bucket)
+	var keys []string
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, append([]byte{}, b[k]...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jdocs are keyed by thing and name
+func jdocKey(thing, name string) string {
+	return thing + "/" + name
+}
+
+// moves the JSON files from before there was a database into it. only runs once
+func importJSONFiles() {
+	var imported bool
+	DB.View(func(tx StoreTx) error {
+		imported = tx.Get(bucketMeta, keyImported) != nil
+		return nil
+	})
+	if imported {
+		return
+	}
+	var files []string
+	err := DB.Update(func(tx StoreTx) error {
+		if file, err := os.ReadFile(JdocsPath); err == nil {
+			var jdocs []botjdoc
+			if err := json.Unmarshal(file, &jdocs); err != nil {
+				logger.Println("Couldn't import " + JdocsPath + ": " + err.Error())
+			} else {
+				for _, jdoc := range jdocs {
+					if err := StorePutJSON(tx, bucketJdocs, jdocKey(jdoc.Thing, jdoc.Name), jdoc); err != nil {
+						return err
+					}
+				}
+				files = append(files, JdocsPath)
+			}
+		}
+		if file, err := os.ReadFile(BotInfoPath); err == nil {
+			var info RobotInfoStore
+			if err := json.Unmarshal(file, &info); err != nil {
+				logger.Println("Couldn't import " + BotInfoPath + ": " + err.Error())
+			} else {
+				if err := putBotInfo(tx, info); err != nil {
+					return err
+				}
+				files = append(files, BotInfoPath)
+			}
+		}
+		if file, err := os.ReadFile(CustomIntentsPath); err == nil {
+			var intents IntentsStruct
+			if err := json.Unmarshal(file, &intents); err != nil {
+				logger.Println("Couldn't import " + CustomIntentsPath + ": " + err.Error())
+			} else {
+				if err := StorePutJSON(tx, bucketCustomIntents, keyCustomIntents, intents); err != nil {
+					return err
+				}
+				files = append(files, CustomIntentsPath)
+			}
+		}
+		if file, err := os.ReadFile(ApiConfigPath); err == nil {
+			// checked by ReadConfig like it always was
+			if err := tx.Put(bucketConfig, keyAPIConfig, file); err != nil {
+				return err
+			}
+			files = append(files, ApiConfigPath)
+		}
+		return tx.Put(bucketMeta, keyImported, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		logger.Println("Couldn't import the JSON files into the database: " + err.Error())
+		return
+	}
+	for _, file := range files {
+		os.Rename(file, file+".imported")
+	}
+	if len(files) > 0 {
+		logger.Println("Imported " + strings.Join(files, ", ") + " into " + DatabasePath)
+	}
+}
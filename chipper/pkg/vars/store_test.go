@@ -0,0 +1,143 @@
+package vars
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreUpdateRollback(t *testing.T) {
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "wirepod.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "bolt": bolt} {
+		err := store.Update(func(tx StoreTx) error {
+			tx.Put(bucketConfig, "a", []byte("1"))
+			return tx.Put(bucketConfig, "b", []byte("2"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed := errors.New("failed")
+		err = store.Update(func(tx StoreTx) error {
+			tx.Put(bucketConfig, "a", []byte("changed"))
+			tx.Delete(bucketConfig, "b")
+			tx.Put(bucketConfig, "c", []byte("3"))
+			tx.Put(bucketJdocs, "vic:00e20000/vic.RobotSettings", []byte("{}"))
+			// seen inside the transaction
+			if string(tx.Get(bucketConfig, "a")) != "changed" || tx.Get(bucketConfig, "b") != nil {
+				t.Errorf("%s: a transaction doesn't see its own changes", name)
+			}
+			return failed
+		})
+		if err != failed {
+			t.Errorf("%s: Update returned %v", name, err)
+		}
+		store.View(func(tx StoreTx) error {
+			var keys []string
+			tx.ForEach(bucketConfig, func(key string, value []byte) error {
+				keys = append(keys, key+"="+string(value))
+				return nil
+			})
+			if len(keys) != 2 || keys[0] != "a=1" || keys[1] != "b=2" {
+				t.Errorf("%s: after a failed update the config bucket is %v", name, keys)
+			}
+			if tx.Get(bucketJdocs, "vic:00e20000/vic.RobotSettings") != nil {
+				t.Errorf("%s: a failed update made a bucket", name)
+			}
+			if tx.Put(bucketConfig, "d", []byte("4")) == nil {
+				t.Errorf("%s: View could write", name)
+			}
+			return nil
+		})
+	}
+}
+
+func TestImportJSONFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := []*string{&JdocsPath, &BotInfoPath, &CustomIntentsPath, &ApiConfigPath, &DatabasePath}
+	old := make([]string, len(paths))
+	for i, path := range paths {
+		old[i] = *path
+	}
+	db := DB
+	t.Cleanup(func() {
+		for i, path := range paths {
+			*path = old[i]
+		}
+		DB.Close()
+		DB = db
+	})
+	JdocsPath = filepath.Join(dir, "jdocs.json")
+	BotInfoPath = filepath.Join(dir, "botSdkInfo.json")
+	CustomIntentsPath = filepath.Join(dir, "customIntents.json")
+	ApiConfigPath = filepath.Join(dir, "apiConfig.json")
+	DatabasePath = filepath.Join(dir, "wirepod.db")
+	files := map[string]string{
+		JdocsPath:         `[{"thing":"vic:00e20000","name":"vic.RobotSettings","jdoc":{"doc_version":3,"json_doc":"{}"}}]`,
+		BotInfoPath:       `{"global_guid":"guid","robots":[{"esn":"00e20000","ip_address":"10.0.0.5"}]}`,
+		CustomIntentsPath: `[{"name":"lights","utterances":["lights on"],"intent":"intent_imperative_praise"}]`,
+		ApiConfigPath:     `{"version":2,"weather":{"enable":false}}`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	OpenStore()
+	for path := range files {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't moved out of the way", path)
+		}
+		if _, err := os.Stat(path + ".imported"); err != nil {
+			t.Errorf("%s.imported: %v", path, err)
+		}
+	}
+	// what's in the database after the import, which a second one mustn't change
+	dump := func() map[string]string {
+		values := make(map[string]string)
+		DB.View(func(tx StoreTx) error {
+			for _, bucket := range []string{bucketJdocs, bucketBotInfo, bucketCustomIntents, bucketConfig} {
+				tx.ForEach(bucket, func(key string, value []byte) error {
+					values[bucket+"/"+key] = string(value)
+					return nil
+				})
+			}
+			return nil
+		})
+		return values
+	}
+	imported := dump()
+	for _, key := range []string{"jdocs/vic:00e20000/vic.RobotSettings", "botinfo/00e20000", "botinfo/global_guid", "customintents/intents", "config/api"} {
+		if imported[key] == "" {
+			t.Errorf("%s wasn't imported", key)
+		}
+	}
+	if imported["config/api"] != files[ApiConfigPath] {
+		t.Errorf("the config was changed on the way in: %s", imported["config/api"])
+	}
+
+	// an old wire-pod put a file back. it's only imported once, so it stays where it is
+	if err := os.WriteFile(BotInfoPath, []byte(`{"global_guid":"other","robots":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	importJSONFiles()
+	DB.Close()
+	OpenStore()
+	again := dump()
+	if len(again) != len(imported) {
+		t.Errorf("importing again changed the database: %v", again)
+	}
+	for key, value := range imported {
+		if again[key] != value {
+			t.Errorf("importing again changed %s to %s", key, again[key])
+		}
+	}
+	if _, err := os.Stat(BotInfoPath); err != nil {
+		t.Error("a file was moved by a second import")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/fforchino/vector-go-sdk/pkg/vector"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
@@ -44,6 +45,12 @@ var (
 	WhisperModelPath  string = "../whisper.cpp/models/"
 	SessionCertPath   string = "./session-certs/"
 	SavedChatsPath    string = "./openaiChats.json"
+	MemoryDir         string = "./memory"
+	HistoryDir        string = "./history"
+	GeocodeCachePath  string = "./geocode.json"
+	AuthPath          string = "./auth.json"
+	DatabasePath      string = "./wirepod.db"
+	LogPath           string = "./logs/wire-pod.log"
 	VersionFile       string = "./version"
 )
 
@@ -51,6 +58,9 @@ var (
 	OutboundIPTester = "8.8.8.8:80"
 	CertPath         = "../certs/cert.crt"
 	KeyPath          = "../certs/cert.key"
+	CACertPath       = "../certs/ca.crt"
+	CAKeyPath        = "../certs/ca.key"
+	JWTKeyPath       = "../certs/jwt.key"
 	ServerConfigPath = "../certs/server_config.json"
 	Certs            = "../certs"
 )
@@ -59,18 +69,15 @@ var WebPort string = "8080"
 
 // /home/name/.anki_vector/
 var SDKIniPath string
-var BotJdocs []botjdoc
-var BotInfo RobotInfoStore
-var CustomIntents IntentsStruct
-var CustomIntentsExist bool = false
+
+// held while sdk_config.ini is being changed, robots can authenticate at the same time
+var SDKIniMu sync.Mutex
 var DownloadedVoskModels []string
 var VoskGrammerEnable bool = false
 
 // here to prevent import cycle (localization restructure)
 var SttInitFunc func() error
 
-var IntentList []JsonIntent
-
 //var MatchListList [][]string
 // var IntentsList = []string{}
 
@@ -78,24 +85,23 @@ var ChipperCert []byte
 var ChipperKey []byte
 var ChipperKeysLoaded bool
 
-var RecurringInfo []RecurringInfoStore
-
+// the format of openaiChats.json, which only gets imported into the memory store now
 type RememberedChat struct {
 	ESN   string                         `json:"esn"`
 	Chats []openai.ChatCompletionMessage `json:"chats"`
 }
 
-var RememberedChats []RememberedChat
-
 type RobotInfoStore struct {
-	GlobalGUID string `json:"global_guid"`
-	Robots     []struct {
-		Esn       string `json:"esn"`
-		IPAddress string `json:"ip_address"`
-		// 192.168.1.150:443
-		GUID      string `json:"guid"`
-		Activated bool   `json:"activated"`
-	} `json:"robots"`
+	GlobalGUID string      `json:"global_guid"`
+	Robots     []RobotInfo `json:"robots"`
+}
+
+type RobotInfo struct {
+	Esn       string `json:"esn"`
+	IPAddress string `json:"ip_address"`
+	// 192.168.1.150:443
+	GUID      string `json:"guid"`
+	Activated bool   `json:"activated"`
 }
 
 type RecurringInfoStore struct {
@@ -111,9 +117,37 @@ type JsonIntent struct {
 	Name              string   `json:"name"`
 	Keyphrases        []string `json:"keyphrases"`
 	RequireExactMatch bool     `json:"requiresexact"`
+	// intent sent to the robot once the slots are filled, if it isn't Name
+	SendAs string       `json:"sendas,omitempty"`
+	Slots  []IntentSlot `json:"slots,omitempty"`
+}
+
+// IntentSlot is a parameter which gets pulled out of the spoken text. see ttr/intentslots.go for the types
+type IntentSlot struct {
+	// parameter name sent to the robot
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// enum: checked in order, the first value with a phrase in the text wins
+	Values []SlotValue `json:"values,omitempty"`
+	// text: the value is whatever comes after one of these
+	After []string `json:"after,omitempty"`
+	// text: how many words to keep, 0 for all of them
+	MaxWords int `json:"maxwords,omitempty"`
+	// sent if nothing was found. const slots always send this
+	Default string `json:"default,omitempty"`
+	// if nothing was found, the intent gets sent as-is without parameters
+	Required bool `json:"required,omitempty"`
+}
+
+type SlotValue struct {
+	Value string `json:"value"`
+	// phrases starting with str_ are localization keys
+	Phrases []string `json:"phrases"`
 }
 
-type IntentsStruct []struct {
+type IntentsStruct []CustomIntent
+
+type CustomIntent struct {
 	Name        string   `json:"name"`
 	Description string   `json:"description"`
 	Utterances  []string `json:"utterances"`
@@ -176,16 +210,29 @@ func Init() {
 		ApiConfigPath = join(podDir, ApiConfigPath)
 		CertPath = join(podDir, "./certs/cert.crt")
 		KeyPath = join(podDir, "./certs/cert.key")
+		CACertPath = join(podDir, "./certs/ca.crt")
+		CAKeyPath = join(podDir, "./certs/ca.key")
+		JWTKeyPath = join(podDir, "./certs/jwt.key")
 		ServerConfigPath = join(podDir, "./certs/server_config.json")
 		Certs = join(podDir, "./certs")
 		SessionCertPath = join(podDir, SessionCertPath)
 		SavedChatsPath = join(podDir, SavedChatsPath)
+		MemoryDir = join(podDir, MemoryDir)
+		HistoryDir = join(podDir, HistoryDir)
+		GeocodeCachePath = join(podDir, GeocodeCachePath)
+		AuthPath = join(podDir, AuthPath)
+		DatabasePath = join(podDir, DatabasePath)
+		LogPath = join(podDir, LogPath)
 		if runtime.GOOS == "android" {
 			VersionFile = AndroidPath + "/static/version"
 		}
 		os.Mkdir(JdocsDir, 0777)
 		os.Mkdir(SessionCertPath, 0777)
-		os.Mkdir(Certs, 0777)
+		os.Mkdir(Certs, 0755)
+	}
+
+	if err := logger.SetFile(LogPath); err != nil {
+		logger.Warn("Couldn't open the log file, only keeping the log in memory", "path", LogPath, "error", err)
 	}
 
 	if os.Getenv("WEBSERVER_PORT") != "" {
@@ -228,35 +275,32 @@ func Init() {
 	}
 	logger.Println("SDK info path: " + SDKIniPath)
 
+	// everything below is loaded from here (store.go)
+	OpenStore()
+
 	// load api config (config.go)
 	ReadConfig()
 
-	// load openai chats
-	LoadChats()
+	// chats from before the memory store (memory.go)
+	importRememberedChats()
 
 	// check models folder, add all models to DownloadedVoskModels
 	if APIConfig.STT.Service == "vosk" {
 		GetDownloadedVoskModels()
 	}
 
-	// load jdocs. if there are any in the old format, conver
-	if _, err := os.Stat(JdocsPath); err == nil {
-		jsonBytes, _ := os.ReadFile(JdocsPath)
-		json.Unmarshal(jsonBytes, &BotJdocs)
-		logger.Println("Loaded jdocs file")
-	}
-
 	// load bot sdk info
-	botBytes, err := os.ReadFile(BotInfoPath)
-	if err == nil {
-		json.Unmarshal(botBytes, &BotInfo)
+	if err := LoadBotInfo(); err != nil {
+		logger.Println("Failed to load bot info: " + err.Error())
+	} else if robots := BotInfo.Robots(); len(robots) > 0 {
 		var botList []string
-		for _, robot := range BotInfo.Robots {
+		for _, robot := range robots {
 			botList = append(botList, robot.Esn)
 		}
-		logger.Println("Loaded bot info file, known bots: " + fmt.Sprint(botList))
+		logger.Println("Loaded bot info, known bots: " + fmt.Sprint(botList))
 	}
 
+	LoadRInfo()
 	ReadSessionCerts()
 	LoadCustomIntents()
 	VarsInited = true
@@ -274,18 +318,26 @@ func GetDownloadedVoskModels() {
 }
 
 func LoadCustomIntents() {
-	jsonBytes, err := os.ReadFile(CustomIntentsPath)
-	if err == nil {
-		json.Unmarshal(jsonBytes, &CustomIntents)
-		CustomIntentsExist = true
+	var intents IntentsStruct
+	err := DB.View(func(tx StoreTx) error {
+		_, err := StoreGetJSON(tx, bucketCustomIntents, keyCustomIntents, &intents)
+		return err
+	})
+	if err != nil {
+		logger.Println("Failed to load custom intents: " + err.Error())
+		return
+	}
+	setCustomIntents(intents)
+	if len(intents) > 0 {
 		logger.Println("Loaded custom intents:")
-		for _, intent := range CustomIntents {
+		for _, intent := range intents {
 			logger.Println(intent.Name)
 		}
 	}
 }
 
-func LoadIntents() ([]JsonIntent, error) {
+// IntentsPath is the intent data for the STT language
+func IntentsPath() string {
 	var path string
 	if runtime.GOOS == "darwin" && Packaged {
 		appPath, _ := os.Executable()
@@ -295,7 +347,12 @@ func LoadIntents() ([]JsonIntent, error) {
 	} else {
 		path = "./"
 	}
-	jsonFile, err := os.ReadFile(path + "intent-data/" + APIConfig.STT.Language + ".json")
+	return path + "intent-data/" + APIConfig.STT.Language + ".json"
+}
+
+// LoadIntents reads the intent data for the STT language. it doesn't swap it in, see SetIntents
+func LoadIntents() ([]JsonIntent, error) {
+	jsonFile, err := os.ReadFile(IntentsPath())
 
 	// var matches [][]string
 	// var intents []string
@@ -316,30 +373,41 @@ func LoadIntents() ([]JsonIntent, error) {
 	return jsonIntents, err
 }
 
-func WriteJdocs() {
-	writeBytes, _ := json.Marshal(BotJdocs)
-	os.WriteFile(JdocsPath, writeBytes, 0644)
-}
-
-// removes a bot from jdocs file
+// removes a bot's jdocs
 func DeleteData(thing string) {
-	var newdocs []botjdoc
-	for _, jdocentry := range BotJdocs {
-		if jdocentry.Thing != thing {
-			newdocs = append(newdocs, jdocentry)
+	err := DB.Update(func(tx StoreTx) error {
+		var keys []string
+		tx.ForEach(bucketJdocs, func(key string, value []byte) error {
+			if strings.HasPrefix(key, thing+"/") {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		for _, key := range keys {
+			if err := tx.Delete(bucketJdocs, key); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		logger.Println("Failed to delete jdocs for " + thing + ": " + err.Error())
 	}
-	BotJdocs = newdocs
-	WriteJdocs()
 }
 
 func GetJdoc(thing, jdocname string) (AJdoc, bool) {
-	for _, botJdoc := range BotJdocs {
-		if botJdoc.Name == jdocname && botJdoc.Thing == thing {
-			return botJdoc.Jdoc, true
-		}
+	var botJdoc botjdoc
+	var exists bool
+	err := DB.View(func(tx StoreTx) error {
+		var err error
+		exists, err = StoreGetJSON(tx, bucketJdocs, jdocKey(thing, jdocname), &botJdoc)
+		return err
+	})
+	if err != nil {
+		logger.Println("Failed to read jdoc " + jdocname + " for " + thing + ": " + err.Error())
+		return AJdoc{}, false
 	}
-	return AJdoc{}, false
+	return botJdoc.Jdoc, exists
 }
 
 //    DocVersion     uint64 `protobuf:"varint,1,opt,name=doc_version,json=docVersion,proto3" json:"doc_version,omitempty"`            // first version = 1; 0 => invalid or doesn't exist
@@ -349,29 +417,24 @@ func GetJdoc(thing, jdocname string) (AJdoc, bool) {
 
 func AddJdoc(thing string, name string, jdoc AJdoc) uint64 {
 	var latestVersion uint64 = 0
-	matched := false
-	for index, jdocentry := range BotJdocs {
-		if jdocentry.Thing == thing && jdocentry.Name == name {
-			BotJdocs[index].Jdoc = jdoc
-			latestVersion = BotJdocs[index].Jdoc.DocVersion
-			matched = true
-			break
+	err := DB.Update(func(tx StoreTx) error {
+		if tx.Get(bucketJdocs, jdocKey(thing, name)) != nil {
+			latestVersion = jdoc.DocVersion
 		}
+		return StorePutJSON(tx, bucketJdocs, jdocKey(thing, name), botjdoc{
+			Thing: thing,
+			Name:  name,
+			Jdoc:  jdoc,
+		})
+	})
+	if err != nil {
+		logger.Println("Failed to save jdoc " + name + " for " + thing + ": " + err.Error())
 	}
-	if !matched {
-		var newbot botjdoc
-		newbot.Thing = thing
-		newbot.Name = name
-		newbot.Jdoc = jdoc
-		BotJdocs = append(BotJdocs, newbot)
-	}
-	WriteJdocs()
 	return latestVersion
 }
 
 func ReadSessionCerts() {
 	logger.Println("Reading session certs for robot IDs")
-	var rinfo RecurringInfoStore
 	certDir, err := os.ReadDir(SessionCertPath)
 	if err != nil {
 		logger.Println(err)
@@ -390,67 +453,27 @@ func ReadSessionCerts() {
 		}
 		pemBytes, _ := pem.Decode(certBytes)
 		cert, _ := x509.ParseCertificate(pemBytes.Bytes)
-		for _, robot := range BotInfo.Robots {
-			if esn == robot.Esn {
-				ip = robot.IPAddress
-				break
-			}
+		if robot, ok := BotInfo.Get(esn); ok {
+			ip = robot.IPAddress
 		}
-		rinfo.ESN = esn
-		rinfo.ID = cert.Issuer.CommonName
-		rinfo.IP = ip
-		RecurringInfo = append(RecurringInfo, rinfo)
-	}
-}
-
-func AddToRInfo(esn string, id string, ip string) {
-	// the only bot constant is ESN
-	for i := range RecurringInfo {
-		if RecurringInfo[i].ESN == esn {
-			RecurringInfo[i].ID = id
-			RecurringInfo[i].IP = ip
-			return
+		if ip == "" {
+			// keep the one from the store if the bot isn't in bot info
+			for _, known := range GetRInfo() {
+				if known.ESN == esn {
+					ip = known.IP
+				}
+			}
 		}
+		AddToRInfo(esn, cert.Issuer.CommonName, ip)
 	}
-	var rinfo RecurringInfoStore
-	rinfo.ESN = esn
-	rinfo.ID = id
-	rinfo.IP = ip
-	RecurringInfo = append(RecurringInfo, rinfo)
-}
-
-func SaveChats() {
-	marshalled, err := json.Marshal(RememberedChats)
-	if err != nil {
-		logger.Println(err)
-	}
-	os.WriteFile(SavedChatsPath, marshalled, 0777)
-}
-
-func LoadChats() {
-	file, err := os.ReadFile(SavedChatsPath)
-	if err != nil {
-		return
-	}
-	json.Unmarshal(file, &RememberedChats)
 }
 
 func GetRobot(esn string) (*vector.Vector, error) {
-	var guid string
-	var target string
-	matched := false
-	for _, bot := range BotInfo.Robots {
-		if esn == bot.Esn {
-			guid = bot.GUID
-			target = bot.IPAddress + ":443"
-			matched = true
-			break
-		}
-	}
+	bot, matched := BotInfo.Get(esn)
 	if !matched {
 		return nil, errors.New("robot not in botsdkinfo")
 	}
-	robot, err := vector.New(vector.WithSerialNo(esn), vector.WithToken(guid), vector.WithTarget(target))
+	robot, err := vector.New(vector.WithSerialNo(esn), vector.WithToken(bot.GUID), vector.WithTarget(bot.IPAddress+":443"))
 	if err != nil {
 		return nil, err
 	}
@@ -15,6 +15,9 @@ type IntentRequest struct {
 	LangString string
 	FirstReq   *pb.StreamingIntentRequest
 	AudioCodec pb.AudioEncoding
+	// replayed audio, not a robot. the intent is still worked out and sent to Stream, but the LLM isn't asked,
+	// custom intents, plugins and reminders aren't run, and the request isn't recorded in the history
+	DryRun bool
 }
 
 // IntentResponse is the response type VTT intent processors
@@ -0,0 +1,28 @@
+package vtt
+
+import (
+	"time"
+
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+)
+
+// TextRequest is the necessary request type for VTT text intent processors
+type TextRequest struct {
+	Time       time.Time
+	Device     string
+	Session    string
+	LangString string
+	Text       string
+	FirstReq   *pb.TextRequest
+	Mode       pb.RobotMode
+
+	// filled in by IntentPass, there is no stream to send it to
+	Response *pb.IntentResponse
+}
+
+// TextResponse is the response type VTT text intent processors
+type TextResponse struct {
+	Intent   *pb.IntentResponse
+	Params   string
+	Duration *time.Duration
+}
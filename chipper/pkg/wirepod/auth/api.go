@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+)
+
+// /api-auth/ endpoints. these are open in Handler, so each one checks the role itself
+
+// checks the request has the role, and writes the error if it doesn't
+func require(w http.ResponseWriter, r *http.Request, need Role) bool {
+	role := RequestRole(r)
+	if role == RoleNone {
+		http.Error(w, "not logged in", http.StatusUnauthorized)
+		return false
+	}
+	if role < need {
+		http.Error(w, "this needs the "+need.String()+" role, you are "+role.String(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func authAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/api-auth/") {
+	case "status":
+		handleStatus(w, r)
+	case "login":
+		handleLogin(w, r)
+	case "logout":
+		handleLogout(w, r)
+	case "set_password":
+		handleSetPassword(w, r)
+	case "disable":
+		if !require(w, r, RoleAdmin) {
+			return
+		}
+		if err := Disable(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Println("Authentication has been turned off")
+		fmt.Fprint(w, "Authentication turned off.")
+	case "get_tokens":
+		if !require(w, r, RoleAdmin) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Tokens())
+	case "create_token":
+		handleCreateToken(w, r)
+	case "delete_token":
+		if !require(w, r, RoleAdmin) {
+			return
+		}
+		if err := DeleteToken(r.FormValue("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "Token deleted.")
+	case "get_cors":
+		if !require(w, r, RoleAdmin) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CORSOrigins())
+	case "set_cors":
+		if !require(w, r, RoleAdmin) {
+			return
+		}
+		var origins []string
+		if err := json.NewDecoder(r.Body).Decode(&origins); err != nil {
+			http.Error(w, "invalid request body (must be a list of origins)", http.StatusBadRequest)
+			return
+		}
+		if err := SetCORSOrigins(origins); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "CORS origins saved.")
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	role := RequestRole(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":   Enabled(),
+		"logged_in": role != RoleNone,
+		"role":      role,
+	})
+}
+
+// logs in with the admin password, or an API token (for the role that token has)
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "must be a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		Password string `json:"password"`
+		Token    string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !Enabled() {
+		http.Error(w, "authentication is off", http.StatusBadRequest)
+		return
+	}
+	var key string
+	var role Role
+	if request.Password != "" && CheckPassword(request.Password) {
+		role = RoleAdmin
+		key = newSession(role, "")
+	} else if t, ok := CheckToken(request.Token); request.Token != "" && ok {
+		role = t.Role
+		key = newSession(role, t.ID)
+	} else {
+		// slows down guessing
+		time.Sleep(time.Second)
+		logger.Println("Failed login from " + r.RemoteAddr)
+		http.Error(w, "wrong password or token", http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    key,
+		Path:     "/",
+		MaxAge:   int(sessionLifetime.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	logger.Println("Logged in as " + role.String() + " from " + r.RemoteAddr)
+	fmt.Fprint(w, role.String())
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		endSession(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   SessionCookie,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+	fmt.Fprint(w, "Logged out.")
+}
+
+// sets the first password (turning auth on) or changes it, which needs admin
+func handleSetPassword(w http.ResponseWriter, r *http.Request) {
+	if Enabled() && !require(w, r, RoleAdmin) {
+		return
+	}
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := SetPassword(request.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Println("The admin password has been set, authentication is on")
+	fmt.Fprint(w, "Password set. Log in again with the new password.")
+}
+
+func handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if !require(w, r, RoleAdmin) {
+		return
+	}
+	var request struct {
+		Name string `json:"name"`
+		Role Role   `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	secret, t, err := CreateToken(request.Name, request.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.Hash = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Token
+		Secret string `json:"token"`
+	}{t, secret})
+}
+
+// RegisterAPI adds the /api-auth/ endpoints
+func RegisterAPI() {
+	http.HandleFunc("/api-auth/", authAPIHandler)
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// optional authentication for the web interface (/api/*) and the SDK app (/api-sdk/*).
+//
+// it's off until an admin password is set. after that, every request needs a session cookie (from logging in)
+// or an API token ("Authorization: Bearer <token>"), and the role which comes with it has to be allowed to use the endpoint.
+// the password is kept as a bcrypt hash and tokens as sha256 hashes in vars.AuthPath.
+// sessions only live in memory, so a restart logs everyone out.
+//
+// locked out? delete vars.AuthPath and restart wire-pod.
+
+// Role is what a session or token is allowed to do. each role can do everything the ones below it can
+type Role int
+
+const (
+	RoleNone Role = iota
+	// read settings which aren't secret, logs, robot status
+	RoleViewer
+	// control the robots through the SDK app and see their cameras
+	RoleOperator
+	// change settings, manage users and tokens
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// ParseRole turns "viewer", "operator" or "admin" into a Role
+func ParseRole(name string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return RoleNone, errors.New("role must be viewer, operator or admin")
+}
+
+func (r Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Role) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	role, err := ParseRole(name)
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// Token is an API token for scripts. the token itself is only shown when it's created
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Role    Role      `json:"role"`
+	Hash    string    `json:"hash,omitempty"`
+	Created time.Time `json:"created"`
+	// zero if it has never been used
+	LastUsed time.Time `json:"last_used"`
+}
+
+type authConfig struct {
+	PasswordHash string  `json:"password_hash"`
+	Tokens       []Token `json:"tokens"`
+	// origins allowed to make cross-origin requests. "*" allows any. empty means none
+	CORSOrigins []string `json:"cors_origins"`
+}
+
+type session struct {
+	role Role
+	// empty if the session came from the password
+	tokenID string
+	expires time.Time
+}
+
+const (
+	SessionCookie   = "wirepod_session"
+	sessionLifetime = 24 * time.Hour
+	minPasswordLen  = 8
+)
+
+var config authConfig
+
+// set if vars.AuthPath couldn't be read. nothing gets in until it's fixed or deleted
+var broken bool
+var sessions = make(map[string]*session)
+var authMu sync.Mutex
+var loadOnce sync.Once
+
+func load() {
+	loadOnce.Do(func() {
+		file, err := os.ReadFile(vars.AuthPath)
+		if err != nil {
+			return
+		}
+		if err := json.Unmarshal(file, &config); err != nil {
+			// better to refuse everything than to silently turn auth off
+			logger.Println("Couldn't read " + vars.AuthPath + ", nobody can log in until it's fixed or deleted: " + err.Error())
+			broken = true
+		}
+	})
+}
+
+// authMu has to be held
+func save() error {
+	marshalled, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := vars.AuthPath + ".tmp"
+	if err := os.WriteFile(tmp, marshalled, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, vars.AuthPath)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Enabled is true once an admin password has been set
+func Enabled() bool {
+	load()
+	authMu.Lock()
+	defer authMu.Unlock()
+	return config.PasswordHash != "" || broken
+}
+
+// SetPassword sets the admin password, which turns auth on. everyone gets logged out
+func SetPassword(password string) error {
+	if len(password) < minPasswordLen {
+		return errors.New("the password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	load()
+	authMu.Lock()
+	defer authMu.Unlock()
+	config.PasswordHash = string(hash)
+	broken = false
+	sessions = make(map[string]*session)
+	return save()
+}
+
+// Disable removes the admin password, which turns auth off. tokens are kept for if it gets turned on again
+func Disable() error {
+	load()
+	authMu.Lock()
+	defer authMu.Unlock()
+	config.PasswordHash = ""
+	broken = false
+	sessions = make(map[string]*session)
+	return save()
+}
+
+// CheckPassword is true if password is the admin password
+func CheckPassword(password string) bool {
+	load()
+	authMu.Lock()
+	hash := config.PasswordHash
+	authMu.Unlock()
+	if hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// CheckToken returns the token the secret belongs to, if it's a valid token
+func CheckToken(token string) (Token, bool) {
+	load()
+	hash := hashToken(strings.TrimSpace(token))
+	authMu.Lock()
+	defer authMu.Unlock()
+	for i, t := range config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			// only written once a minute, not on every request
+			if time.Since(t.LastUsed) > time.Minute {
+				config.Tokens[i].LastUsed = time.Now()
+				save()
+			}
+			return config.Tokens[i], true
+		}
+	}
+	return Token{}, false
+}
+
+// CreateToken makes a new API token and returns it. this is the only time the token itself is available
+func CreateToken(name string, role Role) (string, Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", Token{}, errors.New("a token needs a name")
+	}
+	if role == RoleNone {
+		return "", Token{}, errors.New("a token needs a role")
+	}
+	load()
+	secret := "wp_" + randomHex(24)
+	t := Token{
+		ID:      randomHex(4),
+		Name:    name,
+		Role:    role,
+		Hash:    hashToken(secret),
+		Created: time.Now(),
+	}
+	authMu.Lock()
+	defer authMu.Unlock()
+	config.Tokens = append(config.Tokens, t)
+	if err := save(); err != nil {
+		return "", Token{}, err
+	}
+	return secret, t, nil
+}
+
+// Tokens returns every API token, without their hashes
+func Tokens() []Token {
+	load()
+	authMu.Lock()
+	defer authMu.Unlock()
+	tokens := []Token{}
+	for _, t := range config.Tokens {
+		t.Hash = ""
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+// DeleteToken revokes a token, and logs out the sessions which were started with it
+func DeleteToken(id string) error {
+	load()
+	authMu.Lock()
+	defer authMu.Unlock()
+	for i, t := range config.Tokens {
+		if t.ID == id {
+			config.Tokens = append(config.Tokens[:i], config.Tokens[i+1:]...)
+			for key, s := range sessions {
+				if s.tokenID == id {
+					delete(sessions, key)
+				}
+			}
+			return save()
+		}
+	}
+	return errors.New("no token with id " + id)
+}
+
+// CORSOrigins returns the origins allowed to make cross-origin requests
+func CORSOrigins() []string {
+	load()
+	authMu.Lock()
+	defer authMu.Unlock()
+	return append([]string{}, config.CORSOrigins...)
+}
+
+// SetCORSOrigins replaces the origins allowed to make cross-origin requests
+func SetCORSOrigins(origins []string) error {
+	load()
+	var cleaned []string
+	for _, origin := range origins {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			cleaned = append(cleaned, origin)
+		}
+	}
+	authMu.Lock()
+	defer authMu.Unlock()
+	config.CORSOrigins = cleaned
+	return save()
+}
+
+// starts a session and returns its key, for the session cookie
+func newSession(role Role, tokenID string) string {
+	key := randomHex(32)
+	authMu.Lock()
+	defer authMu.Unlock()
+	sessions[key] = &session{
+		role:    role,
+		tokenID: tokenID,
+		expires: time.Now().Add(sessionLifetime),
+	}
+	return key
+}
+
+func endSession(key string) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	delete(sessions, key)
+}
+
+// returns the session's role and keeps it alive
+func sessionRole(key string) Role {
+	authMu.Lock()
+	defer authMu.Unlock()
+	s, ok := sessions[key]
+	if !ok {
+		return RoleNone
+	}
+	if time.Now().After(s.expires) {
+		delete(sessions, key)
+		return RoleNone
+	}
+	s.expires = time.Now().Add(sessionLifetime)
+	return s.role
+}
+
+// RequestRole returns what the request is allowed to do. everything is allowed if auth is off
+func RequestRole(r *http.Request) Role {
+	if !Enabled() {
+		return RoleAdmin
+	}
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		if t, ok := CheckToken(strings.TrimPrefix(header, "Bearer ")); ok {
+			return t.Role
+		}
+		return RoleNone
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return sessionRole(cookie.Value)
+	}
+	return RoleNone
+}
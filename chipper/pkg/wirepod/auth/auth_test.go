@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// starts from no auth file, as if wire-pod had just been installed
+func useAuthFile(t *testing.T) {
+	t.Helper()
+	path := vars.AuthPath
+	reset := func() {
+		authMu.Lock()
+		defer authMu.Unlock()
+		config = authConfig{}
+		sessions = make(map[string]*session)
+		broken = false
+		loadOnce = sync.Once{}
+	}
+	vars.AuthPath = filepath.Join(t.TempDir(), "auth.json")
+	reset()
+	t.Cleanup(func() {
+		vars.AuthPath = path
+		reset()
+	})
+}
+
+// what Handler answers with, for a request which gets past it
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})).ServeHTTP(w, r)
+	return w
+}
+
+func withToken(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestRequiredRole(t *testing.T) {
+	tests := map[string]Role{
+		"/ok":                       RoleNone,
+		"/login.html":               RoleNone,
+		"/api-auth/login":           RoleNone,
+		"/.well-known/jwks.json":    RoleNone,
+		"/js/main.js":               RoleNone,
+		"/":                         RoleViewer,
+		"/index.html":               RoleViewer,
+		"/sdkapp/control.html":      RoleViewer,
+		"/sdkapp/js/control.js":     RoleViewer,
+		"/metrics":                  RoleViewer,
+		"/api/get_logs":             RoleViewer,
+		"/api/get_config":           RoleAdmin,
+		"/api/set_kg_api":           RoleAdmin,
+		"/api/something_new":        RoleAdmin,
+		"/api-sdk/get_sdk_info":     RoleViewer,
+		"/api-sdk/move_wheels":      RoleOperator,
+		"/cam-stream":               RoleOperator,
+		"/api-ssh/setup":            RoleAdmin,
+		"/api-ble/connect":          RoleAdmin,
+		"/api-chipper/use_ip":       RoleAdmin,
+		"/api-chipper/restart":      RoleAdmin,
+		"/sessions":                 RoleAdmin,
+		"/something/we/don't/serve": RoleAdmin,
+	}
+	for path, want := range tests {
+		if got := RequiredRole(path); got != want {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	useAuthFile(t)
+	// off until there's a password
+	if Enabled() || serve(httptest.NewRequest("GET", "/api-chipper/restart", nil)).Code != http.StatusOK {
+		t.Fatal("auth is on without a password")
+	}
+	if err := SetPassword("short"); err == nil {
+		t.Error("a short password was taken")
+	}
+	if err := SetPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if !Enabled() {
+		t.Fatal("auth is off with a password")
+	}
+	if w := serve(httptest.NewRequest("GET", "/api/get_logs", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("not logged in: got %d", w.Code)
+	}
+	page := httptest.NewRequest("GET", "/setup.html", nil)
+	page.Header.Set("Accept", "text/html")
+	if w := serve(page); w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), "/login.html?next=") {
+		t.Errorf("a page while not logged in: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	login := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		authAPIHandler(w, httptest.NewRequest("POST", "/api-auth/login", strings.NewReader(body)))
+		return w
+	}
+	if w := login(`{"password":"wrong password"}`); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("a wrong password: got %d", w.Code)
+	}
+	w := login(`{"password":"correct horse"}`)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Name != SessionCookie {
+		t.Fatalf("logging in: got %d, %v", w.Code, cookies)
+	}
+	r := httptest.NewRequest("GET", "/api/set_kg_api", nil)
+	r.AddCookie(cookies[0])
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Errorf("as admin: got %d", w.Code)
+	}
+
+	// logging out ends the session
+	out := httptest.NewRequest("GET", "/api-auth/logout", nil)
+	out.AddCookie(cookies[0])
+	authAPIHandler(httptest.NewRecorder(), out)
+	r = httptest.NewRequest("GET", "/api/get_logs", nil)
+	r.AddCookie(cookies[0])
+	if w := serve(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("after logging out: got %d", w.Code)
+	}
+
+	// the password is kept as a hash
+	file, err := os.ReadFile(vars.AuthPath)
+	if err != nil || strings.Contains(string(file), "correct horse") {
+		t.Errorf("the auth file has the password in it, or couldn't be read: %v", err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	useAuthFile(t)
+	key := newSession(RoleOperator, "")
+	if role := sessionRole(key); role != RoleOperator {
+		t.Fatalf("got %v", role)
+	}
+	// using it keeps it alive
+	authMu.Lock()
+	sessions[key].expires = time.Now().Add(time.Minute)
+	authMu.Unlock()
+	sessionRole(key)
+	authMu.Lock()
+	left := time.Until(sessions[key].expires)
+	sessions[key].expires = time.Now().Add(-time.Second)
+	authMu.Unlock()
+	if left < sessionLifetime-time.Minute {
+		t.Errorf("the session wasn't extended, %v left", left)
+	}
+	if role := sessionRole(key); role != RoleNone {
+		t.Errorf("an expired session got %v", role)
+	}
+	authMu.Lock()
+	_, kept := sessions[key]
+	authMu.Unlock()
+	if kept {
+		t.Error("the expired session wasn't removed")
+	}
+	if role := sessionRole("not a session"); role != RoleNone {
+		t.Errorf("an unknown session got %v", role)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	useAuthFile(t)
+	if err := SetPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := CreateToken(" ", RoleViewer); err == nil {
+		t.Error("a token without a name was made")
+	}
+	viewer, viewerToken, err := CreateToken("dashboard", RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	operator, _, err := CreateToken("home assistant", RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		token, path string
+		want        int
+	}{
+		{viewer, "/api/get_logs", http.StatusOK},
+		{viewer, "/index.html", http.StatusOK},
+		{viewer, "/api-sdk/move_wheels", http.StatusForbidden},
+		{viewer, "/api-chipper/use_ip", http.StatusForbidden},
+		{operator, "/api-sdk/move_wheels", http.StatusOK},
+		{operator, "/api/set_kg_api", http.StatusForbidden},
+		{"wp_notatoken", "/api/get_logs", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		if w := serve(withToken(httptest.NewRequest("GET", test.path, nil), test.token)); w.Code != test.want {
+			t.Errorf("%s with %s: got %d, want %d", test.path, test.token, w.Code, test.want)
+		}
+	}
+
+	// only the hashes are kept, and they're still there after a restart
+	file, _ := os.ReadFile(vars.AuthPath)
+	if strings.Contains(string(file), viewer) {
+		t.Error("the auth file has a token in it")
+	}
+	authMu.Lock()
+	config = authConfig{}
+	loadOnce = sync.Once{}
+	authMu.Unlock()
+	if got, ok := CheckToken(viewer); !ok || got.Name != "dashboard" || got.Role != RoleViewer {
+		t.Errorf("after loading again: got %+v, %v", got, ok)
+	}
+	for _, listed := range Tokens() {
+		if listed.Hash != "" {
+			t.Error("Tokens has the hashes")
+		}
+	}
+
+	// a session from a token ends when the token is deleted
+	key := newSession(RoleViewer, viewerToken.ID)
+	if err := DeleteToken(viewerToken.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := CheckToken(viewer); ok {
+		t.Error("a deleted token still works")
+	}
+	if role := sessionRole(key); role != RoleNone {
+		t.Errorf("the deleted token's session got %v", role)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	useAuthFile(t)
+	if err := SetCORSOrigins([]string{" http://homeassistant.local:8123/ ", ""}); err != nil {
+		t.Fatal(err)
+	}
+	if origins := CORSOrigins(); len(origins) != 1 || origins[0] != "http://homeassistant.local:8123" {
+		t.Fatalf("saved %q", origins)
+	}
+	request := func(origin string, method string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/get_logs", nil)
+		r.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		return serve(r)
+	}
+	w := request("http://homeassistant.local:8123", "GET")
+	if w.Header().Get("Access-Control-Allow-Origin") != "http://homeassistant.local:8123" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("an allowed origin got %v", w.Header())
+	}
+	if w := request("http://evil.example", "GET"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("another origin got %v", w.Header())
+	}
+	if w := request("http://homeassistant.local:8123", http.MethodOptions); w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("a preflight got %d, %v", w.Code, w.Header())
+	}
+
+	if err := SetCORSOrigins([]string{"*"}); err != nil {
+		t.Fatal(err)
+	}
+	w = request("http://anywhere.example", "GET")
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("with * got %v", w.Header())
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	pathpkg "path"
+	"strings"
+)
+
+// which role each endpoint needs. anything not listed needs admin, so a new endpoint isn't open by mistake
+
+// open to anyone, because robots use them, they're needed to log in or they're public keys
+var openPaths = []string{"/ok", "/ok:80", "/login.html", "/favicon.ico", "/favicon.png", "/.well-known/jwks.json"}
+var openPrefixes = []string{"/api-auth/", "/session-certs/", "/api/get_ota/", "/css/", "/js/"}
+
+// the web interface's pages and what they load, for viewers
+var viewerPaths = []string{"/", "/metrics", "/sdk-app"}
+var viewerPrefixes = []string{"/sdkapp/"}
+var pageExtensions = map[string]bool{
+	".html": true,
+	".js":   true,
+	".css":  true,
+	".png":  true,
+	".jpg":  true,
+	".svg":  true,
+	".ico":  true,
+	".ttf":  true,
+	".woff": true,
+}
+
+// /api/ endpoints which don't change anything or show secrets (get_config and get_kg_api have the API keys)
+var viewerAPI = map[string]bool{
+	"get_stt_info":            true,
+	"get_stt_engines":         true,
+	"get_download_status":     true,
+	"get_logs":                true,
+	"get_debug_logs":          true,
+	"stream_logs":             true,
+	"is_running":              true,
+	"is_api_v1":               true,
+	"get_version_info":        true,
+	"get_custom_intents_json": true,
+	"get_memory_robots":       true,
+	"get_cert_status":         true,
+	"get_robot_tokens":        true,
+	"list_history":            true,
+	"get_history_audio":       true,
+	"get_history_settings":    true,
+	"list_schedule":           true,
+	"get_config_status":       true,
+	"get_reload_status":       true,
+}
+
+// /api-sdk/ endpoints which only look at the robot. the rest are operator, apart from these
+var viewerSDKAPI = map[string]bool{
+	"conn_test":        true,
+	"get_sdk_info":     true,
+	"get_sdk_settings": true,
+	"get_stim_status":  true,
+	"get_robot_stats":  true,
+}
+
+// RequiredRole returns the role needed to use the path. RoleNone means it's open to anyone
+func RequiredRole(path string) Role {
+	for _, open := range openPaths {
+		if path == open {
+			return RoleNone
+		}
+	}
+	for _, prefix := range openPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return RoleNone
+		}
+	}
+	switch {
+	case strings.HasPrefix(path, "/api/"):
+		if viewerAPI[strings.TrimPrefix(path, "/api/")] {
+			return RoleViewer
+		}
+		return RoleAdmin
+	case strings.HasPrefix(path, "/api-sdk/"):
+		if viewerSDKAPI[strings.TrimPrefix(path, "/api-sdk/")] {
+			return RoleViewer
+		}
+		return RoleOperator
+	case path == "/cam-stream":
+		return RoleOperator
+	case strings.HasPrefix(path, "/api-ssh/"), strings.HasPrefix(path, "/api-ble/"), strings.HasPrefix(path, "/api-chipper/"):
+		return RoleAdmin
+	}
+	for _, page := range viewerPaths {
+		if path == page {
+			return RoleViewer
+		}
+	}
+	for _, prefix := range viewerPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return RoleViewer
+		}
+	}
+	if pageExtensions[strings.ToLower(pathpkg.Ext(path))] {
+		return RoleViewer
+	}
+	return RoleAdmin
+}
+
+// sets the CORS headers if the request's origin is allowed
+func setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for _, allowed := range CORSOrigins() {
+		if allowed == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if strings.EqualFold(allowed, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Add("Vary", "Origin")
+		} else {
+			continue
+		}
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		return
+	}
+}
+
+// Handler checks every request against the role its path needs before passing it on.
+// both web servers are wrapped in it
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setCORS(w, r)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		need := RequiredRole(r.URL.Path)
+		if need == RoleNone {
+			next.ServeHTTP(w, r)
+			return
+		}
+		role := RequestRole(r)
+		if role == RoleNone {
+			// send people opening a page to the login page, everything else just gets the error
+			if r.Method == http.MethodGet && !strings.HasPrefix(r.URL.Path, "/api") && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login.html?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			http.Error(w, "not logged in", http.StatusUnauthorized)
+			return
+		}
+		if role < need {
+			http.Error(w, "this needs the "+need.String()+" role, you are "+role.String(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	botsetup "github.com/kercre123/wire-pod/chipper/pkg/wirepod/setup"
+)
+
+// wire-pod's CA and server cert (setup/certs.go)
+
+func handleGetCertStatus(w http.ResponseWriter) {
+	status := botsetup.GetCertStatus()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		botsetup.CertStatus
+		ExtraSANs []string `json:"extra_sans"`
+	}{status, vars.APIConfig.Server.CertSANs})
+}
+
+// issues a new server cert, signed by the same CA
+func handleGenerateCerts(w http.ResponseWriter) {
+	if err := botsetup.CreateCertCombo(); err != nil {
+		http.Error(w, "error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "done")
+}
+
+// the body is a JSON list of hostnames and IPs. the server cert is reissued if it doesn't cover them
+func handleSetCertSANs(w http.ResponseWriter, r *http.Request) {
+	var sans []string
+	if err := json.NewDecoder(r.Body).Decode(&sans); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	var cleaned []string
+	for _, san := range sans {
+		san = strings.TrimSpace(san)
+		if san == "" {
+			continue
+		}
+		if net.ParseIP(san) == nil && strings.ContainsAny(san, " /:*") {
+			http.Error(w, san+" isn't a valid hostname or IP", http.StatusBadRequest)
+			return
+		}
+		cleaned = append(cleaned, san)
+	}
+	vars.APIConfig.Server.CertSANs = cleaned
+	vars.WriteConfigToDisk()
+	if vars.APIConfig.Server.EPConfig {
+		fmt.Fprint(w, "saved (escape pod mode uses its own cert, so these are used when wire-pod is switched to IP mode)")
+		return
+	}
+	reissued, err := botsetup.EnsureCerts()
+	if err != nil {
+		http.Error(w, "error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reissued {
+		fmt.Fprint(w, "saved, and the server cert was reissued")
+		return
+	}
+	fmt.Fprint(w, "saved")
+}
+
+// replaces the CA. every robot needs the new cert pushed to it after this
+func handleRotateCA(w http.ResponseWriter) {
+	if vars.APIConfig.Server.EPConfig {
+		http.Error(w, "error: escape pod mode doesn't use the CA", http.StatusBadRequest)
+		return
+	}
+	if err := botsetup.RotateCA(); err != nil {
+		http.Error(w, "error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "done")
+}
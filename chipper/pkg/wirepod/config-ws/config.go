@@ -0,0 +1,54 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// the config as a whole: what's wrong with it, and moving it to another machine (see CONFIG.md)
+
+// exported configs are small, this is plenty for a lot of custom intents and robots
+const maxImportSize = 10 << 20
+
+func handleGetConfigStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vars.GetConfigStatus())
+}
+
+func handleExportConfig(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=wire-pod-config-"+time.Now().Format("2006-01-02")+".json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(vars.ExportConfig())
+}
+
+// the body is what export_config gave. if it isn't valid, the response is the status with what's wrong with it
+func handleImportConfig(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	err = vars.ImportConfig(data)
+	var invalid *vars.ConfigValidationError
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(vars.ConfigStatus{Version: vars.ConfigVersion, Errors: invalid.Errors})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Info("Imported a config", logger.UI, "custom_intents", len(vars.CustomIntents()), "robots", len(vars.BotInfo.Robots()))
+	fmt.Fprint(w, "Config imported. Restart wire-pod for the STT and server settings to take effect.")
+}
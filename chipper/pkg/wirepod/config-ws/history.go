@@ -0,0 +1,89 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/history"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/replay"
+)
+
+// the request history (wirepod/history): every voice request, with what the robot heard
+
+// esn and limit are optional
+func handleListHistory(w http.ResponseWriter, r *http.Request) {
+	reqs, err := history.List(r.FormValue("esn"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil && limit > 0 && limit < len(reqs) {
+		reqs = reqs[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reqs)
+}
+
+func handleGetHistoryAudio(w http.ResponseWriter, r *http.Request) {
+	path, err := history.AudioPath(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	http.ServeFile(w, r, path)
+}
+
+// id for one request, or all=true for every request (from esn, if it's given)
+func handleDeleteHistory(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if r.FormValue("all") == "true" {
+		err = history.DeleteAll(r.FormValue("esn"))
+	} else {
+		err = history.Delete(r.FormValue("id"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprint(w, "Deleted.")
+}
+
+// sends a request's audio through the intent matching again, with the intents and STT engine as they are now
+func handleRerunHistory(w http.ResponseWriter, r *http.Request) {
+	req, err := history.Get(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	result, err := replay.Rerun(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleGetHistorySettings(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vars.APIConfig.History)
+}
+
+func handleSetHistorySettings(w http.ResponseWriter, r *http.Request) {
+	settings := vars.APIConfig.History
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := vars.UpdateConfig(func() { vars.APIConfig.History = settings }); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the limits might be lower now
+	history.Prune()
+	fmt.Fprint(w, "Changes successfully applied.")
+}
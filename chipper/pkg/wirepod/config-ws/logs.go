@@ -0,0 +1,90 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+)
+
+// the log (logger/logger.go). get_logs is what was logged for the web interface, get_debug_logs is everything.
+// both can be filtered with esn and level (debug, info, warn or error), and return JSON with format=json
+
+func logFilter(r *http.Request, uiOnly bool) (logger.Filter, error) {
+	filter := logger.Filter{ESN: r.FormValue("esn"), UIOnly: uiOnly}
+	if level := r.FormValue("level"); level != "" {
+		parsed, err := logger.ParseLevel(level)
+		if err != nil {
+			return filter, fmt.Errorf("invalid level (must be debug, info, warn or error)")
+		}
+		filter.Level = parsed
+	}
+	return filter, nil
+}
+
+func handleGetLogs(w http.ResponseWriter, r *http.Request, uiOnly bool) {
+	filter, err := logFilter(r, uiOnly)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries := logger.Entries(filter)
+	if r.FormValue("format") == "json" {
+		if entries == nil {
+			entries = []logger.Entry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(logger.Format(entries)))
+}
+
+// server-sent events, one per line. debug=true for everything, like get_debug_logs. the lines already logged are sent first
+func handleStreamLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := logFilter(r, r.FormValue("debug") != "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	// subscribed before the old lines are read, so nothing falls between them
+	entries, cancel := logger.Subscribe()
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	send := func(entry logger.Entry) error {
+		data, _ := json.Marshal(entry)
+		_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+		return err
+	}
+	var last logger.Entry
+	for _, entry := range logger.Entries(filter) {
+		if send(entry) != nil {
+			return
+		}
+		last = entry
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry := <-entries:
+			if !filter.Match(entry) || !entry.Time.After(last.Time) {
+				continue
+			}
+			if send(entry) != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// conversation memory for the LLM (vars/memory.go), per robot
+
+type memoryRobot struct {
+	ESN        string    `json:"esn"`
+	Messages   int       `json:"messages"`
+	Summarized int       `json:"summarized"`
+	Facts      int       `json:"facts"`
+	Updated    time.Time `json:"updated"`
+}
+
+func handleGetMemoryRobots(w http.ResponseWriter) {
+	robots := []memoryRobot{}
+	for _, esn := range vars.MemoryRobots() {
+		mem, err := vars.GetMemory(esn)
+		if err != nil {
+			continue
+		}
+		robots = append(robots, memoryRobot{
+			ESN:        esn,
+			Messages:   len(mem.History),
+			Summarized: mem.Summarized,
+			Facts:      len(mem.Facts),
+			Updated:    mem.Updated,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(robots)
+}
+
+func handleGetMemory(w http.ResponseWriter, r *http.Request) {
+	mem, err := vars.GetMemory(r.FormValue("esn"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if mem.History == nil {
+		mem.History = []vars.MemoryMessage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mem)
+}
+
+// replaces the summary, facts and history with the ones in the body. anything left out is gone
+func handleSetMemory(w http.ResponseWriter, r *http.Request) {
+	var mem vars.RobotMemory
+	if err := json.NewDecoder(r.Body).Decode(&mem); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	for i, msg := range mem.History {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			http.Error(w, fmt.Sprintf("message %d has an invalid role (must be user or assistant)", i+1), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := vars.ReplaceMemory(mem); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprint(w, "Memory saved successfully.")
+}
+
+func handleSetMemoryFact(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ESN string `json:"esn"`
+		// empty to forget the fact
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := vars.SetMemoryFact(request.ESN, request.Name, request.Value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprint(w, "Fact saved successfully.")
+}
+
+// format is json (default) or text
+func handleExportMemory(w http.ResponseWriter, r *http.Request) {
+	esn := r.FormValue("esn")
+	mem, err := vars.GetMemory(esn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"memory-"+esn+".txt\"")
+		fmt.Fprintln(w, "Memory for "+esn)
+		if len(mem.Facts) > 0 {
+			fmt.Fprintln(w, "\nFacts:")
+			var names []string
+			for name := range mem.Facts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintln(w, name+": "+mem.Facts[name])
+			}
+		}
+		if mem.Summary != "" {
+			fmt.Fprintln(w, "\nSummary of the first "+fmt.Sprint(mem.Summarized)+" messages:\n"+mem.Summary)
+		}
+		fmt.Fprintln(w, "\nHistory:")
+		for _, msg := range mem.History {
+			fmt.Fprintln(w, "["+msg.Time.Format(time.RFC3339)+"] "+msg.Role+": "+strings.ReplaceAll(msg.Content, "\n", " "))
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"memory-"+esn+".json\"")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(mem)
+}
+
+func handleDeleteMemory(w http.ResponseWriter, r *http.Request) {
+	if err := vars.DeleteMemory(r.FormValue("esn")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprint(w, "done")
+}
@@ -0,0 +1,31 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	processreqs "github.com/kercre123/wire-pod/chipper/pkg/wirepod/preqs"
+)
+
+// reloading the intent data, custom intents and plugins without a restart (preqs/reload.go)
+
+// if anything couldn't be reloaded it's a 400, the result says what and the rest was still reloaded
+func handleReloadIntents(w http.ResponseWriter) {
+	result := processreqs.Reload()
+	w.Header().Set("Content-Type", "application/json")
+	if len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// null if nothing has been reloaded since wire-pod started
+func handleGetReloadStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	result, ok := processreqs.LastReload()
+	if !ok {
+		w.Write([]byte("null"))
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,71 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/scheduler"
+)
+
+// the scheduled jobs (wirepod/scheduler): reminders, alarms and whatever else a robot should do at a time
+
+// esn is optional
+func handleListSchedule(w http.ResponseWriter, r *http.Request) {
+	jobs, err := scheduler.List(r.FormValue("esn"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// the body is a scheduler.Job without the id. it responds with the job as it was saved
+func handleAddSchedule(w http.ResponseWriter, r *http.Request) {
+	var job scheduler.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	job, err := scheduler.Add(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// the body is the whole job, with its id
+func handleEditSchedule(w http.ResponseWriter, r *http.Request) {
+	var job scheduler.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	job, err := scheduler.Edit(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := scheduler.Delete(r.FormValue("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprint(w, "Deleted.")
+}
+
+// runs a job right away, without changing when it runs next
+func handleRunSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := scheduler.RunNow(r.FormValue("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprint(w, "Done.")
+}
@@ -0,0 +1,54 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	ttr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/ttr"
+)
+
+// the voice spoken responses are in (ttr/tts.go)
+
+func handleGetTTSSettings(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vars.APIConfig.TTS)
+}
+
+// the body is all of the settings, the voices which aren't in it are removed
+func handleSetTTSSettings(w http.ResponseWriter, r *http.Request) {
+	settings := vars.APIConfig.TTS
+	settings.LanguageVoices, settings.RobotVoices = nil, nil
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := vars.UpdateConfig(func() { vars.APIConfig.TTS = settings }); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// what's cached might be in a voice which changed
+	ttr.ClearSpeechCache()
+	fmt.Fprint(w, "Changes successfully applied.")
+}
+
+// says text on the robot with esn, in the voice it has now
+func handleTestTTS(w http.ResponseWriter, r *http.Request) {
+	esn := r.FormValue("esn")
+	if _, ok := vars.BotInfo.Get(esn); !ok {
+		http.Error(w, "no robot with the ESN "+esn, http.StatusNotFound)
+		return
+	}
+	text := r.FormValue("text")
+	if text == "" {
+		http.Error(w, "no text to say", http.StatusBadRequest)
+		return
+	}
+	voice := ttr.RobotVoice(esn)
+	if err := ttr.KGSim(esn, text); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "Saying it with "+voice.Provider+".")
+}
@@ -2,6 +2,7 @@ package webserver
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,32 +14,20 @@ import (
 	"strings"
 
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/metrics"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/auth"
 	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
 	processreqs "github.com/kercre123/wire-pod/chipper/pkg/wirepod/preqs"
 	botsetup "github.com/kercre123/wire-pod/chipper/pkg/wirepod/setup"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
 )
 
 var SttInitFunc func() error
 
-type CustomIntent struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Utterances  []string `json:"utterances"`
-	Intent      string   `json:"intent"`
-	Params      struct {
-		ParamName  string `json:"paramname"`
-		ParamValue string `json:"paramvalue"`
-	} `json:"params"`
-	Exec           string   `json:"exec"`
-	ExecArgs       []string `json:"execargs"`
-	IsSystemIntent bool     `json:"issystem"`
-}
+type CustomIntent = vars.CustomIntent
 
 func apiHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
-
 	switch strings.TrimPrefix(r.URL.Path, "/api/") {
 	case "add_custom_intent":
 		handleAddCustomIntent(w, r)
@@ -62,22 +51,86 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 		handleGetDownloadStatus(w)
 	case "get_stt_info":
 		handleGetSTTInfo(w)
+	case "get_stt_engines":
+		handleGetSTTEngines(w)
 	case "get_config":
 		handleGetConfig(w)
+	case "get_config_status":
+		handleGetConfigStatus(w)
+	case "export_config":
+		handleExportConfig(w)
+	case "import_config":
+		handleImportConfig(w, r)
+	case "reload_intents":
+		handleReloadIntents(w)
+	case "get_reload_status":
+		handleGetReloadStatus(w)
 	case "get_logs":
-		handleGetLogs(w)
+		handleGetLogs(w, r, true)
 	case "get_debug_logs":
-		handleGetDebugLogs(w)
+		handleGetLogs(w, r, false)
+	case "stream_logs":
+		handleStreamLogs(w, r)
 	case "is_running":
 		handleIsRunning(w)
 	case "delete_chats":
 		handleDeleteChats(w)
+	case "get_memory_robots":
+		handleGetMemoryRobots(w)
+	case "get_memory":
+		handleGetMemory(w, r)
+	case "set_memory":
+		handleSetMemory(w, r)
+	case "set_memory_fact":
+		handleSetMemoryFact(w, r)
+	case "export_memory":
+		handleExportMemory(w, r)
+	case "delete_memory":
+		handleDeleteMemory(w, r)
+	case "list_history":
+		handleListHistory(w, r)
+	case "get_history_audio":
+		handleGetHistoryAudio(w, r)
+	case "delete_history":
+		handleDeleteHistory(w, r)
+	case "rerun_history":
+		handleRerunHistory(w, r)
+	case "get_history_settings":
+		handleGetHistorySettings(w)
+	case "set_history_settings":
+		handleSetHistorySettings(w, r)
+	case "get_tts_settings":
+		handleGetTTSSettings(w)
+	case "set_tts_settings":
+		handleSetTTSSettings(w, r)
+	case "test_tts":
+		handleTestTTS(w, r)
+	case "list_schedule":
+		handleListSchedule(w, r)
+	case "add_schedule":
+		handleAddSchedule(w, r)
+	case "edit_schedule":
+		handleEditSchedule(w, r)
+	case "delete_schedule":
+		handleDeleteSchedule(w, r)
+	case "run_schedule":
+		handleRunSchedule(w, r)
 	case "get_ota":
 		handleGetOTA(w, r)
 	case "get_version_info":
 		handleGetVersionInfo(w)
 	case "generate_certs":
 		handleGenerateCerts(w)
+	case "get_cert_status":
+		handleGetCertStatus(w)
+	case "set_cert_sans":
+		handleSetCertSANs(w, r)
+	case "rotate_ca":
+		handleRotateCA(w)
+	case "get_robot_tokens":
+		handleGetRobotTokens(w)
+	case "set_robot_tokens":
+		handleSetRobotTokens(w, r)
 	case "is_api_v1":
 		fmt.Fprintf(w, "it is!")
 	default:
@@ -95,9 +148,14 @@ func handleAddCustomIntent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing required field (name, description, utterances, and intent are required)", http.StatusBadRequest)
 		return
 	}
-	vars.CustomIntentsExist = true
-	vars.CustomIntents = append(vars.CustomIntents, intent)
-	saveCustomIntents()
+	err := vars.UpdateCustomIntents(func(intents vars.IntentsStruct) (vars.IntentsStruct, error) {
+		return append(intents, intent), nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reloadGrammar()
 	fmt.Fprint(w, "Intent added successfully.")
 }
 
@@ -110,48 +168,55 @@ func handleEditCustomIntent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if request.Number < 1 || request.Number > len(vars.CustomIntents) {
-		http.Error(w, "invalid intent number", http.StatusBadRequest)
+	err := vars.UpdateCustomIntents(func(intents vars.IntentsStruct) (vars.IntentsStruct, error) {
+		if request.Number < 1 || request.Number > len(intents) {
+			return nil, errors.New("invalid intent number")
+		}
+		intent := &intents[request.Number-1]
+		if request.Name != "" {
+			intent.Name = request.Name
+		}
+		if request.Description != "" {
+			intent.Description = request.Description
+		}
+		if len(request.Utterances) != 0 {
+			intent.Utterances = request.Utterances
+		}
+		if request.Intent != "" {
+			intent.Intent = request.Intent
+		}
+		if request.Params.ParamName != "" {
+			intent.Params.ParamName = request.Params.ParamName
+		}
+		if request.Params.ParamValue != "" {
+			intent.Params.ParamValue = request.Params.ParamValue
+		}
+		if request.Exec != "" {
+			intent.Exec = request.Exec
+		}
+		if len(request.ExecArgs) != 0 {
+			intent.ExecArgs = request.ExecArgs
+		}
+		intent.IsSystemIntent = false
+		return intents, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	intent := &vars.CustomIntents[request.Number-1]
-	if request.Name != "" {
-		intent.Name = request.Name
-	}
-	if request.Description != "" {
-		intent.Description = request.Description
-	}
-	if len(request.Utterances) != 0 {
-		intent.Utterances = request.Utterances
-	}
-	if request.Intent != "" {
-		intent.Intent = request.Intent
-	}
-	if request.Params.ParamName != "" {
-		intent.Params.ParamName = request.Params.ParamName
-	}
-	if request.Params.ParamValue != "" {
-		intent.Params.ParamValue = request.Params.ParamValue
-	}
-	if request.Exec != "" {
-		intent.Exec = request.Exec
-	}
-	if len(request.ExecArgs) != 0 {
-		intent.ExecArgs = request.ExecArgs
-	}
-	intent.IsSystemIntent = false
-	saveCustomIntents()
+	reloadGrammar()
 	fmt.Fprint(w, "Intent edited successfully.")
 }
 
 func handleGetCustomIntentsJSON(w http.ResponseWriter) {
-	if !vars.CustomIntentsExist {
+	intents := vars.CustomIntents()
+	if len(intents) == 0 {
 		http.Error(w, "you must create an intent first", http.StatusBadRequest)
 		return
 	}
-	customIntentJSONFile, err := os.ReadFile(vars.CustomIntentsPath)
+	customIntentJSONFile, err := json.Marshal(intents)
 	if err != nil {
-		http.Error(w, "could not read custom intents file", http.StatusInternalServerError)
+		http.Error(w, "could not read custom intents", http.StatusInternalServerError)
 		logger.Println(err)
 		return
 	}
@@ -167,12 +232,17 @@ func handleRemoveCustomIntent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if request.Number < 1 || request.Number > len(vars.CustomIntents) {
-		http.Error(w, "invalid intent number", http.StatusBadRequest)
+	err := vars.UpdateCustomIntents(func(intents vars.IntentsStruct) (vars.IntentsStruct, error) {
+		if request.Number < 1 || request.Number > len(intents) {
+			return nil, errors.New("invalid intent number")
+		}
+		return append(intents[:request.Number-1], intents[request.Number:]...), nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	vars.CustomIntents = append(vars.CustomIntents[:request.Number-1], vars.CustomIntents[request.Number:]...)
-	saveCustomIntents()
+	reloadGrammar()
 	fmt.Fprint(w, "Intent removed successfully.")
 }
 
@@ -185,14 +255,19 @@ func handleSetWeatherAPI(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if config.Provider == "" {
-		vars.APIConfig.Weather.Enable = false
-	} else {
-		vars.APIConfig.Weather.Enable = true
-		vars.APIConfig.Weather.Key = strings.TrimSpace(config.Key)
-		vars.APIConfig.Weather.Provider = config.Provider
+	err := vars.UpdateConfig(func() {
+		if config.Provider == "" {
+			vars.APIConfig.Weather.Enable = false
+		} else {
+			vars.APIConfig.Weather.Enable = true
+			vars.APIConfig.Weather.Key = strings.TrimSpace(config.Key)
+			vars.APIConfig.Weather.Provider = config.Provider
+		}
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	vars.WriteConfigToDisk()
 	fmt.Fprint(w, "Changes successfully applied.")
 }
 
@@ -201,13 +276,40 @@ func handleGetWeatherAPI(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(vars.APIConfig.Weather)
 }
 
+type robotTokens struct {
+	Require bool `json:"require"`
+}
+
+func handleGetRobotTokens(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(robotTokens{Require: vars.APIConfig.Server.RequireRobotTokens})
+}
+
+// whether robots need a token signed by wire-pod for their gRPC requests
+func handleSetRobotTokens(w http.ResponseWriter, r *http.Request) {
+	var request robotTokens
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := vars.UpdateConfig(func() { vars.APIConfig.Server.RequireRobotTokens = request.Require }); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprint(w, "Changes successfully applied.")
+}
+
 func handleSetKGAPI(w http.ResponseWriter, r *http.Request) {
-	if err := json.NewDecoder(r.Body).Decode(&vars.APIConfig.Knowledge); err != nil {
+	knowledge := vars.APIConfig.Knowledge
+	if err := json.NewDecoder(r.Body).Decode(&knowledge); err != nil {
 		fmt.Println(err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	vars.WriteConfigToDisk()
+	if err := vars.UpdateConfig(func() { vars.APIConfig.Knowledge = knowledge }); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	fmt.Fprint(w, "Changes successfully applied.")
 }
 
@@ -218,39 +320,71 @@ func handleGetKGAPI(w http.ResponseWriter) {
 
 func handleSetSTTInfo(w http.ResponseWriter, r *http.Request) {
 	var request struct {
+		// optional, switches to another engine in this build without a restart
+		Provider string `json:"provider"`
 		Language string `json:"language"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if vars.APIConfig.STT.Service == "vosk" {
-		if !isValidLanguage(request.Language, localization.ValidVoskModels) {
+	provider := vars.APIConfig.STT.Service
+	if request.Provider != "" {
+		provider = request.Provider
+	}
+	engine, ok := stt.Get(provider)
+	if !ok {
+		http.Error(w, "stt provider "+provider+" is not available in this build", http.StatusBadRequest)
+		return
+	}
+	if request.Language == "" {
+		request.Language = vars.APIConfig.STT.Language
+	}
+	if !engine.SupportsLanguage(request.Language) {
+		if request.Provider == "" {
 			http.Error(w, "language not valid", http.StatusBadRequest)
 			return
 		}
-		if !isDownloadedLanguage(request.Language, vars.DownloadedVoskModels) {
-			go localization.DownloadVoskModel(request.Language)
-			fmt.Fprint(w, "downloading language model...")
-			return
-		}
-	} else if vars.APIConfig.STT.Service == "whisper.cpp" {
-		if !isValidLanguage(request.Language, localization.ValidVoskModels) {
-			http.Error(w, "language not valid", http.StatusBadRequest)
+		request.Language = engine.DefaultLanguage()
+	}
+	switching := provider != processreqs.CurrentEngine().Name
+	if provider == "vosk" && !isDownloadedLanguage(request.Language, vars.DownloadedVoskModels) {
+		go func() {
+			localization.DownloadVoskModel(request.Language)
+			if switching {
+				if err := processreqs.UseEngine(provider, request.Language); err != nil {
+					logger.Println("Error switching to " + provider + ": " + err.Error())
+					return
+				}
+				vars.WriteConfigToDisk()
+			}
+		}()
+		fmt.Fprint(w, "downloading language model...")
+		return
+	}
+	vars.APIConfig.PastInitialSetup = true
+	if switching {
+		if err := processreqs.UseEngine(provider, request.Language); err != nil {
+			http.Error(w, "error initializing "+provider+": "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-	} else {
-		http.Error(w, "service must be vosk or whisper", http.StatusBadRequest)
+		vars.WriteConfigToDisk()
+		logger.Println("Switched voice processor to " + provider)
+		fmt.Fprint(w, "Provider switched successfully.")
 		return
 	}
 	vars.APIConfig.STT.Language = request.Language
-	vars.APIConfig.PastInitialSetup = true
 	vars.WriteConfigToDisk()
 	processreqs.ReloadVosk()
 	logger.Println("Reloaded voice processor successfully")
 	fmt.Fprint(w, "Language switched successfully.")
 }
 
+func handleGetSTTEngines(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stt.Engines())
+}
+
 func handleGetDownloadStatus(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte(localization.DownloadStatus))
@@ -269,16 +403,6 @@ func handleGetConfig(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(vars.APIConfig)
 }
 
-func handleGetLogs(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte(logger.LogList))
-}
-
-func handleGetDebugLogs(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte(logger.LogTrayList))
-}
-
 func handleIsRunning(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte("true"))
@@ -286,7 +410,10 @@ func handleIsRunning(w http.ResponseWriter) {
 
 func handleDeleteChats(w http.ResponseWriter) {
 	os.Remove(vars.SavedChatsPath)
-	vars.RememberedChats = []vars.RememberedChat{}
+	if err := vars.DeleteAllMemory(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	fmt.Fprint(w, "done")
 }
 
@@ -371,17 +498,11 @@ func handleGetVersionInfo(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(verInfo)
 }
 
-func handleGenerateCerts(w http.ResponseWriter) {
-	if err := botsetup.CreateCertCombo(); err != nil {
-		http.Error(w, "error: "+err.Error(), http.StatusInternalServerError)
-		return
+// the custom intents are part of the Vosk grammar
+func reloadGrammar() {
+	if err := processreqs.ReloadGrammar(); err != nil {
+		logger.Warn("Couldn't reload the grammar", logger.UI, "error", err)
 	}
-	fmt.Fprint(w, "done")
-}
-
-func saveCustomIntents() {
-	customIntentJSONFile, _ := json.Marshal(vars.CustomIntents)
-	os.WriteFile(vars.CustomIntentsPath, customIntentJSONFile, 0644)
 }
 
 func DisableCachingAndSniffing(next http.Handler) http.Handler {
@@ -396,8 +517,10 @@ func DisableCachingAndSniffing(next http.Handler) http.Handler {
 func StartWebServer() {
 	botsetup.RegisterSSHAPI()
 	botsetup.RegisterBLEAPI()
+	auth.RegisterAPI()
 	http.HandleFunc("/api/", apiHandler)
 	http.HandleFunc("/session-certs/", certHandler)
+	http.HandleFunc("/metrics", metrics.Handler)
 	var webRoot http.Handler
 	if runtime.GOOS == "darwin" && vars.Packaged {
 		appPath, _ := os.Executable()
@@ -409,7 +532,7 @@ func StartWebServer() {
 	}
 	http.Handle("/", DisableCachingAndSniffing(webRoot))
 	fmt.Printf("Starting webserver at port " + vars.WebPort + " (http://localhost:" + vars.WebPort + ")\n")
-	if err := http.ListenAndServe(":"+vars.WebPort, nil); err != nil {
+	if err := http.ListenAndServe(":"+vars.WebPort, auth.Handler(http.DefaultServeMux)); err != nil {
 		logger.Println("Error binding to " + vars.WebPort + ": " + err.Error())
 		if vars.Packaged {
 			logger.ErrMsg("FATAL: Wire-pod was unable to bind to port " + vars.WebPort + ". Another process is likely using it. Exiting.")
@@ -497,15 +620,6 @@ func anyEmpty(values ...string) bool {
 	return false
 }
 
-func isValidLanguage(language string, validLanguages []string) bool {
-	for _, lang := range validLanguages {
-		if lang == language {
-			return true
-		}
-	}
-	return false
-}
-
 func isDownloadedLanguage(language string, downloadedLanguages []string) bool {
 	for _, lang := range downloadedLanguages {
 		if lang == language {
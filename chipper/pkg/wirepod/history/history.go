@@ -0,0 +1,343 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// every voice request, so a misheard command can be looked at (and listened to) later.
+// each one is two files in vars.HistoryDir: <id>.json with what happened, and <id>.wav with what the robot heard.
+//
+// preqs calls Begin when a request comes in and Finish when it's done. in between, whatever learns something
+// about the request (the transcript, the intent, the LLM's answer) adds it by the request's session.
+// APIConfig.History decides whether anything is kept (nothing is until it's enabled), whether audio is, and for how long.
+
+const (
+	KindIntent         = "intent"
+	KindIntentGraph    = "intent_graph"
+	KindKnowledgeGraph = "knowledge_graph"
+)
+
+const (
+	defaultMaxDays     = 7
+	defaultMaxRequests = 200
+	// the robot streams 16000 Hz mono 16-bit audio
+	sampleRate = 16000
+	// requests which never finish (a panic in an engine) are forgotten after this
+	pendingTimeout = 5 * time.Minute
+)
+
+// Request is a recorded voice request
+type Request struct {
+	ID      string    `json:"id"`
+	ESN     string    `json:"esn"`
+	Session string    `json:"session"`
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	// the STT engine
+	Engine     string            `json:"engine"`
+	Transcript string            `json:"transcript"`
+	Intent     string            `json:"intent,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	LLM        string            `json:"llm_response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+	HasAudio   bool              `json:"has_audio"`
+}
+
+var idRegex = regexp.MustCompile(`^[0-9]+$`)
+
+// held for anything which touches the files, and pending
+var mu sync.Mutex
+
+// requests which haven't finished yet, by session
+var pending = make(map[string]*Request)
+
+// Enabled is whether requests are being recorded
+func Enabled() bool {
+	return vars.APIConfig.History.Enable
+}
+
+// Begin starts recording a request
+func Begin(esn, session, kind, engine string) {
+	if !Enabled() || session == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for s, req := range pending {
+		if time.Since(req.Time) > pendingTimeout {
+			delete(pending, s)
+		}
+	}
+	pending[session] = &Request{
+		ESN:     esn,
+		Session: session,
+		Time:    time.Now(),
+		Kind:    kind,
+		Engine:  engine,
+	}
+}
+
+func update(session string, fn func(req *Request)) {
+	mu.Lock()
+	defer mu.Unlock()
+	if req, ok := pending[session]; ok {
+		fn(req)
+	}
+}
+
+func SetTranscript(session, text string) {
+	update(session, func(req *Request) {
+		req.Transcript = text
+	})
+}
+
+// SetIntent records the intent sent to the robot. if there's more than one (the LLM path sends one first), the last one wins
+func SetIntent(session, intent string, params map[string]string) {
+	update(session, func(req *Request) {
+		req.Intent = intent
+		req.Params = nil
+		for name, value := range params {
+			if name == "" && value == "" {
+				continue
+			}
+			if req.Params == nil {
+				req.Params = make(map[string]string)
+			}
+			req.Params[name] = value
+		}
+	})
+}
+
+func SetLLMResponse(session, text string) {
+	update(session, func(req *Request) {
+		req.LLM = text
+	})
+}
+
+func SetError(session string, err error) {
+	if err == nil {
+		return
+	}
+	update(session, func(req *Request) {
+		req.Error = err.Error()
+	})
+}
+
+// Finish saves the request. audio is what the robot sent, decoded (16000 Hz mono s16le)
+func Finish(session string, audio []byte) {
+	mu.Lock()
+	req, ok := pending[session]
+	delete(pending, session)
+	mu.Unlock()
+	if !ok {
+		return
+	}
+	req.DurationMs = time.Since(req.Time).Milliseconds()
+	if err := save(req, audio); err != nil {
+		logger.Warn("Couldn't save the request to the history", logger.KeyESN, req.ESN, logger.KeySession, session, "error", err)
+		return
+	}
+	Prune()
+}
+
+func save(req *Request, audio []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := os.MkdirAll(vars.HistoryDir, 0700); err != nil {
+		return err
+	}
+	// ids sort by time. two requests in the same nanosecond get the next one
+	id := req.Time.UnixNano()
+	for {
+		if _, err := os.Stat(jsonPath(strconv.FormatInt(id, 10))); os.IsNotExist(err) {
+			break
+		}
+		id++
+	}
+	req.ID = strconv.FormatInt(id, 10)
+	if len(audio) > 0 && !vars.APIConfig.History.NoAudio {
+		if err := os.WriteFile(wavPath(req.ID), pcmToWAV(audio), 0600); err != nil {
+			return err
+		}
+		req.HasAudio = true
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(jsonPath(req.ID), data, 0600)
+}
+
+func jsonPath(id string) string {
+	return filepath.Join(vars.HistoryDir, id+".json")
+}
+
+func wavPath(id string) string {
+	return filepath.Join(vars.HistoryDir, id+".wav")
+}
+
+func checkID(id string) error {
+	if !idRegex.MatchString(id) {
+		return errors.New("invalid id: " + id)
+	}
+	return nil
+}
+
+// List returns the recorded requests, newest first. esn is optional
+func List(esn string) ([]Request, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return list(esn)
+}
+
+func list(esn string) ([]Request, error) {
+	entries, err := os.ReadDir(vars.HistoryDir)
+	if os.IsNotExist(err) {
+		return []Request{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	reqs := []Request{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || checkID(id) != nil {
+			continue
+		}
+		req, err := get(id)
+		if err != nil {
+			continue
+		}
+		if esn != "" && !strings.EqualFold(req.ESN, esn) {
+			continue
+		}
+		reqs = append(reqs, req)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].Time.After(reqs[j].Time)
+	})
+	return reqs, nil
+}
+
+// Get returns one recorded request
+func Get(id string) (Request, error) {
+	if err := checkID(id); err != nil {
+		return Request{}, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return get(id)
+}
+
+func get(id string) (Request, error) {
+	var req Request
+	data, err := os.ReadFile(jsonPath(id))
+	if err != nil {
+		return req, err
+	}
+	err = json.Unmarshal(data, &req)
+	return req, err
+}
+
+// AudioPath is where a request's WAV file is
+func AudioPath(id string) (string, error) {
+	req, err := Get(id)
+	if err != nil {
+		return "", err
+	}
+	if !req.HasAudio {
+		return "", errors.New("no audio was kept for this request")
+	}
+	return wavPath(id), nil
+}
+
+// Delete removes a request and its audio
+func Delete(id string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return remove(id)
+}
+
+func remove(id string) error {
+	if err := os.Remove(wavPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(jsonPath(id))
+}
+
+// DeleteAll removes every request from a robot, or every request if esn is empty
+func DeleteAll(esn string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	reqs, err := list(esn)
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		if err := remove(req.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes requests older than MaxDays, then the oldest ones over MaxRequests
+func Prune() {
+	maxDays := vars.APIConfig.History.MaxDays
+	if maxDays <= 0 {
+		maxDays = defaultMaxDays
+	}
+	maxRequests := vars.APIConfig.History.MaxRequests
+	if maxRequests <= 0 {
+		maxRequests = defaultMaxRequests
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	reqs, err := list("")
+	if err != nil {
+		return
+	}
+	oldest := time.Now().AddDate(0, 0, -maxDays)
+	for i, req := range reqs {
+		if i >= maxRequests || req.Time.Before(oldest) {
+			if err := remove(req.ID); err != nil {
+				logger.Warn("Couldn't remove an old request from the history", "id", req.ID, "error", err)
+			}
+		}
+	}
+}
+
+func pcmToWAV(pcm []byte) []byte {
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(36+len(pcm)))
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	// pcm, mono
+	binary.LittleEndian.PutUint16(header[20:], 1)
+	binary.LittleEndian.PutUint16(header[22:], 1)
+	binary.LittleEndian.PutUint32(header[24:], sampleRate)
+	binary.LittleEndian.PutUint32(header[28:], sampleRate*2)
+	binary.LittleEndian.PutUint16(header[32:], 2)
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(len(pcm)))
+	return append(header, pcm...)
+}
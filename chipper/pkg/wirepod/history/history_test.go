@@ -0,0 +1,138 @@
+package history
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+func useTempHistory(t *testing.T) {
+	t.Helper()
+	dir := vars.HistoryDir
+	config := vars.APIConfig.History
+	vars.HistoryDir = t.TempDir()
+	vars.APIConfig.History.Enable = true
+	t.Cleanup(func() {
+		vars.HistoryDir = dir
+		vars.APIConfig.History = config
+	})
+}
+
+func record(esn, session, transcript, intent string, audio []byte) {
+	Begin(esn, session, KindIntentGraph, "vosk")
+	SetTranscript(session, transcript)
+	SetIntent(session, intent, map[string]string{"": ""})
+	Finish(session, audio)
+}
+
+func TestRecordListAndDelete(t *testing.T) {
+	useTempHistory(t)
+	audio := make([]byte, 3200)
+	record("00e20000", "s1", "what's the weather", "intent_weather_extend", audio)
+	Begin("00e20001", "s2", KindIntent, "vosk")
+	SetTranscript("s2", "tell me a joke")
+	SetIntent("s2", "intent_greeting_hello", nil)
+	SetLLMResponse("s2", "Why did the robot cross the road?")
+	SetError("s2", errors.New("stream error"))
+	Finish("s2", audio)
+	// never begun, so nothing is kept
+	SetTranscript("s3", "ignored")
+	Finish("s3", audio)
+
+	reqs, err := List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("%d requests, want 2", len(reqs))
+	}
+	if reqs[0].Session != "s2" || reqs[1].Session != "s1" {
+		t.Errorf("got sessions %s, %s, want newest first", reqs[0].Session, reqs[1].Session)
+	}
+	got := reqs[0]
+	if got.Transcript != "tell me a joke" || got.Intent != "intent_greeting_hello" || got.LLM == "" || got.Error != "stream error" || !got.HasAudio {
+		t.Errorf("request wasn't recorded right: %+v", got)
+	}
+	if reqs[1].Params != nil {
+		t.Errorf("empty params should be left out, got %v", reqs[1].Params)
+	}
+
+	path, err := AudioPath(reqs[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wav, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(wav[0:4]) != "RIFF" || string(wav[8:12]) != "WAVE" || binary.LittleEndian.Uint32(wav[40:44]) != uint32(len(audio)) || len(wav) != 44+len(audio) {
+		t.Errorf("invalid wav header: %v", wav[:44])
+	}
+
+	if filtered, _ := List("00E20000"); len(filtered) != 1 {
+		t.Errorf("%d requests from 00e20000, want 1", len(filtered))
+	}
+	if err := Delete(reqs[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("audio is still there after the request was deleted")
+	}
+	if err := Delete("../wirepod"); err == nil {
+		t.Error("an invalid id was accepted")
+	}
+	if err := DeleteAll(""); err != nil {
+		t.Fatal(err)
+	}
+	if reqs, _ := List(""); len(reqs) != 0 {
+		t.Errorf("%d requests left after deleting them all", len(reqs))
+	}
+}
+
+func TestPrivacySettings(t *testing.T) {
+	useTempHistory(t)
+	vars.APIConfig.History.NoAudio = true
+	record("00e20000", "s1", "hello", "intent_greeting_hello", make([]byte, 320))
+	reqs, _ := List("")
+	if len(reqs) != 1 || reqs[0].HasAudio {
+		t.Fatalf("audio was kept with no_audio set: %+v", reqs)
+	}
+	if _, err := AudioPath(reqs[0].ID); err == nil {
+		t.Error("AudioPath worked for a request with no audio")
+	}
+
+	vars.APIConfig.History.Enable = false
+	record("00e20000", "s2", "hello", "intent_greeting_hello", nil)
+	if reqs, _ := List(""); len(reqs) != 1 {
+		t.Errorf("%d requests, want 1 (nothing recorded while disabled)", len(reqs))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	useTempHistory(t)
+	vars.APIConfig.History.MaxRequests = 3
+	for _, session := range []string{"s1", "s2", "s3", "s4", "s5"} {
+		record("00e20000", session, "hello", "intent_greeting_hello", nil)
+		// ids come from the time
+		time.Sleep(time.Millisecond)
+	}
+	reqs, _ := List("")
+	if len(reqs) != 3 || reqs[2].Session != "s3" {
+		t.Fatalf("got %d requests (oldest %+v), want s3 to s5", len(reqs), reqs[len(reqs)-1])
+	}
+
+	// a request from before the limit
+	Begin("00e20000", "old", KindIntent, "vosk")
+	pending["old"].Time = time.Now().AddDate(0, 0, -8)
+	Finish("old", nil)
+	reqs, _ = List("")
+	for _, req := range reqs {
+		if req.Session == "old" {
+			t.Error("a request older than 7 days was kept")
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package localization
 
-import "github.com/kercre123/wire-pod/chipper/pkg/vars"
+import (
+	"strings"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
 
 var ValidVoskModels []string = []string{"en-US", "it-IT", "es-ES", "fr-FR", "de-DE", "pt-BR", "pl-PL", "zh-CN", "tr-TR", "ru-RU", "nt-NL"}
 
@@ -38,6 +42,45 @@ const STR_NAME_IS = "str_name_is"
 const STR_NAME_IS2 = "str_name_is1"
 const STR_NAME_IS3 = "str_name_is2"
 const STR_FOR = "str_for"
+const STR_FOLLOWUP_AND = "str_followup_and"
+const STR_FOLLOWUP_WHAT_ABOUT = "str_followup_what_about"
+const STR_FOLLOWUP_HOW_ABOUT = "str_followup_how_about"
+
+// for the time parser in ttr/temporal.go. "|" separates the ways of saying it
+const STR_TIME_TODAY = "str_time_today"
+const STR_TIME_MORNING = "str_time_morning"
+const STR_TIME_NOON = "str_time_noon"
+const STR_TIME_EVENING = "str_time_evening"
+const STR_TIME_MIDNIGHT = "str_time_midnight"
+const STR_TIME_NEXT_WEEK = "str_time_next_week"
+const STR_TIME_WEEKEND = "str_time_weekend"
+const STR_TIME_BEFORE_DAY = "str_time_before_day"
+const STR_TIME_IN = "str_time_in"
+const STR_TIME_LATER = "str_time_later"
+const STR_TIME_AT = "str_time_at"
+const STR_TIME_OCLOCK = "str_time_oclock"
+const STR_TIME_AM = "str_time_am"
+const STR_TIME_PM = "str_time_pm"
+const STR_TIME_MINUTES = "str_time_minutes"
+const STR_TIME_HOURS = "str_time_hours"
+const STR_TIME_DAYS = "str_time_days"
+const STR_TIME_WEEKS = "str_time_weeks"
+const STR_TIME_MONDAY = "str_time_monday"
+const STR_TIME_TUESDAY = "str_time_tuesday"
+const STR_TIME_WEDNESDAY = "str_time_wednesday"
+const STR_TIME_THURSDAY = "str_time_thursday"
+const STR_TIME_FRIDAY = "str_time_friday"
+const STR_TIME_SATURDAY = "str_time_saturday"
+const STR_TIME_SUNDAY = "str_time_sunday"
+const STR_TIME_NUMBERS = "str_time_numbers"
+const STR_TIME_TENS = "str_time_tens"
+
+// for reminders, ttr/schedule.go
+const STR_REMIND_ME = "str_remind_me"
+const STR_REMINDER = "str_reminder"
+const STR_REMINDER_SET = "str_reminder_set"
+const STR_REMINDER_NO_TIME = "str_reminder_no_time"
+const STR_REMINDER_FAILED = "str_reminder_failed"
 
 // for grammer
 var ALL_STR []string = []string{
@@ -75,18 +118,49 @@ var ALL_STR []string = []string{
 	"str_name_is1",
 	"str_name_is2",
 	"str_for",
+	"str_followup_and",
+	"str_followup_what_about",
+	"str_followup_how_about",
+	"str_time_today",
+	"str_time_morning",
+	"str_time_noon",
+	"str_time_evening",
+	"str_time_midnight",
+	"str_time_next_week",
+	"str_time_weekend",
+	"str_time_before_day",
+	"str_time_in",
+	"str_time_later",
+	"str_time_at",
+	"str_time_oclock",
+	"str_time_am",
+	"str_time_pm",
+	"str_time_minutes",
+	"str_time_hours",
+	"str_time_days",
+	"str_time_weeks",
+	"str_time_monday",
+	"str_time_tuesday",
+	"str_time_wednesday",
+	"str_time_thursday",
+	"str_time_friday",
+	"str_time_saturday",
+	"str_time_sunday",
+	"str_time_numbers",
+	"str_time_tens",
+	"str_remind_me",
 }
 
 // All text must be lowercase!
 
 var texts = map[string][]string{
 	//  key                 			en-US   it-IT   es-ES    fr-FR    de-DE    pl-PL   tr-TR	ru-RU nt-NL
-	STR_WEATHER_IN:                     {" in ", " a ", " en ", " en ", " in ", " w ", " 的 ", " içinde ", "в", " in "},
-	STR_WEATHER_FORECAST:               {"forecast", "previsioni", "pronóstico", "prévisions", "wettervorhersage", "prognoza", "预报", "tahmin", "прогноз","voorspelling"},
-	STR_WEATHER_TOMORROW:               {"tomorrow", "domani", "mañana", "demain", "morgen", "jutro", "明天", "yarın", "завтра","morgen"},
-	STR_WEATHER_THE_DAY_AFTER_TOMORROW: {"day after tomorrow", "dopodomani", "el día después de mañana", "lendemain de demain", "am tag nach morgen", "pojutrze", "后天", "yarından sonra", "послезавтра","overmorgen"},
-	STR_WEATHER_TONIGHT:                {"tonight", "stasera", "esta noche", "ce soir", "heute abend", "dziś wieczorem", "今晚", "bu gece", "сегодня вечером","vanavond"},
-	STR_WEATHER_THIS_AFTERNOON:         {"afternoon", "pomeriggio", "esta tarde", "après-midi", "heute nachmittag", "popołudniu", "下午", "bu öğleden sonra", "после полудня","middag"},
+	STR_WEATHER_IN:                     {" in ", " a ", " en ", " en ", " in ", " w ", " 的 ", " içinde ", "в", " in ", " em "},
+	STR_WEATHER_FORECAST:               {"forecast", "previsioni", "pronóstico", "prévisions", "wettervorhersage", "prognoza", "预报", "tahmin", "прогноз","voorspelling", "previsão"},
+	STR_WEATHER_TOMORROW:               {"tomorrow", "domani", "mañana", "demain", "morgen", "jutro", "明天", "yarın", "завтра","morgen", "amanhã"},
+	STR_WEATHER_THE_DAY_AFTER_TOMORROW: {"day after tomorrow", "dopodomani", "el día después de mañana", "lendemain de demain", "am tag nach morgen", "pojutrze", "后天", "yarından sonra", "послезавтра","overmorgen", "depois de amanhã"},
+	STR_WEATHER_TONIGHT:                {"tonight", "stasera", "esta noche", "ce soir", "heute abend", "dziś wieczorem", "今晚", "bu gece", "сегодня вечером","vanavond", "hoje à noite"},
+	STR_WEATHER_THIS_AFTERNOON:         {"afternoon", "pomeriggio", "esta tarde", "après-midi", "heute nachmittag", "popołudniu", "下午", "bu öğleden sonra", "после полудня","middag", "tarde"},
 	STR_EYE_COLOR_PURPLE:               {"purple", "lilla", "violeta", "violet", "violett", "fioletowy", "紫色", "mor", "фиолетовый","paars"},
 	STR_EYE_COLOR_BLUE:                 {"blue", "blu", "azul", "bleu", "blau", "niebieski", "蓝色", "mavi", "голубой","blauw"},
 	STR_EYE_COLOR_SAPPHIRE:             {"sapphire", "zaffiro", "zafiro", "saphir", "saphir", "szafir", "天蓝", "safir", "синий","saffier"},
@@ -115,37 +189,100 @@ var texts = map[string][]string{
 	STR_NAME_IS2:                       {"'s", "sono ", "soy ", "suis ", "bin ", " się ", "的", "'nin", "",""},
 	STR_NAME_IS3:                       {"names", " chiamo ", " llamo ", "appelle ", "werde", "imię", "名字", "adlar", "имена","namen"},
 	STR_FOR:                            {" for ", " per ", " para ", " pour ", " für ", " dla ", "给", " için ", "для"," voor "},
+	STR_FOLLOWUP_AND:                   {"and", "e", "y", "et", "und", "a", "那", "peki", "а", "en"},
+	STR_FOLLOWUP_WHAT_ABOUT:            {"what about", "e per", "qué tal", "et pour", "und was ist mit", "a co z", "那么", "ya", "а как насчёт", "en hoe zit het met"},
+	STR_FOLLOWUP_HOW_ABOUT:             {"how about", "che ne dici di", "y para", "et si", "wie wäre es mit", "a może", "那么 呢", "peki ya", "а если", "hoe zit het met"},
+	//  the time parser's, which also have pt-BR. en-US it-IT es-ES fr-FR de-DE pl-PL zh-CN tr-TR ru-RU nt-NL pt-BR
+	STR_TIME_TODAY: {"today", "oggi", "hoy", "aujourd'hui", "heute", "dziś|dzisiaj", "今天", "bugün", "сегодня", "vandaag", "hoje"},
+	STR_TIME_MORNING: {"morning", "mattina|mattino", "por la mañana", "matin", "morgens|vormittag|früh", "rano", "早上|上午", "sabah", "утром|утро", "ochtend", "manhã"},
+	STR_TIME_NOON: {"noon|midday", "mezzogiorno", "mediodía", "midi", "mittag", "południe", "中午", "öğlen|öğle", "полдень", "", "meio-dia"},
+	STR_TIME_EVENING: {"evening", "sera", "por la noche", "soir", "abend|abends", "wieczorem|wieczór", "晚上", "akşam", "вечером|вечер", "avond", "noite"},
+	STR_TIME_MIDNIGHT: {"midnight", "mezzanotte", "medianoche", "minuit", "mitternacht", "północ", "午夜", "gece yarısı", "полночь", "middernacht", "meia-noite"},
+	STR_TIME_NEXT_WEEK: {"next week", "la prossima settimana|settimana prossima", "la próxima semana|la semana que viene", "la semaine prochaine", "nächste woche", "w przyszłym tygodniu", "下周", "gelecek hafta|haftaya", "на следующей неделе", "volgende week", "semana que vem|próxima semana"},
+	STR_TIME_WEEKEND: {"weekend", "fine settimana|weekend", "fin de semana", "week-end|weekend", "wochenende", "weekend", "周末", "hafta sonu", "выходные", "weekend", "fim de semana"},
+	STR_TIME_BEFORE_DAY: {"on|this|next|for|the|in", "il|la|questo|questa|per|nel|prossimo|prossima|di", "el|este|esta|para|la|próximo|próxima|por", "ce|cette|pour|le|la|prochain|prochaine", "am|an|diesen|dieses|diese|für|nächsten|nächste|den", "w|we|na|ten|tę|następny|następną|przyszły|przyszłą", "这个|下个", "bu|gelecek|önümüzdeki", "в|во|на|эту|этот|это|следующую|следующий", "op|deze|dit|voor|volgende|aanstaande", "no|na|neste|nesta|para|de|próximo|próxima"},
+	STR_TIME_IN: {"in", "tra|fra", "en|dentro de", "dans", "in", "za", "", "", "через", "over", "em|daqui a"},
+	STR_TIME_LATER: {"from now|later", "", "", "", "", "", "后|以后|之后", "sonra", "", "", "depois"},
+	STR_TIME_AT: {"at", "alle|all'|a", "a las|a la", "à", "um", "o", "", "saat", "в", "om", "às|as"},
+	STR_TIME_OCLOCK: {"o'clock", "", "en punto", "heures|heure|h", "uhr", "", "点|点钟", "", "часов|часа|час", "uur", "horas|hora"},
+	STR_TIME_AM: {"am|a.m.", "", "", "", "", "", "", "", "", "", ""},
+	STR_TIME_PM: {"pm|p.m.", "", "", "", "", "", "", "", "", "", ""},
+	STR_TIME_MINUTES: {"minute|minutes", "minuto|minuti", "minuto|minutos", "minute|minutes", "minute|minuten", "minuta|minuty|minut|minutę", "分钟|分", "dakika", "минуту|минуты|минут|минута", "minuut|minuten", "minuto|minutos"},
+	STR_TIME_HOURS: {"hour|hours", "ora|ore", "hora|horas", "heure|heures", "stunde|stunden", "godzina|godziny|godzin|godzinę", "小时|个小时|钟头", "saat", "час|часа|часов", "uur|uren", "hora|horas"},
+	STR_TIME_DAYS: {"day|days", "giorno|giorni", "día|días", "jour|jours", "tag|tage|tagen", "dzień|dni", "天", "gün", "день|дня|дней", "dag|dagen", "dia|dias"},
+	STR_TIME_WEEKS: {"week|weeks", "settimana|settimane", "semana|semanas", "semaine|semaines", "woche|wochen", "tydzień|tygodnie|tygodni", "周|星期|个星期", "hafta", "неделю|недели|недель|неделя", "week|weken", "semana|semanas"},
+	STR_TIME_MONDAY: {"monday", "lunedì|lunedi", "lunes", "lundi", "montag", "poniedziałek", "星期一|周一", "pazartesi", "понедельник", "maandag", "segunda|segunda-feira"},
+	STR_TIME_TUESDAY: {"tuesday", "martedì|martedi", "martes", "mardi", "dienstag", "wtorek", "星期二|周二", "salı", "вторник", "dinsdag", "terça|terça-feira"},
+	STR_TIME_WEDNESDAY: {"wednesday", "mercoledì|mercoledi", "miércoles|miercoles", "mercredi", "mittwoch", "środa|środę", "星期三|周三", "çarşamba", "среду|среда", "woensdag", "quarta|quarta-feira"},
+	STR_TIME_THURSDAY: {"thursday", "giovedì|giovedi", "jueves", "jeudi", "donnerstag", "czwartek", "星期四|周四", "perşembe", "четверг", "donderdag", "quinta|quinta-feira"},
+	STR_TIME_FRIDAY: {"friday", "venerdì|venerdi", "viernes", "vendredi", "freitag", "piątek", "星期五|周五", "cuma", "пятницу|пятница", "vrijdag", "sexta|sexta-feira"},
+	STR_TIME_SATURDAY: {"saturday", "sabato", "sábado|sabado", "samedi", "samstag|sonnabend", "sobota|sobotę", "星期六|周六", "cumartesi", "субботу|суббота", "zaterdag", "sábado"},
+	STR_TIME_SUNDAY: {"sunday", "domenica", "domingo", "dimanche", "sonntag", "niedziela|niedzielę", "星期天|星期日|周日", "pazar", "воскресенье", "zondag", "domingo"},
+	STR_TIME_NUMBERS: {"zero one|a|an two three four five six seven eight nine ten eleven twelve", "zero uno|un|una due tre quattro cinque sei sette otto nove dieci undici dodici", "cero uno|un|una dos tres cuatro cinco seis siete ocho nueve diez once doce", "zéro un|une deux trois quatre cinq six sept huit neuf dix onze douze", "null eins|ein|eine|einer zwei drei vier fünf sechs sieben acht neun zehn elf zwölf", "zero jeden|jedna|jedną dwa|dwie trzy cztery pięć sześć siedem osiem dziewięć dziesięć jedenaście dwanaście", "零 一|一个 两|二|两个 三|三个 四|四个 五|五个 六|六个 七|七个 八|八个 九|九个 十|十个 十一 十二", "sıfır bir iki üç dört beş altı yedi sekiz dokuz on onbir oniki", "ноль один|одну|одна два|две три четыре пять шесть семь восемь девять десять одиннадцать двенадцать", "nul een|één twee drie vier vijf zes zeven acht negen tien elf twaalf", "zero um|uma dois|duas três quatro cinco seis sete oito nove dez onze doze"},
+	STR_TIME_TENS: {"ten twenty thirty forty fifty", "dieci venti trenta quaranta cinquanta", "diez veinte treinta cuarenta cincuenta", "dix vingt trente quarante cinquante", "zehn zwanzig dreißig vierzig fünfzig", "dziesięć dwadzieścia trzydzieści czterdzieści pięćdziesiąt", "十 二十 三十 四十 五十", "on yirmi otuz kırk elli", "десять двадцать тридцать сорок пятьдесят", "tien twintig dertig veertig vijftig", "dez vinte trinta quarenta cinquenta"},
+	STR_REMIND_ME: {"remind me to|remind me", "ricordami di|ricordami", "recuérdame|recuerdame", "rappelle-moi de|rappelle moi de|rappelle-moi|rappelle moi", "erinnere mich daran|erinnere mich", "przypomnij mi", "提醒我", "bana hatırlat|hatırlat", "напомни мне|напомни", "herinner me eraan|herinner me", "me lembre de|lembre-me de|me lembra de"},
+	STR_REMINDER: {"reminder:", "promemoria:", "recordatorio:", "rappel :", "erinnerung:", "przypomnienie:", "提醒：", "hatırlatma:", "напоминание:", "herinnering:", "lembrete:"},
+	STR_REMINDER_SET: {"okay, i'll remind you", "va bene, te lo ricorderò", "vale, te lo recordaré", "d'accord, je te le rappellerai", "okay, ich erinnere dich", "dobrze, przypomnę ci", "好的，我会提醒你", "tamam, sana hatırlatacağım", "хорошо, я напомню", "oké, ik herinner je eraan", "tudo bem, vou te lembrar"},
+	STR_REMINDER_NO_TIME: {"when should i remind you? say it again with a time", "quando te lo devo ricordare? ripetilo con un orario", "¿cuándo te lo recuerdo? dilo otra vez con una hora", "quand dois-je te le rappeler ? redis-le avec une heure", "wann soll ich dich erinnern? sag es noch einmal mit einer uhrzeit", "kiedy mam ci przypomnieć? powiedz to jeszcze raz z godziną", "我应该什么时候提醒你？请带上时间再说一遍", "ne zaman hatırlatayım? bir saatle tekrar söyle", "когда тебе напомнить? скажи ещё раз со временем", "wanneer moet ik je eraan herinneren? zeg het nog eens met een tijd", "quando devo te lembrar? diga de novo com um horário"},
+	STR_REMINDER_FAILED: {"sorry, i couldn't set that reminder", "scusa, non sono riuscito a impostare il promemoria", "lo siento, no pude crear el recordatorio", "désolé, je n'ai pas pu créer ce rappel", "tut mir leid, ich konnte die erinnerung nicht anlegen", "przepraszam, nie udało mi się ustawić przypomnienia", "抱歉，我没能设置这个提醒", "üzgünüm, hatırlatmayı kuramadım", "извини, не получилось создать напоминание", "sorry, ik kon die herinnering niet instellen", "desculpe, não consegui criar o lembrete"},
+}
+
+// HasText is true if key is one of the keys above
+func HasText(key string) bool {
+	_, ok := texts[key]
+	return ok
 }
 
 func GetText(key string) string {
 	var data = texts[key]
-	if data != nil {
-		if vars.APIConfig.STT.Language == "it-IT" {
-			return data[1]
-		} else if vars.APIConfig.STT.Language == "es-ES" {
-			return data[2]
-		} else if vars.APIConfig.STT.Language == "fr-FR" {
-			return data[3]
-		} else if vars.APIConfig.STT.Language == "de-DE" {
-			return data[4]
-		} else if vars.APIConfig.STT.Language == "pl-PL" {
-			return data[5]
-		} else if vars.APIConfig.STT.Language == "zh-CN" {
-			return data[6]
-		} else if vars.APIConfig.STT.Language == "tr-TR" {
-			return data[7]
-		} else if vars.APIConfig.STT.Language == "ru-RU" {
-			return data[8]
-		} else if vars.APIConfig.STT.Language == "nt-NL" {
-			return data[9]
+	// the older strings have no pt-BR, so they're in English
+	i := 0
+	switch vars.APIConfig.STT.Language {
+	case "it-IT":
+		i = 1
+	case "es-ES":
+		i = 2
+	case "fr-FR":
+		i = 3
+	case "de-DE":
+		i = 4
+	case "pl-PL":
+		i = 5
+	case "zh-CN":
+		i = 6
+	case "tr-TR":
+		i = 7
+	case "ru-RU":
+		i = 8
+	case "nt-NL":
+		i = 9
+	case "pt-BR":
+		i = 10
+	}
+	if i >= len(data) {
+		i = 0
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	return data[i]
+}
+
+// GetTexts splits GetText on "|", for the strings with more than one way of saying them
+func GetTexts(key string) []string {
+	var alternatives []string
+	for _, text := range strings.Split(GetText(key), "|") {
+		if text = strings.TrimSpace(text); text != "" {
+			alternatives = append(alternatives, text)
 		}
 	}
-	return data[0]
+	return alternatives
 }
 
 func ReloadVosk() {
 	if vars.APIConfig.STT.Service == "vosk" || vars.APIConfig.STT.Service == "whisper.cpp" {
-		vars.IntentList, _ = vars.LoadIntents()
+		intents, _ := vars.LoadIntents()
+		vars.SetIntents(intents)
 		vars.SttInitFunc()
 	}
 }
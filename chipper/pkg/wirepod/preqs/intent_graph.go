@@ -2,22 +2,40 @@ package processreqs
 
 import (
 	"strings"
+	"time"
 
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
 	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/history"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
 	ttr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/ttr"
 )
 
 func (s *Server) ProcessIntentGraph(req *vtt.IntentGraphRequest) (*vtt.IntentGraphResponse, error) {
+	start := time.Now()
 	var successMatched bool
 	speechReq := sr.ReqToSpeechRequest(req)
+	// engines with partial transcripts can end a short command without waiting for the end of speech
+	speechReq.OnPartial = func(text string) bool {
+		return ttr.EarlyMatch(req.Device, text)
+	}
 	var transcribedText string
-	if !isSti {
+	e := CurrentEngine()
+	log := logger.With(logger.KeyESN, speechReq.Device, logger.KeySession, speechReq.Session, logger.KeySTT, e.Name)
+	history.Begin(req.Device, req.Session, history.KindIntentGraph, e.Name)
+	// the engine adds to the recording as it goes
+	defer func() {
+		history.Finish(req.Session, speechReq.Recording.Bytes())
+	}()
+	if !e.Intents {
 		var err error
-		transcribedText, err = sttHandler(speechReq)
+		transcribedText, err = e.STT(speechReq)
+		observeSpeech(speechReq, e.Name)
+		history.SetTranscript(req.Session, transcribedText)
 		if err != nil {
+			history.SetError(req.Session, err)
+			log.Error("Voice processing error", "error", err)
 			ttr.IntentPass(req, "intent_system_noaudio", "voice processing error: "+err.Error(), map[string]string{"error": err.Error()}, true)
 			return nil, nil
 		}
@@ -25,16 +43,18 @@ func (s *Server) ProcessIntentGraph(req *vtt.IntentGraphRequest) (*vtt.IntentGra
 			ttr.IntentPass(req, "intent_system_noaudio", "", map[string]string{}, false)
 			return nil, nil
 		}
-		successMatched = ttr.ProcessTextAll(req, transcribedText, vars.IntentList, speechReq.IsOpus)
+		successMatched = ttr.ProcessTextAll(req, transcribedText, vars.Intents(), speechReq.IsOpus)
 	} else {
-		intent, slots, err := stiHandler(speechReq)
+		intent, slots, err := e.STI(speechReq)
+		observeSpeech(speechReq, e.Name)
 		if err != nil {
+			history.SetError(req.Session, err)
 			if err.Error() == "inference not understood" {
-				logger.Println("Bot " + speechReq.Device + " No intent was matched")
+				log.Info("No intent was matched")
 				ttr.IntentPass(req, "intent_system_unmatched", "voice processing error", map[string]string{"error": err.Error()}, true)
 				return nil, nil
 			}
-			logger.Println(err)
+			log.Error("Voice processing error", "error", err)
 			ttr.IntentPass(req, "intent_system_noaudio", "voice processing error", map[string]string{"error": err.Error()}, true)
 			return nil, nil
 		}
@@ -61,21 +81,23 @@ func (s *Server) ProcessIntentGraph(req *vtt.IntentGraphRequest) (*vtt.IntentGra
 	// }
 	if !successMatched {
 		if vars.APIConfig.Knowledge.IntentGraph && vars.APIConfig.Knowledge.Enable {
-			logger.Println("Making LLM request for device " + req.Device + "...")
+			log.Info("Making LLM request")
 			_, err := ttr.StreamingKGSim(req, req.Device, transcribedText)
 			if err != nil {
-				logger.Println("LLM error: " + err.Error())
-				logger.LogUI("LLM error: " + err.Error())
+				log.Error("LLM error", logger.UI, "error", err)
+				history.SetError(req.Session, err)
 				ttr.IntentPass(req, "intent_system_unmatched", transcribedText, map[string]string{"": ""}, false)
 				ttr.KGSim(req.Device, "There was an error getting a response from the L L M. Check the logs in the web interface.")
 			}
-			logger.Println("Bot " + speechReq.Device + " request served.")
+			log.Info("Request served", logger.KeyLatency, time.Since(start).Milliseconds())
+			observeTotal(speechReq, e.Name)
 			return nil, nil
 		}
-		logger.Println("No intent was matched.")
+		log.Info("No intent was matched", "text", transcribedText)
 		ttr.IntentPass(req, "intent_system_unmatched", transcribedText, map[string]string{"": ""}, false)
 		return nil, nil
 	}
-	logger.Println("Bot " + speechReq.Device + " request served.")
+	log.Info("Request served", logger.KeyLatency, time.Since(start).Milliseconds())
+	observeTotal(speechReq, e.Name)
 	return nil, nil
 }
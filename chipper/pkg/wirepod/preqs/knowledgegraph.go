@@ -12,7 +12,9 @@ import (
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
 	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/history"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	ttr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/ttr"
 	"github.com/pkg/errors"
 	"github.com/soundhound/houndify-sdk-go"
 )
@@ -192,7 +194,7 @@ func openaiRequest(transcribedText string) string {
 }
 
 func openaiKG(speechReq sr.SpeechRequest) string {
-	transcribedText, err := sttHandler(speechReq)
+	transcribedText, err := transcribe(speechReq)
 	if err != nil {
 		return "There was an error."
 	}
@@ -200,13 +202,31 @@ func openaiKG(speechReq sr.SpeechRequest) string {
 }
 
 func togetherKG(speechReq sr.SpeechRequest) string {
-	transcribedText, err := sttHandler(speechReq)
+	transcribedText, err := transcribe(speechReq)
 	if err != nil {
 		return "There was an error."
 	}
 	return togetherRequest(transcribedText)
 }
 
+// ollama and llamacpp, through ttr's KnowledgeProvider
+func llmRequest(transcribedText string, esn string) string {
+	apiResponse, err := ttr.LLMRequest(transcribedText, esn)
+	if err != nil {
+		logger.Println("LLM error: " + err.Error())
+		return "There was an error getting a response from the L L M."
+	}
+	return apiResponse
+}
+
+func llmKG(speechReq sr.SpeechRequest) string {
+	transcribedText, err := transcribe(speechReq)
+	if err != nil {
+		return "There was an error."
+	}
+	return llmRequest(transcribedText, speechReq.Device)
+}
+
 // Takes a SpeechRequest, figures out knowledgegraph provider, makes request, returns API response
 func KgRequest(speechReq sr.SpeechRequest) string {
 	if vars.APIConfig.Knowledge.Enable {
@@ -216,6 +236,36 @@ func KgRequest(speechReq sr.SpeechRequest) string {
 			return openaiKG(speechReq)
 		} else if vars.APIConfig.Knowledge.Provider == "together" {
 			return togetherKG(speechReq)
+		} else if vars.APIConfig.Knowledge.Provider == "ollama" || vars.APIConfig.Knowledge.Provider == "llamacpp" {
+			return llmKG(speechReq)
+		}
+	}
+	return "Knowledge graph is not enabled. This can be enabled in the web interface."
+}
+
+// Like KgRequest, but for text which has already been transcribed (or typed)
+func KgTextRequest(req *vtt.TextRequest, text string) string {
+	if vars.APIConfig.Knowledge.Enable {
+		if vars.APIConfig.Knowledge.Provider == "houndify" {
+			InitKnowledge()
+			serverResponse, err := HKGclient.TextSearch(houndify.TextRequest{
+				Query:     text,
+				UserID:    req.Device,
+				RequestID: req.Session,
+			})
+			if err != nil {
+				logger.Println("Houndify error: " + err.Error())
+				return "There was an error making the request to Houndify."
+			}
+			apiResponse, _ := ParseSpokenResponse(serverResponse)
+			logger.Println("Houndify response: " + apiResponse)
+			return apiResponse
+		} else if vars.APIConfig.Knowledge.Provider == "openai" {
+			return openaiRequest(text)
+		} else if vars.APIConfig.Knowledge.Provider == "together" || vars.APIConfig.Knowledge.Provider == "custom" {
+			return togetherRequest(text)
+		} else if vars.APIConfig.Knowledge.Provider == "ollama" || vars.APIConfig.Knowledge.Provider == "llamacpp" {
+			return llmRequest(text, req.Device)
 		}
 	}
 	return "Knowledge graph is not enabled. This can be enabled in the web interface."
@@ -224,7 +274,16 @@ func KgRequest(speechReq sr.SpeechRequest) string {
 func (s *Server) ProcessKnowledgeGraph(req *vtt.KnowledgeGraphRequest) (*vtt.KnowledgeGraphResponse, error) {
 	InitKnowledge()
 	speechReq := sr.ReqToSpeechRequest(req)
+	history.Begin(req.Device, req.Session, history.KindKnowledgeGraph, CurrentEngine().Name)
+	// the engine adds to the recording as it goes
+	defer func() {
+		history.Finish(req.Session, speechReq.Recording.Bytes())
+	}()
+	if ttr.TakeFollowUp(req.Device) {
+		return followUpKG(req, speechReq)
+	}
 	apiResponse := KgRequest(speechReq)
+	history.SetLLMResponse(req.Session, apiResponse)
 	kg := pb.KnowledgeGraphResponse{
 		Session:     req.Session,
 		DeviceId:    req.Device,
@@ -232,9 +291,34 @@ func (s *Server) ProcessKnowledgeGraph(req *vtt.KnowledgeGraphRequest) (*vtt.Kno
 		SpokenText:  apiResponse,
 	}
 	logger.Println("(KG) Bot " + speechReq.Device + " request served.")
+	observeTotal(speechReq, CurrentEngine().Name)
 	if err := req.Stream.Send(&kg); err != nil {
 		return nil, err
 	}
 	return nil, nil
 
 }
+
+// the robot was told to listen after the LLM answered (ttr/conversation.go), so this goes through the LLM like the first request did
+func followUpKG(req *vtt.KnowledgeGraphRequest, speechReq sr.SpeechRequest) (*vtt.KnowledgeGraphResponse, error) {
+	speechReq.SilenceTimeout = ttr.ConversationTimeout()
+	transcribedText, err := transcribe(speechReq)
+	if err != nil || strings.TrimSpace(transcribedText) == "" {
+		logger.Println("Bot " + req.Device + " didn't say anything, ending the conversation")
+		ttr.EndConversation(req.Device)
+		ttr.IntentPass(req, "intent_system_noaudio", "", map[string]string{}, false)
+		return nil, nil
+	}
+	logger.Println("Making LLM request for device " + req.Device + " (follow-up)...")
+	_, err = ttr.StreamingKGSim(req, req.Device, transcribedText)
+	if err != nil {
+		logger.Println("LLM error: " + err.Error())
+		logger.LogUI("LLM error: " + err.Error())
+		history.SetError(req.Session, err)
+		ttr.IntentPass(req, "intent_system_unmatched", transcribedText, map[string]string{}, false)
+		ttr.KGSim(req.Device, "There was an error getting a response from the L L M. Check the logs in the web interface.")
+	}
+	logger.Println("(KG) Bot " + req.Device + " request served.")
+	observeTotal(speechReq, CurrentEngine().Name)
+	return nil, nil
+}
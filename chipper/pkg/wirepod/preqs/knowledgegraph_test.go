@@ -0,0 +1,75 @@
+package processreqs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
+	"google.golang.org/grpc"
+)
+
+// stands in for the robot's side of a knowledge graph request
+type sentKG struct {
+	grpc.ServerStream
+	sent []*pb.KnowledgeGraphResponse
+}
+
+func (s *sentKG) Send(resp *pb.KnowledgeGraphResponse) error {
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func (s *sentKG) Recv() (*pb.StreamingKnowledgeGraphRequest, error) {
+	return nil, nil
+}
+
+// an engine which hears whatever heard returns, and keeps the silence timeout it was given
+func useEngine(t *testing.T, heard func() (string, error)) *time.Duration {
+	t.Helper()
+	var timeout time.Duration
+	engineMu.Lock()
+	old := engine
+	engine = stt.Engine{Name: "test", STT: func(req sr.SpeechRequest) (string, error) {
+		timeout = req.SilenceTimeout
+		return heard()
+	}}
+	engineMu.Unlock()
+	t.Cleanup(func() {
+		engineMu.Lock()
+		engine = old
+		engineMu.Unlock()
+	})
+	return &timeout
+}
+
+func TestFollowUpSilence(t *testing.T) {
+	knowledge := vars.APIConfig.Knowledge
+	t.Cleanup(func() { vars.APIConfig.Knowledge = knowledge })
+	vars.APIConfig.Knowledge.ConversationRobots = []string{"00e20000"}
+	vars.APIConfig.Knowledge.ConversationTimeout = 3
+
+	for name, heard := range map[string]func() (string, error){
+		"nothing said": func() (string, error) { return " ", nil },
+		"stt error":    func() (string, error) { return "", errors.New("no audio") },
+	} {
+		timeout := useEngine(t, heard)
+		stream := &sentKG{}
+		req := &vtt.KnowledgeGraphRequest{Stream: stream, Device: "00e20000", Session: "kg-1"}
+		if _, err := followUpKG(req, sr.SpeechRequest{Device: "00e20000", Session: "kg-1"}); err != nil {
+			t.Fatal(err)
+		}
+		// the user gets the conversation's timeout to start talking, not the usual one
+		if *timeout != 3*time.Second {
+			t.Errorf("%s: silence timeout %v, want 3s", name, *timeout)
+		}
+		// the robot's question is ended without an answer, and the LLM isn't asked
+		if len(stream.sent) != 1 || stream.sent[0].CommandType != "NoResultCommand" || stream.sent[0].SpokenText != "" {
+			t.Errorf("%s: sent %v", name, stream.sent)
+		}
+	}
+}
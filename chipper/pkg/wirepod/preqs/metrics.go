@@ -0,0 +1,32 @@
+package processreqs
+
+import (
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/metrics"
+	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+)
+
+// records the audio, end of speech and STT stages once the engine is done with a request
+func observeSpeech(speechReq sr.SpeechRequest, engine string) {
+	t := speechReq.Timing
+	if t == nil {
+		return
+	}
+	metrics.ObserveStage(metrics.StageEndOfSpeech, engine, "", speechReq.Device, t.Detecting)
+	if t.SpeechEnded.IsZero() {
+		// the engine didn't use DetectEndOfSpeech, so listening and transcribing can't be told apart
+		metrics.ObserveStage(metrics.StageSTT, engine, "", speechReq.Device, time.Since(t.Received))
+		return
+	}
+	metrics.ObserveStage(metrics.StageAudio, engine, "", speechReq.Device, t.SpeechEnded.Sub(t.Received))
+	metrics.ObserveStage(metrics.StageSTT, engine, "", speechReq.Device, time.Since(t.SpeechEnded))
+}
+
+// records how long the whole request took
+func observeTotal(speechReq sr.SpeechRequest, engine string) {
+	if speechReq.Timing == nil {
+		return
+	}
+	metrics.ObserveStage(metrics.StageTotal, engine, "", speechReq.Device, time.Since(speechReq.Timing.Received))
+}
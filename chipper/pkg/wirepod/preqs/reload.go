@@ -0,0 +1,175 @@
+package processreqs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	ttr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/ttr"
+)
+
+// reloading the intent data, custom intents and plugins while robots are using them. everything gets checked
+// before it's swapped in, and requests which already started keep what they had.
+// WatchIntents reloads when the files change, the reload_intents API call does it on demand.
+//
+// custom intents live in the database. if a customIntents.json shows up, it's loaded into it (like at the first start)
+
+// ReloadResult is what a reload did
+type ReloadResult struct {
+	Time          time.Time `json:"time"`
+	Intents       int       `json:"intents"`
+	CustomIntents int       `json:"custom_intents"`
+	// plugins which weren't loaded before
+	Plugins []string `json:"plugins,omitempty"`
+	// what couldn't be reloaded. the version from before stays in use
+	Errors []string `json:"errors,omitempty"`
+}
+
+// how often WatchIntents looks at the files
+var watchInterval = 2 * time.Second
+
+var reloadMu sync.Mutex
+var lastReload *ReloadResult
+
+// LastReload returns what the last reload did, if there's been one
+func LastReload() (ReloadResult, bool) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if lastReload == nil {
+		return ReloadResult{}, false
+	}
+	return *lastReload, true
+}
+
+// Reload loads the intent data, custom intents and plugins again, and swaps in whatever is valid
+func Reload() ReloadResult {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	result := ReloadResult{Time: time.Now()}
+	fail := func(what string, errs ...error) {
+		for _, err := range errs {
+			result.Errors = append(result.Errors, what+": "+err.Error())
+		}
+	}
+
+	if intents, err := vars.LoadIntents(); err != nil {
+		fail(vars.IntentsPath(), err)
+	} else if errs := ttr.ValidateIntents(intents); len(errs) > 0 {
+		fail(vars.IntentsPath(), errs...)
+	} else {
+		vars.SetIntents(intents)
+	}
+	result.Intents = len(vars.Intents())
+
+	if err := importCustomIntentsFile(); err != nil {
+		fail(vars.CustomIntentsPath, err)
+	}
+	result.CustomIntents = len(vars.CustomIntents())
+
+	loaded, errs := ttr.LoadPlugins()
+	result.Plugins = loaded
+	fail("plugins", errs...)
+
+	if err := ReloadGrammar(); err != nil {
+		fail(CurrentEngine().Name, err)
+	}
+
+	if len(result.Errors) > 0 {
+		logger.Warn("Reloaded the intents, with errors", logger.UI, "errors", strings.Join(result.Errors, "; "))
+	} else {
+		logger.Info("Reloaded the intents", logger.UI, "intents", result.Intents, "custom_intents", result.CustomIntents, "new_plugins", len(loaded))
+	}
+	lastReload = &result
+	return result
+}
+
+// ReloadGrammar lets the STT engine know the intents or custom intents changed
+func ReloadGrammar() error {
+	e := CurrentEngine()
+	if e.Reload == nil {
+		return nil
+	}
+	return e.Reload()
+}
+
+func importCustomIntentsFile() error {
+	file, err := os.ReadFile(vars.CustomIntentsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var intents vars.IntentsStruct
+	if err := json.Unmarshal(file, &intents); err != nil {
+		return err
+	}
+	if err := vars.ReplaceCustomIntents(intents); err != nil {
+		return err
+	}
+	return os.Rename(vars.CustomIntentsPath, vars.CustomIntentsPath+".imported")
+}
+
+var watchOnce sync.Once
+
+// WatchIntents reloads whenever the intent data, customIntents.json or the plugins change
+func WatchIntents() {
+	watchOnce.Do(func() {
+		go watchIntents()
+	})
+}
+
+func watchIntents() {
+	last := watchedFiles()
+	var changing string
+	for range time.Tick(watchInterval) {
+		now := watchedFiles()
+		if now == last {
+			changing = ""
+			continue
+		}
+		// reload once they've stopped changing, so a file which is still being written isn't read
+		if now != changing {
+			changing = now
+			continue
+		}
+		changing = ""
+		Reload()
+		// the reload can change them itself (customIntents.json gets renamed)
+		last = watchedFiles()
+	}
+}
+
+// a fingerprint of every file a reload reads
+func watchedFiles() string {
+	paths := []string{vars.IntentsPath(), vars.CustomIntentsPath}
+	if entries, err := os.ReadDir(ttr.PluginsDir); err == nil {
+		for _, entry := range entries {
+			if strings.Contains(entry.Name(), ".so") {
+				paths = append(paths, filepath.Join(ttr.PluginsDir, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(paths[2:])
+	var fingerprint strings.Builder
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(&fingerprint, "%s:%v\n", path, err)
+			continue
+		}
+		fmt.Fprintf(&fingerprint, "%s:%d:%d\n", path, info.ModTime().UnixNano(), info.Size())
+	}
+	return fingerprint.String()
+}
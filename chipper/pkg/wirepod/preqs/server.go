@@ -1,11 +1,15 @@
 package processreqs
 
 import (
-	"fmt"
+	"errors"
+	"strings"
+	"sync"
 
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/history"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
 	ttr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/ttr"
 )
 
@@ -22,53 +26,93 @@ type JsonIntent struct {
 
 var sttLanguage string = "en-US"
 
-// speech-to-text
-var sttHandler func(sr.SpeechRequest) (string, error)
+// the engine requests are currently going to. swapped by UseEngine
+var engine stt.Engine
+var engineMu sync.RWMutex
 
-// speech-to-intent (rhino)
-var stiHandler func(sr.SpeechRequest) (string, map[string]string, error)
+// CurrentEngine returns the STT engine in use
+func CurrentEngine() stt.Engine {
+	engineMu.RLock()
+	defer engineMu.RUnlock()
+	return engine
+}
 
-var isSti bool = false
+// speech-to-text with whatever engine is in use. speech-to-intent engines can't do this
+func transcribe(req sr.SpeechRequest) (string, error) {
+	e := CurrentEngine()
+	if e.STT == nil {
+		return "", errors.New(e.Name + " does not produce text")
+	}
+	text, err := e.STT(req)
+	observeSpeech(req, e.Name)
+	history.SetTranscript(req.Session, text)
+	history.SetError(req.Session, err)
+	return text, err
+}
 
 func ReloadVosk() {
 	if vars.APIConfig.STT.Service == "vosk" || vars.APIConfig.STT.Service == "whisper.cpp" {
+		// the grammar is made from the intents, so they're loaded first
+		intents, _ := vars.LoadIntents()
+		vars.SetIntents(intents)
 		vars.SttInitFunc()
-		vars.IntentList, _ = vars.LoadIntents()
 	}
 }
 
-// New returns a new server
-func New(InitFunc func() error, SttHandler interface{}, voiceProcessor string) (*Server, error) {
+// UseEngine initializes a registered STT engine for a language and sends all new requests to it.
+// An empty language keeps the configured one, and the engine's default is used if it doesn't support it.
+// If initialization fails, the previous engine stays in use, with its language.
+func UseEngine(name, language string) error {
+	e, ok := stt.Get(name)
+	if !ok {
+		return errors.New("stt engine " + name + " is not registered in this build (available: " + strings.Join(stt.Names(), ", ") + ")")
+	}
+	// Decide the STT language
+	if language == "" {
+		language = vars.APIConfig.STT.Language
+	}
+	if !e.SupportsLanguage(language) {
+		language = e.DefaultLanguage()
+	}
+	oldLanguage, oldSTTLanguage, oldIntents := vars.APIConfig.STT.Language, sttLanguage, vars.Intents()
+	// engines read the language from the config when they're initialized
+	vars.APIConfig.STT.Language = language
+	sttLanguage = language
+	intents, _ := vars.LoadIntents()
+	vars.SetIntents(intents)
+	logger.Println("Initiating " + name + " voice processor with language " + sttLanguage)
+	err := e.Init()
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	// with nothing else to fall back to, keep the engine anyway so setup can init it again later
+	if err != nil && engine.Name != "" {
+		vars.APIConfig.STT.Language = oldLanguage
+		sttLanguage = oldSTTLanguage
+		vars.SetIntents(oldIntents)
+		return err
+	}
+	engine = e
+	VoiceProcessor = name
+	vars.SttInitFunc = e.Init
+	vars.APIConfig.STT.Service = name
+	return err
+}
 
-	// Decide the TTS language
-	if voiceProcessor != "vosk" && voiceProcessor != "whisper.cpp" {
-		vars.APIConfig.STT.Language = "en-US"
+// New returns a new server, using the engine the config asks for if this build has it, or voiceProcessor otherwise
+func New(voiceProcessor string) (*Server, error) {
+	name := voiceProcessor
+	if _, ok := stt.Get(vars.APIConfig.STT.Service); ok {
+		name = vars.APIConfig.STT.Service
+	} else if _, ok := stt.Get(name); !ok {
+		if engines := stt.Engines(); len(engines) > 0 {
+			name = engines[0].Name
+		}
 	}
-	sttLanguage = vars.APIConfig.STT.Language
-	vars.IntentList, _ = vars.LoadIntents()
-	logger.Println("Initiating " + voiceProcessor + " voice processor with language " + sttLanguage)
-	vars.SttInitFunc = InitFunc
-	err := InitFunc()
+	err := UseEngine(name, "")
 	if err != nil {
 		return nil, err
 	}
 
-	// SttHandler can either be `func(sr.SpeechRequest) (string, error)` or `func (sr.SpeechRequest) (string, map[string]string, error)`
-	// second one exists to accomodate Rhino
-
-	// check function type
-	if str, is := SttHandler.(func(sr.SpeechRequest) (string, error)); is {
-		sttHandler = str
-	} else if str, is := SttHandler.(func(sr.SpeechRequest) (string, map[string]string, error)); is {
-		stiHandler = str
-		isSti = true
-	} else {
-		return nil, fmt.Errorf("stthandler not of correct type")
-	}
-
-	// Initiating the chosen voice processor and load intents from json
-	VoiceProcessor = voiceProcessor
-
 	// Load plugins
 	ttr.LoadPlugins()
 
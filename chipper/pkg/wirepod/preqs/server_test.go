@@ -0,0 +1,65 @@
+package processreqs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
+)
+
+func TestUseEngineLanguage(t *testing.T) {
+	language := vars.APIConfig.STT.Language
+	service := vars.APIConfig.STT.Service
+	engineMu.Lock()
+	old, oldLanguage, initFunc := engine, sttLanguage, vars.SttInitFunc
+	engineMu.Unlock()
+	t.Cleanup(func() {
+		vars.APIConfig.STT.Language = language
+		vars.APIConfig.STT.Service = service
+		engineMu.Lock()
+		engine, sttLanguage, vars.SttInitFunc = old, oldLanguage, initFunc
+		engineMu.Unlock()
+	})
+	// the language each engine was initialized with
+	var initialized []string
+	register := func(name string, err error) {
+		stt.Register(stt.Engine{
+			Name: name,
+			Init: func() error {
+				initialized = append(initialized, name+" "+vars.APIConfig.STT.Language)
+				return err
+			},
+			STT:          func(sr.SpeechRequest) (string, error) { return "", nil },
+			Capabilities: stt.Capabilities{Languages: []string{"en-US", "de-DE", "fr-FR"}},
+		})
+	}
+	register("test-working", nil)
+	register("test-broken", errors.New("no model"))
+
+	vars.APIConfig.STT.Language = "en-US"
+	if err := UseEngine("test-working", "de-DE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UseEngine("test-broken", "fr-FR"); err == nil {
+		t.Error("a broken engine was switched to")
+	}
+	if e := CurrentEngine(); e.Name != "test-working" || vars.APIConfig.STT.Language != "de-DE" || sttLanguage != "de-DE" {
+		t.Errorf("after a failed switch: %s in %s (%s)", e.Name, vars.APIConfig.STT.Language, sttLanguage)
+	}
+	// not supported, so the engine's default
+	if err := UseEngine("test-working", "pl-PL"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"test-working de-DE", "test-broken fr-FR", "test-working en-US"}
+	if len(initialized) != len(want) {
+		t.Fatalf("initialized %v, want %v", initialized, want)
+	}
+	for i := range want {
+		if initialized[i] != want[i] {
+			t.Errorf("initialized %v, want %v", initialized, want)
+			break
+		}
+	}
+}
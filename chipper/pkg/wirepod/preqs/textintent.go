@@ -0,0 +1,39 @@
+package processreqs
+
+import (
+	"strings"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	ttr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/ttr"
+)
+
+// ProcessTextIntent runs typed text through the same matcher as transcribed speech
+func (s *Server) ProcessTextIntent(req *vtt.TextRequest) (*vtt.TextResponse, error) {
+	text := strings.TrimSpace(req.Text)
+	logger.Println("Bot " + req.Device + " text request: " + text)
+	if text == "" {
+		ttr.IntentPass(req, "intent_system_noaudio", "", map[string]string{}, false)
+		return &vtt.TextResponse{Intent: req.Response}, nil
+	}
+	// there is no audio codec here, so always use the newer param checker
+	successMatched := ttr.ProcessTextAll(req, text, vars.Intents(), true)
+	if !successMatched {
+		if vars.APIConfig.Knowledge.Enable {
+			logger.Println("Making knowledge request for device " + req.Device + "...")
+			apiResponse := KgTextRequest(req, text)
+			ttr.IntentPass(req, "intent_system_unmatched", text, map[string]string{}, false)
+			ttr.AttachKGResponse(req, text, apiResponse)
+		} else {
+			logger.Println("No intent was matched.")
+			ttr.IntentPass(req, "intent_system_unmatched", text, map[string]string{}, false)
+		}
+	}
+	if req.Response == nil {
+		// make sure the rpc always has something to return
+		ttr.IntentPass(req, "intent_system_unmatched", text, map[string]string{}, false)
+	}
+	logger.Println("Bot " + req.Device + " text request served.")
+	return &vtt.TextResponse{Intent: req.Response}, nil
+}
@@ -0,0 +1,140 @@
+package replay
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/digital-dream-labs/opus-go/opus"
+)
+
+// the robot streams 16000 Hz mono 16-bit audio
+const sampleRate = 16000
+
+// 50ms of pcm per chunk, roughly what the robot sends
+const pcmChunkSize = 1600
+
+// silence added to the end of every utterance so the VAD always sees an end of speech,
+// otherwise the stream would run out before the STT engine stops reading
+const silencePadding = sampleRate * 2
+
+// loaded audio, split up the same way the robot would send it
+type utterance struct {
+	Chunks [][]byte
+	IsOpus bool
+}
+
+func loadAudio(path string) (utterance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return utterance{}, err
+	}
+	if len(data) < 4 {
+		return utterance{}, errors.New("file too short")
+	}
+	switch string(data[:4]) {
+	case "OggS":
+		return loadOgg(data)
+	case "RIFF":
+		pcm, err := wavToPCM(data)
+		if err != nil {
+			return utterance{}, err
+		}
+		return utterance{Chunks: chunkPCM(pcm)}, nil
+	default:
+		// assume raw 16000 Hz mono s16le
+		return utterance{Chunks: chunkPCM(data)}, nil
+	}
+}
+
+func chunkPCM(pcm []byte) [][]byte {
+	pcm = append(pcm, make([]byte, silencePadding)...)
+	var chunks [][]byte
+	for len(pcm) > 0 {
+		n := pcmChunkSize
+		if len(pcm) < n {
+			n = len(pcm)
+		}
+		chunks = append(chunks, pcm[:n])
+		pcm = pcm[n:]
+	}
+	return chunks
+}
+
+func wavToPCM(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("not a wave file")
+	}
+	var gotFmt bool
+	pos := 12
+	for pos+8 <= len(data) {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, errors.New("invalid fmt chunk")
+			}
+			format := binary.LittleEndian.Uint16(body[0:2])
+			channels := binary.LittleEndian.Uint16(body[2:4])
+			rate := binary.LittleEndian.Uint32(body[4:8])
+			bits := binary.LittleEndian.Uint16(body[14:16])
+			if format != 1 || channels != 1 || rate != sampleRate || bits != 16 {
+				return nil, fmt.Errorf("wav must be 16000 Hz mono 16-bit PCM (got format %d, %d channels, %d Hz, %d-bit)", format, channels, rate, bits)
+			}
+			gotFmt = true
+		case "data":
+			if !gotFmt {
+				return nil, errors.New("data chunk before fmt chunk")
+			}
+			return body, nil
+		}
+		// chunks are padded to an even size
+		pos = pos + 8 + size + size%2
+	}
+	return nil, errors.New("no data chunk in wav")
+}
+
+// Ogg files get decoded, padded with silence, then encoded again. The decoder on the other
+// end is the same one a real stream goes through, page by page.
+func loadOgg(data []byte) (utterance, error) {
+	var dec opus.OggStream
+	pcm, err := dec.Decode(data)
+	if err != nil {
+		return utterance{}, err
+	}
+	pcm = append(pcm, make([]byte, silencePadding)...)
+	enc := opus.OggStream{
+		SampleRate: sampleRate,
+		Channels:   1,
+		Bitrate:    40000,
+	}
+	// 20ms frames
+	frameBytes := sampleRate / 50 * 2
+	var chunks [][]byte
+	for len(pcm) >= frameBytes {
+		out, err := enc.EncodeBytes(pcm[:frameBytes])
+		if err != nil {
+			return utterance{}, err
+		}
+		pcm = pcm[frameBytes:]
+		if len(out) > 0 {
+			chunks = append(chunks, out)
+		}
+	}
+	if out := enc.Flush(); len(out) > 0 {
+		chunks = append(chunks, out)
+	}
+	if len(chunks) < 2 {
+		return utterance{}, errors.New("ogg file contains no audio")
+	}
+	// the first chunk is only the headers. the robot's first chunk always has audio in it too
+	chunks = append([][]byte{append(chunks[0], chunks[1]...)}, chunks[2:]...)
+	return utterance{Chunks: chunks, IsOpus: true}, nil
+}
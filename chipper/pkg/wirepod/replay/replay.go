@@ -0,0 +1,322 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/history"
+	wp "github.com/kercre123/wire-pod/chipper/pkg/wirepod/preqs"
+	"google.golang.org/grpc"
+)
+
+// replays recorded utterances through the same path a robot's voice stream goes through,
+// then reports how many got the intent they were supposed to
+
+// ManifestEntry is one recorded utterance and the intent it should match
+type ManifestEntry struct {
+	File string `json:"file"`
+	// intent name as sent to the robot, like intent_weather_extend
+	Intent string `json:"intent"`
+	// defaults to the configured STT language
+	Language string `json:"language,omitempty"`
+	// optional, only used in the report
+	Text string `json:"text,omitempty"`
+}
+
+// Result is the outcome of one utterance
+type Result struct {
+	File        string            `json:"file"`
+	Language    string            `json:"language"`
+	Expected    string            `json:"expected"`
+	Got         string            `json:"got"`
+	Transcribed string            `json:"transcribed"`
+	Params      map[string]string `json:"params,omitempty"`
+	Correct     bool              `json:"correct"`
+	Skipped     bool              `json:"skipped,omitempty"` // the engine doesn't support the language, counted as wrong
+	Error       string            `json:"error,omitempty"`
+	Duration    time.Duration     `json:"duration"`
+}
+
+// Tally is a count of correct results for an intent or language
+type Tally struct {
+	Total   int `json:"total"`
+	Correct int `json:"correct"`
+	// part of Total
+	Skipped int `json:"skipped"`
+}
+
+func (t Tally) Accuracy() float64 {
+	if t.Total == 0 {
+		return 0
+	}
+	return float64(t.Correct) / float64(t.Total) * 100
+}
+
+// Report is everything a replay run produced
+type Report struct {
+	Engine     string           `json:"engine"`
+	Results    []Result         `json:"results"`
+	Total      Tally            `json:"total"`
+	ByIntent   map[string]Tally `json:"by_intent"`
+	ByLanguage map[string]Tally `json:"by_language"`
+}
+
+// LoadManifest reads a json array of ManifestEntry
+func LoadManifest(path string) ([]ManifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if entry.File == "" || entry.Intent == "" {
+			return nil, fmt.Errorf("manifest entry %d needs both file and intent", i)
+		}
+	}
+	return entries, nil
+}
+
+// stands in for the robot's side of a StreamingIntent call
+type replayStream struct {
+	grpc.ServerStream
+	chunks    [][]byte
+	responses []*pb.IntentResponse
+}
+
+func (s *replayStream) Recv() (*pb.StreamingIntentRequest, error) {
+	if len(s.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return &pb.StreamingIntentRequest{InputAudio: chunk}, nil
+}
+
+func (s *replayStream) Send(resp *pb.IntentResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *replayStream) Context() context.Context {
+	return context.Background()
+}
+
+// Run replays every entry in the manifest with the given STT engine. Audio files are relative to dir.
+func Run(dir string, entries []ManifestEntry, voiceProcessorName string, esn string) (*Report, error) {
+	// the LLM fallback takes control of a real robot, and an unmatched result must stay unmatched
+	vars.APIConfig.Knowledge.Enable = false
+	vars.APIConfig.Knowledge.IntentGraph = false
+	vars.APIConfig.PastInitialSetup = true
+
+	defaultLang := vars.APIConfig.STT.Language
+	if defaultLang == "" {
+		defaultLang = "en-US"
+	}
+	// group by language so each model only gets loaded once
+	byLang := make(map[string][]ManifestEntry)
+	var langs []string
+	for _, entry := range entries {
+		if entry.Language == "" {
+			entry.Language = defaultLang
+		}
+		if _, ok := byLang[entry.Language]; !ok {
+			langs = append(langs, entry.Language)
+		}
+		byLang[entry.Language] = append(byLang[entry.Language], entry)
+	}
+
+	report := &Report{
+		Engine:     voiceProcessorName,
+		ByIntent:   make(map[string]Tally),
+		ByLanguage: make(map[string]Tally),
+	}
+	if len(langs) == 0 {
+		return report, nil
+	}
+	// the engine named here wins over whatever the config says
+	vars.APIConfig.STT.Service = voiceProcessorName
+	vars.APIConfig.STT.Language = langs[0]
+	s, err := wp.New(voiceProcessorName)
+	if err != nil {
+		return nil, errors.New("error initializing " + voiceProcessorName + ": " + err.Error())
+	}
+	for _, lang := range langs {
+		if !wp.CurrentEngine().SupportsLanguage(lang) {
+			logger.Println("Replay: " + voiceProcessorName + " doesn't support " + lang + ", skipping " + strconv.Itoa(len(byLang[lang])) + " utterances")
+			for _, entry := range byLang[lang] {
+				report.add(Result{
+					File:     entry.File,
+					Language: lang,
+					Expected: entry.Intent,
+					Skipped:  true,
+					Error:    voiceProcessorName + " doesn't support " + lang,
+				})
+			}
+			continue
+		}
+		if lang != vars.APIConfig.STT.Language {
+			if err := wp.UseEngine(voiceProcessorName, lang); err != nil {
+				return nil, errors.New("error initializing " + voiceProcessorName + " for " + lang + ": " + err.Error())
+			}
+		}
+		for i, entry := range byLang[lang] {
+			result := replayOne(s, filepath.Join(dir, entry.File), entry, esn, i)
+			report.add(result)
+		}
+	}
+	return report, nil
+}
+
+func replayOne(s *wp.Server, path string, entry ManifestEntry, esn string, num int) Result {
+	result := Result{
+		File:     entry.File,
+		Language: entry.Language,
+		Expected: entry.Intent,
+	}
+	utt, err := loadAudio(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	return replayUtterance(s, utt, result, esn, "replay-"+strconv.Itoa(num))
+}
+
+// Rerun sends a request from the history through the pipeline again, as it's set up now, to see what it matches.
+// it stops there: the LLM isn't asked, and a custom intent or plugin which matches is named instead of run
+func Rerun(req history.Request) (Result, error) {
+	path, err := history.AudioPath(req.ID)
+	if err != nil {
+		return Result{}, err
+	}
+	utt, err := loadAudio(path)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		File:     filepath.Base(path),
+		Language: vars.APIConfig.STT.Language,
+		Expected: req.Intent,
+	}
+	return replayUtterance(&wp.Server{}, utt, result, req.ESN, "rerun-"+req.ID), nil
+}
+
+func replayUtterance(s *wp.Server, utt utterance, result Result, esn string, session string) Result {
+	codec := pb.AudioEncoding_LINEAR_PCM
+	if utt.IsOpus {
+		codec = pb.AudioEncoding_OGG_OPUS
+	}
+	stream := &replayStream{chunks: utt.Chunks[1:]}
+	firstReq := &pb.StreamingIntentRequest{
+		DeviceId:      esn,
+		Session:       session,
+		InputAudio:    utt.Chunks[0],
+		AudioEncoding: codec,
+	}
+	startTime := time.Now()
+	_, err := s.ProcessIntent(&vtt.IntentRequest{
+		Time:       startTime,
+		Stream:     stream,
+		Device:     esn,
+		Session:    session,
+		LangString: result.Language,
+		FirstReq:   firstReq,
+		AudioCodec: codec,
+		DryRun:     true,
+	})
+	result.Duration = time.Since(startTime)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if len(stream.responses) == 0 {
+		result.Error = "no intent was sent"
+		return result
+	}
+	intentResult := stream.responses[len(stream.responses)-1].IntentResult
+	if intentResult != nil {
+		result.Got = intentResult.Action
+		result.Transcribed = intentResult.QueryText
+		result.Params = intentResult.Parameters
+	}
+	result.Correct = result.Got == result.Expected
+	return result
+}
+
+func (r *Report) add(result Result) {
+	r.Results = append(r.Results, result)
+	intent := r.ByIntent[result.Expected]
+	lang := r.ByLanguage[result.Language]
+	intent.Total++
+	lang.Total++
+	r.Total.Total++
+	if result.Skipped {
+		intent.Skipped++
+		lang.Skipped++
+		r.Total.Skipped++
+	}
+	if result.Correct {
+		intent.Correct++
+		lang.Correct++
+		r.Total.Correct++
+	}
+	r.ByIntent[result.Expected] = intent
+	r.ByLanguage[result.Language] = lang
+}
+
+// Print writes a human-readable summary of the report
+func (r *Report) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MISMATCHED FILE\tLANGUAGE\tEXPECTED\tGOT\tTRANSCRIBED")
+	for _, result := range r.Results {
+		if result.Correct {
+			continue
+		}
+		got := result.Got
+		if result.Skipped {
+			got = "skipped: " + result.Error
+		} else if result.Error != "" {
+			got = "error: " + result.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%q\n", result.File, result.Language, result.Expected, got, result.Transcribed)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "INTENT\tCORRECT\tTOTAL\tACCURACY")
+	printTallies(tw, r.ByIntent)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "LANGUAGE\tCORRECT\tTOTAL\tACCURACY")
+	printTallies(tw, r.ByLanguage)
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "%s overall\t%d\t%d\t%.1f%%\n", r.Engine, r.Total.Correct, r.Total.Total, r.Total.Accuracy())
+	if r.Total.Skipped > 0 {
+		fmt.Fprintf(tw, "%d skipped (languages %s doesn't support), counted as wrong\n", r.Total.Skipped, r.Engine)
+	}
+	tw.Flush()
+}
+
+func printTallies(w io.Writer, tallies map[string]Tally) {
+	var keys []string
+	for key := range tallies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		t := tallies[key]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f%%\n", key, t.Correct, t.Total, t.Accuracy())
+	}
+}
@@ -0,0 +1,161 @@
+package scheduler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cron expressions: "minute hour day-of-month month day-of-week", like crontab.
+// each field is *, a number, a range (1-5), a step (*/15, 8-18/2) or a list of those (1,15,30).
+// day-of-week is 0-6 from Sunday (7 is Sunday too), and months and weekdays can be names (jan, mon).
+// @hourly, @daily, @weekly, @monthly and @yearly work too. like cron, if both days are set, either one matches.
+
+// Cron is a parsed cron expression
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// whether the day fields were *, for the either-one rule
+	domAll, dowAll bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseCron parses a cron expression
+func ParseCron(expr string) (Cron, error) {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return Cron{}, errors.New("a cron expression needs 5 fields (minute hour day month weekday), got " + strconv.Itoa(len(fields)))
+	}
+	var c Cron
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return Cron{}, errors.New("minute: " + err.Error())
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return Cron{}, errors.New("hour: " + err.Error())
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return Cron{}, errors.New("day of month: " + err.Error())
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return Cron{}, errors.New("month: " + err.Error())
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return Cron{}, errors.New("day of week: " + err.Error())
+	}
+	// 7 is Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAll = fields[2] == "*"
+	c.dowAll = fields[4] == "*"
+	return c, nil
+}
+
+// the field as a bitmask of the values it allows. names start at min
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.New("invalid step in " + part)
+			}
+			rangePart = part[:i]
+		}
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = cronValue(bounds[0], min, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = cronValue(bounds[1], min, names); err != nil {
+					return 0, err
+				}
+			} else if step != 1 {
+				// "5/15" is from 5 on
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.New(part + " is out of range (" + strconv.Itoa(min) + "-" + strconv.Itoa(max) + ")")
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << v
+		}
+	}
+	return mask, nil
+}
+
+func cronValue(s string, min int, names []string) (int, error) {
+	for i, name := range names {
+		if s == name {
+			return i + min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("invalid value " + s)
+	}
+	return v, nil
+}
+
+func (c Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.domAll || c.dowAll {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t the expression matches, in t's time zone.
+// zero if it never does (the 31st of February)
+func (c Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// a match is at most a few years away, unless there is none
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<t.Month()) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			// not t.Add(time.Hour), the clocks might change that day
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,449 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// jobs which make a robot do something at a time, or over and over on a cron expression.
+// they're kept in the database (vars.DB), so they survive a restart. Start runs them, and what running one means
+// is up to the Runner it's given (ttr's), so this package doesn't need to know about robots.
+//
+// jobs whose time passed while wire-pod wasn't running go by their Missed setting when it starts again:
+// MissedRun (the default for one-off jobs) runs them once, right away, however late it is.
+// MissedSkip (the default for cron jobs) moves on to the next time without running.
+// a job's next time is saved before it runs, so a crash while it's running doesn't run it twice.
+
+const (
+	// says the value
+	ActionSay = "say"
+	// plays the animation called the value (the ones the LLM can use)
+	ActionAnimation = "animation"
+	// runs the exec of the custom intent called the value
+	ActionCustomIntent = "custom_intent"
+	// sends the value as an app intent, like /api-sdk/cloud_intent
+	ActionCloudIntent = "cloud_intent"
+)
+
+const (
+	MissedRun  = "run"
+	MissedSkip = "skip"
+)
+
+const bucket = "schedule"
+
+// the timer is never set for longer than this, so a change to the clock is noticed
+const maxSleep = time.Minute
+
+// Action is what a job does
+type Action struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Job is something a robot does at a time
+type Job struct {
+	ID  string `json:"id"`
+	ESN string `json:"esn"`
+	// what it's for ("take out the trash")
+	Name string `json:"name"`
+	// one of At (once) and Cron (over and over)
+	At   time.Time `json:"at"`
+	Cron string    `json:"cron,omitempty"`
+	// the IANA time zone the cron expression is in. the robot's if it's empty
+	TimeZone string `json:"time_zone,omitempty"`
+	Action   Action `json:"action"`
+	// MissedRun or MissedSkip
+	Missed  string    `json:"missed,omitempty"`
+	Created time.Time `json:"created"`
+	// when it runs next. zero once a one-off job has run
+	Next    time.Time `json:"next"`
+	LastRun time.Time `json:"last_run"`
+	// empty if the last run went fine
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Runner does what the jobs say
+type Runner interface {
+	// whether an action can be done at all, checked before a job is saved
+	Check(action Action) error
+	Run(job Job) error
+	// for jobs without a time zone
+	TimeZone(esn string) *time.Location
+}
+
+var (
+	// held for anything which reads and writes jobs, so a run and an edit don't overwrite each other
+	mu     sync.Mutex
+	runner Runner
+	// poked when the jobs change, so the timer is set again
+	wake = make(chan struct{}, 1)
+	// replaced in tests
+	now = time.Now
+)
+
+// Start runs the jobs whose time passed while wire-pod was off (if their Missed setting says to), then the rest as they come up
+func Start(r Runner) {
+	mu.Lock()
+	if runner != nil {
+		mu.Unlock()
+		return
+	}
+	runner = r
+	mu.Unlock()
+	catchUp()
+	go loop(make(chan struct{}))
+}
+
+func loop(stop chan struct{}) {
+	for {
+		runDue()
+		sleep := maxSleep
+		if next, ok := nextRun(); ok {
+			sleep = min(max(next.Sub(now()), 0), maxSleep)
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func poke() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// List returns the jobs, the next to run first. esn is optional
+func List(esn string) ([]Job, error) {
+	jobs, err := load()
+	if err != nil {
+		return nil, err
+	}
+	list := []Job{}
+	for _, job := range jobs {
+		if esn == "" || job.ESN == esn {
+			list = append(list, job)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].Next, list[j].Next
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		return a.Before(b)
+	})
+	return list, nil
+}
+
+// Get returns the job with the id
+func Get(id string) (Job, error) {
+	var job Job
+	err := vars.DB.View(func(tx vars.StoreTx) error {
+		found, err := vars.StoreGetJSON(tx, bucket, id, &job)
+		if err == nil && !found {
+			err = errors.New("no job with the id " + id)
+		}
+		return err
+	})
+	return job, err
+}
+
+// Add saves a new job and works out when it runs
+func Add(job Job) (Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	job.Created = now()
+	if err := prepare(&job); err != nil {
+		return Job{}, err
+	}
+	if job.Next.IsZero() {
+		return Job{}, errors.New("the job would never run")
+	}
+	err := vars.DB.Update(func(tx vars.StoreTx) error {
+		// ids sort by when the jobs were made. two in the same nanosecond get the next one
+		id := job.Created.UnixNano()
+		for tx.Get(bucket, strconv.FormatInt(id, 10)) != nil {
+			id++
+		}
+		job.ID = strconv.FormatInt(id, 10)
+		return vars.StorePutJSON(tx, bucket, job.ID, job)
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	logger.Info("Scheduled a job", logger.UI, logger.KeyESN, job.ESN, "id", job.ID, "name", job.Name, "next", job.Next.Format(time.RFC3339))
+	poke()
+	return job, nil
+}
+
+// Edit replaces a job. it runs next at the time the new one says
+func Edit(job Job) (Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	old, err := Get(job.ID)
+	if err != nil {
+		return Job{}, err
+	}
+	job.Created, job.LastRun, job.LastError = old.Created, old.LastRun, old.LastError
+	if err := prepare(&job); err != nil {
+		return Job{}, err
+	}
+	if err := save(job); err != nil {
+		return Job{}, err
+	}
+	poke()
+	return job, nil
+}
+
+// Delete removes a job
+func Delete(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, err := Get(id); err != nil {
+		return err
+	}
+	err := vars.DB.Update(func(tx vars.StoreTx) error {
+		return tx.Delete(bucket, id)
+	})
+	poke()
+	return err
+}
+
+// RunNow runs a job right away and waits for it. it still runs at its next time too
+func RunNow(id string) error {
+	job, err := Get(id)
+	if err != nil {
+		return err
+	}
+	return run(job)
+}
+
+// checks the job and sets Missed and Next
+func prepare(job *Job) error {
+	if job.ESN == "" {
+		return errors.New("a job needs a robot (esn)")
+	}
+	switch job.Action.Type {
+	case ActionSay, ActionAnimation, ActionCustomIntent, ActionCloudIntent:
+	default:
+		return errors.New("unknown action " + job.Action.Type)
+	}
+	if job.Action.Value == "" {
+		return errors.New("the " + job.Action.Type + " action needs a value")
+	}
+	if runner != nil {
+		if err := runner.Check(job.Action); err != nil {
+			return err
+		}
+	}
+	if job.At.IsZero() == (job.Cron == "") {
+		return errors.New("a job needs either a time (at) or a cron expression")
+	}
+	if _, err := location(*job); err != nil {
+		return err
+	}
+	switch job.Missed {
+	case "":
+		job.Missed = MissedRun
+		if job.Cron != "" {
+			job.Missed = MissedSkip
+		}
+	case MissedRun, MissedSkip:
+	default:
+		return errors.New("missed is " + MissedRun + " or " + MissedSkip)
+	}
+	if job.Cron == "" {
+		if !job.At.After(now()) {
+			return errors.New(job.At.Format(time.RFC3339) + " has already passed")
+		}
+		job.Next = job.At
+		return nil
+	}
+	next, err := nextCron(*job, now())
+	if err != nil {
+		return err
+	}
+	job.Next = next
+	return nil
+}
+
+func location(job Job) (*time.Location, error) {
+	if job.TimeZone != "" {
+		loc, err := time.LoadLocation(job.TimeZone)
+		if err != nil {
+			return nil, errors.New("unknown time zone " + job.TimeZone)
+		}
+		return loc, nil
+	}
+	if runner != nil {
+		return runner.TimeZone(job.ESN), nil
+	}
+	return time.Local, nil
+}
+
+// the first time after t a cron job runs
+func nextCron(job Job, t time.Time) (time.Time, error) {
+	cron, err := ParseCron(job.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := location(job)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cron.Next(t.In(loc)), nil
+}
+
+func load() ([]Job, error) {
+	var jobs []Job
+	err := vars.DB.View(func(tx vars.StoreTx) error {
+		return tx.ForEach(bucket, func(key string, value []byte) error {
+			var job Job
+			if err := json.Unmarshal(value, &job); err != nil {
+				logger.Warn("Skipping a scheduled job which can't be read", "id", key, "error", err)
+				return nil
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+func save(job Job) error {
+	return vars.DB.Update(func(tx vars.StoreTx) error {
+		return vars.StorePutJSON(tx, bucket, job.ID, job)
+	})
+}
+
+func nextRun() (time.Time, bool) {
+	jobs, err := load()
+	if err != nil {
+		return time.Time{}, false
+	}
+	var next time.Time
+	for _, job := range jobs {
+		if !job.Next.IsZero() && (next.IsZero() || job.Next.Before(next)) {
+			next = job.Next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// moves a job past its time, and saves it before it runs. false if it shouldn't run after all
+func advance(job *Job, missed bool) bool {
+	t := now()
+	shouldRun := !missed || job.Missed != MissedSkip
+	if job.Cron == "" {
+		job.Next = time.Time{}
+	} else {
+		next, err := nextCron(*job, t)
+		if err != nil {
+			logger.Warn("A scheduled job's cron expression stopped working", logger.KeyESN, job.ESN, "id", job.ID, "error", err)
+		}
+		job.Next = next
+	}
+	if !shouldRun && job.Cron == "" {
+		logger.Info("Dropping a job which should have run while wire-pod was off", logger.UI, logger.KeyESN, job.ESN, "id", job.ID, "name", job.Name)
+		vars.DB.Update(func(tx vars.StoreTx) error {
+			return tx.Delete(bucket, job.ID)
+		})
+		return false
+	}
+	if err := save(*job); err != nil {
+		logger.Warn("Couldn't save a scheduled job, not running it", logger.KeyESN, job.ESN, "id", job.ID, "error", err)
+		return false
+	}
+	if !shouldRun {
+		logger.Info("Skipping a run which was missed while wire-pod was off", logger.UI, logger.KeyESN, job.ESN, "id", job.ID, "name", job.Name)
+	}
+	return shouldRun
+}
+
+// runs the jobs which were due while wire-pod was off
+func catchUp() {
+	dueJobs(true)
+}
+
+func runDue() {
+	dueJobs(false)
+}
+
+func dueJobs(missed bool) {
+	mu.Lock()
+	jobs, err := load()
+	if err != nil {
+		mu.Unlock()
+		logger.Warn("Couldn't load the scheduled jobs", "error", err)
+		return
+	}
+	var due []Job
+	t := now()
+	for _, job := range jobs {
+		if job.Next.IsZero() || job.Next.After(t) {
+			continue
+		}
+		if missed {
+			logger.Info("A job's time passed while wire-pod was off", logger.KeyESN, job.ESN, "id", job.ID, "time", job.Next.Format(time.RFC3339), "missed", job.Missed)
+		}
+		if advance(&job, missed) {
+			due = append(due, job)
+		}
+	}
+	mu.Unlock()
+	for _, job := range due {
+		go run(job)
+	}
+}
+
+// runs a job and saves how it went. one-off jobs which ran fine are deleted
+func run(job Job) error {
+	logger.Info("Running a scheduled job", logger.UI, logger.KeyESN, job.ESN, "id", job.ID, "name", job.Name, "action", job.Action.Type)
+	mu.Lock()
+	r := runner
+	mu.Unlock()
+	var err error
+	if r == nil {
+		err = errors.New("the scheduler hasn't been started")
+	} else {
+		err = r.Run(job)
+	}
+	if err != nil {
+		logger.Warn("A scheduled job failed", logger.UI, logger.KeyESN, job.ESN, "id", job.ID, "error", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// it might have been edited or deleted while it ran
+	current, getErr := Get(job.ID)
+	if getErr != nil {
+		return err
+	}
+	current.LastRun = now()
+	current.LastError = ""
+	if err != nil {
+		current.LastError = err.Error()
+	}
+	if current.Next.IsZero() && err == nil {
+		vars.DB.Update(func(tx vars.StoreTx) error {
+			return tx.Delete(bucket, job.ID)
+		})
+		return nil
+	}
+	save(current)
+	return err
+}
@@ -0,0 +1,309 @@
+package scheduler
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+func TestCronNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, 5, 1, 15, 31, 20, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 1, 15, 32, 0, 0, time.UTC)},
+		{"*/15 8-18 * * mon-fri", time.Date(2024, 5, 1, 15, 45, 0, 0, time.UTC)},
+		{"0 8 * * 1-5", time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)},
+		{"30 7 * * sat,sun", time.Date(2024, 5, 4, 7, 30, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 5, 5, 9, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 jun *", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+		// either day: the 10th, or a Friday
+		{"0 0 10 * fri", time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, 5, 1, 15, 45, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		c, err := ParseCron(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if got := c.Next(from); !got.Equal(test.want) {
+			t.Errorf("%q: next is %v, want %v", test.expr, got, test.want)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "* * * * someday"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: no error", expr)
+		}
+	}
+}
+
+type fakeRunner struct {
+	mu   sync.Mutex
+	ran  []string
+	fail bool
+	done chan string
+}
+
+func (f *fakeRunner) Check(action Action) error {
+	if action.Value == "bad" {
+		return errors.New("no such thing")
+	}
+	return nil
+}
+
+func (f *fakeRunner) Run(job Job) error {
+	f.mu.Lock()
+	f.ran = append(f.ran, job.Name)
+	fail := f.fail
+	f.mu.Unlock()
+	defer func() { f.done <- job.Name }()
+	if fail {
+		return errors.New("the robot is off")
+	}
+	return nil
+}
+
+func (f *fakeRunner) TimeZone(esn string) *time.Location {
+	return time.UTC
+}
+
+// waits for n jobs to run
+func (f *fakeRunner) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-f.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d jobs ran", i, n)
+		}
+	}
+}
+
+// run saves how it went after Run returns, so that's waited for too
+func eventually(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// a fresh store, a fake runner and a clock which only moves when told to
+func useScheduler(t *testing.T, start time.Time) (*fakeRunner, func(time.Duration)) {
+	t.Helper()
+	db := vars.DB
+	vars.DB = vars.NewMemoryStore()
+	clock := start
+	var clockMu sync.Mutex
+	now = func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		return clock
+	}
+	f := &fakeRunner{done: make(chan string, 10)}
+	runner = f
+	t.Cleanup(func() {
+		vars.DB = db
+		now = time.Now
+		runner = nil
+	})
+	return f, func(d time.Duration) {
+		clockMu.Lock()
+		clock = clock.Add(d)
+		clockMu.Unlock()
+	}
+}
+
+func TestAddAndRun(t *testing.T) {
+	start := time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC)
+	f, tick := useScheduler(t, start)
+	say := Action{Type: ActionSay, Value: "hello"}
+
+	bad := []Job{
+		{Action: say, At: start.Add(time.Hour)},
+		{ESN: "00e20000", Action: Action{Type: "dance", Value: "x"}, At: start.Add(time.Hour)},
+		{ESN: "00e20000", Action: Action{Type: ActionSay}, At: start.Add(time.Hour)},
+		{ESN: "00e20000", Action: Action{Type: ActionAnimation, Value: "bad"}, At: start.Add(time.Hour)},
+		{ESN: "00e20000", Action: say},
+		{ESN: "00e20000", Action: say, At: start.Add(time.Hour), Cron: "@daily"},
+		{ESN: "00e20000", Action: say, At: start.Add(-time.Minute)},
+		{ESN: "00e20000", Action: say, Cron: "0 0 31 2 *"},
+		{ESN: "00e20000", Action: say, Cron: "@daily", TimeZone: "Mars/Olympus_Mons"},
+		{ESN: "00e20000", Action: say, Cron: "@daily", Missed: "sometimes"},
+	}
+	for _, job := range bad {
+		if _, err := Add(job); err == nil {
+			t.Errorf("%+v was added", job)
+		}
+	}
+
+	once, err := Add(Job{ESN: "00e20000", Name: "once", Action: say, At: start.Add(10 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if once.Missed != MissedRun || !once.Next.Equal(once.At) || once.ID == "" {
+		t.Errorf("one-off job: %+v", once)
+	}
+	daily, err := Add(Job{ESN: "00e20001", Name: "daily", Action: say, Cron: "0 8 * * *", TimeZone: "America/Los_Angeles"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	if daily.Missed != MissedSkip || !daily.Next.Equal(time.Date(2024, 5, 2, 8, 0, 0, 0, la)) {
+		t.Errorf("cron job: %+v", daily)
+	}
+	if next, ok := nextRun(); !ok || !next.Equal(once.Next) {
+		t.Errorf("next run is %v", next)
+	}
+	if list, _ := List("00e20001"); len(list) != 1 || list[0].ID != daily.ID {
+		t.Errorf("the robot's jobs are %+v", list)
+	}
+
+	// nothing is due yet
+	runDue()
+	tick(10 * time.Minute)
+	runDue()
+	f.wait(t, 1)
+	if len(f.ran) != 1 || f.ran[0] != "once" {
+		t.Errorf("ran %v", f.ran)
+	}
+	// it ran fine, so it's gone
+	eventually(t, "the one-off job is still there", func() bool {
+		_, err := Get(once.ID)
+		return err != nil
+	})
+
+	// a failed run is kept, with the error
+	f.fail = true
+	tick(24 * time.Hour)
+	runDue()
+	f.wait(t, 1)
+	var got Job
+	eventually(t, "the failed run wasn't saved", func() bool {
+		got, err = Get(daily.ID)
+		return err == nil && !got.LastRun.IsZero()
+	})
+	if got.LastError != "the robot is off" || !got.LastRun.Equal(now()) || !got.Next.Equal(time.Date(2024, 5, 3, 8, 0, 0, 0, la)) {
+		t.Errorf("after a failed run: %+v", got)
+	}
+
+	// an edit keeps the history, and RunNow clears the error
+	f.fail = false
+	got.Cron = "0 9 * * *"
+	edited, err := Edit(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !edited.Created.Equal(daily.Created) || edited.LastError == "" || edited.Next.Hour() != 9 {
+		t.Errorf("edited: %+v", edited)
+	}
+	go func() { <-f.done }()
+	if err := RunNow(daily.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := Get(daily.ID); got.LastError != "" || got.Next.IsZero() {
+		t.Errorf("after running it now: %+v", got)
+	}
+
+	if err := Delete(daily.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(daily.ID); err == nil {
+		t.Error("deleted it twice")
+	}
+	if list, _ := List(""); len(list) != 0 {
+		t.Errorf("left %+v", list)
+	}
+}
+
+func TestMissedJobs(t *testing.T) {
+	start := time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC)
+	f, tick := useScheduler(t, start)
+	say := Action{Type: ActionSay, Value: "hello"}
+	add := func(job Job) Job {
+		t.Helper()
+		job.ESN = "00e20000"
+		job.Action = say
+		added, err := Add(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return added
+	}
+	late := add(Job{Name: "late", At: start.Add(time.Hour)})
+	dropped := add(Job{Name: "dropped", At: start.Add(time.Hour), Missed: MissedSkip})
+	skipped := add(Job{Name: "skipped", Cron: "0 * * * *"})
+	caughtUp := add(Job{Name: "caught up", Cron: "0 * * * *", Missed: MissedRun})
+
+	// wire-pod was off for a day
+	tick(24 * time.Hour)
+	catchUp()
+	f.wait(t, 2)
+	f.mu.Lock()
+	ran := map[string]bool{}
+	for _, name := range f.ran {
+		ran[name] = true
+	}
+	f.mu.Unlock()
+	if len(ran) != 2 || !ran["late"] || !ran["caught up"] {
+		t.Errorf("ran %v, want late and caught up", f.ran)
+	}
+	for _, id := range []string{late.ID, dropped.ID} {
+		eventually(t, "one-off job "+id+" is still there", func() bool {
+			_, err := Get(id)
+			return err != nil
+		})
+	}
+	// the cron jobs go on from now, once each
+	want := start.Add(24*time.Hour + 30*time.Minute)
+	for _, id := range []string{skipped.ID, caughtUp.ID} {
+		job, err := Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !job.Next.Equal(want) {
+			t.Errorf("%s runs next at %v, want %v", job.Name, job.Next, want)
+		}
+	}
+	runDue()
+	select {
+	case name := <-f.done:
+		t.Errorf("%s ran again", name)
+	default:
+	}
+}
+
+func TestLoop(t *testing.T) {
+	f, _ := useScheduler(t, time.Now())
+	now = time.Now
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		loop(stop)
+		close(stopped)
+	}()
+	// the loop is woken up by the new job, and sleeps until it's due
+	job, err := Add(Job{ESN: "00e20000", Name: "soon", Action: Action{Type: ActionSay, Value: "hi"}, At: time.Now().Add(200 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.wait(t, 1)
+	eventually(t, "the job is still there", func() bool {
+		_, err := Get(job.ID)
+		return err != nil
+	})
+	close(stop)
+	<-stopped
+}
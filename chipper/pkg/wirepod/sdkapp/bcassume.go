@@ -7,7 +7,7 @@ import (
 	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
 )
 
-func assumeBehaviorControl(robot Robot, robotIndex int, priority string) {
+func assumeBehaviorControl(robot *Robot, priority string) {
 	var controlRequest *vectorpb.BehaviorControlRequest
 	if priority == "high" {
 		controlRequest = &vectorpb.BehaviorControlRequest{
@@ -29,7 +29,7 @@ func assumeBehaviorControl(robot Robot, robotIndex int, priority string) {
 	go func() {
 		start := make(chan bool)
 		stop := make(chan bool)
-		robots[robotIndex].BcAssumption = true
+		robot.SetBcAssumption(true)
 		go func() {
 			// * begin - modified from official vector-go-sdk
 			r, err := robot.Vector.Conn.BehaviorControl(
@@ -79,7 +79,7 @@ func assumeBehaviorControl(robot Robot, robotIndex int, priority string) {
 		}()
 		for range start {
 			for {
-				if robots[robotIndex].BcAssumption {
+				if robot.BcAssumption() {
 					time.Sleep(time.Millisecond * 500)
 				} else {
 					break
@@ -2,7 +2,6 @@ package sdkapp
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -41,18 +40,12 @@ var PingerEnabled bool = true
 func pingJdocs(target string) {
 	ctx := context.Background()
 	target = strings.Split(target, ":")[0]
-	var serial string
-	matched := false
-	for _, robot := range vars.BotInfo.Robots {
-		if strings.TrimSpace(strings.ToLower(robot.IPAddress)) == strings.TrimSpace(strings.ToLower(target)) {
-			matched = true
-			serial = robot.Esn
-		}
-	}
+	robot, matched := vars.BotInfo.GetByIP(target)
 	if !matched {
 		logger.Println("jdocs pinger error: serial did not match any bot in bot json")
 		return
 	}
+	serial := robot.Esn
 	robotTmp, err := NewWP(serial, false)
 	if err != nil {
 		logger.Println(err)
@@ -118,20 +111,13 @@ func InitJdocsPinger() {
 }
 
 func ShouldPingJdocs(target string) bool {
-	var esn, guid, botip string
-	matched := false
-	for _, bot := range vars.BotInfo.Robots {
-		if target == bot.IPAddress {
-			esn = bot.Esn
-			guid = bot.GUID
-			botip = bot.IPAddress
-			matched = true
-			break
-		}
-	}
+	bot, matched := vars.BotInfo.GetByIP(target)
 	if !matched {
 		return false
 	}
+	esn := bot.Esn
+	guid := bot.GUID
+	botip := bot.IPAddress
 	JdocsPingerBots.mu.Lock()
 	defer JdocsPingerBots.mu.Unlock()
 	for i, bot := range JdocsPingerBots.Robots {
@@ -172,9 +158,7 @@ func connCheck(w http.ResponseWriter, r *http.Request) {
 		if PingerEnabled {
 			//logger.Println("connCheck request from " + r.RemoteAddr)
 			robotTarget := strings.Split(r.RemoteAddr, ":")[0]
-			jsonB, _ := json.Marshal(vars.BotInfo)
-			json := string(jsonB)
-			if strings.Contains(json, strings.TrimSpace(robotTarget)) {
+			if _, known := vars.BotInfo.GetByIP(robotTarget); known {
 				ping := ShouldPingJdocs(robotTarget)
 				if ping {
 					pingJdocs(robotTarget)
@@ -188,16 +172,27 @@ func connCheck(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// connCheck runs this in a goroutine for every unknown robot, so it's behind mdnsMu
 var MDNSAlreadyRun []string
+var mdnsMu sync.Mutex
 
 var RunningMDNS bool
 
-func RunMDNS(botIP string) {
+func mdnsAlreadyRun(botIP string) bool {
+	mdnsMu.Lock()
+	defer mdnsMu.Unlock()
 	for _, ip := range MDNSAlreadyRun {
 		if ip == botIP {
-			return
+			return true
 		}
 	}
+	return false
+}
+
+func RunMDNS(botIP string) {
+	if mdnsAlreadyRun(botIP) {
+		return
+	}
 	fmt.Println("Running mDNS...")
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
@@ -207,16 +202,14 @@ func RunMDNS(botIP string) {
 	for entry := range entries {
 		robotID := strings.Split(entry.HostName, ".")[0]
 		matched := false
-		for _, rinf := range vars.RecurringInfo {
+		for _, rinf := range vars.GetRInfo() {
 			if rinf.ID == robotID {
 				vars.AddToRInfo(rinf.ESN, robotID, fmt.Sprint(entry.AddrIPv4[0]))
-				for i, rob := range vars.BotInfo.Robots {
-					if rob.Esn == rinf.ESN {
-						vars.BotInfo.Robots[i].IPAddress = fmt.Sprint(entry.AddrIPv4[0])
-						jsonBytes, _ := json.Marshal(vars.BotInfo)
-						fmt.Println("Updating robot " + robotID)
-						go os.WriteFile(vars.BotInfoPath, jsonBytes, 0777)
-					}
+				if vars.BotInfo.Update(rinf.ESN, func(rob *vars.RobotInfo) {
+					rob.IPAddress = fmt.Sprint(entry.AddrIPv4[0])
+				}) {
+					fmt.Println("Updating robot " + robotID)
+					go vars.SaveBotInfo()
 				}
 				go func() {
 					// wait for escapepod.local trasmit
@@ -230,7 +223,9 @@ func RunMDNS(botIP string) {
 			}
 		}
 		if !matched {
+			mdnsMu.Lock()
 			MDNSAlreadyRun = append(MDNSAlreadyRun, botIP)
+			mdnsMu.Unlock()
 		}
 	}
 	fmt.Println("Done running mDNS")
@@ -4,8 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/digital-dream-labs/hugh/grpc/client"
@@ -15,51 +15,129 @@ import (
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
 )
 
-var robots []Robot
-var timerStopIndexes []int
-var inhibitCreation bool
+// SDK connections to the robots, by ESN. HTTP handlers and the stream goroutines use these at the same time
+var robots = make(map[string]*Robot)
+var robotsMu sync.Mutex
+
+// held while a robot is being connected or removed, so the same robot doesn't get connected twice
+var creationMu sync.Mutex
+
+// how long removeRobot gives the streams to stop
+var removeWait = time.Second * 3
 
 type Robot struct {
 	ESN               string
 	GUID              string
 	Target            string
 	Vector            *vector.Vector
-	BcAssumption      bool
-	CamStreaming      bool
 	EventStreamClient vectorpb.ExternalInterface_EventStreamClient
-	EventsStreaming   bool
-	StimState         float32
-	ConnTimer         int32
 	Ctx               context.Context
+
+	// everything below is changed while the robot is in use, so it's behind mu
+	mu              sync.Mutex
+	bcAssumption    bool
+	camStreaming    bool
+	eventsStreaming bool
+	stimState       float32
+	connTimer       int32
+	// closed when the robot is removed
+	removed chan struct{}
+}
+
+func (r *Robot) BcAssumption() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bcAssumption
+}
+
+func (r *Robot) SetBcAssumption(assume bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bcAssumption = assume
+}
+
+func (r *Robot) CamStreaming() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.camStreaming
+}
+
+func (r *Robot) SetCamStreaming(streaming bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.camStreaming = streaming
+}
+
+func (r *Robot) EventsStreaming() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.eventsStreaming
+}
+
+func (r *Robot) SetEventsStreaming(streaming bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.eventsStreaming = streaming
+}
+
+func (r *Robot) StimState() float32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stimState
+}
+
+func (r *Robot) SetStimState(state float32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stimState = state
+}
+
+// resets the inactivity timer
+func (r *Robot) touch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connTimer = 0
 }
 
-func newRobot(serial string) (Robot, int, error) {
-	inhibitCreation = true
-	var RobotObj Robot
+// stops the streams, which check these
+func (r *Robot) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.camStreaming = false
+	r.eventsStreaming = false
+	r.bcAssumption = false
+}
+
+func robotKey(serial string) string {
+	return strings.TrimSpace(strings.ToLower(serial))
+}
+
+func lookupRobot(serial string) (*Robot, bool) {
+	robotsMu.Lock()
+	defer robotsMu.Unlock()
+	robot, ok := robots[robotKey(serial)]
+	return robot, ok
+}
+
+func newRobot(serial string) (*Robot, error) {
+	RobotObj := &Robot{removed: make(chan struct{})}
 
 	// generate context
 	RobotObj.Ctx = context.Background()
 
 	// find robot info in BotInfo
-	matched := false
-	for _, robot := range vars.BotInfo.Robots {
-		if strings.EqualFold(serial, robot.Esn) {
-			RobotObj.ESN = strings.TrimSpace(strings.ToLower(serial))
-			RobotObj.Target = robot.IPAddress + ":443"
-			matched = true
-			if robot.GUID == "" {
-				robot.GUID = vars.BotInfo.GlobalGUID
-				RobotObj.GUID = vars.BotInfo.GlobalGUID
-			} else {
-				RobotObj.GUID = robot.GUID
-			}
-			logger.Println("Connecting to " + serial + " with GUID " + RobotObj.GUID)
-		}
-	}
+	robot, matched := vars.BotInfo.Get(serial)
 	if !matched {
-		inhibitCreation = false
-		return RobotObj, 0, fmt.Errorf("error: robot not found in SDK info file")
+		return nil, fmt.Errorf("error: robot not found in SDK info file")
+	}
+	RobotObj.ESN = robotKey(serial)
+	RobotObj.Target = robot.IPAddress + ":443"
+	if robot.GUID == "" {
+		RobotObj.GUID = vars.BotInfo.GlobalGUID()
+	} else {
+		RobotObj.GUID = robot.GUID
 	}
+	logger.Println("Connecting to " + serial + " with GUID " + RobotObj.GUID)
 
 	// create Vector instance
 	var err error
@@ -69,15 +147,13 @@ func newRobot(serial string) (Robot, int, error) {
 		vector.WithToken(RobotObj.GUID),
 	)
 	if err != nil {
-		inhibitCreation = false
-		return RobotObj, 0, err
+		return nil, err
 	}
 
 	// connection check
 	_, err = RobotObj.Vector.Conn.BatteryState(context.Background(), &vectorpb.BatteryStateRequest{})
 	if err != nil {
-		inhibitCreation = false
-		return RobotObj, 0, err
+		return nil, err
 	}
 
 	// create client for event stream
@@ -93,107 +169,87 @@ func newRobot(serial string) (Robot, int, error) {
 		},
 	)
 	if err != nil {
-		inhibitCreation = false
-		return RobotObj, 0, err
+		return nil, err
 	}
-	RobotObj.CamStreaming = false
-	RobotObj.EventsStreaming = false
-
-	// we have confirmed robot connection works, append to list of bots
-	robots = append(robots, RobotObj)
-	robotIndex := len(robots) - 1
 
-	// begin inactivity timer
-	go connTimer(robotIndex)
+	// we have confirmed robot connection works, add to list of bots
+	addRobot(RobotObj)
+	return RobotObj, nil
+}
 
-	inhibitCreation = false
-	return RobotObj, robotIndex, nil
+// adds a connected robot and starts its inactivity timer
+func addRobot(robot *Robot) {
+	robotsMu.Lock()
+	robots[robot.ESN] = robot
+	robotsMu.Unlock()
+	go connTimer(robot)
 }
 
-func getRobot(serial string) (Robot, int, error) {
+func getRobot(serial string) (*Robot, error) {
 	// look in robot list
-	for {
-		if !inhibitCreation {
-			break
-		}
-		time.Sleep(time.Second / 2)
+	if robot, ok := lookupRobot(serial); ok {
+		return robot, nil
 	}
-	for index, robot := range robots {
-		if strings.EqualFold(serial, robot.ESN) {
-			return robot, index, nil
-		}
+	creationMu.Lock()
+	defer creationMu.Unlock()
+	// it might have been connected while this was waiting
+	if robot, ok := lookupRobot(serial); ok {
+		return robot, nil
 	}
 	return newRobot(serial)
 }
 
 // if connection is inactive for more than 5 minutes, remove robot
 // run this as a goroutine
-func connTimer(ind int) {
-	robots[ind].ConnTimer = 0
+func connTimer(robot *Robot) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
-		time.Sleep(time.Second)
-		// check if timer needs to be stopped
-		for _, num := range timerStopIndexes {
-			if num == ind {
-				logger.Println("Conn timer for robot index " + strconv.Itoa(ind) + " stopping")
-				var newIndexes []int
-				for _, num := range timerStopIndexes {
-					if num != ind {
-						newIndexes = append(newIndexes, num)
-					}
-				}
-				timerStopIndexes = newIndexes
-				return
-			}
+		select {
+		case <-robot.removed:
+			logger.Println("Conn timer for " + robot.ESN + " stopping")
+			return
+		case <-ticker.C:
 		}
-		if robots[ind].ConnTimer >= 300 {
-			logger.Println("Closing SDK connection for " + robots[ind].ESN + ", source: connTimer")
-			removeRobot(robots[ind].ESN, "connTimer")
+		robot.mu.Lock()
+		robot.connTimer++
+		expired := robot.connTimer >= 300
+		robot.mu.Unlock()
+		if expired {
+			logger.Println("Closing SDK connection for " + robot.ESN + ", source: connTimer")
+			removeRobot(robot.ESN, "connTimer")
 			return
 		}
-		robots[ind].ConnTimer = robots[ind].ConnTimer + 1
 	}
 }
 
 func removeRobot(serial, source string) {
-	inhibitCreation = true
-	var newRobots []Robot
-	for ind, robot := range robots {
-		if !strings.EqualFold(serial, robot.ESN) {
-			newRobots = append(newRobots, robot)
-		} else {
-			if source == "server" {
-				timerStopIndexes = append(timerStopIndexes, ind)
-			}
-			robots[ind].CamStreaming = false
-			robots[ind].EventsStreaming = false
-			robots[ind].BcAssumption = false
-			// give time for all of that to stop
-			time.Sleep(time.Second * 3)
-		}
+	creationMu.Lock()
+	defer creationMu.Unlock()
+	robotsMu.Lock()
+	robot, ok := robots[robotKey(serial)]
+	delete(robots, robotKey(serial))
+	robotsMu.Unlock()
+	if !ok {
+		return
 	}
-	robots = newRobots
-	inhibitCreation = false
+	robot.stop()
+	close(robot.removed)
+	// give time for all of that to stop
+	time.Sleep(removeWait)
 }
 
 func NewWP(serial string, useGlobal bool) (*vector.Vector, error) {
-	var target, guid string
 	if serial == "" {
 		return nil, fmt.Errorf("serial string missing")
 	}
-	matched := false
-	for _, robot := range vars.BotInfo.Robots {
-		if strings.EqualFold(serial, robot.Esn) {
-			matched = true
-			target = robot.IPAddress + ":443"
-			guid = robot.GUID
-			break
-		}
-	}
+	robot, matched := vars.BotInfo.Get(serial)
 	if !matched {
 		logger.Println("serial did not match any bot in bot json")
 		return nil, errors.New("serial did not match any bot in bot json")
 	}
+	target := robot.IPAddress + ":443"
+	guid := robot.GUID
 	c, err := client.New(
 		client.WithTarget(target),
 		client.WithInsecureSkipVerify(),
@@ -0,0 +1,97 @@
+package sdkapp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// run with -race. the HTTP handlers, the stream goroutines and connTimer all use the same robots at once
+
+func TestRobotsStreamingAtOnce(t *testing.T) {
+	removeWait = time.Millisecond
+	const count = 6
+	var all []*Robot
+	for i := 0; i < count; i++ {
+		robot := &Robot{
+			ESN:     fmt.Sprintf("00e3%04x", i),
+			Ctx:     context.Background(),
+			removed: make(chan struct{}),
+		}
+		addRobot(robot)
+		all = append(all, robot)
+	}
+
+	var wg sync.WaitGroup
+	for _, robot := range all {
+		robot := robot
+		esn := robot.ESN
+		// an event stream writing the stim state while get_stim_status reads it
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			robot, ok := lookupRobot(esn)
+			if !ok {
+				t.Errorf("%s not found", esn)
+				return
+			}
+			robot.SetEventsStreaming(true)
+			for i := 0; i < 200 && robot.EventsStreaming(); i++ {
+				robot.SetStimState(float32(i))
+				robot.touch()
+			}
+			robot.SetEventsStreaming(false)
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if robot, err := getRobot(esn); err == nil {
+					robot.StimState()
+					robot.SetCamStreaming(i%2 == 0)
+					robot.CamStreaming()
+				}
+			}
+		}()
+		// behavior control being taken and given back
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				robot.SetBcAssumption(true)
+				robot.BcAssumption()
+				robot.SetBcAssumption(false)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// disconnecting while the streams are still going
+	for _, robot := range all {
+		robot.SetCamStreaming(true)
+		robot.SetEventsStreaming(true)
+		wg.Add(2)
+		go func(robot *Robot) {
+			defer wg.Done()
+			for robot.CamStreaming() || robot.EventsStreaming() {
+				time.Sleep(time.Millisecond)
+			}
+		}(robot)
+		go func(robot *Robot) {
+			defer wg.Done()
+			removeRobot(robot.ESN, "server")
+		}(robot)
+	}
+	wg.Wait()
+
+	for _, robot := range all {
+		if _, ok := lookupRobot(robot.ESN); ok {
+			t.Errorf("%s wasn't removed", robot.ESN)
+		}
+		select {
+		case <-robot.removed:
+		default:
+			t.Errorf("%s's conn timer wasn't stopped", robot.ESN)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package sdkapp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
@@ -15,23 +16,26 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fforchino/vector-go-sdk/pkg/vector"
 	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/auth"
 )
 
 var serverFiles string = "./webroot/sdkapp"
 
 func SdkapiHandler(w http.ResponseWriter, r *http.Request) {
-	robotObj, robotIndex, err := getRobot(r.FormValue("serial"))
-	robot := robotObj.Vector
-	ctx := robotObj.Ctx
-	if r.URL.Path != "/api-sdk/get_sdk_info" && r.URL.Path != "/api-sdk/debug" {
-		if err != nil {
-			fmt.Fprint(w, "error: "+err.Error())
-			return
-		}
-		robots[robotIndex].ConnTimer = 0
+	var robot *vector.Vector
+	var ctx context.Context
+	robotObj, err := getRobot(r.FormValue("serial"))
+	if err == nil {
+		robot = robotObj.Vector
+		ctx = robotObj.Ctx
+		robotObj.touch()
+	} else if r.URL.Path != "/api-sdk/get_sdk_info" && r.URL.Path != "/api-sdk/debug" {
+		fmt.Fprint(w, "error: "+err.Error())
+		return
 	}
 	switch {
 	default:
@@ -160,10 +164,10 @@ func SdkapiHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	case r.URL.Path == "/api-sdk/assume_behavior_control":
 		fmt.Fprintf(w, "success")
-		assumeBehaviorControl(robotObj, robotIndex, r.FormValue("priority"))
+		assumeBehaviorControl(robotObj, r.FormValue("priority"))
 		return
 	case r.URL.Path == "/api-sdk/release_behavior_control":
-		robots[robotIndex].BcAssumption = false
+		robotObj.SetBcAssumption(false)
 		fmt.Fprintf(w, "success")
 		return
 	case r.URL.Path == "/api-sdk/say_text":
@@ -302,7 +306,7 @@ func SdkapiHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	case r.URL.Path == "/api-sdk/begin_event_stream":
 		// setup websocket
-		robots[robotIndex].EventsStreaming = true
+		robotObj.SetEventsStreaming(true)
 		go func() {
 			client, err := robot.Conn.EventStream(
 				ctx,
@@ -319,18 +323,18 @@ func SdkapiHandler(w http.ResponseWriter, r *http.Request) {
 				fmt.Fprint(w, err.Error())
 			}
 			for {
-				if robots[robotIndex].EventsStreaming {
+				if robotObj.EventsStreaming() {
 					resp, err := client.Recv()
 					if err != nil {
 						fmt.Fprint(w, err.Error())
-						robots[robotIndex].EventsStreaming = false
+						robotObj.SetEventsStreaming(false)
 						return
 					}
 					stimInfo := resp.Event.GetStimulationInfo()
 					stimInfoString := fmt.Sprint(stimInfo)
 					if strings.Contains(stimInfoString, "velocity") {
 						// velocity in the string means there is a value
-						robots[robotIndex].StimState = stimInfo.Value
+						robotObj.SetStimState(stimInfo.Value)
 					}
 				} else {
 					return
@@ -340,23 +344,23 @@ func SdkapiHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "done")
 		return
 	case r.URL.Path == "/api-sdk/stop_event_stream":
-		robots[robotIndex].EventsStreaming = false
-		robots[robotIndex].StimState = 0
+		robotObj.SetEventsStreaming(false)
+		robotObj.SetStimState(0)
 		fmt.Fprint(w, "done")
 		return
 	case r.URL.Path == "/api-sdk/get_stim_status":
-		if robots[robotIndex].EventsStreaming {
-			fmt.Fprint(w, robots[robotIndex].StimState)
+		if robotObj.EventsStreaming() {
+			fmt.Fprint(w, robotObj.StimState())
 			return
 		}
 		fmt.Fprint(w, "error: must start event stream")
 		return
 	case r.URL.Path == "/api-sdk/begin_cam_stream":
-		//robots[robotIndex].CamStreaming = true
+		//robotObj.SetCamStreaming(true)
 		fmt.Fprint(w, "done")
 		return
 	case r.URL.Path == "/api-sdk/stop_cam_stream":
-		robots[robotIndex].CamStreaming = false
+		robotObj.SetCamStreaming(false)
 		fmt.Fprint(w, "done")
 		return
 	case r.URL.Path == "/api-sdk/get_image_ids":
@@ -447,13 +451,13 @@ func SdkapiHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func camStreamHandler(w http.ResponseWriter, r *http.Request) {
-	robotObj, robotIndex, err := getRobot(r.FormValue("serial"))
+	robotObj, err := getRobot(r.FormValue("serial"))
 	if err != nil {
 		fmt.Fprint(w, "error: "+err.Error())
 		return
 	}
-	if robots[robotIndex].CamStreaming {
-		robots[robotIndex].CamStreaming = false
+	if robotObj.CamStreaming() {
+		robotObj.SetCamStreaming(false)
 		time.Sleep(time.Second / 2)
 	}
 	robotObj.Vector.Conn.EnableImageStreaming(
@@ -473,7 +477,7 @@ func camStreamHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=--boundary")
 	multi := io.MultiWriter(w)
-	robots[robotIndex].CamStreaming = true
+	robotObj.SetCamStreaming(true)
 	for {
 		select {
 		case <-r.Context().Done():
@@ -483,10 +487,10 @@ func camStreamHandler(w http.ResponseWriter, r *http.Request) {
 					Enable: false,
 				},
 			)
-			robots[robotIndex].CamStreaming = false
+			robotObj.SetCamStreaming(false)
 			return
 		default:
-			if robots[robotIndex].CamStreaming {
+			if robotObj.CamStreaming() {
 				response, err := client.Recv()
 				if err == nil {
 					imageBytes := response.GetData()
@@ -540,7 +544,7 @@ func BeginServer() {
 	ipAddr := vars.GetOutboundIP().String()
 	logger.Println("\033[1;36mConfiguration page: http://" + ipAddr + ":" + vars.WebPort + "\033[0m")
 	if runtime.GOOS != "android" {
-		if err := http.ListenAndServe(":80", nil); err != nil {
+		if err := http.ListenAndServe(":80", auth.Handler(http.DefaultServeMux)); err != nil {
 			if vars.Packaged {
 				logger.WarnMsg("A process is using port 80. Wire-pod will keep running, but connCheck functionality will not work, so your bot may not always stay connected to your wire-pod instance.")
 			}
@@ -1,22 +1,41 @@
 package botsetup
 
 import (
-	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"math/big"
 	"net"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
 )
 
+// wire-pod's certificate authority, and the server cert it signs for chipper.
+//
+// the CA is made once and kept in vars.CACertPath, so robots only have to be given it once.
+// cert.crt is the server cert followed by the CA, and it's what gets pushed to robots as wirepod-cert.crt (vic-cloud trusts every cert in it).
+// the server cert gets reissued when it doesn't cover this machine's IP or the SANs in the config anymore, or it's about to expire.
+// chipper gets it through ServerCertificate, so a new one is used straight away.
+// if the IP changed, robots still have the old one in server_config.json, so the new config has to be pushed to them (PushCertsViaSSH).
+//
+// none of this is used in escape pod mode, which has its own fixed cert for escapepod.local.
+
 type ClientServerConfig struct {
 	Jdocs    string `json:"jdocs"`
 	Token    string `json:"tms"`
@@ -26,79 +45,455 @@ type ClientServerConfig struct {
 	Appkey   string `json:"appkey"`
 }
 
-// creates and exports a priv/pub key combo generated with IP address
-func CreateCertCombo() error {
-	// get preferred IP address of machine
-	ipAddr := vars.GetOutboundIP()
-
-	// ca certificate
-	ca := &x509.Certificate{
-		SerialNumber:          big.NewInt(2019),
-		Subject:               pkix.Name{},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(30, 0, 0),
+// CertInfo describes a certificate, for the web interface
+type CertInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	KeyType     string    `json:"key_type"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	DaysLeft    int       `json:"days_left"`
+	Fingerprint string    `json:"sha256_fingerprint"`
+	SANs        []string  `json:"sans"`
+}
+
+// CertStatus is what /api/get_cert_status returns
+type CertStatus struct {
+	EscapePod bool      `json:"escape_pod"`
+	CA        *CertInfo `json:"ca"`
+	Server    *CertInfo `json:"server"`
+	// false for certs made before there was a CA. they keep working, but get replaced by a CA-signed one when they're next reissued
+	SignedByCA  bool      `json:"signed_by_ca"`
+	LastReissue time.Time `json:"last_reissue"`
+	// why the server cert was last reissued
+	ReissueReason string `json:"reissue_reason"`
+	// robots set up before this need the new cert and server config pushed to them. zero if none do
+	PushNeededSince time.Time `json:"push_needed_since"`
+}
+
+const (
+	caYears     = 20
+	serverYears = 2
+	// the server cert gets reissued this long before it expires
+	renewBefore = 30 * 24 * time.Hour
+	// how often the IP and expiry get checked
+	certCheckInterval = time.Minute
+)
+
+var (
+	certMu     sync.Mutex
+	caCert     *x509.Certificate
+	caKey      crypto.Signer
+	serverCert *tls.Certificate
+	serverLeaf *x509.Certificate
+	certStatus CertStatus
+	monitorOne sync.Once
+)
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func newKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writeCertFiles(certPath string, certPEM []byte, keyPath string, keyPEM []byte) error {
+	if err := os.MkdirAll(vars.Certs, 0755); err != nil {
+		return err
+	}
+	logger.Println("Outputting certificate to " + certPath)
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return err
+	}
+	logger.Println("Outputting private key to " + keyPath)
+	// WriteFile doesn't change the mode of a file which is already there
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(keyPath, 0600)
+}
+
+// certMu has to be held
+func loadCA() error {
+	certPEM, err := os.ReadFile(vars.CACertPath)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(vars.CAKeyPath)
+	if err != nil {
+		return err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("the CA key can't sign")
+	}
+	caCert = cert
+	caKey = signer
+	return nil
+}
+
+// certMu has to be held
+func createCA() error {
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+	name := "wire-pod CA"
+	if hostname, err := os.Hostname(); err == nil {
+		name = name + " (" + hostname + ")"
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"wire-pod"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(caYears, 0, 0),
 		IsCA:                  true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
 		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
 	}
-	caPrivKey, err := rsa.GenerateKey(rand.Reader, 1028)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
 	if err != nil {
 		return err
 	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writeCertFiles(vars.CACertPath, encodeCert(der), vars.CAKeyPath, keyPEM); err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	caCert = cert
+	caKey = key
+	logger.Println("Created a new certificate authority, fingerprint " + fingerprint(cert))
+	return nil
+}
 
-	// create actual certificate
-	cert := &x509.Certificate{
-		SerialNumber: big.NewInt(1658),
-		Subject:      pkix.Name{},
-		IPAddresses:  []net.IP{ipAddr},
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(10, 0, 0),
-		SubjectKeyId: []byte{1, 2, 3, 4, 6},
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:     x509.KeyUsageDigitalSignature,
+// certMu has to be held
+func ensureCA() error {
+	if caCert != nil {
+		return nil
+	}
+	err := loadCA()
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		// don't replace a CA robots might trust just because it couldn't be read
+		return fmt.Errorf("couldn't load the CA from %s: %w", vars.CACertPath, err)
+	}
+	return createCA()
+}
+
+// the IPs and names the server cert should cover
+func wantedSANs() ([]net.IP, []string) {
+	var ips []net.IP
+	var names []string
+	addIP := func(ip net.IP) {
+		for _, known := range ips {
+			if known.Equal(ip) {
+				return
+			}
+		}
+		ips = append(ips, ip)
+	}
+	addName := func(name string) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for _, known := range names {
+			if known == name {
+				return
+			}
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	addIP(vars.GetOutboundIP())
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() {
+				addIP(ipNet.IP.To4())
+			}
+		}
+	}
+	addName("escapepod.local")
+	if hostname, err := os.Hostname(); err == nil {
+		addName(hostname)
+		if !strings.Contains(hostname, ".") {
+			addName(hostname + ".local")
+		}
 	}
-	certPrivKey, err := rsa.GenerateKey(rand.Reader, 1028)
+	for _, san := range vars.APIConfig.Server.CertSANs {
+		if ip := net.ParseIP(strings.TrimSpace(san)); ip != nil {
+			addIP(ip)
+		} else {
+			addName(san)
+		}
+	}
+	return ips, names
+}
+
+// why the server cert needs to be reissued, or "" if it doesn't. certMu has to be held
+func reissueReason() string {
+	if serverLeaf == nil {
+		return "there was no server cert"
+	}
+	if time.Until(serverLeaf.NotAfter) < renewBefore {
+		return "it expires " + serverLeaf.NotAfter.Format("2006-01-02")
+	}
+	if ip := vars.GetOutboundIP(); serverLeaf.VerifyHostname(ip.String()) != nil {
+		return "this machine's IP changed to " + ip.String()
+	}
+	for _, san := range vars.APIConfig.Server.CertSANs {
+		if san = strings.TrimSpace(san); san != "" && serverLeaf.VerifyHostname(san) != nil {
+			return san + " was added to the SANs"
+		}
+	}
+	return ""
+}
+
+// certMu has to be held
+func loadServerCert() error {
+	pair, err := tls.LoadX509KeyPair(vars.CertPath, vars.KeyPath)
 	if err != nil {
 		return err
 	}
-	certBytes, err := x509.CreateCertificate(rand.Reader, cert, ca, &certPrivKey.PublicKey, caPrivKey)
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
 	if err != nil {
 		return err
 	}
-	certPEM := new(bytes.Buffer)
-	pem.Encode(certPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: certBytes,
-	})
-	certPrivKeyPEM := new(bytes.Buffer)
-	pem.Encode(certPrivKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(certPrivKey),
-	})
+	serverCert = &pair
+	serverLeaf = leaf
+	certStatus.SignedByCA = caCert != nil && leaf.CheckSignatureFrom(caCert) == nil
+	return nil
+}
 
-	// export certificates
-	os.MkdirAll(vars.Certs, 0777)
-	logger.Println("Outputting certificate to " + vars.CertPath)
-	err = os.WriteFile(vars.CertPath, certPEM.Bytes(), 0777)
+// certMu has to be held
+func issueServerCert(reason string) error {
+	if err := ensureCA(); err != nil {
+		return err
+	}
+	key, err := newKey()
 	if err != nil {
 		return err
 	}
-	logger.Println("Outputting private key to " + vars.KeyPath)
-	err = os.WriteFile(vars.KeyPath, certPrivKeyPEM.Bytes(), 0777)
+	serial, err := randomSerial()
 	if err != nil {
 		return err
 	}
-	vars.ChipperCert = certPEM.Bytes()
-	vars.ChipperKey = certPrivKeyPEM.Bytes()
+	ips, names := wantedSANs()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "wire-pod", Organization: []string{"wire-pod"}},
+		IPAddresses:  ips,
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(serverYears, 0, 0),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	// the CA goes after the server cert, so robots given this file trust the CA too
+	certPEM := append(encodeCert(der), encodeCert(caCert.Raw)...)
+	if err := writeCertFiles(vars.CertPath, certPEM, vars.KeyPath, keyPEM); err != nil {
+		return err
+	}
+	vars.ChipperCert = certPEM
+	vars.ChipperKey = keyPEM
 	vars.ChipperKeysLoaded = true
+	if err := loadServerCert(); err != nil {
+		return err
+	}
+	certStatus.LastReissue = time.Now()
+	certStatus.ReissueReason = reason
+	logger.Println("Issued a new server cert (" + reason + "), fingerprint " + fingerprint(serverLeaf))
+	return nil
+}
+
+// CreateCertCombo issues a new server cert for this machine, making the CA first if there isn't one
+func CreateCertCombo() error {
+	certMu.Lock()
+	defer certMu.Unlock()
+	return issueServerCert("it was requested")
+}
+
+// EnsureCerts loads the CA and server cert, and reissues the server cert if it has to be.
+// true if it was reissued
+func EnsureCerts() (bool, error) {
+	certMu.Lock()
+	defer certMu.Unlock()
+	if err := ensureCA(); err != nil {
+		return false, err
+	}
+	if serverLeaf == nil {
+		if err := loadServerCert(); err != nil && !os.IsNotExist(err) {
+			logger.Println("Couldn't load the server cert, making a new one: " + err.Error())
+		}
+	}
+	reason := reissueReason()
+	if reason == "" {
+		return false, nil
+	}
+	oldLeaf := serverLeaf
+	if err := issueServerCert(reason); err != nil {
+		return false, err
+	}
+	if oldLeaf != nil && (oldLeaf.VerifyHostname(vars.GetOutboundIP().String()) != nil || oldLeaf.CheckSignatureFrom(caCert) != nil) {
+		// robots have the old IP in their server config, or only trust the old cert
+		CreateServerConfig()
+		certStatus.PushNeededSince = time.Now()
+		logger.Println("Robots which were set up before now need the new server config and cert. Push them from the web interface, or set the robots up again")
+	}
+	return true, nil
+}
 
+// RotateCA replaces the CA and the server cert. every robot will need the new cert pushed to it
+func RotateCA() error {
+	certMu.Lock()
+	defer certMu.Unlock()
+	if err := createCA(); err != nil {
+		return err
+	}
+	if err := issueServerCert("the CA was rotated"); err != nil {
+		return err
+	}
+	certStatus.PushNeededSince = time.Now()
 	return nil
 }
 
+// ServerCertificate is for tls.Config.GetCertificate, so chipper always uses the newest server cert
+func ServerCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certMu.Lock()
+	defer certMu.Unlock()
+	if serverCert == nil {
+		return nil, errors.New("no server cert has been loaded")
+	}
+	return serverCert, nil
+}
+
+// StartCertMonitor checks the server cert every minute, and reissues it if the IP changed or it's about to expire
+func StartCertMonitor() {
+	monitorOne.Do(func() {
+		go func() {
+			for {
+				time.Sleep(certCheckInterval)
+				if vars.APIConfig.Server.EPConfig || !vars.APIConfig.PastInitialSetup {
+					continue
+				}
+				if _, err := EnsureCerts(); err != nil {
+					logger.Println("Couldn't check the server cert: " + err.Error())
+				}
+			}
+		}()
+	})
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	var parts []string
+	for _, b := range sum {
+		parts = append(parts, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+func describeCert(cert *x509.Certificate) *CertInfo {
+	if cert == nil {
+		return nil
+	}
+	info := &CertInfo{
+		Subject:     cert.Subject.CommonName,
+		Issuer:      cert.Issuer.CommonName,
+		Serial:      fmt.Sprintf("%X", cert.SerialNumber),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		DaysLeft:    int(time.Until(cert.NotAfter).Hours() / 24),
+		Fingerprint: fingerprint(cert),
+	}
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		info.KeyType = "ECDSA " + key.Curve.Params().Name
+	case *rsa.PublicKey:
+		info.KeyType = fmt.Sprintf("RSA %d", key.N.BitLen())
+	default:
+		info.KeyType = "unknown"
+	}
+	for _, ip := range cert.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	info.SANs = append(info.SANs, cert.DNSNames...)
+	return info
+}
+
+// GetCertStatus returns the CA's and server cert's details
+func GetCertStatus() CertStatus {
+	certMu.Lock()
+	defer certMu.Unlock()
+	status := certStatus
+	status.EscapePod = vars.APIConfig.Server.EPConfig
+	if status.EscapePod {
+		// the fixed escape pod cert
+		if certPEM, err := os.ReadFile(epCertPath()); err == nil {
+			if block, _ := pem.Decode(certPEM); block != nil {
+				if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+					status.Server = describeCert(cert)
+				}
+			}
+		}
+		return status
+	}
+	if caCert == nil {
+		loadCA()
+	}
+	if serverLeaf == nil {
+		loadServerCert()
+	}
+	status.CA = describeCert(caCert)
+	status.Server = describeCert(serverLeaf)
+	status.SignedByCA = caCert != nil && serverLeaf != nil && serverLeaf.CheckSignatureFrom(caCert) == nil
+	return status
+}
+
 // outputs a server config to ../certs/server_config.json
 func CreateServerConfig() {
-	os.MkdirAll(vars.Certs, 0777)
+	os.MkdirAll(vars.Certs, 0755)
 	var config ClientServerConfig
 	//{"jdocs": "escapepod.local:443", "tms": "escapepod.local:443", "chipper": "escapepod.local:443", "check": "escapepod.local/ok:80", "logfiles": "s3://anki-device-logs-prod/victor", "appkey": "oDoa0quieSeir6goowai7f"}
 	if vars.APIConfig.Server.EPConfig {
@@ -120,5 +515,20 @@ func CreateServerConfig() {
 		config.Appkey = "oDoa0quieSeir6goowai7f"
 	}
 	writeBytes, _ := json.Marshal(config)
-	os.WriteFile(vars.ServerConfigPath, writeBytes, 0777)
+	os.WriteFile(vars.ServerConfigPath, writeBytes, 0644)
+}
+
+// the cert robots get given: the escape pod one, or cert.crt (which has the CA in it)
+func botCertPath() string {
+	if vars.APIConfig.Server.EPConfig {
+		return epCertPath()
+	}
+	return vars.CertPath
+}
+
+func epCertPath() string {
+	if runtime.GOOS == "android" || runtime.GOOS == "ios" {
+		return vars.AndroidPath + "/static/epod/ep.crt"
+	}
+	return "./epod/ep.crt"
 }
@@ -0,0 +1,194 @@
+package botsetup
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// points the cert paths at a temporary directory, and forgets the loaded certs like a restart would
+func useTempCerts(t *testing.T) {
+	dir := t.TempDir()
+	paths := []*string{&vars.Certs, &vars.CertPath, &vars.KeyPath, &vars.CACertPath, &vars.CAKeyPath, &vars.ServerConfigPath}
+	old := make([]string, len(paths))
+	for i, path := range paths {
+		old[i] = *path
+	}
+	sans := vars.APIConfig.Server.CertSANs
+	chipperCert, chipperKey, keysLoaded := vars.ChipperCert, vars.ChipperKey, vars.ChipperKeysLoaded
+	t.Cleanup(func() {
+		for i, path := range paths {
+			*path = old[i]
+		}
+		vars.APIConfig.Server.CertSANs = sans
+		vars.ChipperCert, vars.ChipperKey, vars.ChipperKeysLoaded = chipperCert, chipperKey, keysLoaded
+		forgetCerts()
+	})
+	vars.Certs = dir
+	vars.CertPath = filepath.Join(dir, "cert.crt")
+	vars.KeyPath = filepath.Join(dir, "cert.key")
+	vars.CACertPath = filepath.Join(dir, "ca.crt")
+	vars.CAKeyPath = filepath.Join(dir, "ca.key")
+	vars.ServerConfigPath = filepath.Join(dir, "server_config.json")
+	forgetCerts()
+}
+
+func forgetCerts() {
+	certMu.Lock()
+	defer certMu.Unlock()
+	caCert, caKey, serverCert, serverLeaf = nil, nil, nil, nil
+	certStatus = CertStatus{}
+}
+
+// the certs in a PEM file, in order
+func readCerts(t *testing.T, path string) []*x509.Certificate {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func TestServerCert(t *testing.T) {
+	useTempCerts(t)
+	vars.APIConfig.Server.CertSANs = []string{"10.1.2.3", " Vector.Example "}
+	reissued, err := EnsureCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reissued {
+		t.Error("no server cert was made")
+	}
+
+	ca := readCerts(t, vars.CACertPath)
+	chain := readCerts(t, vars.CertPath)
+	if len(ca) != 1 || len(chain) != 2 {
+		t.Fatalf("ca.crt has %d certs and cert.crt has %d", len(ca), len(chain))
+	}
+	if !chain[1].Equal(ca[0]) {
+		t.Error("cert.crt doesn't end with the CA")
+	}
+	if !ca[0].IsCA {
+		t.Error("the CA can't sign certs")
+	}
+	leaf := chain[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(ca[0])
+	names := []string{vars.GetOutboundIP().String(), "10.1.2.3", "vector.example", "escapepod.local"}
+	if hostname, err := os.Hostname(); err == nil {
+		names = append(names, hostname)
+	}
+	for _, name := range names {
+		_, err := leaf.Verify(x509.VerifyOptions{
+			DNSName:   name,
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if leaf.VerifyHostname("10.9.9.9") == nil {
+		t.Error("the server cert covers an IP it wasn't asked to")
+	}
+
+	now := time.Now()
+	validity := []struct {
+		name  string
+		cert  *x509.Certificate
+		years int
+	}{
+		{"CA", ca[0], caYears},
+		{"server", leaf, serverYears},
+	}
+	for _, v := range validity {
+		if v.cert.NotBefore.After(now) {
+			t.Errorf("the %s cert isn't valid until %v", v.name, v.cert.NotBefore)
+		}
+		if want := now.AddDate(v.years, 0, 0); v.cert.NotAfter.Before(want.Add(-time.Hour)) || v.cert.NotAfter.After(want.Add(time.Hour)) {
+			t.Errorf("the %s cert expires %v, want %v", v.name, v.cert.NotAfter, want)
+		}
+	}
+	for _, path := range []string{vars.KeyPath, vars.CAKeyPath} {
+		if info, err := os.Stat(path); err != nil {
+			t.Error(err)
+		} else if info.Mode().Perm() != 0600 {
+			t.Errorf("%s can be read by others: %v", path, info.Mode())
+		}
+	}
+	status := GetCertStatus()
+	if !status.SignedByCA || status.Server == nil || status.Server.Fingerprint != fingerprint(leaf) {
+		t.Errorf("the status doesn't describe the new cert: %+v", status)
+	}
+}
+
+func TestServerCertReused(t *testing.T) {
+	useTempCerts(t)
+	if _, err := EnsureCerts(); err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, path := range []string{vars.CertPath, vars.KeyPath, vars.CACertPath, vars.CAKeyPath} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[path] = data
+	}
+
+	// a restart loads what's on disk
+	forgetCerts()
+	reissued, err := EnsureCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reissued {
+		t.Error("the server cert was reissued on restart")
+	}
+	for path, data := range files {
+		if now, _ := os.ReadFile(path); !bytes.Equal(now, data) {
+			t.Errorf("%s was rewritten on restart", path)
+		}
+	}
+	served, err := ServerCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := readCerts(t, vars.CertPath)[0]; !bytes.Equal(served.Certificate[0], leaf.Raw) {
+		t.Error("chipper isn't serving the cert on disk")
+	}
+
+	// a new SAN gets a new server cert from the same CA
+	vars.APIConfig.Server.CertSANs = []string{"vector.example"}
+	reissued, err = EnsureCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reissued {
+		t.Error("the server cert wasn't reissued for a new SAN")
+	}
+	if now, _ := os.ReadFile(vars.CACertPath); !bytes.Equal(now, files[vars.CACertPath]) {
+		t.Error("the CA was replaced when the server cert was reissued")
+	}
+	if leaf := readCerts(t, vars.CertPath)[0]; leaf.VerifyHostname("vector.example") != nil {
+		t.Error("the reissued cert doesn't cover the new SAN")
+	}
+}
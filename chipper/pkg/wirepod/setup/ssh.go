@@ -43,6 +43,90 @@ func runCmd(client *ssh.Client, cmd string) (string, error) {
 	return string(output), nil
 }
 
+// connects to the bot as root and makes sure it's a Vector
+func connectToBot(ip string, key []byte) (*ssh.Client, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, doErr(err, "parsing priv key")
+	}
+	config := &ssh.ClientConfig{
+		User: "root",
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+		},
+		HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+		HostKeyAlgorithms: []string{"ssh-rsa"},
+		Timeout:           time.Second * 5,
+	}
+	client, err := ssh.Dial("tcp", ip+":22", config)
+	if err != nil {
+		return nil, doErr(err, "ssh dial")
+	}
+	SetupSSHStatus = "Checking if device is a Vector..."
+	output, err := runCmd(client, "uname -a")
+	if err != nil {
+		client.Close()
+		return nil, doErr(err, "checking if vector")
+	}
+	if !strings.Contains(output, "Vector") {
+		client.Close()
+		return nil, doErr(fmt.Errorf("the remote device is not a vector"), "checking if vector")
+	}
+	return client, nil
+}
+
+func copyToBot(client *ssh.Client, localPath string, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scpClient, err := scp.NewClientBySSH(client)
+	if err != nil {
+		return err
+	}
+	defer scpClient.Session.Close()
+	return scpClient.CopyFile(context.Background(), file, remotePath, "0755")
+}
+
+// PushCertsViaSSH gives an already set up bot the current server config and cert, after the IP changed or the CA was rotated
+func PushCertsViaSSH(ip string, key []byte) error {
+	if SSHSettingUp {
+		return fmt.Errorf("a bot is already being setup")
+	}
+	SSHSettingUp = true
+	logger.Println("Pushing the server config and cert to " + ip + " via SSH")
+	SetupSSHStatus = "Setting up SSH connection..."
+	CreateServerConfig()
+	client, err := connectToBot(ip, key)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	SetupSSHStatus = "Transferring server config and cert..."
+	_, err = runCmd(client, "mount -o rw,remount /")
+	if err != nil && !strings.Contains(err.Error(), "Process exited with status 1") {
+		return doErr(err, "remounting rootfs")
+	}
+	if err := copyToBot(client, vars.ServerConfigPath, "/anki/data/assets/cozmo_resources/config/server_config.json"); err != nil {
+		return doErr(err, "copying server-config.json")
+	}
+	if err := copyToBot(client, botCertPath(), "/anki/etc/wirepod-cert.crt"); err != nil {
+		return doErr(err, "copying wire-pod cert")
+	}
+	_, err = runCmd(client, "cp /anki/etc/wirepod-cert.crt /data/data/wirepod-cert.crt && chmod +rwx /anki/data/assets/cozmo_resources/config/server_config.json /data/data/wirepod-cert.crt /anki/etc/wirepod-cert.crt")
+	if err != nil {
+		return doErr(err, "copying wire-pod cert in robot")
+	}
+	SetupSSHStatus = "Restarting the robot's services..."
+	if _, err := runCmd(client, "systemctl restart anki-robot.target"); err != nil {
+		return doErr(err, "restarting anki-robot.target")
+	}
+	SSHSettingUp = false
+	SetupSSHStatus = "done"
+	return nil
+}
+
 func setCPURAMfreq(client *ssh.Client, cpufreq string, ramfreq string, gov string) {
 	runCmd(client, "echo "+cpufreq+" > /sys/devices/system/cpu/cpu0/cpufreq/scaling_max_freq && echo disabled > /sys/kernel/debug/msm_otg/bus_voting && echo 0 > /sys/kernel/debug/msm-bus-dbg/shell-client/update_request && echo 1 > /sys/kernel/debug/msm-bus-dbg/shell-client/mas && echo 512 > /sys/kernel/debug/msm-bus-dbg/shell-client/slv && echo 0 > /sys/kernel/debug/msm-bus-dbg/shell-client/ab && echo active clk2 0 1 max "+ramfreq+" > /sys/kernel/debug/rpm_send_msg/message && echo "+gov+" > /sys/devices/system/cpu/cpu0/cpufreq/scaling_governor && echo 1 > /sys/kernel/debug/msm-bus-dbg/shell-client/update_request")
 }
@@ -55,34 +139,15 @@ func SetupBotViaSSH(ip string, key []byte) error {
 		SetupScriptPath = "./pod-bot-install.sh"
 	}
 	if !SSHSettingUp {
+		SSHSettingUp = true
 		logger.Println("Setting up " + ip + " via SSH")
 		SetupSSHStatus = "Setting up SSH connection..."
 		CreateServerConfig()
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			doErr(err, "parsing priv key")
-		}
-		config := &ssh.ClientConfig{
-			User: "root",
-			Auth: []ssh.AuthMethod{
-				ssh.PublicKeys(signer),
-			},
-			HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
-			HostKeyAlgorithms: []string{"ssh-rsa"},
-			Timeout:           time.Second * 5,
-		}
-		client, err := ssh.Dial("tcp", ip+":22", config)
-		if err != nil {
-			return doErr(err, "ssh dial")
-		}
-		SetupSSHStatus = "Checking if device is a Vector..."
-		output, err := runCmd(client, "uname -a")
+		client, err := connectToBot(ip, key)
 		if err != nil {
-			return doErr(err, "checking if vector")
-		}
-		if !strings.Contains(output, "Vector") {
-			return doErr(fmt.Errorf("the remote device is not a vector"), "checking if vector")
+			return err
 		}
+		defer client.Close()
 		SetupSSHStatus = "Running initial commands before transfers (screen will go blank, this is normal)..."
 		_, err = runCmd(client, "mount -o rw,remount / && mount -o rw,remount,exec /data && systemctl stop anki-robot.target && mv /anki/data/assets/cozmo_resources/config/server_config.json /anki/data/assets/cozmo_resources/config/server_config.json.bak")
 		if err != nil {
@@ -166,15 +231,7 @@ func SetupBotViaSSH(ip string, key []byte) error {
 			}
 		}
 		scpClient.Session.Close()
-		certPath := vars.CertPath
-		if vars.APIConfig.Server.EPConfig {
-			if runtime.GOOS == "android" || runtime.GOOS == "ios" {
-				certPath = vars.AndroidPath + "/static/epod/ep.crt"
-			} else {
-				certPath = "./epod/ep.crt"
-			}
-		}
-		cert, err := os.Open(certPath)
+		cert, err := os.Open(botCertPath())
 		if err != nil {
 			return doErr(err, "opening cert")
 		}
@@ -197,7 +254,7 @@ func SetupBotViaSSH(ip string, key []byte) error {
 			return doErr(err, "generating new robot cert")
 		}
 		setCPURAMfreq(client, "733333", "500000", "interactive")
-		client.Close()
+		SSHSettingUp = false
 		SetupSSHStatus = "done"
 	} else {
 		return fmt.Errorf("a bot is already being setup")
@@ -226,6 +283,25 @@ func SSHSetup(w http.ResponseWriter, r *http.Request) {
 		go SetupBotViaSSH(ip, keyBytes)
 		fmt.Fprint(w, "running")
 		return
+	case r.URL.Path == "/api-ssh/push_certs":
+		ip := r.FormValue("ip")
+		if ip == "" {
+			fmt.Fprint(w, "error: must provide ip")
+			return
+		}
+		key, _, err := r.FormFile("key")
+		if err != nil {
+			fmt.Fprint(w, "error: must provide ssh key ("+err.Error()+")")
+			return
+		}
+		keyBytes, _ := io.ReadAll(key)
+		if len(keyBytes) < 5 {
+			fmt.Fprint(w, "error: must provide ssh key")
+			return
+		}
+		go PushCertsViaSSH(ip, keyBytes)
+		fmt.Fprint(w, "running")
+		return
 	case r.URL.Path == "/api-ssh/get_setup_status":
 		fmt.Fprint(w, SetupSSHStatus)
 		if SetupSSHStatus == "done" || strings.Contains(SetupSSHStatus, "error") {
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"strings"
 	"time"
 
 	pb "github.com/digital-dream-labs/api/go/chipperpb"
@@ -40,6 +41,32 @@ type SpeechRequest struct {
 	LastAudioChunk  []byte
 	IsOpus          bool
 	OpusStream      *opus.OggStream
+	// if set, the request ends if the user hasn't started speaking this long after it began
+	SilenceTimeout time.Duration
+	Started        time.Time
+	// shared by every copy of the request, so preqs can see what the STT engine's copy got up to
+	Timing *Timing
+	// the decoded audio, shared like Timing. DecodedMicData is only on the engine's copy
+	Recording *bytes.Buffer
+	// if set, engines which can (stt Capabilities.Partials) give Partial what they've heard so far.
+	// it returns true if that's the whole request, and the engine stops listening
+	OnPartial func(text string) bool
+	// the last partial transcript, how much audio there was when it became that, and if OnPartial saw it
+	partial        string
+	partialAt      int
+	partialChecked bool
+}
+
+// Timing is when a voice request's stages happened, for the metrics
+type Timing struct {
+	// when the robot started sending audio (the vtt request's Time)
+	Received time.Time
+	// when DetectEndOfSpeech decided the user was done. zero if it never did
+	SpeechEnded time.Time
+	// time spent in DetectEndOfSpeech
+	Detecting time.Duration
+	// true if a partial transcript ended the request before DetectEndOfSpeech did
+	EndedEarly bool
 }
 
 func BytesToSamples(buf []byte) []int16 {
@@ -108,6 +135,12 @@ func BytesToIntVAD(stream opus.OggStream, data []byte, die bool, isOpus bool) []
 // Uses VAD to detect when the user stops speaking
 func (req *SpeechRequest) DetectEndOfSpeech() (bool, bool) {
 	// changes InactiveFrames and ActiveFrames in req
+	if req.Timing != nil {
+		began := time.Now()
+		defer func() {
+			req.Timing.Detecting += time.Since(began)
+		}()
+	}
 	inactiveNumMax := 23
 	for _, chunk := range SplitVAD(req.LastAudioChunk) {
 		active, err := req.VADInst.Process(16000, chunk)
@@ -124,15 +157,63 @@ func (req *SpeechRequest) DetectEndOfSpeech() (bool, bool) {
 		}
 		if req.InactiveFrames >= inactiveNumMax && req.ActiveFrames > 18 {
 			logger.Println("(Bot " + req.Device + ") End of speech detected.")
+			req.speechEnded()
 			return true, true
 		}
 	}
 	if req.ActiveFrames < 5 {
+		if req.SilenceTimeout > 0 && time.Since(req.Started) > req.SilenceTimeout {
+			logger.Println("(Bot " + req.Device + ") Nothing was said before the silence timeout.")
+			req.speechEnded()
+			return true, false
+		}
 		return false, false
 	}
 	return false, true
 }
 
+// a partial transcript has to stay the same for this much audio before OnPartial gets it,
+// so "stop" isn't taken while the user is still saying "stop the timer"
+const partialStableBytes = 16000 * 2 / 10
+
+// Partial is called by the engine with its latest partial transcript. true means the request is done,
+// and text is the transcript
+func (req *SpeechRequest) Partial(text string) bool {
+	text = strings.TrimSpace(text)
+	if req.OnPartial == nil || text == "" {
+		return false
+	}
+	if text != req.partial {
+		req.partial, req.partialAt, req.partialChecked = text, len(req.DecodedMicData), false
+		return false
+	}
+	if req.partialChecked || len(req.DecodedMicData)-req.partialAt < partialStableBytes {
+		return false
+	}
+	req.partialChecked = true
+	if !req.OnPartial(text) {
+		return false
+	}
+	logger.Println("(Bot " + req.Device + ") Ending early on the partial transcript: " + text)
+	req.speechEnded()
+	if req.Timing != nil {
+		req.Timing.EndedEarly = true
+	}
+	return true
+}
+
+func (req *SpeechRequest) speechEnded() {
+	if req.Timing != nil && req.Timing.SpeechEnded.IsZero() {
+		req.Timing.SpeechEnded = time.Now()
+	}
+}
+
+func (req *SpeechRequest) record(pcm []byte) {
+	if req.Recording != nil {
+		req.Recording.Write(pcm)
+	}
+}
+
 func bytesToInt16(data []byte) ([]int16, error) {
 	var samples []int16
 	buf := bytes.NewReader(data)
@@ -214,6 +295,9 @@ func ReqToSpeechRequest(req interface{}) SpeechRequest {
 	}
 	var request SpeechRequest
 	request.PrevLen = 0
+	request.Started = time.Now()
+	request.Timing = &Timing{Received: request.Started}
+	request.Recording = &bytes.Buffer{}
 	var err error
 	request.VADInst, err = webrtcvad.New()
 	request.VADInst.SetMode(2)
@@ -224,6 +308,9 @@ func ReqToSpeechRequest(req interface{}) SpeechRequest {
 		var req1 *vtt.IntentRequest = str
 		request.Device = req1.Device
 		request.Session = req1.Session
+		if !req1.Time.IsZero() {
+			request.Timing.Received = req1.Time
+		}
 		request.Stream = req1.Stream
 		request.FirstReq = req1.FirstReq.InputAudio
 		request.MicData = append(request.MicData, req1.FirstReq.InputAudio...)
@@ -232,6 +319,9 @@ func ReqToSpeechRequest(req interface{}) SpeechRequest {
 		request.IsKG = true
 		request.Device = req1.Device
 		request.Session = req1.Session
+		if !req1.Time.IsZero() {
+			request.Timing.Received = req1.Time
+		}
 		request.Stream = req1.Stream
 		request.FirstReq = req1.FirstReq.InputAudio
 		request.MicData = append(request.MicData, req1.FirstReq.InputAudio...)
@@ -240,6 +330,9 @@ func ReqToSpeechRequest(req interface{}) SpeechRequest {
 		var req1 *vtt.IntentGraphRequest = str
 		request.Device = req1.Device
 		request.Session = req1.Session
+		if !req1.Time.IsZero() {
+			request.Timing.Received = req1.Time
+		}
 		request.Stream = req1.Stream
 		request.FirstReq = req1.FirstReq.InputAudio
 		if debugWriteFile {
@@ -259,6 +352,9 @@ func ReqToSpeechRequest(req interface{}) SpeechRequest {
 		request.LastAudioChunk = request.FilteredMicData[request.PrevLen:]
 		request.PrevLen = len(request.DecodedMicData)
 		request.IsOpus = true
+		request.Recording.Write(decodedFirstReq)
+	} else {
+		request.Recording.Write(request.FirstReq)
 	}
 	return request
 }
@@ -275,6 +371,7 @@ func (req *SpeechRequest) GetNextStreamChunk() ([]byte, error) {
 		}
 		req.MicData = append(req.MicData, chunk.InputAudio...)
 		req.DecodedMicData = append(req.DecodedMicData, req.OpusDecode(chunk.InputAudio)...)
+		req.record(req.DecodedMicData[req.PrevLen:])
 		req.FilteredMicData = append(req.FilteredMicData, highPassFilter(req.OpusDecode(chunk.InputAudio))...)
 		dataReturn := req.DecodedMicData[req.PrevLen:]
 		req.LastAudioChunk = req.FilteredMicData[req.PrevLen:]
@@ -289,6 +386,7 @@ func (req *SpeechRequest) GetNextStreamChunk() ([]byte, error) {
 		}
 		req.MicData = append(req.MicData, chunk.InputAudio...)
 		req.DecodedMicData = append(req.DecodedMicData, req.OpusDecode(chunk.InputAudio)...)
+		req.record(req.DecodedMicData[req.PrevLen:])
 		req.FilteredMicData = append(req.FilteredMicData, highPassFilter(req.OpusDecode(chunk.InputAudio))...)
 		dataReturn := req.DecodedMicData[req.PrevLen:]
 		req.LastAudioChunk = req.FilteredMicData[req.PrevLen:]
@@ -306,6 +404,7 @@ func (req *SpeechRequest) GetNextStreamChunk() ([]byte, error) {
 		}
 		req.MicData = append(req.MicData, chunk.InputAudio...)
 		req.DecodedMicData = append(req.DecodedMicData, req.OpusDecode(chunk.InputAudio)...)
+		req.record(req.DecodedMicData[req.PrevLen:])
 		req.FilteredMicData = append(req.FilteredMicData, highPassFilter(req.OpusDecode(chunk.InputAudio))...)
 		dataReturn := req.DecodedMicData[req.PrevLen:]
 		req.LastAudioChunk = req.FilteredMicData[req.PrevLen:]
@@ -327,6 +426,7 @@ func (req *SpeechRequest) GetNextStreamChunkOpus() ([]byte, error) {
 		}
 		req.MicData = append(req.MicData, chunk.InputAudio...)
 		req.DecodedMicData = append(req.DecodedMicData, req.OpusDecode(chunk.InputAudio)...)
+		req.record(req.DecodedMicData[req.PrevLen:])
 		dataReturn := req.MicData[req.PrevLenRaw:]
 		req.LastAudioChunk = req.DecodedMicData[req.PrevLen:]
 		req.PrevLen = len(req.DecodedMicData)
@@ -341,6 +441,7 @@ func (req *SpeechRequest) GetNextStreamChunkOpus() ([]byte, error) {
 		}
 		req.MicData = append(req.MicData, chunk.InputAudio...)
 		req.DecodedMicData = append(req.DecodedMicData, req.OpusDecode(chunk.InputAudio)...)
+		req.record(req.DecodedMicData[req.PrevLen:])
 		dataReturn := req.MicData[req.PrevLenRaw:]
 		req.LastAudioChunk = req.DecodedMicData[req.PrevLen:]
 		req.PrevLen = len(req.DecodedMicData)
@@ -355,6 +456,7 @@ func (req *SpeechRequest) GetNextStreamChunkOpus() ([]byte, error) {
 		}
 		req.MicData = append(req.MicData, chunk.InputAudio...)
 		req.DecodedMicData = append(req.DecodedMicData, req.OpusDecode(chunk.InputAudio)...)
+		req.record(req.DecodedMicData[req.PrevLen:])
 		dataReturn := req.MicData[req.PrevLenRaw:]
 		req.LastAudioChunk = req.DecodedMicData[req.PrevLen:]
 		req.PrevLen = len(req.DecodedMicData)
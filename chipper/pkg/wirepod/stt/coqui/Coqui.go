@@ -9,10 +9,23 @@ import (
 	"github.com/asticode/go-asticoqui"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
 )
 
 var Name string = "coqui"
 
+func init() {
+	stt.Register(stt.Engine{
+		Name: Name,
+		Init: Init,
+		STT:  STT,
+		Capabilities: stt.Capabilities{
+			Streaming: true,
+			Languages: []string{"en-US"},
+		},
+	})
+}
+
 // Init should be defined as `func() error`
 func Init() error {
 	logger.Println("Running a Coqui test...")
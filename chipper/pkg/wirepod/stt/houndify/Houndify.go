@@ -8,6 +8,7 @@ import (
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	preqs "github.com/kercre123/wire-pod/chipper/pkg/wirepod/preqs"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
 	"github.com/soundhound/houndify-sdk-go"
 )
 
@@ -17,6 +18,18 @@ import (
 
 var Name string = "houndify"
 
+func init() {
+	stt.Register(stt.Engine{
+		Name: Name,
+		Init: Init,
+		STT:  STT,
+		Capabilities: stt.Capabilities{
+			Streaming: true,
+			Languages: []string{"en-US"},
+		},
+	})
+}
+
 var houndSTTClient houndify.Client
 
 func Init() error {
@@ -10,6 +10,7 @@ import (
 	leopard "github.com/Picovoice/leopard/binding/go/v2"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
 )
 
 var BotNum int
@@ -17,6 +18,18 @@ var BotNumMu sync.Mutex
 
 var Name string = "leopard"
 
+func init() {
+	stt.Register(stt.Engine{
+		Name: Name,
+		Init: Init,
+		STT:  STT,
+		Capabilities: stt.Capabilities{
+			Streaming: false,
+			Languages: []string{"en-US"},
+		},
+	})
+}
+
 var leopardSTTArray []leopard.Leopard
 var picovoiceInstancesOS string = os.Getenv("PICOVOICE_INSTANCES")
 var picovoiceInstances int
@@ -0,0 +1,109 @@
+package wirepod_stt
+
+import (
+	"sort"
+	"sync"
+
+	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+)
+
+// STT engines register themselves here (in an init() func) so one binary can carry several of them.
+// preqs picks one by name, and can switch to another one while running.
+
+// Capabilities describe what an engine can do
+type Capabilities struct {
+	// true if audio is fed to the engine while the robot is still talking,
+	// false if it waits for the end of speech and processes the whole utterance at once
+	Streaming bool `json:"streaming"`
+	// true if the engine gives SpeechRequest.Partial what it has heard so far while audio arrives,
+	// so a request can end as soon as it's a whole command
+	Partials bool `json:"partials"`
+	// languages the engine can transcribe. the first one is used if the configured language isn't in here
+	Languages []string `json:"languages"`
+	// speech-to-intent engines (like Picovoice Rhino) return an intent and slots instead of text
+	Intents bool `json:"intents"`
+}
+
+// Engine is a registered speech-to-text (or speech-to-intent) engine
+type Engine struct {
+	Name string `json:"name"`
+	// loads models, checks keys. called again whenever the language changes
+	Init func() error `json:"-"`
+	// set for regular engines
+	STT func(sr.SpeechRequest) (string, error) `json:"-"`
+	// set for speech-to-intent engines
+	STI func(sr.SpeechRequest) (string, map[string]string, error) `json:"-"`
+	// optional, called after the intents or custom intents change, for engines which use them (Vosk's grammar)
+	Reload func() error `json:"-"`
+	Capabilities
+}
+
+var engines = make(map[string]Engine)
+var enginesOrder []string
+var enginesMu sync.RWMutex
+
+// Register adds an engine. Registering a name twice or an engine with no handler is a programming error.
+func Register(e Engine) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	if e.Name == "" || e.Init == nil {
+		panic("stt: engine needs a name and an init function")
+	}
+	if (e.STT == nil) == (e.STI == nil) || e.Intents != (e.STI != nil) {
+		panic("stt: engine " + e.Name + " needs exactly one of STT or STI, matching its Intents capability")
+	}
+	if _, exists := engines[e.Name]; exists {
+		panic("stt: engine " + e.Name + " registered twice")
+	}
+	engines[e.Name] = e
+	enginesOrder = append(enginesOrder, e.Name)
+}
+
+// Get returns the engine registered under name
+func Get(name string) (Engine, bool) {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	e, ok := engines[name]
+	return e, ok
+}
+
+// Engines returns every registered engine, in the order they registered
+func Engines() []Engine {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	var list []Engine
+	for _, name := range enginesOrder {
+		list = append(list, engines[name])
+	}
+	return list
+}
+
+// Names returns the names of every registered engine, sorted
+func Names() []string {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	names := append([]string{}, enginesOrder...)
+	sort.Strings(names)
+	return names
+}
+
+// SupportsLanguage is true if the engine can transcribe lang
+func (e Engine) SupportsLanguage(lang string) bool {
+	if len(e.Languages) == 0 {
+		return true
+	}
+	for _, l := range e.Languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultLanguage is what the engine falls back to if the configured language isn't supported
+func (e Engine) DefaultLanguage() string {
+	if len(e.Languages) == 0 {
+		return "en-US"
+	}
+	return e.Languages[0]
+}
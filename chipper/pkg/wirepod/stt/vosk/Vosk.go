@@ -13,13 +13,29 @@ import (
 	vosk "github.com/kercre123/vosk-api/go"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
 )
 
 var GrammerEnable bool = false
 
 var Name string = "vosk"
 
+func init() {
+	stt.Register(stt.Engine{
+		Name:   Name,
+		Init:   Init,
+		STT:    STT,
+		Reload: ReloadGrammer,
+		Capabilities: stt.Capabilities{
+			Streaming: true,
+			Partials:  true,
+			Languages: localization.ValidVoskModels,
+		},
+	})
+}
+
 var model *vosk.VoskModel
 var recsmu sync.Mutex
 
@@ -112,7 +128,7 @@ func runTest() {
 		logger.Println("Using general recognizer")
 		withGrm = false
 	}
-	rec, recind := getRec(withGrm)
+	rec := getRec(withGrm)
 	sttTestPath := "./stttest.pcm"
 	if runtime.GOOS == "android" {
 		sttTestPath = vars.AndroidPath + "/static/stttest.pcm"
@@ -126,11 +142,7 @@ func runTest() {
 	}
 	var jres map[string]interface{}
 	json.Unmarshal([]byte(rec.FinalResult()), &jres)
-	if withGrm {
-		grmRecs[recind].InUse = false
-	} else {
-		gpRecs[recind].InUse = false
-	}
+	putRec(rec, withGrm)
 	transcribedText := jres["text"].(string)
 	tTime := time.Now().Sub(cTime)
 	logger.Println("Text (from test):", transcribedText)
@@ -141,31 +153,32 @@ func runTest() {
 
 }
 
-func getRec(withGrm bool) (*vosk.VoskRecognizer, int) {
+func getRec(withGrm bool) *vosk.VoskRecognizer {
 	recsmu.Lock()
 	defer recsmu.Unlock()
 	if withGrm && GrammerEnable {
 		for ind, rec := range grmRecs {
 			if !rec.InUse {
 				grmRecs[ind].InUse = true
-				return grmRecs[ind].Rec, ind
+				return grmRecs[ind].Rec
 			}
 		}
 	} else {
 		for ind, rec := range gpRecs {
 			if !rec.InUse {
 				gpRecs[ind].InUse = true
-				return gpRecs[ind].Rec, ind
+				return gpRecs[ind].Rec
 			}
 		}
 	}
+	grammer := Grammer
 	recsmu.Unlock()
 	var newrec ARec
 	var newRec *vosk.VoskRecognizer
 	var err error
 	newrec.InUse = true
 	if withGrm {
-		newRec, err = vosk.NewRecognizerGrm(model, 16000.0, Grammer)
+		newRec, err = vosk.NewRecognizerGrm(model, 16000.0, grammer)
 	} else {
 		newRec, err = vosk.NewRecognizer(model, 16000.0)
 	}
@@ -175,12 +188,56 @@ func getRec(withGrm bool) (*vosk.VoskRecognizer, int) {
 	newrec.Rec = newRec
 	recsmu.Lock()
 	if withGrm {
+		// the grammar was reloaded while this one was being made. putRec frees it
+		if grammer != Grammer {
+			return newRec
+		}
 		grmRecs = append(grmRecs, newrec)
-		return grmRecs[len(grmRecs)-1].Rec, len(grmRecs) - 1
 	} else {
 		gpRecs = append(gpRecs, newrec)
-		return gpRecs[len(gpRecs)-1].Rec, len(gpRecs) - 1
 	}
+	return newRec
+}
+
+// putRec makes a recognizer available again. one which isn't in the pool anymore (ReloadGrammer) is freed
+func putRec(rec *vosk.VoskRecognizer, withGrm bool) {
+	recsmu.Lock()
+	defer recsmu.Unlock()
+	recs := gpRecs
+	if withGrm {
+		recs = grmRecs
+	}
+	for ind := range recs {
+		if recs[ind].Rec == rec {
+			recs[ind].InUse = false
+			return
+		}
+	}
+	rec.Free()
+}
+
+// ReloadGrammer makes the grammar again, after the intents or custom intents changed.
+// recognizers with the old grammar are freed once the requests using them are done
+func ReloadGrammer() error {
+	if !GrammerEnable || !modelLoaded {
+		return nil
+	}
+	grammer := GetGrammerList(vars.APIConfig.STT.Language)
+	rec, err := vosk.NewRecognizerGrm(model, 16000.0, grammer)
+	if err != nil {
+		return err
+	}
+	recsmu.Lock()
+	defer recsmu.Unlock()
+	for _, old := range grmRecs {
+		if !old.InUse {
+			old.Rec.Free()
+		}
+	}
+	Grammer = grammer
+	grmRecs = []ARec{{Rec: rec}}
+	logger.Println("Reloaded the VOSK grammar")
+	return nil
 }
 
 func STT(req sr.SpeechRequest) (string, error) {
@@ -193,18 +250,30 @@ func STT(req sr.SpeechRequest) (string, error) {
 		logger.Println("Using grammer-optimized recognizer")
 		withGrm = true
 	}
-	rec, recind := getRec(withGrm)
+	rec := getRec(withGrm)
+	defer putRec(rec, withGrm)
 	rec.SetWords(1)
 	rec.AcceptWaveform(req.FirstReq)
 	req.DetectEndOfSpeech()
 	for {
 		chunk, err := req.GetNextStreamChunk()
 		if err != nil {
+			// so the next request doesn't get this one's audio
+			rec.Reset()
 			return "", err
 		}
 		speechIsDone, doProcess := req.DetectEndOfSpeech()
 		if doProcess {
-			rec.AcceptWaveform(chunk)
+			// 0 means it's still in the middle of an utterance, so there's a partial result
+			if rec.AcceptWaveform(chunk) == 0 && req.OnPartial != nil {
+				var partial map[string]interface{}
+				json.Unmarshal([]byte(rec.PartialResult()), &partial)
+				if text, _ := partial["partial"].(string); req.Partial(text) {
+					rec.Reset()
+					logger.Println("Bot " + req.Device + " Transcribed text: " + text)
+					return text, nil
+				}
+			}
 		}
 		if speechIsDone {
 			break
@@ -212,11 +281,6 @@ func STT(req sr.SpeechRequest) (string, error) {
 	}
 	var jres map[string]interface{}
 	json.Unmarshal([]byte(rec.FinalResult()), &jres)
-	if withGrm {
-		grmRecs[recind].InUse = false
-	} else {
-		gpRecs[recind].InUse = false
-	}
 	transcribedText := jres["text"].(string)
 	logger.Println("Bot " + req.Device + " Transcribed text: " + transcribedText)
 	return transcribedText, nil
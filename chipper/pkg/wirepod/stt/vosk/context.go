@@ -24,7 +24,7 @@ func GetGrammerList(lang string) string {
 	var wordsList []string
 	var grammer string
 	// add words in intent json
-	for _, words := range vars.IntentList {
+	for _, words := range vars.Intents() {
 		for _, word := range words.Keyphrases {
 			wors := strings.Split(word, " ")
 			for _, wor := range wors {
@@ -38,7 +38,8 @@ func GetGrammerList(lang string) string {
 	// add words in localization
 	for _, str := range localization.ALL_STR {
 		text := localization.GetText(str)
-		wors := strings.Split(text, " ")
+		// some have alternatives, split by "|"
+		wors := strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == '|' })
 		for _, wor := range wors {
 			found := model.FindWord(wor)
 			if found != -1 {
@@ -47,7 +48,7 @@ func GetGrammerList(lang string) string {
 		}
 	}
 	// add custom intent matches
-	for _, intent := range vars.CustomIntents {
+	for _, intent := range vars.CustomIntents() {
 		for _, utterance := range intent.Utterances {
 			wors := strings.Split(utterance, " ")
 			for _, wor := range wors {
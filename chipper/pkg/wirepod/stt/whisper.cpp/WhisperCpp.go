@@ -7,18 +7,48 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
 
 	whisper "github.com/ggerganov/whisper.cpp/bindings/go"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
 )
 
 var Name string = "whisper.cpp"
 
+func init() {
+	stt.Register(stt.Engine{
+		Name: Name,
+		Init: Init,
+		STT:  STT,
+		Capabilities: stt.Capabilities{
+			Streaming: false,
+			Partials:  true,
+			Languages: localization.ValidVoskModels,
+		},
+	})
+}
+
 var context *whisper.Context
 var params whisper.Params
 
+// Whisper_full can't run twice on the context at once
+var contextMu sync.Mutex
+
+// final transcriptions waiting for the context. partials are only made when nothing else wants it
+var finalsWaiting atomic.Int32
+
+// with WHISPER_PARTIALS=true, what has been said so far is transcribed every half a second of audio while
+// the user is talking, so a short command can end the request early. it takes a lot more CPU, so it's off by default
+var partialsEnabled bool
+
+const partialEveryBytes = 16000 * 2 / 2
+
 func padPCM(data []byte) []byte {
 	const sampleRate = 16000
 	const minDurationMs = 1020
@@ -72,6 +102,7 @@ func Init() error {
 	params.SetNoContext(true)
 	params.SetSingleSegment(true)
 	params.SetLanguage(context.Whisper_lang_id(sttLanguage))
+	partialsEnabled = os.Getenv("WHISPER_PARTIALS") == "true"
 	return nil
 }
 
@@ -79,6 +110,10 @@ func STT(req sr.SpeechRequest) (string, error) {
 	logger.Println("(Bot " + req.Device + ", Whisper) Processing...")
 	speechIsDone := false
 	var err error
+	partials := partialsEnabled && req.OnPartial != nil
+	// the partial transcript being made, nil if there isn't one
+	var partial chan string
+	var partialLen int
 	for {
 		_, err = req.GetNextStreamChunk()
 		if err != nil {
@@ -89,6 +124,31 @@ func STT(req sr.SpeechRequest) (string, error) {
 		if speechIsDone {
 			break
 		}
+		if !partials {
+			continue
+		}
+		if partial != nil {
+			select {
+			case text := <-partial:
+				partial = nil
+				if req.Partial(text) {
+					logger.Println("Bot " + req.Device + " Transcribed text: " + text)
+					return text, nil
+				}
+			default:
+			}
+		}
+		if partial == nil && len(req.DecodedMicData)-partialLen >= partialEveryBytes {
+			partialLen = len(req.DecodedMicData)
+			// a copy, padPCM appends to it
+			audio := append([]byte{}, req.DecodedMicData...)
+			partial = make(chan string, 1)
+			go func(partial chan string) {
+				// empty if the context was busy, and Partial ignores it
+				text, _ := processPartial(audio)
+				partial <- text
+			}(partial)
+		}
 	}
 	transcribedText, err := process(BytesToFloat32Buffer(padPCM(req.DecodedMicData)))
 	if err != nil {
@@ -100,11 +160,33 @@ func STT(req sr.SpeechRequest) (string, error) {
 }
 
 func process(data []float32) (string, error) {
+	finalsWaiting.Add(1)
+	contextMu.Lock()
+	finalsWaiting.Add(-1)
+	defer contextMu.Unlock()
+	return transcribe(data), nil
+}
+
+// transcribes what has been said so far, unless the context is in use or wanted by a final transcription.
+// the text is lowercase and without punctuation ("Stop." is "stop"), so it can be compared with the keyphrases
+func processPartial(audio []byte) (string, bool) {
+	if finalsWaiting.Load() > 0 || !contextMu.TryLock() {
+		return "", false
+	}
+	defer contextMu.Unlock()
+	words := strings.FieldsFunc(strings.ToLower(transcribe(BytesToFloat32Buffer(padPCM(audio)))), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	return strings.Join(words, " "), true
+}
+
+// contextMu has to be held
+func transcribe(data []float32) string {
 	var transcribedText string
 	context.Whisper_full(params, data, nil, func(_ int) {
 		transcribedText = strings.TrimSpace(context.Whisper_full_get_segment_text(0))
 	}, nil)
-	return transcribedText, nil
+	return transcribedText
 }
 
 func BytesToFloat32Buffer(buf []byte) []float32 {
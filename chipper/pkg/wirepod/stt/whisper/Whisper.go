@@ -16,10 +16,24 @@ import (
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
 	sr "github.com/kercre123/wire-pod/chipper/pkg/wirepod/speechrequest"
+	stt "github.com/kercre123/wire-pod/chipper/pkg/wirepod/stt"
 	"github.com/orcaman/writerseeker"
 )
 
 var Name string = "whisper"
+
+func init() {
+	stt.Register(stt.Engine{
+		Name: Name,
+		Init: Init,
+		STT:  STT,
+		Capabilities: stt.Capabilities{
+			Streaming: false,
+			Languages: []string{"en-US"},
+		},
+	})
+}
+
 var openaiKey string = ""
 var openaiBase string = "https://one-api.jl-t.com/v1"
 
@@ -0,0 +1,83 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fforchino/vector-go-sdk/pkg/vector"
+	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// conversation mode, for robots in Knowledge.ConversationRobots.
+//
+// once the LLM's answer has been spoken, the robot is told to listen again through the SDK, as if the user had said the wake word.
+// that comes in as a knowledge graph request, which preqs sends back through StreamingKGSim if TakeFollowUp says it is one.
+// if nothing is said within the silence timeout, the robot goes back to what it was doing and the conversation is over.
+
+// DefaultConversationTimeout is used when APIConfig.Knowledge.ConversationTimeout is 0
+const DefaultConversationTimeout = 5 * time.Second
+
+// the app intent which makes the robot listen for a question, like the button in the app
+const listenAppIntent = "knowledge_question"
+
+// how long after being told to listen the robot's request can take to get here
+const followUpWindow = 10 * time.Second
+
+// when each robot was last told to listen for a follow-up
+var followUps = make(map[string]time.Time)
+var followUpsMu sync.Mutex
+
+// ConversationEnabled is true if the robot listens again after the LLM answers
+func ConversationEnabled(esn string) bool {
+	for _, robot := range vars.APIConfig.Knowledge.ConversationRobots {
+		if robot == esn {
+			return true
+		}
+	}
+	return false
+}
+
+// ConversationTimeout is how long the user has to start talking before a conversation ends
+func ConversationTimeout() time.Duration {
+	if vars.APIConfig.Knowledge.ConversationTimeout > 0 {
+		return time.Duration(vars.APIConfig.Knowledge.ConversationTimeout) * time.Second
+	}
+	return DefaultConversationTimeout
+}
+
+// TakeFollowUp is true if the robot was just told to listen for a follow-up. it's only true once per follow-up
+func TakeFollowUp(esn string) bool {
+	followUpsMu.Lock()
+	defer followUpsMu.Unlock()
+	asked, ok := followUps[esn]
+	delete(followUps, esn)
+	return ok && time.Since(asked) < followUpWindow
+}
+
+// EndConversation stops the robot from being told to listen again
+func EndConversation(esn string) {
+	followUpsMu.Lock()
+	defer followUpsMu.Unlock()
+	delete(followUps, esn)
+}
+
+// tells the robot to listen for a follow-up. behavior control has to have been released already
+func listenForFollowUp(robot *vector.Vector, esn string) {
+	followUpsMu.Lock()
+	followUps[esn] = time.Now()
+	followUpsMu.Unlock()
+	// give the robot a moment to get out of the override
+	time.Sleep(time.Millisecond * 500)
+	_, err := robot.Conn.AppIntent(context.Background(), &vectorpb.AppIntentRequest{
+		Intent: listenAppIntent,
+	})
+	if err != nil {
+		logger.Println("Bot " + esn + " couldn't be told to listen for a follow-up: " + err.Error())
+		EndConversation(esn)
+		return
+	}
+	logger.Println("Bot " + esn + " is listening for a follow-up")
+}
@@ -0,0 +1,57 @@
+package wirepod_ttr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+func TestConversationMode(t *testing.T) {
+	knowledge := vars.APIConfig.Knowledge
+	t.Cleanup(func() {
+		vars.APIConfig.Knowledge = knowledge
+		EndConversation("00e20000")
+	})
+	vars.APIConfig.Knowledge.ConversationRobots = []string{"00e20000"}
+	if !ConversationEnabled("00e20000") || ConversationEnabled("00e20001") {
+		t.Error("conversation mode isn't only on for the robot it was turned on for")
+	}
+
+	vars.APIConfig.Knowledge.ConversationTimeout = 0
+	if ConversationTimeout() != DefaultConversationTimeout {
+		t.Errorf("timeout = %v, want the default", ConversationTimeout())
+	}
+	vars.APIConfig.Knowledge.ConversationTimeout = 8
+	if ConversationTimeout() != 8*time.Second {
+		t.Errorf("timeout = %v, want 8s", ConversationTimeout())
+	}
+
+	expect := func(asked time.Time) {
+		followUpsMu.Lock()
+		followUps["00e20000"] = asked
+		followUpsMu.Unlock()
+	}
+	if TakeFollowUp("00e20000") {
+		t.Error("a request was taken for a follow-up before the robot was told to listen")
+	}
+	// the robot was just told to listen, so its next request is the follow-up, and only that one
+	expect(time.Now())
+	if !TakeFollowUp("00e20000") {
+		t.Error("the follow-up wasn't taken")
+	}
+	if TakeFollowUp("00e20000") {
+		t.Error("a second request was taken for the same follow-up")
+	}
+	// a request long after the robot was told to listen came from the wake word
+	expect(time.Now().Add(-followUpWindow))
+	if TakeFollowUp("00e20000") {
+		t.Error("a follow-up was taken after the window")
+	}
+	// the user said nothing
+	expect(time.Now())
+	EndConversation("00e20000")
+	if TakeFollowUp("00e20000") {
+		t.Error("a follow-up was taken after the conversation ended")
+	}
+}
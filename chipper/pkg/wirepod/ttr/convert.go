@@ -2,6 +2,7 @@ package wirepod_ttr
 
 import (
 	"encoding/binary"
+	"errors"
 	"math"
 )
 
@@ -21,23 +22,127 @@ func int16sToBytes(data []int16) []byte {
 	return bytes
 }
 
-func downsample24kTo16k(input []byte) [][]byte {
-	outBytes := downsample24kTo16kLinear(input)
-	var audioChunks [][]byte
-	filteredBytes := lowPassFilter(outBytes, 4000, 16000)
-	iVolBytes := increaseVolume(filteredBytes, 5)
-	for len(iVolBytes) > 0 {
-		if len(iVolBytes) < 1024 {
-			chunk := make([]byte, 1024)
-			copy(chunk, iVolBytes)
-			audioChunks = append(audioChunks, chunk)
+// the robot plays 16 kHz mono 16-bit PCM
+const robotSampleRate = 16000
+
+// makes a TTS engine's audio ready for the robot: 16 kHz, and about as loud as its own voice
+func prepareSpeech(pcm []byte, sampleRate int) ([]byte, error) {
+	if sampleRate <= 0 {
+		return nil, errors.New("the TTS engine didn't say what sample rate its audio is")
+	}
+	if len(pcm) < 2 {
+		return nil, errors.New("the TTS engine returned no audio")
+	}
+	if sampleRate > robotSampleRate {
+		// what's above what 16 kHz can hold would come back as noise
+		pcm = lowPassFilter(pcm, 7000, sampleRate)
+	}
+	return normalizeVolume(resample(pcm, sampleRate, robotSampleRate)), nil
+}
+
+// linear, which is fine for speech
+func resample(pcm []byte, from, to int) []byte {
+	if from == to {
+		return pcm
+	}
+	in := bytesToInt16s(pcm)
+	out := make([]int16, int(int64(len(in))*int64(to)/int64(from)))
+	for i := range out {
+		pos := float64(i) * float64(from) / float64(to)
+		j := int(pos)
+		if j+1 >= len(in) {
+			out[i] = in[len(in)-1]
+			continue
+		}
+		frac := pos - float64(j)
+		out[i] = int16(float64(in[j])*(1-frac) + float64(in[j+1])*frac)
+	}
+	return int16sToBytes(out)
+}
+
+// the robot's speaker is quiet, so the audio is made as loud as it can be without clipping (up to 5 times)
+func normalizeVolume(pcm []byte) []byte {
+	var peak float64
+	for _, sample := range bytesToInt16s(pcm) {
+		peak = math.Max(peak, math.Abs(float64(sample)))
+	}
+	if peak == 0 {
+		return pcm
+	}
+	return increaseVolume(pcm, math.Min(0.9*math.MaxInt16/peak, 5))
+}
+
+// splits the audio into chunks of size bytes. the last one is padded with silence
+func chunkPCM(pcm []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(pcm) > 0 {
+		if len(pcm) < size {
+			chunk := make([]byte, size)
+			copy(chunk, pcm)
+			chunks = append(chunks, chunk)
 			break
 		}
-		audioChunks = append(audioChunks, iVolBytes[:1024])
-		iVolBytes = iVolBytes[1024:]
+		chunks = append(chunks, pcm[:size])
+		pcm = pcm[size:]
 	}
+	return chunks
+}
 
-	return audioChunks
+// the samples and sample rate of a 16-bit PCM WAV. stereo is mixed down to mono
+func parseWAV(data []byte) ([]byte, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+	var channels, bits, sampleRate int
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8:]
+		// servers which stream the WAV don't know the size yet
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, 0, errors.New("the WAV's format is cut off")
+			}
+			if format := binary.LittleEndian.Uint16(body[0:2]); format != 1 && format != 0xfffe {
+				return nil, 0, errors.New("the WAV isn't PCM")
+			}
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bits = int(binary.LittleEndian.Uint16(body[14:16]))
+		case "data":
+			if sampleRate == 0 {
+				return nil, 0, errors.New("the WAV has no format before its data")
+			}
+			if bits != 16 || channels < 1 {
+				return nil, 0, errors.New("the WAV isn't 16-bit")
+			}
+			return mixToMono(body[:len(body)/(2*channels)*2*channels], channels), sampleRate, nil
+		}
+		// chunks are padded to an even size
+		pos += 8 + size + size%2
+	}
+	return nil, 0, errors.New("the WAV has no data")
+}
+
+func mixToMono(pcm []byte, channels int) []byte {
+	if channels == 1 {
+		return pcm
+	}
+	in := bytesToInt16s(pcm)
+	out := make([]int16, len(in)/channels)
+	for i := range out {
+		var sum int
+		for c := 0; c < channels; c++ {
+			sum += int(in[i*channels+c])
+		}
+		out[i] = int16(sum / channels)
+	}
+	return int16sToBytes(out)
 }
 
 func increaseVolume(data []byte, factor float64) []byte {
@@ -72,21 +177,3 @@ func lowPassFilter(data []byte, cutoffFreq float64, sampleRate int) []byte {
 
 	return int16sToBytes(filtered)
 }
-
-// copied too
-func downsample24kTo16kLinear(input []byte) []byte {
-	int16s := bytesToInt16s(input)
-	outputLength := (len(int16s) * 2) / 3
-	output := make([]int16, outputLength)
-
-	j := 0
-	for i := 0; i < len(int16s)-2; i += 3 {
-		first := (2*int32(int16s[i]) + int32(int16s[i+1])) / 3
-		second := (int32(int16s[i+1]) + 2*int32(int16s[i+2])) / 3
-		output[j] = int16(first)
-		output[j+1] = int16(second)
-		j += 2
-	}
-
-	return int16sToBytes(output)
-}
@@ -0,0 +1,196 @@
+package wirepod_ttr
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	lcztn "github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
+)
+
+// what wire-pod remembers about the last exchange with each robot, for a little while.
+//
+// a plugin or custom intent can ask a question ("set a timer for how long?"). the next thing the robot hears
+// goes straight back to that handler, with the name of the slot it asked for.
+// plugins ask by returning AskIntentPrefix+slot as the intent and the question as the response,
+// and get the answer through an optional Continue func (see LoadPlugins). custom intents which are system intents
+// ask with "ask" and "question" in their json output, and get the answer through the !slot arg.
+//
+// an utterance starting with something like "and" or "what about" ("and tomorrow?") re-runs the last intent
+// with the new text, keeping whatever parameters the new text doesn't mention.
+
+// DefaultDialogTimeout is used when APIConfig.STT.DialogTimeout is 0
+const DefaultDialogTimeout = 30 * time.Second
+
+// AskIntentPrefix is returned by a plugin as its intent when its response is a question. the rest is the slot name.
+const AskIntentPrefix = "ask:"
+
+const (
+	handlerPlugin       = "plugin"
+	handlerCustomIntent = "customintent"
+)
+
+// PendingSlot is a question a handler asked and is waiting on the answer to
+type PendingSlot struct {
+	// handlerPlugin or handlerCustomIntent
+	Kind string
+	// plugin or custom intent name
+	Handler  string
+	Slot     string
+	Question string
+}
+
+// DialogContext is the last exchange with a robot
+type DialogContext struct {
+	ESN string
+	// the intent which was matched (not necessarily the one sent, intent_imperative_eyecolor becomes ..._specific_extend)
+	LastIntent string
+	LastParams map[string]string
+	LastText   string
+	Pending    *PendingSlot
+	Updated    time.Time
+}
+
+var dialogs = make(map[string]*DialogContext)
+var dialogsMu sync.Mutex
+
+func dialogTimeout() time.Duration {
+	if vars.APIConfig.STT.DialogTimeout > 0 {
+		return time.Duration(vars.APIConfig.STT.DialogTimeout) * time.Second
+	}
+	return DefaultDialogTimeout
+}
+
+// GetDialog returns a copy of the robot's dialog context if it hasn't timed out
+func GetDialog(esn string) (DialogContext, bool) {
+	dialogsMu.Lock()
+	defer dialogsMu.Unlock()
+	d, ok := dialogs[esn]
+	if !ok {
+		return DialogContext{}, false
+	}
+	if time.Since(d.Updated) > dialogTimeout() {
+		delete(dialogs, esn)
+		return DialogContext{}, false
+	}
+	return *d, true
+}
+
+// ClearDialog forgets everything about the last exchange with a robot
+func ClearDialog(esn string) {
+	dialogsMu.Lock()
+	defer dialogsMu.Unlock()
+	delete(dialogs, esn)
+}
+
+func rememberIntent(esn string, intent string, params map[string]string, text string) {
+	dialogsMu.Lock()
+	defer dialogsMu.Unlock()
+	dialogs[esn] = &DialogContext{
+		ESN:        esn,
+		LastIntent: intent,
+		LastParams: params,
+		LastText:   text,
+		Updated:    time.Now(),
+	}
+}
+
+func askSlot(esn string, pending PendingSlot) {
+	dialogsMu.Lock()
+	defer dialogsMu.Unlock()
+	d, ok := dialogs[esn]
+	if !ok {
+		d = &DialogContext{ESN: esn}
+		dialogs[esn] = d
+	}
+	d.Pending = &pending
+	d.Updated = time.Now()
+	logger.Println("Bot " + esn + " " + pending.Handler + " is waiting on an answer for " + pending.Slot)
+}
+
+// returns the pending question and removes it from the context, so an answer is only routed once
+func takePendingSlot(esn string) (PendingSlot, bool) {
+	d, ok := GetDialog(esn)
+	if !ok || d.Pending == nil {
+		return PendingSlot{}, false
+	}
+	dialogsMu.Lock()
+	defer dialogsMu.Unlock()
+	if cur, ok := dialogs[esn]; ok {
+		cur.Pending = nil
+	}
+	return *d.Pending, true
+}
+
+// follow-ups are short. something longer which starts with "and" (or "e", "y" and "a", which are easy to hear
+// at the start of anything) is a new request
+const maxFollowUpWords = 4
+
+// true if the text is a follow-up to the last intent ("and tomorrow?", "what about in paris?")
+func isFollowUp(voiceText string) bool {
+	text := strings.ToLower(strings.TrimSpace(voiceText))
+	words := tokenize(text)
+	for _, key := range []string{lcztn.STR_FOLLOWUP_WHAT_ABOUT, lcztn.STR_FOLLOWUP_HOW_ABOUT, lcztn.STR_FOLLOWUP_AND} {
+		phrase := lcztn.GetText(key)
+		if phrase == "" {
+			continue
+		}
+		if strings.IndexFunc(phrase, func(r rune) bool { return unicode.Is(unicode.Han, r) }) != -1 {
+			if hanFollowUp(text, phrase) {
+				return true
+			}
+			continue
+		}
+		// whole words, so "and" isn't the start of "android"
+		phraseWords := tokenize(phrase)
+		rest := len(words) - len(phraseWords)
+		if rest < 1 || rest > maxFollowUpWords {
+			continue
+		}
+		if strings.Join(words[:len(phraseWords)], " ") == strings.Join(phraseWords, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+// Chinese has no spaces between words, so the phrase is found as it is. a space in it is where the new part goes:
+// "那么 呢" is "那么明天呢"
+func hanFollowUp(text string, phrase string) bool {
+	text = strings.Join(tokenize(text), "")
+	parts := strings.Fields(phrase)
+	rest, ok := strings.CutPrefix(text, parts[0])
+	if !ok {
+		return false
+	}
+	for _, part := range parts[1:] {
+		i := strings.LastIndex(rest, part)
+		if i == -1 {
+			return false
+		}
+		rest = rest[:i] + rest[i+len(part):]
+	}
+	return rest != ""
+}
+
+// routes the answer to a question back to whoever asked it
+func answerPendingSlot(req interface{}, pending PendingSlot, voiceText string, botSerial string) bool {
+	logger.Println("Bot " + botSerial + " answer for " + pending.Slot + " goes to " + pending.Handler)
+	switch pending.Kind {
+	case handlerPlugin:
+		if p, ok := pluginByName(pending.Handler); ok {
+			return runPlugin(req, p, voiceText, botSerial, pending.Slot)
+		}
+	case handlerCustomIntent:
+		for _, c := range vars.CustomIntents() {
+			if c.Name == pending.Handler {
+				return runCustomIntent(req, c, voiceText, botSerial, pending.Slot)
+			}
+		}
+	}
+	logger.Println("Bot " + botSerial + " " + pending.Handler + " is gone, treating the answer as a new request")
+	return false
+}
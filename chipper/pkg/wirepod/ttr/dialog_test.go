@@ -0,0 +1,45 @@
+package wirepod_ttr
+
+import (
+	"testing"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+func TestIsFollowUp(t *testing.T) {
+	language := vars.APIConfig.STT.Language
+	t.Cleanup(func() { vars.APIConfig.STT.Language = language })
+	tests := []struct {
+		language string
+		text     string
+		want     bool
+	}{
+		{"en-US", "and tomorrow", true},
+		{"en-US", "And tomorrow?", true},
+		{"en-US", "what about in paris", true},
+		{"en-US", "how about, new york", true},
+		{"en-US", "and", false},
+		{"en-US", "android", false},
+		{"en-US", "what about", false},
+		{"en-US", "whatabout paris", false},
+		{"en-US", "and then set a timer for ten minutes", false},
+		{"es-ES", "y mañana", true},
+		{"es-ES", "ya es hora", false},
+		{"es-ES", "y luego pon un temporizador de diez minutos", false},
+		{"it-IT", "e domani", true},
+		{"it-IT", "ehi vector", false},
+		{"pl-PL", "a jutro", true},
+		{"pl-PL", "ale jutro", false},
+		{"zh-CN", "那明天呢", true},
+		{"zh-CN", "那么北京？", true},
+		{"zh-CN", "那么 上海 呢", true},
+		{"zh-CN", "那", false},
+		{"zh-CN", "明天那", false},
+	}
+	for _, test := range tests {
+		vars.APIConfig.STT.Language = test.language
+		if got := isFollowUp(test.text); got != test.want {
+			t.Errorf("%s %q: got %v, want %v", test.language, test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package wirepod_ttr
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// scores every intent against the transcribed text, rather than taking the first keyphrase which is contained in it.
+//
+// a keyphrase found in the text scores at least substringScore, more if it covers more of the text
+// and if it sits on word boundaries. so "set your eye color to" beats "change". being on word boundaries counts for less
+// the less of the text it covers, so "stop" matches "please stop now" but not "tell me about the bus stop down the road".
+// one in the middle of a word has to cover enough of the text too: "plor" matches "explore"
+// (a lot of the keyphrases are there to catch STT mistakes), but not "can you go and explore for a bit".
+// a keyphrase which isn't in the text as-is can still score through its words, weighted by how rare
+// each word is among all the keyphrases ("weather for tomorrow" has every word of "weather tomorrow").
+
+// DefaultMatchThreshold is used when APIConfig.STT.MatchThreshold is 0. it's above substringScore, or a keyphrase
+// found anywhere would always match. a keyphrase on word boundaries clears it once it covers a seventh of the text
+const DefaultMatchThreshold = 0.5
+
+// how many candidates get logged
+const logCandidatesNum = 3
+
+const substringScore = 0.4
+
+// the most a keyphrase can score when its words are all there, but not next to each other
+const wordsScore = 0.6
+
+// what being on word boundaries adds to a keyphrase which covers at least boundaryCoverage of the text.
+// one which covers less gets that much less of it
+const (
+	boundaryScore    = 0.2
+	boundaryCoverage = 0.5
+)
+
+var (
+	idfMu      sync.Mutex
+	idfIntents []vars.JsonIntent
+	idfTable   map[string]float64
+)
+
+// IntentCandidate is an intent with its best-scoring keyphrase
+type IntentCandidate struct {
+	Intent    string
+	Keyphrase string
+	Score     float64
+	Exact     bool
+}
+
+func matchThreshold() float64 {
+	if vars.APIConfig.STT.MatchThreshold > 0 {
+		return vars.APIConfig.STT.MatchThreshold
+	}
+	return DefaultMatchThreshold
+}
+
+func isWordRune(r rune) bool {
+	// no spaces between words in Chinese, so every character counts as its own word
+	return (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'') && !unicode.Is(unicode.Han, r)
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// true if the match at text[start:end] does not cut a word in half
+func onWordBoundary(text string, start int, end int) bool {
+	if start > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(text[:start])
+		first, _ := utf8.DecodeRuneInString(text[start:end])
+		if isWordRune(prev) && isWordRune(first) {
+			return false
+		}
+	}
+	if end < len(text) {
+		next, _ := utf8.DecodeRuneInString(text[end:])
+		last, _ := utf8.DecodeLastRuneInString(text[start:end])
+		if isWordRune(next) && isWordRune(last) {
+			return false
+		}
+	}
+	return true
+}
+
+// inverse document frequency of each word, treating every keyphrase as a document
+func keyphraseIDF(intents []vars.JsonIntent) map[string]float64 {
+	df := make(map[string]int)
+	var docs int
+	for _, intent := range intents {
+		for _, kp := range intent.Keyphrases {
+			docs++
+			seen := make(map[string]bool)
+			for _, tok := range tokenize(strings.ToLower(kp)) {
+				if !seen[tok] {
+					df[tok]++
+					seen[tok] = true
+				}
+			}
+		}
+	}
+	idf := make(map[string]float64)
+	for tok, n := range df {
+		idf[tok] = math.Log(1 + float64(docs)/float64(n))
+	}
+	return idf
+}
+
+// the keyphrase IDF table for the intents. the intent data is never changed in place, only swapped (vars.SetIntents),
+// so it's only worked out again when the intents are another list
+func intentsIDF(intents []vars.JsonIntent) map[string]float64 {
+	idfMu.Lock()
+	defer idfMu.Unlock()
+	same := idfTable != nil && len(intents) == len(idfIntents) && (len(intents) == 0 || &intents[0] == &idfIntents[0])
+	if !same {
+		idfTable = keyphraseIDF(intents)
+		idfIntents = intents
+	}
+	return idfTable
+}
+
+func scoreKeyphrase(text string, textTokens map[string]bool, kp string, idf map[string]float64) float64 {
+	if kp == "" {
+		return 0
+	}
+	if text == kp {
+		return 1
+	}
+	textLen := len([]rune(text))
+	if idx := strings.Index(text, kp); idx != -1 {
+		coverage := float64(len([]rune(kp))) / float64(textLen)
+		score := substringScore + 0.3*coverage
+		// a later occurrence might be on a word boundary even if the first isn't
+		for off := 0; idx != -1; {
+			start := off + idx
+			if onWordBoundary(text, start, start+len(kp)) {
+				score += boundaryScore * math.Min(coverage/boundaryCoverage, 1)
+				break
+			}
+			off = start + 1
+			if off >= len(text) {
+				break
+			}
+			idx = strings.Index(text[off:], kp)
+		}
+		return math.Min(score, 0.99)
+	}
+	// not there as a whole. see how much of it is there word by word
+	var total, found float64
+	for _, tok := range tokenize(kp) {
+		w := idf[tok]
+		if w == 0 {
+			w = 1
+		}
+		total += w
+		if textTokens[tok] {
+			found += w
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return (found / total) * wordsScore
+}
+
+// ScoreIntents returns every intent which scored above zero, best first
+func ScoreIntents(voiceText string, intents []vars.JsonIntent) []IntentCandidate {
+	text := normalizeText(voiceText)
+	textTokens := make(map[string]bool)
+	for _, tok := range tokenize(text) {
+		textTokens[tok] = true
+	}
+	idf := intentsIDF(intents)
+	var candidates []IntentCandidate
+	for _, intent := range intents {
+		best := IntentCandidate{Intent: intent.Name}
+		for _, c := range intent.Keyphrases {
+			kp := normalizeText(c)
+			var score float64
+			if intent.RequireExactMatch {
+				if text == kp {
+					score = 1
+				}
+			} else {
+				score = scoreKeyphrase(text, textTokens, kp, idf)
+			}
+			if score > best.Score {
+				best.Score = score
+				best.Keyphrase = kp
+				best.Exact = score == 1
+			}
+		}
+		if best.Score > 0 {
+			candidates = append(candidates, best)
+		}
+	}
+	// ties go to whichever comes first in the intent list
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].Score > candidates[b].Score
+	})
+	return candidates
+}
+
+// MatchIntent returns the best intent if it clears the threshold
+func MatchIntent(voiceText string, intents []vars.JsonIntent, botSerial string) (IntentCandidate, bool) {
+	candidates := ScoreIntents(voiceText, intents)
+	if len(candidates) == 0 {
+		logger.Println("Bot " + botSerial + " No intent candidates")
+		return IntentCandidate{}, false
+	}
+	var logStr []string
+	for i, c := range candidates {
+		if i >= logCandidatesNum {
+			break
+		}
+		logStr = append(logStr, c.Intent+" ("+strconv.FormatFloat(c.Score, 'f', 2, 64)+", '"+c.Keyphrase+"')")
+	}
+	logger.Println("Bot " + botSerial + " Intent candidates: " + strings.Join(logStr, ", "))
+	threshold := matchThreshold()
+	if candidates[0].Score < threshold {
+		logger.Println("Bot " + botSerial + " Best intent candidate is below the threshold of " + strconv.FormatFloat(threshold, 'f', 2, 64))
+		return candidates[0], false
+	}
+	return candidates[0], true
+}
+
+// EarlyMatch is true if a partial transcript is already a whole command, so the robot can stop listening
+// without waiting for the end of speech. only an exact keyphrase or custom intent utterance counts, and only if
+// nothing could want more words: it isn't the first words of a longer phrase, and the robot didn't just ask a question
+func EarlyMatch(esn string, text string) bool {
+	text = normalizeText(text)
+	if text == "" {
+		return false
+	}
+	if d, ok := GetDialog(esn); ok && d.Pending != nil {
+		return false
+	}
+	var exact bool
+	var phrases []string
+	for _, intent := range vars.Intents() {
+		for _, kp := range intent.Keyphrases {
+			phrases = append(phrases, normalizeText(kp))
+		}
+	}
+	for _, c := range vars.CustomIntents() {
+		for _, u := range c.Utterances {
+			u = normalizeText(u)
+			// a system intent which takes anything wants all of it
+			if c.IsSystemIntent && strings.HasPrefix(u, "*") {
+				return false
+			}
+			phrases = append(phrases, u)
+		}
+	}
+	for _, p := range Plugins() {
+		for _, u := range *p.Utterances {
+			phrases = append(phrases, normalizeText(u))
+		}
+	}
+	for _, phrase := range phrases {
+		if phrase == text {
+			exact = true
+		} else if strings.HasPrefix(phrase, text) && onWordBoundary(phrase, 0, len(text)) {
+			// more words would make it another phrase. Chinese has no spaces, so there any longer phrase counts
+			return false
+		}
+	}
+	return exact
+}
@@ -0,0 +1,165 @@
+package wirepod_ttr
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+func TestScoreIntents(t *testing.T) {
+	intents := []vars.JsonIntent{
+		{Name: "intent_imperative_quiet", Keyphrases: []string{"stop", "be quiet"}},
+		{Name: "intent_global_stop_extend", Keyphrases: []string{"stop the timer"}},
+		{Name: "intent_greeting_hello", Keyphrases: []string{"hello"}},
+		{Name: "intent_imperative_hello", Keyphrases: []string{"hello"}},
+		{Name: "intent_play_explore", Keyphrases: []string{"plor"}},
+		{Name: "intent_names_ask", Keyphrases: []string{"who am i"}, RequireExactMatch: true},
+	}
+	tests := []struct {
+		text   string
+		intent string
+		score  float64
+		exact  bool
+	}{
+		{"Stop the  timer", "intent_global_stop_extend", 1, true},
+		// on word boundaries: substringScore + 0.3 * 4/15, and boundaryScore scaled by 4/15 of boundaryCoverage
+		{"please stop now", "intent_imperative_quiet", 0.4 + 0.3*4.0/15 + 0.2*(4.0/15)/0.5, false},
+		// in the middle of a word: substringScore + 0.3 * 4/7
+		{"explore", "intent_play_explore", 0.4 + 0.3*4.0/7, false},
+		// the same keyphrase, so the first intent in the list wins
+		{"hello", "intent_greeting_hello", 1, true},
+		{"who am i", "intent_names_ask", 1, true},
+		// an exact match only intent doesn't score at all on anything else
+		{"who am i really", "", 0, false},
+	}
+	for _, test := range tests {
+		candidates := ScoreIntents(test.text, intents)
+		var got IntentCandidate
+		if len(candidates) > 0 {
+			got = candidates[0]
+		}
+		if got.Intent != test.intent || math.Abs(got.Score-test.score) > 1e-9 || got.Exact != test.exact {
+			t.Errorf("%q: got %+v, want %s %.3f (exact %v)", test.text, got, test.intent, test.score, test.exact)
+		}
+		for i := 1; i < len(candidates); i++ {
+			if candidates[i].Score > candidates[i-1].Score {
+				t.Errorf("%q: candidates aren't best first: %+v", test.text, candidates)
+			}
+		}
+	}
+}
+
+func TestMatchIntent(t *testing.T) {
+	threshold := vars.APIConfig.STT.MatchThreshold
+	t.Cleanup(func() { vars.APIConfig.STT.MatchThreshold = threshold })
+	intents := []vars.JsonIntent{
+		{Name: "intent_weather_extend", Keyphrases: []string{"weather tomorrow"}},
+		{Name: "intent_play_explore", Keyphrases: []string{"plor"}},
+		{Name: "intent_imperative_quiet", Keyphrases: []string{"stop"}},
+	}
+	tests := []struct {
+		threshold float64
+		text      string
+		intent    string
+		ok        bool
+	}{
+		// half of "weather tomorrow" by its words: 0.5 * wordsScore
+		{0.3, "the weather", "intent_weather_extend", true},
+		{0.31, "the weather", "intent_weather_extend", false},
+		{0, "the weather", "intent_weather_extend", false},
+		// all of the words, not next to each other
+		{0, "weather for tomorrow", "intent_weather_extend", true},
+		// a keyphrase in the middle of a word needs to cover enough of the text
+		{0, "explore", "intent_play_explore", true},
+		{0, "can you go and explore for a bit", "intent_play_explore", false},
+		// one on word boundaries has to cover enough of the text too
+		{0, "please stop now", "intent_imperative_quiet", true},
+		{0.6, "please stop now", "intent_imperative_quiet", false},
+		{0, "tell me about the bus stop down the road from my house", "intent_imperative_quiet", false},
+		{1, "stop", "intent_imperative_quiet", true},
+		{0, "dance", "", false},
+	}
+	for _, test := range tests {
+		vars.APIConfig.STT.MatchThreshold = test.threshold
+		got, ok := MatchIntent(test.text, intents, "00e20000")
+		if got.Intent != test.intent || ok != test.ok {
+			t.Errorf("%q at %v: got %s %.3f %v, want %s %v", test.text, test.threshold, got.Intent, got.Score, ok, test.intent, test.ok)
+		}
+	}
+	if substringScore >= DefaultMatchThreshold {
+		t.Error("a keyphrase anywhere in the text always clears the default threshold")
+	}
+}
+
+func TestIntentsIDF(t *testing.T) {
+	intents := []vars.JsonIntent{
+		{Name: "intent_weather_extend", Keyphrases: []string{"weather tomorrow", "weather"}},
+		{Name: "intent_clock_time", Keyphrases: []string{"what time is it"}},
+	}
+	idf := intentsIDF(intents)
+	if idf["weather"] >= idf["tomorrow"] {
+		t.Errorf("weather is in more keyphrases than tomorrow, but weighs as much: %v", idf)
+	}
+	if again := intentsIDF(intents); reflect.ValueOf(again).Pointer() != reflect.ValueOf(idf).Pointer() {
+		t.Error("the IDF table was worked out again for the same intents")
+	}
+	// swapped in intents get their own
+	changed := append([]vars.JsonIntent{}, intents...)
+	changed[1] = vars.JsonIntent{Name: "intent_clock_time", Keyphrases: []string{"weather clock"}}
+	if got := intentsIDF(changed); got["clock"] == 0 || got["weather"] >= idf["weather"] {
+		t.Errorf("the IDF table is for the old intents: %v", got)
+	}
+}
+
+func TestEarlyMatch(t *testing.T) {
+	intents := vars.Intents()
+	t.Cleanup(func() { vars.SetIntents(intents) })
+	vars.SetIntents([]vars.JsonIntent{
+		{Name: "intent_imperative_quiet", Keyphrases: []string{"stop", "be quiet"}},
+		{Name: "intent_global_stop_extend", Keyphrases: []string{"stopped t", "stop the timer"}},
+		{Name: "intent_imperative_volumeup", Keyphrases: []string{"volume up", "louder"}},
+		{Name: "intent_imperative_volumelevel_extend", Keyphrases: []string{"volume"}},
+		{Name: "intent_clock_time", Keyphrases: []string{"what time is it", "几点了"}},
+		{Name: "intent_weather_extend", Keyphrases: []string{"几点", "be"}},
+	})
+	tests := map[string]bool{
+		"Volume Up":  true,
+		" louder ":   true,
+		"be quiet":   true,
+		"volume":     false, // could still be "volume up"
+		"what time":  false,
+		"stop":       false, // could still be "stop the timer"
+		"stop the":   false,
+		"louder now": false,
+		"be":         false,
+		"几点":         false, // could still be "几点了"
+		"几点了":        true,
+		"":           false,
+	}
+	for text, want := range tests {
+		if got := EarlyMatch("00e20000", text); got != want {
+			t.Errorf("%q: got %v, want %v", text, got, want)
+		}
+	}
+
+	// the robot is waiting for an answer
+	askSlot("00e20000", PendingSlot{Kind: handlerPlugin, Handler: "test", Slot: "name"})
+	t.Cleanup(func() { ClearDialog("00e20000") })
+	if EarlyMatch("00e20000", "louder") {
+		t.Error("an answer to a question ended early")
+	}
+	if !EarlyMatch("00e20001", "louder") {
+		t.Error("another robot's question stopped an early match")
+	}
+
+	// a longer phrase only counts if it has more words, not if it's a longer word
+	vars.SetIntents([]vars.JsonIntent{
+		{Name: "intent_imperative_quiet", Keyphrases: []string{"stop"}},
+		{Name: "intent_global_stop_extend", Keyphrases: []string{"stopped t", "stopwatch"}},
+	})
+	if !EarlyMatch("00e20001", "stop") {
+		t.Error("stop didn't end early because of stopped and stopwatch")
+	}
+}
@@ -4,15 +4,16 @@ import (
 	"encoding/json"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fforchino/vector-go-sdk/pkg/vector"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
-	lcztn "github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
 )
 
 // stt
-func ParamChecker(req interface{}, intent string, speechText string, botSerial string) {
+// previous holds the parameters of the last intent if this is a follow-up to it. returns the parameters which were sent
+func ParamChecker(req interface{}, intent string, speechText string, botSerial string, previous map[string]string) map[string]string {
 	var intentParam string
 	var intentParamValue string
 	var newIntent string
@@ -103,43 +104,26 @@ func ParamChecker(req interface{}, intent string, speechText string, botSerial s
 		}
 	}
 	logger.Println("Checking params for candidate intent " + intent)
-	if strings.Contains(intent, "intent_photo_take_extend") {
-		isParam = true
-		newIntent = intent
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_ME)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_SELF)) {
-			intentParam = "entity_photo_selfie"
-			intentParamValue = "photo_selfie"
-		} else {
-			intentParam = "entity_photo_selfie"
-			intentParamValue = ""
-		}
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_imperative_eyecolor") {
-		isParam = true
-		newIntent = "intent_imperative_eyecolor_specific_extend"
-		intentParam = "eye_color"
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_PURPLE)) {
-			intentParamValue = "COLOR_PURPLE"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_BLUE)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_SAPPHIRE)) {
-			intentParamValue = "COLOR_BLUE"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_YELLOW)) {
-			intentParamValue = "COLOR_YELLOW"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_TEAL)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_TEAL2)) {
-			intentParamValue = "COLOR_TEAL"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_GREEN)) {
-			intentParamValue = "COLOR_GREEN"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_ORANGE)) {
-			intentParamValue = "COLOR_ORANGE"
-		} else {
-			newIntent = intent
-			intentParamValue = ""
-			isParam = false
+	if strings.Contains(intent, "intent_names_username_extend") && vars.VoskGrammerEnable {
+		if bot, matched := vars.BotInfo.Get(botSerial); matched {
+			vec, err := vector.New(vector.WithSerialNo(botSerial), vector.WithToken(bot.GUID), vector.WithTarget(bot.IPAddress+":443"))
+			if err != nil {
+				logger.Println("error connecting to vector:", err)
+			} else {
+				sayText(vec, "You must add a face in the web interface. It cannot be done via voice by default.")
+			}
 		}
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_weather_extend") {
+		logger.Println("You must add a face via the web interface (Bot Settings -> Connect -> Faces).")
+		logger.LogUI("You must add a face via the web interface (Bot Settings -> Connect -> Faces).")
+	}
+	if strings.Contains(intent, "intent_weather_extend") {
 		isParam = true
 		newIntent = intent
-		condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit := weatherParser(speechText, botLocation, botUnits)
+		if previous["speakable_location_string"] != "" && !mentionsLocation(speechText) {
+			// "and tomorrow?" is about the same place
+			botLocation = previous["speakable_location_string"]
+		}
+		condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit := weatherParser(speechText, botLocation, botUnits, RobotTimeZone(botSerial))
 		if local_datetime == "test" {
 			newIntent = "intent_system_unmatched"
 			isParam = false
@@ -147,142 +131,13 @@ func ParamChecker(req interface{}, intent string, speechText string, botSerial s
 		} else {
 			intentParams = map[string]string{"condition": condition, "is_forecast": is_forecast, "local_datetime": local_datetime, "speakable_location_string": speakable_location_string, "temperature": temperature, "temperature_unit": temperature_unit}
 		}
-	} else if strings.Contains(intent, "intent_imperative_volumelevel_extend") {
-		isParam = true
-		newIntent = intent
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_MEDIUM_LOW)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_2"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_LOW)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_QUIET)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_1"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_MEDIUM_HIGH)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_4"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_MEDIUM)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_NORMAL)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_REGULAR)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_3"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_HIGH)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_LOUD)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_5"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_MUTE)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_NOTHING)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_SILENT)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_OFF)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_ZERO)) {
-			// there is no VOLUME_0 :(
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_1"
-		} else {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_1"
-		}
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_names_username_extend") {
-		if vars.VoskGrammerEnable {
-			var guid string
-			var target string
-			matched := false
-			for _, bot := range vars.BotInfo.Robots {
-				if botSerial == bot.Esn {
-					guid = bot.GUID
-					target = bot.IPAddress + ":443"
-					matched = true
-					break
-				}
-			}
-			if matched {
-				vec, err := vector.New(vector.WithSerialNo(botSerial), vector.WithToken(guid), vector.WithTarget(target))
-				if err != nil {
-					logger.Println("error connecting to vector:", err)
-				} else {
-					sayText(vec, "You must add a face in the web interface. It cannot be done via voice by default.")
-				}
-			}
-			logger.Println("You must add a face via the web interface (Bot Settings -> Connect -> Faces).")
-			logger.LogUI("You must add a face via the web interface (Bot Settings -> Connect -> Faces).")
-		}
-		var username string
-		var nameSplitter string = ""
-		isParam = true
-		newIntent = intent
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_NAME_IS)) {
-			nameSplitter = lcztn.GetText(lcztn.STR_NAME_IS)
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_NAME_IS2)) {
-			nameSplitter = lcztn.GetText(lcztn.STR_NAME_IS2)
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_NAME_IS3)) {
-			nameSplitter = lcztn.GetText(lcztn.STR_NAME_IS3)
-		}
-		if nameSplitter != "" {
-			splitPhrase := strings.SplitAfter(speechText, nameSplitter)
-			username = strings.TrimSpace(splitPhrase[1])
-			if len(splitPhrase) == 3 {
-				username = username + " " + strings.TrimSpace(splitPhrase[2])
-			} else if len(splitPhrase) == 4 {
-				username = username + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			} else if len(splitPhrase) > 4 {
-				username = username + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			}
-			logger.Println("Name parsed from speech: " + "`" + username + "`")
-			intentParam = "username"
-			intentParamValue = username
-			intentParams = map[string]string{intentParam: intentParamValue}
-		} else {
-			logger.Println("No name parsed from speech")
-			intentParam = "username"
-			intentParamValue = ""
-			intentParams = map[string]string{intentParam: intentParamValue}
-		}
-	} else if strings.Contains(intent, "intent_clock_settimer_extend") {
-		isParam = true
-		newIntent = intent
-		timerSecs := words2num(speechText)
-		logger.Println("Seconds parsed from speech: " + timerSecs)
-		intentParam = "timer_duration"
-		intentParamValue = timerSecs
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_global_stop_extend") {
-		isParam = true
-		newIntent = intent
-		intentParam = "what_to_stop"
-		intentParamValue = "timer"
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_message_playmessage_extend") {
-		var given_name string
-		isParam = true
-		newIntent = intent
-		intentParam = "given_name"
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_FOR)) {
-			splitPhrase := strings.SplitAfter(speechText, lcztn.GetText(lcztn.STR_FOR))
-			given_name = strings.TrimSpace(splitPhrase[1])
-			if len(splitPhrase) == 3 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2])
-			} else if len(splitPhrase) == 4 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			} else if len(splitPhrase) > 4 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			}
-			intentParamValue = given_name
-		} else {
-			intentParamValue = ""
-		}
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_message_recordmessage_extend") {
-		var given_name string
-		isParam = true
+	} else if schema, hasSlots := intentSchema(intent); hasSlots {
+		// slots are defined in intent-data/<lang>.json
 		newIntent = intent
-		intentParam = "given_name"
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_FOR)) {
-			splitPhrase := strings.SplitAfter(speechText, lcztn.GetText(lcztn.STR_FOR))
-			given_name = strings.TrimSpace(splitPhrase[1])
-			if len(splitPhrase) == 3 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2])
-			} else if len(splitPhrase) == 4 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			} else if len(splitPhrase) > 4 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			}
-			intentParamValue = given_name
-		} else {
-			intentParamValue = ""
+		intentParams, isParam = FillSlots(schema, speechText, previous)
+		if isParam && schema.SendAs != "" {
+			newIntent = schema.SendAs
 		}
-		intentParams = map[string]string{intentParam: intentParamValue}
 	} else {
 		if intentParam == "" {
 			newIntent = intent
@@ -314,7 +169,7 @@ func ParamChecker(req interface{}, intent string, speechText string, botSerial s
 		}
 	}
 	IntentPass(req, newIntent, speechText, intentParams, isParam)
-	if DoWeatherError {
+	if DoWeatherError && !isDryRun(req) {
 		if vars.APIConfig.Weather.Enable {
 			logger.Println("The weather API is not configured properly.")
 			KGSim(botSerial, "The weather API is not configured properly. Please check the wire pod logs for more details.")
@@ -323,6 +178,7 @@ func ParamChecker(req interface{}, intent string, speechText string, botSerial s
 			KGSim(botSerial, "The weather API is not configured.")
 		}
 	}
+	return intentParams
 }
 
 // stintent
@@ -462,7 +318,7 @@ func ParamCheckerSlotsEnUS(req interface{}, intent string, slots map[string]stri
 	} else if strings.Contains(intent, "intent_weather_extend") {
 		isParam = true
 		newIntent = intent
-		condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit := weatherParser("what's the weather", botLocation, botUnits)
+		condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit := weatherParser("what's the weather", botLocation, botUnits, RobotTimeZone(botSerial))
 		intentParams = map[string]string{"condition": condition, "is_forecast": is_forecast, "local_datetime": local_datetime, "speakable_location_string": speakable_location_string, "temperature": temperature, "temperature_unit": temperature_unit}
 	} else {
 		if intentParam == "" {
@@ -515,7 +371,16 @@ func ParamCheckerSlotsEnUS(req interface{}, intent string, slots map[string]stri
 	IntentPass(req, newIntent, intent, intentParams, isParam)
 }
 
-func prehistoricParamChecker(req interface{}, intent string, speechText string) {
+// 0.10 builds don't know the _extend versions of these
+var prehistoricIntentNames = map[string]string{
+	"intent_names_username_extend":        "intent_names_username",
+	"intent_clock_settimer_extend":        "intent_clock_settimer",
+	"intent_global_stop_extend":           "intent_global_stop",
+	"intent_message_playmessage_extend":   "intent_message_playmessage",
+	"intent_message_recordmessage_extend": "intent_message_recordmessage",
+}
+
+func prehistoricParamChecker(req interface{}, intent string, speechText string, previous map[string]string) map[string]string {
 	// intent.go detects if the stream uses opus or PCM.
 	// If the stream is PCM, it is likely a bot with 0.10.
 	// This accounts for the newer 0.10.1### builds.
@@ -526,158 +391,26 @@ func prehistoricParamChecker(req interface{}, intent string, speechText string)
 	var intentParams map[string]string
 	var botLocation string = "San Francisco"
 	var botUnits string = "F"
-	if strings.Contains(intent, "intent_photo_take_extend") {
+	if strings.Contains(intent, "intent_weather_extend") {
 		isParam = true
 		newIntent = intent
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_ME)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_SELF)) {
-			intentParam = "entity_photo_selfie"
-			intentParamValue = "photo_selfie"
-		} else {
-			intentParam = "entity_photo_selfie"
-			intentParamValue = ""
-		}
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_imperative_eyecolor") {
-		// leaving stuff like this in case someone wants to add features like this to older software
-		isParam = true
-		newIntent = "intent_imperative_eyecolor_specific_extend"
-		intentParam = "eye_color"
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_PURPLE)) {
-			intentParamValue = "COLOR_PURPLE"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_BLUE)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_SAPPHIRE)) {
-			intentParamValue = "COLOR_BLUE"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_YELLOW)) {
-			intentParamValue = "COLOR_YELLOW"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_TEAL)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_TEAL2)) {
-			intentParamValue = "COLOR_TEAL"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_GREEN)) {
-			intentParamValue = "COLOR_GREEN"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_EYE_COLOR_ORANGE)) {
-			intentParamValue = "COLOR_ORANGE"
-		} else {
-			newIntent = intent
-			intentParamValue = ""
-			isParam = false
+		if previous["speakable_location_string"] != "" && !mentionsLocation(speechText) {
+			// "and tomorrow?" is about the same place
+			botLocation = previous["speakable_location_string"]
 		}
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_weather_extend") {
-		isParam = true
-		newIntent = intent
-		condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit := weatherParser(speechText, botLocation, botUnits)
+		condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit := weatherParser(speechText, botLocation, botUnits, time.Local) // which robot it is isn't known here
 		intentParams = map[string]string{"condition": condition, "is_forecast": is_forecast, "local_datetime": local_datetime, "speakable_location_string": speakable_location_string, "temperature": temperature, "temperature_unit": temperature_unit}
-	} else if strings.Contains(intent, "intent_imperative_volumelevel_extend") {
-		isParam = true
+	} else if schema, hasSlots := intentSchema(intent); hasSlots {
 		newIntent = intent
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_MEDIUM_LOW)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_2"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_LOW)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_QUIET)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_1"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_MEDIUM_HIGH)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_4"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_MEDIUM)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_NORMAL)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_REGULAR)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_3"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_HIGH)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_LOUD)) {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_5"
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_MUTE)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_NOTHING)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_SILENT)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_OFF)) || strings.Contains(speechText, lcztn.GetText(lcztn.STR_VOLUME_ZERO)) {
-			// there is no VOLUME_0 :(
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_1"
-		} else {
-			intentParam = "volume_level"
-			intentParamValue = "VOLUME_1"
-		}
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_names_username_extend") {
-		var username string
-		var nameSplitter string = ""
-		isParam = true
-		newIntent = "intent_names_username"
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_NAME_IS)) {
-			nameSplitter = lcztn.GetText(lcztn.STR_NAME_IS)
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_NAME_IS2)) {
-			nameSplitter = lcztn.GetText(lcztn.STR_NAME_IS2)
-		} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_NAME_IS3)) {
-			nameSplitter = lcztn.GetText(lcztn.STR_NAME_IS3)
-		}
-		if nameSplitter != "" {
-			splitPhrase := strings.SplitAfter(speechText, nameSplitter)
-			username = strings.TrimSpace(splitPhrase[1])
-			if len(splitPhrase) == 3 {
-				username = username + " " + strings.TrimSpace(splitPhrase[2])
-			} else if len(splitPhrase) == 4 {
-				username = username + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			} else if len(splitPhrase) > 4 {
-				username = username + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			}
-			logger.Println("Name parsed from speech: " + "`" + username + "`")
-			intentParam = "username"
-			intentParamValue = username
-			intentParams = map[string]string{intentParam: intentParamValue}
-		} else {
-			logger.Println("No name parsed from speech")
-			intentParam = "username"
-			intentParamValue = ""
-			intentParams = map[string]string{intentParam: intentParamValue}
-		}
-	} else if strings.Contains(intent, "intent_clock_settimer_extend") {
-		isParam = true
-		newIntent = "intent_clock_settimer"
-		timerSecs := words2num(speechText)
-		logger.Println("Seconds parsed from speech: " + timerSecs)
-		intentParam = "timer_duration"
-		intentParamValue = timerSecs
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_global_stop_extend") {
-		isParam = true
-		newIntent = "intent_global_stop"
-		intentParam = "what_to_stop"
-		intentParamValue = "timer"
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_message_playmessage_extend") {
-		var given_name string
-		isParam = true
-		newIntent = "intent_message_playmessage"
-		intentParam = "given_name"
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_FOR)) {
-			splitPhrase := strings.SplitAfter(speechText, lcztn.GetText(lcztn.STR_FOR))
-			given_name = strings.TrimSpace(splitPhrase[1])
-			if len(splitPhrase) == 3 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2])
-			} else if len(splitPhrase) == 4 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			} else if len(splitPhrase) > 4 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
+		intentParams, isParam = FillSlots(schema, speechText, previous)
+		if isParam {
+			if schema.SendAs != "" {
+				newIntent = schema.SendAs
 			}
-			intentParamValue = given_name
-		} else {
-			intentParamValue = ""
-		}
-		intentParams = map[string]string{intentParam: intentParamValue}
-	} else if strings.Contains(intent, "intent_message_recordmessage_extend") {
-		var given_name string
-		isParam = true
-		newIntent = "intent_message_recordmessage"
-		intentParam = "given_name"
-		if strings.Contains(speechText, lcztn.GetText(lcztn.STR_FOR)) {
-			splitPhrase := strings.SplitAfter(speechText, lcztn.GetText(lcztn.STR_FOR))
-			given_name = strings.TrimSpace(splitPhrase[1])
-			if len(splitPhrase) == 3 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2])
-			} else if len(splitPhrase) == 4 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
-			} else if len(splitPhrase) > 4 {
-				given_name = given_name + " " + strings.TrimSpace(splitPhrase[2]) + " " + strings.TrimSpace(splitPhrase[3])
+			if name, ok := prehistoricIntentNames[newIntent]; ok {
+				newIntent = name
 			}
-			intentParamValue = given_name
-		} else {
-			intentParamValue = ""
 		}
-		intentParams = map[string]string{intentParam: intentParamValue}
 	} else if strings.Contains(intent, "intent_play_blackjack") {
 		isParam = true
 		newIntent = "intent_play_specific_extend"
@@ -716,4 +449,5 @@ func prehistoricParamChecker(req interface{}, intent string, speechText string)
 		intentParams = map[string]string{intentParam: intentParamValue}
 	}
 	IntentPass(req, newIntent, speechText, intentParams, isParam)
+	return intentParams
 }
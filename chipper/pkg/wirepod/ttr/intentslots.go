@@ -0,0 +1,164 @@
+package wirepod_ttr
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	lcztn "github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
+)
+
+// fills intent parameters from the slots an intent has in intent-data/<lang>.json,
+// so adding a parameterized intent is a change to the json rather than to this package.
+//
+//	enum     - the value of the first entry in "values" which has one of its phrases in the text
+//	number   - the first number in the text, as digits or words
+//	duration - seconds, from something like "five minutes and ten seconds"
+//	text     - whatever comes after the first of the "after" phrases which is in the text
+//	const    - always "default"
+//
+// phrases which are localization keys (str_...) get translated to the configured language, anything else is used as-is.
+
+const (
+	SlotEnum     = "enum"
+	SlotNumber   = "number"
+	SlotDuration = "duration"
+	SlotText     = "text"
+	SlotConst    = "const"
+)
+
+type slotExtractor func(slot vars.IntentSlot, speechText string) (string, bool)
+
+var slotExtractors = map[string]slotExtractor{
+	SlotEnum:     extractEnum,
+	SlotNumber:   extractNumber,
+	SlotDuration: extractDuration,
+	SlotText:     extractText,
+	SlotConst:    extractConst,
+}
+
+func slotPhrase(phrase string) string {
+	if lcztn.HasText(phrase) {
+		return lcztn.GetText(phrase)
+	}
+	return phrase
+}
+
+func extractEnum(slot vars.IntentSlot, speechText string) (string, bool) {
+	for _, value := range slot.Values {
+		for _, phrase := range value.Phrases {
+			phrase = slotPhrase(phrase)
+			if phrase != "" && strings.Contains(speechText, phrase) {
+				return value.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+func extractNumber(slot vars.IntentSlot, speechText string) (string, bool) {
+	num, found := firstNumber(speechText)
+	if !found {
+		return "", false
+	}
+	return strconv.Itoa(num), true
+}
+
+func extractDuration(slot vars.IntentSlot, speechText string) (string, bool) {
+	secs := words2num(speechText)
+	return secs, secs != "0"
+}
+
+func extractText(slot vars.IntentSlot, speechText string) (string, bool) {
+	for _, phrase := range slot.After {
+		phrase = slotPhrase(phrase)
+		if phrase == "" || !strings.Contains(speechText, phrase) {
+			continue
+		}
+		// keep at most three pieces if the phrase shows up again ("my name is bob and this is ...")
+		splitPhrase := strings.SplitAfter(speechText, phrase)
+		var pieces []string
+		for i := 1; i < len(splitPhrase) && i < 4; i++ {
+			pieces = append(pieces, strings.TrimSpace(splitPhrase[i]))
+		}
+		value := strings.Join(pieces, " ")
+		if slot.MaxWords > 0 {
+			words := strings.Fields(value)
+			if len(words) > slot.MaxWords {
+				value = strings.Join(words[:slot.MaxWords], " ")
+			}
+		}
+		return value, value != ""
+	}
+	return "", false
+}
+
+func extractConst(slot vars.IntentSlot, speechText string) (string, bool) {
+	return slot.Default, true
+}
+
+// ValidateIntents finds problems in intent data before it's used
+func ValidateIntents(intents []vars.JsonIntent) []error {
+	var errs []error
+	for i, intent := range intents {
+		name := intent.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+			errs = append(errs, errors.New("intent "+name+" has no name"))
+		}
+		if len(intent.Keyphrases) == 0 {
+			errs = append(errs, errors.New("intent "+name+" has no keyphrases"))
+		}
+		for _, slot := range intent.Slots {
+			if slot.Name == "" {
+				errs = append(errs, errors.New("intent "+name+" has a slot without a name"))
+			}
+			if _, ok := slotExtractors[slot.Type]; !ok {
+				errs = append(errs, errors.New("intent "+name+" has a slot ("+slot.Name+") with an unknown type: "+slot.Type))
+			}
+			if slot.Type == SlotEnum && len(slot.Values) == 0 {
+				errs = append(errs, errors.New("intent "+name+" has an enum slot ("+slot.Name+") without values"))
+			}
+		}
+	}
+	return errs
+}
+
+// returns the intent from the loaded intent list if it has any slots
+func intentSchema(intent string) (vars.JsonIntent, bool) {
+	for _, jsonIntent := range vars.Intents() {
+		if jsonIntent.Name == intent {
+			return jsonIntent, len(jsonIntent.Slots) > 0
+		}
+	}
+	return vars.JsonIntent{}, false
+}
+
+// FillSlots pulls every slot of the intent out of the spoken text. filled is false if a required slot wasn't found.
+// previous is used for slots the text doesn't mention, for follow-ups ("and tomorrow?"). it can be nil.
+func FillSlots(intent vars.JsonIntent, speechText string, previous map[string]string) (params map[string]string, filled bool) {
+	params = make(map[string]string)
+	for _, slot := range intent.Slots {
+		extract, ok := slotExtractors[slot.Type]
+		if !ok {
+			logger.Println("Intent " + intent.Name + " has a slot (" + slot.Name + ") with an unknown type: " + slot.Type)
+			continue
+		}
+		value, found := extract(slot, speechText)
+		if !found && previous[slot.Name] != "" {
+			value, found = previous[slot.Name], true
+		}
+		if !found {
+			if slot.Required {
+				logger.Println("No " + slot.Name + " parsed from speech")
+				return nil, false
+			}
+			value = slot.Default
+		}
+		logger.Println(slot.Name + " parsed from speech: " + "`" + value + "`")
+		params[slot.Name] = value
+	}
+	return params, true
+}
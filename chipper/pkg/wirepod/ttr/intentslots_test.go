@@ -0,0 +1,47 @@
+package wirepod_ttr
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// the intent data which ships with wire-pod has to pass, or it couldn't be reloaded
+func TestValidateShippedIntents(t *testing.T) {
+	files, err := filepath.Glob("../../../intent-data/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no intent data: %v", err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var intents []vars.JsonIntent
+		if err := json.Unmarshal(data, &intents); err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		for _, err := range ValidateIntents(intents) {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+}
+
+func TestValidateIntents(t *testing.T) {
+	intents := []vars.JsonIntent{
+		{Name: "intent_weather_extend", Keyphrases: []string{"weather"}},
+		{Keyphrases: []string{"hello"}},
+		{Name: "intent_no_keyphrases"},
+		{Name: "intent_bad_slots", Keyphrases: []string{"set"}, Slots: []vars.IntentSlot{
+			{Name: "when", Type: "someday"},
+			{Name: "color", Type: SlotEnum},
+		}},
+	}
+	if errs := ValidateIntents(intents); len(errs) != 4 {
+		t.Errorf("got %v, want 4 errors", errs)
+	}
+}
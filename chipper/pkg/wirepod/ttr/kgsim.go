@@ -4,11 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -18,56 +17,13 @@ import (
 	"github.com/fforchino/vector-go-sdk/pkg/vector"
 	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/metrics"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/history"
 	"github.com/sashabaranov/go-openai"
 )
 
-func GetChat(esn string) vars.RememberedChat {
-	for _, chat := range vars.RememberedChats {
-		if chat.ESN == esn {
-			return chat
-		}
-	}
-	return vars.RememberedChat{
-		ESN: esn,
-	}
-}
-
-func PlaceChat(chat vars.RememberedChat) {
-	for i, achat := range vars.RememberedChats {
-		if achat.ESN == chat.ESN {
-			vars.RememberedChats[i] = chat
-			vars.SaveChats()
-			return
-		}
-	}
-	vars.RememberedChats = append(vars.RememberedChats, chat)
-	vars.SaveChats()
-}
-
-// remember last 16 lines of chat
-func Remember(user, ai openai.ChatCompletionMessage, esn string) {
-	chatAppend := []openai.ChatCompletionMessage{
-		user,
-		ai,
-	}
-	currentChat := GetChat(esn)
-	if len(currentChat.Chats) == 16 {
-		var newChat vars.RememberedChat
-		newChat.ESN = currentChat.ESN
-		for i, chat := range currentChat.Chats {
-			if i < 2 {
-				continue
-			}
-			newChat.Chats = append(newChat.Chats, chat)
-		}
-		currentChat = newChat
-	}
-	currentChat.ESN = esn
-	currentChat.Chats = append(currentChat.Chats, chatAppend...)
-	PlaceChat(currentChat)
-}
-
 func isMn(r rune) bool {
 	return unicode.Is(unicode.Mn, r) // Mn: nonspacing marks
 }
@@ -117,6 +73,14 @@ func removeEmojis(input string) string {
 	return result
 }
 
+// the model set in the config, or GPT-4o for openai
+func knowledgeModel() string {
+	if vars.APIConfig.Knowledge.Provider == "openai" {
+		return openai.GPT4o
+	}
+	return vars.APIConfig.Knowledge.Model
+}
+
 func CreateAIReq(transcribedText, esn string, gpt3tryagain bool) openai.ChatCompletionRequest {
 	defaultPrompt := "You are a helpful, animated robot called Vector. Keep the response concise yet informative."
 
@@ -135,22 +99,26 @@ func CreateAIReq(transcribedText, esn string, gpt3tryagain bool) openai.ChatComp
 
 	if gpt3tryagain {
 		model = openai.GPT3Dot5Turbo
-	} else if vars.APIConfig.Knowledge.Provider == "openai" {
-		model = openai.GPT4o
-		logger.Println("Using " + model)
 	} else {
-		logger.Println("Using " + vars.APIConfig.Knowledge.Model)
-		model = vars.APIConfig.Knowledge.Model
+		model = knowledgeModel()
+		logger.Println("Using " + model)
 	}
 
 	smsg.Content = CreatePrompt(smsg.Content, model)
 
-	nChat = append(nChat, smsg)
+	var remembered []openai.ChatCompletionMessage
 	if vars.APIConfig.Knowledge.SaveChat {
-		rchat := GetChat(esn)
-		logger.Println("Using remembered chats, length of " + fmt.Sprint(len(rchat.Chats)) + " messages")
-		nChat = append(nChat, rchat.Chats...)
+		mem, err := vars.GetMemory(esn)
+		if err != nil {
+			logger.Println("Couldn't get remembered chats: " + err.Error())
+		} else {
+			smsg.Content = smsg.Content + memoryPrompt(mem)
+			remembered = memoryMessages(mem)
+			logger.Println("Using remembered chats, length of " + fmt.Sprint(len(remembered)) + " messages, " + fmt.Sprint(len(mem.Facts)) + " facts")
+		}
 	}
+	nChat = append(nChat, smsg)
+	nChat = append(nChat, remembered...)
 	nChat = append(nChat, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
 		Content: transcribedText,
@@ -169,162 +137,176 @@ func CreateAIReq(transcribedText, esn string, gpt3tryagain bool) openai.ChatComp
 	return aireq
 }
 
+// the session of a vtt request, for the history
+func requestSession(req interface{}) string {
+	switch r := req.(type) {
+	case *vtt.IntentRequest:
+		return r.Session
+	case *vtt.IntentGraphRequest:
+		return r.Session
+	case *vtt.KnowledgeGraphRequest:
+		return r.Session
+	case *vtt.TextRequest:
+		return r.Session
+	}
+	return ""
+}
+
+// if set, StreamingKGSim talks to this instead of the robot. for tests
+var robotOverride *vector.Vector
+
+func connectRobot(esn string) (*vector.Vector, error) {
+	if robotOverride != nil {
+		return robotOverride, nil
+	}
+	bot, ok := vars.BotInfo.Get(esn)
+	if !ok {
+		return nil, errors.New("bot " + esn + " isn't known to wire-pod")
+	}
+	return vector.New(vector.WithSerialNo(esn), vector.WithToken(bot.GUID), vector.WithTarget(bot.IPAddress+":443"))
+}
+
 func StreamingKGSim(req interface{}, esn string, transcribedText string) (string, error) {
-	matched := false
-	var robot *vector.Vector
-	var guid string
-	var target string
-	for _, bot := range vars.BotInfo.Robots {
-		if esn == bot.Esn {
-			guid = bot.GUID
-			target = bot.IPAddress + ":443"
-			matched = true
-			break
-		}
+	robot, err := connectRobot(esn)
+	if err != nil {
+		return err.Error(), err
 	}
-	if matched {
-		var err error
-		robot, err = vector.New(vector.WithSerialNo(esn), vector.WithToken(guid), vector.WithTarget(target))
-		if err != nil {
-			return err.Error(), err
-		}
+	_, err = robot.Conn.BatteryState(context.Background(), &vectorpb.BatteryStateRequest{})
+	if err != nil {
+		return "", err
 	}
-	_, err := robot.Conn.BatteryState(context.Background(), &vectorpb.BatteryStateRequest{})
+	split := &sentenceSplitter{}
+	session := requestSession(req)
+	providerName := vars.APIConfig.Knowledge.Provider
+	llmFailed := func(reason string) {
+		metrics.LLMErrors.Inc(providerName, esn, reason)
+	}
+	provider, err := GetKnowledgeProvider()
 	if err != nil {
+		llmFailed("provider")
 		return "", err
 	}
-	var fullRespText string
-	var fullfullRespText string
-	var fullRespSlice []string
-	var isDone bool
-	var c *openai.Client
-	if vars.APIConfig.Knowledge.Provider == "together" {
-		if vars.APIConfig.Knowledge.Model == "" {
-			vars.APIConfig.Knowledge.Model = "meta-llama/Llama-3-70b-chat-hf"
-			vars.WriteConfigToDisk()
+	ctx := context.Background()
+	// buffered so a sentence (or the end of the stream) isn't missed if nothing is waiting yet
+	speakReady := make(chan string, 1)
+	successIntent := make(chan bool, 1)
+	signalIntent := func(success bool) {
+		select {
+		case successIntent <- success:
+		default:
 		}
-		conf := openai.DefaultConfig(vars.APIConfig.Knowledge.Key)
-		conf.BaseURL = "https://api.together.xyz/v1"
-		c = openai.NewClientWithConfig(conf)
-	} else if vars.APIConfig.Knowledge.Provider == "custom" {
-		conf := openai.DefaultConfig(vars.APIConfig.Knowledge.Key)
-		conf.BaseURL = vars.APIConfig.Knowledge.Endpoint
-		c = openai.NewClientWithConfig(conf)
-	} else if vars.APIConfig.Knowledge.Provider == "openai" {
-		//vars.APIConfig.Knowledge.Key
-		config := openai.DefaultConfig(vars.APIConfig.Knowledge.Key)
-		if v := os.Getenv("OPENAI_BASE"); v != "" {
-			config.BaseURL = v
-		} else {
-			if vars.APIConfig.Knowledge.Endpoint != "" {
-				config.BaseURL = vars.APIConfig.Knowledge.Endpoint
-			}
+	}
+	signalSpeak := func(sentence string) {
+		select {
+		case speakReady <- sentence:
+		default:
 		}
-		c = openai.NewClientWithConfig(config)
 	}
-	ctx := context.Background()
-	speakReady := make(chan string)
-	successIntent := make(chan bool)
 
+	var tools []LLMTool
+	useTools := vars.APIConfig.Knowledge.ToolsEnable
+	if useTools {
+		tools = LLMTools()
+	}
 	aireq := CreateAIReq(transcribedText, esn, false)
+	if useTools {
+		aireq.Tools = openAITools(tools)
+	}
 
-	stream, err := c.CreateChatCompletionStream(ctx, aireq)
+	metrics.LLMRequests.Inc(providerName, esn)
+	llmStart := time.Now()
+	stream, err := provider.CreateChatCompletionStream(ctx, aireq)
 	if err != nil {
 		if strings.Contains(err.Error(), "does not exist") && vars.APIConfig.Knowledge.Provider == "openai" {
 			logger.Println("GPT-4 model cannot be accessed with this API key. You likely need to add more than $5 dollars of funds to your OpenAI account.")
 			logger.LogUI("GPT-4 model cannot be accessed with this API key. You likely need to add more than $5 dollars of funds to your OpenAI account.")
-			aireq := CreateAIReq(transcribedText, esn, true)
+			aireq = CreateAIReq(transcribedText, esn, true)
+			if useTools {
+				aireq.Tools = openAITools(tools)
+			}
 			logger.Println("Falling back to " + aireq.Model)
 			logger.LogUI("Falling back to " + aireq.Model)
-			stream, err = c.CreateChatCompletionStream(ctx, aireq)
+			stream, err = provider.CreateChatCompletionStream(ctx, aireq)
 			if err != nil {
 				logger.Println("OpenAI still not returning a response even after falling back. Erroring.")
+				llmFailed("request")
 				return "", err
 			}
 		} else {
+			llmFailed("request")
 			return "", err
 		}
 	}
-	nChat := aireq.Messages
-	nChat = append(nChat, openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleAssistant,
-	})
-	fmt.Println("LLM stream response: ")
-	go func() {
-		for {
-			response, err := stream.Recv()
-			if errors.Is(err, io.EOF) {
-				// if fullRespSlice != fullRespText, add that missing bit to fullRespSlice
-				if len(fullRespSlice) == 0 {
-					logger.Println("LLM returned no response")
-					successIntent <- false
-					break
-				}
-				isDone = true
-				newStr := fullRespSlice[0]
-				for i, str := range fullRespSlice {
-					if i == 0 {
-						continue
-					}
-					newStr = newStr + " " + str
-				}
-				if strings.TrimSpace(newStr) != strings.TrimSpace(fullfullRespText) {
-					logger.Println("LLM debug: there is content after the last punctuation mark")
-					extraBit := strings.TrimPrefix(fullRespText, newStr)
-					fullRespSlice = append(fullRespSlice, extraBit)
-				}
-				if vars.APIConfig.Knowledge.SaveChat {
-					Remember(openai.ChatCompletionMessage{
-						Role:    openai.ChatMessageRoleUser,
-						Content: transcribedText,
+	var nChat []openai.ChatCompletionMessage
+	// tool calls from the current response, and the text which came with them. set by readStream
+	var toolCalls []openai.ToolCall
+	var roundText string
+	var roundMu sync.Mutex
+	takeToolCalls := func() ([]openai.ToolCall, string) {
+		roundMu.Lock()
+		defer roundMu.Unlock()
+		calls, text := toolCalls, roundText
+		toolCalls, roundText = nil, ""
+		return calls, text
+	}
+	readStream := func(stream KnowledgeStream, began time.Time) {
+		defer stream.Close()
+		resp, err := readLLMResponse(stream, split, func(sentence string) {
+			signalIntent(true)
+			signalSpeak(sentence)
+		})
+		if !resp.FirstToken.IsZero() {
+			metrics.ObserveStage(metrics.StageLLMFirstToken, providerName, "", esn, resp.FirstToken.Sub(began))
+		}
+		if err != nil {
+			llmFailed("stream")
+			logger.Println("Stream error: " + err.Error())
+			split.setDone(true)
+			signalIntent(false)
+			signalSpeak("")
+			return
+		}
+		roundMu.Lock()
+		toolCalls = resp.ToolCalls
+		roundText = resp.Text
+		roundMu.Unlock()
+		if _, extra := split.finish(); extra {
+			logger.Println("LLM debug: there is content after the last punctuation mark")
+		}
+		if sentences, _ := split.snapshot(); len(sentences) == 0 && len(resp.ToolCalls) == 0 {
+			llmFailed("empty")
+			logger.Println("LLM returned no response")
+			split.setDone(true)
+			signalIntent(false)
+			signalSpeak("")
+			return
+		}
+		// if there are tool calls, there will be more once the tools have run.
+		// the response is recorded before the robot is told it's done, which lets StreamingKGSim return
+		if len(resp.ToolCalls) == 0 {
+			newStr := split.text()
+			if vars.APIConfig.Knowledge.SaveChat {
+				Remember(openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleUser,
+					Content: transcribedText,
+				},
+					openai.ChatCompletionMessage{
+						Role:    openai.ChatMessageRoleAssistant,
+						Content: newStr,
 					},
-						openai.ChatCompletionMessage{
-							Role:    openai.ChatMessageRoleAssistant,
-							Content: newStr,
-						},
-						esn)
-				}
-				logger.LogUI("LLM response for " + esn + ": " + newStr)
-				logger.Println("LLM stream finished")
-				return
-			}
-
-			if err != nil {
-				logger.Println("Stream error: " + err.Error())
-				return
-			}
-
-			fullfullRespText = fullfullRespText + removeSpecialCharacters(response.Choices[0].Delta.Content)
-			fullRespText = fullRespText + removeSpecialCharacters(response.Choices[0].Delta.Content)
-			if strings.Contains(fullRespText, "...") || strings.Contains(fullRespText, ".'") || strings.Contains(fullRespText, ".\"") || strings.Contains(fullRespText, ".") || strings.Contains(fullRespText, "?") || strings.Contains(fullRespText, "!") {
-				var sepStr string
-				if strings.Contains(fullRespText, "...") {
-					sepStr = "..."
-				} else if strings.Contains(fullRespText, ".'") {
-					sepStr = ".'"
-				} else if strings.Contains(fullRespText, ".\"") {
-					sepStr = ".\""
-				} else if strings.Contains(fullRespText, ".") {
-					sepStr = "."
-				} else if strings.Contains(fullRespText, "?") {
-					sepStr = "?"
-				} else if strings.Contains(fullRespText, "!") {
-					sepStr = "!"
-				}
-				splitResp := strings.Split(strings.TrimSpace(fullRespText), sepStr)
-				fullRespSlice = append(fullRespSlice, strings.TrimSpace(splitResp[0])+sepStr)
-				fullRespText = splitResp[1]
-				select {
-				case successIntent <- true:
-				default:
-				}
-				select {
-				case speakReady <- strings.TrimSpace(splitResp[0]) + sepStr:
-				default:
-				}
+					esn)
 			}
+			history.SetLLMResponse(session, newStr)
+			logger.LogUI("LLM response for " + esn + ": " + newStr)
+			logger.Println("LLM stream finished")
 		}
-	}()
+		// might only be tool calls so far. the robot still needs to get the intent and behavior control to run them
+		signalIntent(true)
+		split.setDone(true)
+		signalSpeak("")
+	}
+	fmt.Println("LLM stream response: ")
+	go readStream(stream, llmStart)
 	for is := range successIntent {
 		if is {
 			IntentPass(req, "intent_greeting_hello", transcribedText, map[string]string{}, false)
@@ -341,7 +323,8 @@ func StreamingKGSim(req interface{}, esn string, transcribedText string) (string
 			},
 		},
 	}
-	start := make(chan bool)
+	// gets true once the robot gives us behavior control, or is closed if it doesn't
+	start := make(chan bool, 1)
 	stop := make(chan bool)
 
 	go func() {
@@ -351,11 +334,13 @@ func StreamingKGSim(req interface{}, esn string, transcribedText string) (string
 		)
 		if err != nil {
 			logger.Println(err)
+			close(start)
 			return
 		}
 
 		if err := r.Send(controlRequest); err != nil {
 			logger.Println(err)
+			close(start)
 			return
 		}
 
@@ -363,6 +348,7 @@ func StreamingKGSim(req interface{}, esn string, transcribedText string) (string
 			ctrlresp, err := r.Recv()
 			if err != nil {
 				logger.Println(err)
+				close(start)
 				return
 			}
 			if ctrlresp.GetControlGrantedResponse() != nil {
@@ -371,31 +357,25 @@ func StreamingKGSim(req interface{}, esn string, transcribedText string) (string
 			}
 		}
 
-		for {
-			select {
-			case <-stop:
-				logger.Println("KGSim: releasing behavior control (interrupt)")
-				if err := r.Send(
-					&vectorpb.BehaviorControlRequest{
-						RequestType: &vectorpb.BehaviorControlRequest_ControlRelease{
-							ControlRelease: &vectorpb.ControlRelease{},
-						},
-					},
-				); err != nil {
-					logger.Println(err)
-					return
-				}
-				return
-			default:
-				continue
-			}
+		<-stop
+		logger.Println("KGSim: releasing behavior control (interrupt)")
+		if err := r.Send(
+			&vectorpb.BehaviorControlRequest{
+				RequestType: &vectorpb.BehaviorControlRequest_ControlRelease{
+					ControlRelease: &vectorpb.ControlRelease{},
+				},
+			},
+		); err != nil {
+			logger.Println(err)
 		}
 		// * end - modified from official vector-go-sdk
 	}()
 
-	var stopTTSLoop bool
+	stopTTSLoop := make(chan bool)
 	TTSLoopStopped := make(chan bool)
-	for range start {
+	// the talking animation loops while it speaks, unless it might do other things (commands, tools) in between
+	ttsLoop := !vars.APIConfig.Knowledge.CommandsEnable && !useTools
+	if <-start {
 		time.Sleep(time.Millisecond * 300)
 		robot.Conn.PlayAnimation(
 			ctx,
@@ -406,12 +386,14 @@ func StreamingKGSim(req interface{}, esn string, transcribedText string) (string
 				Loops: 1,
 			},
 		)
-		if !vars.APIConfig.Knowledge.CommandsEnable {
+		if ttsLoop {
 			go func() {
 				for {
-					if stopTTSLoop {
+					select {
+					case <-stopTTSLoop:
 						TTSLoopStopped <- true
-						break
+						return
+					default:
 					}
 					robot.Conn.PlayAnimation(
 						ctx,
@@ -427,30 +409,53 @@ func StreamingKGSim(req interface{}, esn string, transcribedText string) (string
 		}
 		var disconnect bool
 		numInResp := 0
+		toolRounds := 0
 		for {
-			respSlice := fullRespSlice
+			respSlice, done := split.snapshot()
 			if len(respSlice)-1 < numInResp {
-				if !isDone {
+				if !done {
 					logger.Println("Waiting for more content from LLM...")
 					for range speakReady {
-						respSlice = fullRespSlice
 						break
 					}
+					continue
+				} else if calls, text := takeToolCalls(); len(calls) > 0 && toolRounds < maxToolRounds {
+					// everything before the tool calls has been said, now run them and give the results back
+					toolRounds++
+					aireq.Messages = append(aireq.Messages, runToolCalls(tools, calls, text, robot, esn)...)
+					metrics.LLMRequests.Inc(providerName, esn)
+					roundStart := time.Now()
+					stream, err = provider.CreateChatCompletionStream(ctx, aireq)
+					if err != nil {
+						llmFailed("request")
+						logger.Println("LLM error after tool calls: " + err.Error())
+						break
+					}
+					split.setDone(false)
+					go readStream(stream, roundStart)
+					continue
 				} else {
 					break
 				}
 			}
 			logger.Println(respSlice[numInResp])
 			acts := GetActionsFromString(respSlice[numInResp])
-			nChat[len(nChat)-1].Content = fullRespText
+			// the next sentence can be synthesized while this one is said
+			if numInResp+1 < len(respSlice) {
+				prefetchActions(esn, GetActionsFromString(respSlice[numInResp+1]))
+			}
+			nChat = append(aireq.Messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: split.text(),
+			})
 			disconnect = PerformActions(nChat, acts, robot)
 			if disconnect {
 				break
 			}
 			numInResp = numInResp + 1
 		}
-		if !vars.APIConfig.Knowledge.CommandsEnable {
-			stopTTSLoop = true
+		if ttsLoop {
+			close(stopTTSLoop)
 			for range TTSLoopStopped {
 				break
 			}
@@ -467,6 +472,11 @@ func StreamingKGSim(req interface{}, esn string, transcribedText string) (string
 		// )
 		//time.Sleep(time.Millisecond * 3300)
 		stop <- true
+		if ConversationEnabled(esn) && !disconnect {
+			go listenForFollowUp(robot, esn)
+		}
+	} else {
+		return "", errors.New("the robot didn't give wire-pod behavior control")
 	}
 	return "", nil
 }
@@ -477,13 +487,10 @@ func KGSim(esn string, textToSay string) error {
 	var robot *vector.Vector
 	var guid string
 	var target string
-	for _, bot := range vars.BotInfo.Robots {
-		if esn == bot.Esn {
-			guid = bot.GUID
-			target = bot.IPAddress + ":443"
-			matched = true
-			break
-		}
+	if bot, ok := vars.BotInfo.Get(esn); ok {
+		guid = bot.GUID
+		target = bot.IPAddress + ":443"
+		matched = true
 	}
 	if matched {
 		var err error
@@ -582,20 +589,23 @@ func KGSim(esn string, textToSay string) error {
 					)
 				}
 			}()
-			textToSaySplit := strings.Split(textToSay, ". ")
-			for _, str := range textToSaySplit {
-				_, err := robot.Conn.SayText(
-					ctx,
-					&vectorpb.SayTextRequest{
-						Text: str + ".",
-						// UseVectorVoice: true,
-						DurationScalar: 1.0,
-					},
-				)
-				if err != nil {
-					logger.Println("KG SayText error: " + err.Error())
-					stop <- true
-					break
+			// the robot's own voice if there's no other, or it doesn't work
+			if voice := RobotVoice(esn); voice.Provider == ttsVector || !sayWithVoice(robot, voice, textToSay) {
+				textToSaySplit := strings.Split(textToSay, ". ")
+				for _, str := range textToSaySplit {
+					_, err := robot.Conn.SayText(
+						ctx,
+						&vectorpb.SayTextRequest{
+							Text: str + ".",
+							// UseVectorVoice: true,
+							DurationScalar: 1.0,
+						},
+					)
+					if err != nil {
+						logger.Println("KG SayText error: " + err.Error())
+						stop <- true
+						break
+					}
 				}
 			}
 			stopTTSLoop = true
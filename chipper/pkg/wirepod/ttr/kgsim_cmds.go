@@ -3,16 +3,14 @@ package wirepod_ttr
 import (
 	"context"
 	"encoding/base64"
-	"errors"
 	"fmt"
-	"io"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/fforchino/vector-go-sdk/pkg/vector"
 	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/metrics"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
 	"github.com/sashabaranov/go-openai"
 )
@@ -29,6 +27,8 @@ const (
 	ActionGetImage = 3
 	// arg: sound file
 	ActionPlaySound = 4
+	// arg: name: value
+	ActionRememberFact = 5
 )
 
 var animationMap [][2]string = [][2]string{
@@ -124,6 +124,13 @@ var ValidLLMCommands []LLMCommand = []LLMCommand{
 		Action:          ActionGetImage,
 		SupportedModels: []string{openai.GPT4o},
 	},
+	{
+		Command:         "rememberFact",
+		Description:     "Remembers a fact for all future conversations, like the user's name or their favorite color. Use this when the user tells you something about themselves or asks you to remember something. The parameter is a short name for the fact, a colon, then the fact. Using a name which already exists replaces the fact.",
+		ParamChoices:    "any, like \"owner's name: Sam\"",
+		Action:          ActionRememberFact,
+		SupportedModels: []string{"all"},
+	},
 	// {
 	// 	Command:      "playSound",
 	// 	Description:  "Plays a sound on the robot.",
@@ -146,12 +153,18 @@ func CreatePrompt(origPrompt string, model string) string {
 	if vars.APIConfig.Knowledge.CommandsEnable {
 		prompt = prompt + "\n\n" + "You are running ON an Anki Vector robot. You have a set of commands. If you include an emoji, I will make you start over. If you want to use a command but it doesn't exist or your desired parameter isn't in the list, avoid using the command. The format is {{command||parameter}}. You can embed these in sentences. Example: \"User: How are you feeling? | Response: \"{{playAnimationWI||sad}} I'm feeling sad...\". Square brackets ([]) are not valid.\n\nUse the playAnimation or playAnimationWI commands if you want to express emotion! You are very animated and good at following instructions. Animation takes precendence over words. You are to include many animations in your response.\n\nHere is every valid command:"
 		for _, cmd := range ValidLLMCommands {
+			if cmd.Action == ActionRememberFact && !vars.APIConfig.Knowledge.SaveChat {
+				continue
+			}
 			if ModelIsSupported(cmd, model) {
 				promptAppendage := "\n\nCommand Name: " + cmd.Command + "\nDescription: " + cmd.Description + "\nParameter choices: " + cmd.ParamChoices
 				prompt = prompt + promptAppendage
 			}
 		}
 	}
+	if vars.APIConfig.Knowledge.ToolsEnable {
+		prompt = prompt + "\n\n" + "You can also control the robot with the tools you have been given. If you are going to use one, say what you are about to do first. Use tools one after another if a request needs more than one."
+	}
 	return prompt
 }
 
@@ -182,15 +195,19 @@ func GetActionsFromString(input string) []RobotAction {
 
 		cmdPlusParam := strings.Split(strings.TrimSpace(strings.Split(spl, "}}")[0]), "||")
 		cmd := strings.TrimSpace(cmdPlusParam[0])
-		param := strings.TrimSpace(cmdPlusParam[1])
+		var param string
+		if len(cmdPlusParam) > 1 {
+			param = strings.TrimSpace(cmdPlusParam[1])
+		}
 		action := CmdParamToAction(cmd, param)
 		if action.Action != -1 {
 			actions = append(actions, action)
 		}
-		if len(strings.Split(spl, "}}")) != 1 {
+		// don't say anything if the command ends the sentence
+		if after := strings.TrimSpace(strings.Split(spl, "}}")[1]); after != "" {
 			action := RobotAction{
 				Action:    ActionSayText,
-				Parameter: strings.TrimSpace(strings.Split(spl, "}}")[1]),
+				Parameter: after,
 			}
 			actions = append(actions, action)
 		}
@@ -267,15 +284,11 @@ func DoPlaySound(sound string, robot *vector.Vector) error {
 	return nil
 }
 
+// DoSayText says the text in the robot's voice (tts.go). the robot's own voice says it if the TTS engine can't
 func DoSayText(input string, robot *vector.Vector) error {
-
-	// just before vector speaks
-	removeSpecialCharacters(input) 	
-
-	// TODO
-	if (vars.APIConfig.STT.Language != "en-US" && vars.APIConfig.Knowledge.Provider == "openai") || os.Getenv("USE_OPENAI_VOICE") == "true" {
-		err := DoSayText_OpenAI(robot, input)
-		return err
+	start := time.Now()
+	if voice := RobotVoice(robot.Cfg.SerialNo); voice.Provider != ttsVector && sayWithVoice(robot, voice, input) {
+		return nil
 	}
 	robot.Conn.SayText(
 		context.Background(),
@@ -285,6 +298,7 @@ func DoSayText(input string, robot *vector.Vector) error {
 			DurationScalar: 0.95,
 		},
 	)
+	metrics.ObserveStage(metrics.StageTTS, ttsVector, "", robot.Cfg.SerialNo, time.Since(start))
 	return nil
 }
 
@@ -296,83 +310,6 @@ func pcmLength(data []byte) time.Duration {
 	return duration
 }
 
-func getOpenAIVoice(voice string) openai.SpeechVoice {
-	voiceMap := map[string]openai.SpeechVoice{
-		"alloy":   openai.VoiceAlloy,
-		"onyx":    openai.VoiceOnyx,
-		"fable":   openai.VoiceFable,
-		"shimmer": openai.VoiceShimmer,
-		"nova":    openai.VoiceNova,
-		"echo":    openai.VoiceEcho,
-		"":        openai.VoiceFable,
-	}
-	return voiceMap[voice]
-}
-
-// TODO
-func DoSayText_OpenAI(robot *vector.Vector, input string) error {
-	if strings.TrimSpace(input) == "" {
-		return nil
-	}
-	openaiVoice := getOpenAIVoice(vars.APIConfig.Knowledge.OpenAIPrompt)
-	// if vars.APIConfig.Knowledge.OpenAIVoice == "" {
-	// 	openaiVoice = openai.VoiceFable
-	// } else {
-	// 	openaiVoice = getOpenAIVoice(vars.APIConfig.Knowledge.OpenAIPrompt)
-	// }
-	oc := openai.NewClient(vars.APIConfig.Knowledge.Key)
-	resp, err := oc.CreateSpeech(context.Background(), openai.CreateSpeechRequest{
-		Model:          openai.TTSModel1,
-		Input:          input,
-		Voice:          openaiVoice,
-		ResponseFormat: openai.SpeechResponseFormatPcm,
-	})
-	if err != nil {
-		logger.Println(err)
-		return err
-	}
-	speechBytes, _ := io.ReadAll(resp)
-	vclient, err := robot.Conn.ExternalAudioStreamPlayback(context.Background())
-	if err != nil {
-		return err
-	}
-	vclient.Send(&vectorpb.ExternalAudioStreamRequest{
-		AudioRequestType: &vectorpb.ExternalAudioStreamRequest_AudioStreamPrepare{
-			AudioStreamPrepare: &vectorpb.ExternalAudioStreamPrepare{
-				AudioFrameRate: 16000,
-				AudioVolume:    100,
-			},
-		},
-	})
-	//time.Sleep(time.Millisecond * 30)
-	audioChunks := downsample24kTo16k(speechBytes)
-
-	var chunksToDetermineLength []byte
-	for _, chunk := range audioChunks {
-		chunksToDetermineLength = append(chunksToDetermineLength, chunk...)
-	}
-	go func() {
-		for _, chunk := range audioChunks {
-			vclient.Send(&vectorpb.ExternalAudioStreamRequest{
-				AudioRequestType: &vectorpb.ExternalAudioStreamRequest_AudioStreamChunk{
-					AudioStreamChunk: &vectorpb.ExternalAudioStreamChunk{
-						AudioChunkSizeBytes: 1024,
-						AudioChunkSamples:   chunk,
-					},
-				},
-			})
-			time.Sleep(time.Millisecond * 25)
-		}
-		vclient.Send(&vectorpb.ExternalAudioStreamRequest{
-			AudioRequestType: &vectorpb.ExternalAudioStreamRequest_AudioStreamComplete{
-				AudioStreamComplete: &vectorpb.ExternalAudioStreamComplete{},
-			},
-		})
-	}()
-	time.Sleep(pcmLength(chunksToDetermineLength) + (time.Millisecond * 50))
-	return nil
-}
-
 func DoGetImage(msgs []openai.ChatCompletionMessage, param string, robot *vector.Vector) {
 	logger.Println("Get image here...")
 	// get image
@@ -431,24 +368,14 @@ func DoGetImage(msgs []openai.ChatCompletionMessage, param string, robot *vector
 	})
 
 	// recreate openai
-	var fullRespText string
-	var fullfullRespText string
-	var fullRespSlice []string
-	var isDone bool
-	var c *openai.Client
-	if vars.APIConfig.Knowledge.Provider == "together" {
-		if vars.APIConfig.Knowledge.Model == "" {
-			vars.APIConfig.Knowledge.Model = "meta-llama/Llama-2-70b-chat-hf"
-			vars.WriteConfigToDisk()
-		}
-		conf := openai.DefaultConfig(vars.APIConfig.Knowledge.Key)
-		conf.BaseURL = "https://api.together.xyz/v1"
-		c = openai.NewClientWithConfig(conf)
-	} else if vars.APIConfig.Knowledge.Provider == "openai" {
-		c = openai.NewClient(vars.APIConfig.Knowledge.Key)
+	split := &sentenceSplitter{}
+	provider, err := GetKnowledgeProvider()
+	if err != nil {
+		logger.Println("LLM error: " + err.Error())
+		return
 	}
 	ctx := context.Background()
-	speakReady := make(chan string)
+	speakReady := make(chan string, 1)
 
 	aireq := openai.ChatCompletionRequest{
 		MaxTokens:        2048,
@@ -466,7 +393,7 @@ func DoGetImage(msgs []openai.ChatCompletionMessage, param string, robot *vector
 		logger.Println("Using " + vars.APIConfig.Knowledge.Model)
 		aireq.Model = vars.APIConfig.Knowledge.Model
 	}
-	stream, err := c.CreateChatCompletionStream(ctx, aireq)
+	stream, err := provider.CreateChatCompletionStream(ctx, aireq)
 	if err != nil {
 		if strings.Contains(err.Error(), "does not exist") && vars.APIConfig.Knowledge.Provider == "openai" {
 			logger.Println("GPT-4 model cannot be accessed with this API key. You likely need to add more than $5 dollars of funds to your OpenAI account.")
@@ -474,7 +401,7 @@ func DoGetImage(msgs []openai.ChatCompletionMessage, param string, robot *vector
 			aireq.Model = openai.GPT3Dot5Turbo
 			logger.Println("Falling back to " + aireq.Model)
 			logger.LogUI("Falling back to " + aireq.Model)
-			stream, err = c.CreateChatCompletionStream(ctx, aireq)
+			stream, err = provider.CreateChatCompletionStream(ctx, aireq)
 			if err != nil {
 				logger.Println("OpenAI still not returning a response even after falling back. Erroring.")
 				return
@@ -484,86 +411,60 @@ func DoGetImage(msgs []openai.ChatCompletionMessage, param string, robot *vector
 			return
 		}
 	}
-	//defer stream.Close()
 
 	fmt.Println("LLM stream response: ")
 	go func() {
-		for {
-			response, err := stream.Recv()
-			if errors.Is(err, io.EOF) {
-				isDone = true
-				newStr := fullRespSlice[0]
-				for i, str := range fullRespSlice {
-					if i == 0 {
-						continue
-					}
-					newStr = newStr + " " + str
-				}
-				if strings.TrimSpace(newStr) != strings.TrimSpace(fullfullRespText) {
-					logger.Println("LLM debug: there is content after the last punctuation mark")
-					extraBit := strings.TrimPrefix(fullRespText, newStr)
-					fullRespSlice = append(fullRespSlice, extraBit)
-				}
-				if vars.APIConfig.Knowledge.SaveChat {
-					Remember(msgs[len(msgs)-1],
-						openai.ChatCompletionMessage{
-							Role:    openai.ChatMessageRoleAssistant,
-							Content: newStr,
-						},
-						robot.Cfg.SerialNo)
-				}
-				logger.LogUI("LLM response for " + robot.Cfg.SerialNo + ": " + newStr)
-				logger.Println("LLM stream finished")
-				return
-			}
-
-			if err != nil {
-				logger.Println("Stream error: " + err.Error())
-				return
-			}
-			fullfullRespText = fullfullRespText + removeSpecialCharacters(response.Choices[0].Delta.Content)
-			fullRespText = fullRespText + removeSpecialCharacters(response.Choices[0].Delta.Content)
-			if strings.Contains(fullRespText, "...") || strings.Contains(fullRespText, ".'") || strings.Contains(fullRespText, ".\"") || strings.Contains(fullRespText, ".") || strings.Contains(fullRespText, "?") || strings.Contains(fullRespText, "!") {
-				var sepStr string
-				if strings.Contains(fullRespText, "...") {
-					sepStr = "..."
-				} else if strings.Contains(fullRespText, ".'") {
-					sepStr = ".'"
-				} else if strings.Contains(fullRespText, ".\"") {
-					sepStr = ".\""
-				} else if strings.Contains(fullRespText, ".") {
-					sepStr = "."
-				} else if strings.Contains(fullRespText, "?") {
-					sepStr = "?"
-				} else if strings.Contains(fullRespText, "!") {
-					sepStr = "!"
-				}
-				splitResp := strings.Split(strings.TrimSpace(fullRespText), sepStr)
-				fullRespSlice = append(fullRespSlice, strings.TrimSpace(splitResp[0])+sepStr)
-				fullRespText = splitResp[1]
-				select {
-				case speakReady <- strings.TrimSpace(splitResp[0]) + sepStr:
-				default:
-				}
+		defer stream.Close()
+		_, err := readLLMResponse(stream, split, func(sentence string) {
+			select {
+			case speakReady <- sentence:
+			default:
 			}
+		})
+		if err != nil {
+			logger.Println("Stream error: " + err.Error())
+		} else if _, extra := split.finish(); extra {
+			logger.Println("LLM debug: there is content after the last punctuation mark")
+		}
+		split.setDone(true)
+		select {
+		case speakReady <- "":
+		default:
+		}
+		if err != nil {
+			return
 		}
+		newStr := split.text()
+		if vars.APIConfig.Knowledge.SaveChat {
+			Remember(msgs[len(msgs)-1],
+				openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: newStr,
+				},
+				robot.Cfg.SerialNo)
+		}
+		logger.LogUI("LLM response for " + robot.Cfg.SerialNo + ": " + newStr)
+		logger.Println("LLM stream finished")
 	}()
 	numInResp := 0
 	for {
-		respSlice := fullRespSlice
+		respSlice, done := split.snapshot()
 		if len(respSlice)-1 < numInResp {
-			if !isDone {
+			if !done {
 				logger.Println("Waiting for more content from LLM...")
 				for range speakReady {
-					respSlice = fullRespSlice
 					break
 				}
+				continue
 			} else {
 				break
 			}
 		}
 		logger.Println(respSlice[numInResp])
 		acts := GetActionsFromString(respSlice[numInResp])
+		if numInResp+1 < len(respSlice) {
+			prefetchActions(robot.Cfg.SerialNo, GetActionsFromString(respSlice[numInResp+1]))
+		}
 		PerformActions(msgs, acts, robot)
 		numInResp = numInResp + 1
 	}
@@ -584,6 +485,8 @@ func PerformActions(msgs []openai.ChatCompletionMessage, actions []RobotAction,
 			return true
 		case action.Action == ActionPlaySound:
 			DoPlaySound(action.Parameter, robot)
+		case action.Action == ActionRememberFact:
+			DoRememberFact(action.Parameter, robot.Cfg.SerialNo)
 		}
 	}
 	WaitForAnim_Queue(robot.Cfg.SerialNo)
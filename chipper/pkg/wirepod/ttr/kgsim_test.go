@@ -0,0 +1,183 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/fforchino/vector-go-sdk/pkg/vector"
+	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/metrics"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/history"
+	"google.golang.org/grpc"
+)
+
+// stands in for a robot's SDK server, keeping what it was asked to say
+type fakeRobot struct {
+	vectorpb.ExternalInterfaceClient
+	// whether it gives wire-pod behavior control
+	grant    bool
+	released chan struct{}
+	mu       sync.Mutex
+	said     []string
+}
+
+func (r *fakeRobot) BatteryState(context.Context, *vectorpb.BatteryStateRequest, ...grpc.CallOption) (*vectorpb.BatteryStateResponse, error) {
+	return &vectorpb.BatteryStateResponse{}, nil
+}
+
+func (r *fakeRobot) BehaviorControl(context.Context, ...grpc.CallOption) (vectorpb.ExternalInterface_BehaviorControlClient, error) {
+	return &fakeControl{robot: r}, nil
+}
+
+func (r *fakeRobot) PlayAnimation(context.Context, *vectorpb.PlayAnimationRequest, ...grpc.CallOption) (*vectorpb.PlayAnimationResponse, error) {
+	time.Sleep(time.Millisecond)
+	return &vectorpb.PlayAnimationResponse{}, nil
+}
+
+func (r *fakeRobot) SayText(ctx context.Context, req *vectorpb.SayTextRequest, opts ...grpc.CallOption) (*vectorpb.SayTextResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.said = append(r.said, req.Text)
+	return &vectorpb.SayTextResponse{}, nil
+}
+
+type fakeControl struct {
+	grpc.ClientStream
+	robot *fakeRobot
+}
+
+func (c *fakeControl) Send(req *vectorpb.BehaviorControlRequest) error {
+	if req.GetControlRelease() != nil {
+		close(c.robot.released)
+	}
+	return nil
+}
+
+func (c *fakeControl) Recv() (*vectorpb.BehaviorControlResponse, error) {
+	if !c.robot.grant {
+		return nil, errors.New("control lost")
+	}
+	return &vectorpb.BehaviorControlResponse{
+		ResponseType: &vectorpb.BehaviorControlResponse_ControlGrantedResponse{
+			ControlGrantedResponse: &vectorpb.ControlGrantedResponse{},
+		},
+	}, nil
+}
+
+type sentIntentGraph struct {
+	grpc.ServerStream
+	sent []*pb.IntentGraphResponse
+}
+
+func (s *sentIntentGraph) Send(resp *pb.IntentGraphResponse) error {
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func (s *sentIntentGraph) Recv() (*pb.StreamingIntentGraphRequest, error) {
+	return nil, nil
+}
+
+func TestStreamingKGSim(t *testing.T) {
+	knowledge := vars.APIConfig.Knowledge
+	historyConfig := vars.APIConfig.History
+	historyDir := vars.HistoryDir
+	t.Cleanup(func() {
+		vars.APIConfig.Knowledge = knowledge
+		vars.APIConfig.History = historyConfig
+		vars.HistoryDir = historyDir
+		providerOverride = nil
+		robotOverride = nil
+	})
+	vars.APIConfig.Knowledge.Enable = true
+	vars.APIConfig.Knowledge.Provider = "fake"
+	vars.APIConfig.Knowledge.IntentGraph = true
+	vars.APIConfig.Knowledge.CommandsEnable = false
+	vars.APIConfig.Knowledge.ToolsEnable = false
+	vars.APIConfig.Knowledge.SaveChat = false
+	vars.APIConfig.History.Enable = true
+	vars.HistoryDir = t.TempDir()
+
+	for _, grant := range []bool{true, false} {
+		fake := &fakeRobot{grant: grant, released: make(chan struct{})}
+		// not vector.New, which would leave a connection trying to reach a robot
+		robot := &vector.Vector{Conn: fake}
+		vector.WithSerialNo("00e20000")(&robot.Cfg)
+		robotOverride = robot
+		providerOverride = &FakeProvider{Script: []FakeResponse{{Deltas: []string{"Hello there.", " How are you?"}}}}
+
+		// what ProcessIntentGraph does around it
+		session := "kgsim-granted"
+		if !grant {
+			session = "kgsim-refused"
+		}
+		stream := &sentIntentGraph{}
+		req := &vtt.IntentGraphRequest{Stream: stream, Device: "00e20000", Session: session}
+		history.Begin(req.Device, req.Session, history.KindIntentGraph, "vosk")
+		llmRequests := metrics.LLMRequests.Value("fake", req.Device)
+		firstTokens := metrics.StageDuration.Count(metrics.StageLLMFirstToken, "fake", "", req.Device)
+		said := metrics.StageDuration.Count(metrics.StageTTS, ttsVector, "", req.Device)
+		totals := metrics.StageDuration.Count(metrics.StageTotal, "vosk", "", req.Device)
+		start := time.Now()
+		done := make(chan error)
+		go func() {
+			_, err := StreamingKGSim(req, req.Device, "tell me something")
+			history.Finish(req.Session, nil)
+			metrics.ObserveStage(metrics.StageTotal, "vosk", "", req.Device, time.Since(start))
+			done <- err
+		}()
+		var err error
+		select {
+		case err = <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("granted %v: StreamingKGSim didn't return", grant)
+		}
+		if grant != (err == nil) {
+			t.Errorf("granted %v: got %v", grant, err)
+		}
+		if len(stream.sent) == 0 || stream.sent[0].IntentResult.Action != "intent_greeting_hello" {
+			t.Errorf("granted %v: sent %v", grant, stream.sent)
+		}
+		if metrics.LLMRequests.Value("fake", req.Device) != llmRequests+1 ||
+			metrics.StageDuration.Count(metrics.StageTotal, "vosk", "", req.Device) != totals+1 {
+			t.Errorf("granted %v: the LLM request or the whole request wasn't measured", grant)
+		}
+		if !grant {
+			continue
+		}
+		// the response has been said, so the stream is finished
+		if metrics.StageDuration.Count(metrics.StageLLMFirstToken, "fake", "", req.Device) != firstTokens+1 ||
+			metrics.StageDuration.Count(metrics.StageTTS, ttsVector, "", req.Device) != said+2 {
+			t.Error("the first token or saying the response wasn't measured")
+		}
+		select {
+		case <-fake.released:
+		case <-time.After(10 * time.Second):
+			t.Error("behavior control wasn't released")
+		}
+		fake.mu.Lock()
+		if len(fake.said) != 2 || fake.said[0] != "Hello there." || fake.said[1] != "How are you?" {
+			t.Errorf("the robot said %q", fake.said)
+		}
+		fake.mu.Unlock()
+	}
+
+	reqs, err := history.List("00e20000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("%d requests in the history, want 2", len(reqs))
+	}
+	for _, got := range reqs {
+		if got.Kind != history.KindIntentGraph || got.Intent != "intent_greeting_hello" || got.LLM != "Hello there. How are you?" {
+			t.Errorf("request wasn't recorded right: %+v", got)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/fforchino/vector-go-sdk/pkg/vector"
+	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// tools the LLM can call through the OpenAI-compatible tool calling API, if Knowledge.ToolsEnable is set.
+// unlike the {{command||param}} strings in kgsim_cmds.go, what a tool returns goes back to the LLM, so it can
+// chain them ("turn around and tell me what you see" is turnInPlace, then getImage, then the answer).
+
+// how many times the LLM can go back and forth with tool calls in one request
+const maxToolRounds = 5
+
+// the lift's range, from the SDK
+const (
+	minLiftHeightMm = 32
+	maxLiftHeightMm = 92
+)
+
+// LLMTool is something the LLM can call
+type LLMTool struct {
+	Name        string
+	Description string
+	Parameters  jsonschema.Definition
+	// returns the text given back to the LLM
+	Run func(tc *toolCallContext, args map[string]interface{}) (string, error)
+}
+
+type toolCallContext struct {
+	robot *vector.Vector
+	esn   string
+	guid  string
+	// the ip:port the robot can be reached at
+	target string
+	// added to the conversation after the tool results, for things (like images) which can't be in a tool result
+	extraMessages []openai.ChatCompletionMessage
+}
+
+var toolNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func toolName(prefix, name string) string {
+	n := prefix + strings.Trim(toolNameRegex.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if len(n) > 64 {
+		n = n[:64]
+	}
+	return n
+}
+
+func textParam(description string) jsonschema.Definition {
+	return jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"text": {
+				Type:        jsonschema.String,
+				Description: description,
+			},
+		},
+		Required: []string{"text"},
+	}
+}
+
+func numberParam(name string, description string) jsonschema.Definition {
+	return jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			name: {
+				Type:        jsonschema.Number,
+				Description: description,
+			},
+		},
+		Required: []string{name},
+	}
+}
+
+func argNumber(args map[string]interface{}, name string) (float64, error) {
+	num, ok := args[name].(float64)
+	if !ok {
+		return 0, errors.New(name + " must be a number")
+	}
+	return num, nil
+}
+
+func clamp(num, min, max float64) float64 {
+	return math.Max(min, math.Min(max, num))
+}
+
+var sdkTools = []LLMTool{
+	{
+		Name:        "moveHead",
+		Description: "Moves the robot's head. Looking straight ahead is 0 degrees.",
+		Parameters:  numberParam("angle", "Angle in degrees, from -22 (all the way down) to 45 (all the way up)."),
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			angle, err := argNumber(args, "angle")
+			if err != nil {
+				return "", err
+			}
+			angle = clamp(angle, -22, 45)
+			_, err = tc.robot.Conn.SetHeadAngle(context.Background(), &vectorpb.SetHeadAngleRequest{
+				AngleRad:          float32(angle * math.Pi / 180),
+				MaxSpeedRadPerSec: 10,
+				AccelRadPerSec2:   10,
+			})
+			return fmt.Sprintf("head moved to %.0f degrees", angle), err
+		},
+	},
+	{
+		Name:        "moveLift",
+		Description: "Moves the robot's lift (its arms).",
+		Parameters:  numberParam("height", "From 0 (all the way down) to 1 (all the way up)."),
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			height, err := argNumber(args, "height")
+			if err != nil {
+				return "", err
+			}
+			height = clamp(height, 0, 1)
+			_, err = tc.robot.Conn.SetLiftHeight(context.Background(), &vectorpb.SetLiftHeightRequest{
+				HeightMm:          float32(minLiftHeightMm + height*(maxLiftHeightMm-minLiftHeightMm)),
+				MaxSpeedRadPerSec: 10,
+				AccelRadPerSec2:   10,
+			})
+			return fmt.Sprintf("lift moved to %.1f", height), err
+		},
+	},
+	{
+		Name:        "driveStraight",
+		Description: "Drives the robot forwards or backwards. Be careful, the robot is on a table.",
+		Parameters:  numberParam("distance", "Distance in millimeters, from -300 to 300. Negative drives backwards."),
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			distance, err := argNumber(args, "distance")
+			if err != nil {
+				return "", err
+			}
+			distance = clamp(distance, -300, 300)
+			_, err = tc.robot.Conn.DriveStraight(context.Background(), &vectorpb.DriveStraightRequest{
+				SpeedMmps: 100,
+				DistMm:    float32(distance),
+			})
+			return fmt.Sprintf("drove %.0f millimeters", distance), err
+		},
+	},
+	{
+		Name:        "turnInPlace",
+		Description: "Turns the robot around without moving forwards.",
+		Parameters:  numberParam("angle", "Angle in degrees. Positive turns left, negative turns right. 180 turns around."),
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			angle, err := argNumber(args, "angle")
+			if err != nil {
+				return "", err
+			}
+			angle = clamp(angle, -360, 360)
+			_, err = tc.robot.Conn.TurnInPlace(context.Background(), &vectorpb.TurnInPlaceRequest{
+				AngleRad:        float32(angle * math.Pi / 180),
+				SpeedRadPerSec:  float32(math.Pi / 2),
+				AccelRadPerSec2: float32(math.Pi),
+			})
+			return fmt.Sprintf("turned %.0f degrees", angle), err
+		},
+	},
+	{
+		Name:        "setEyeColor",
+		Description: "Sets the color of the robot's eyes until it's changed in the settings again.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"hue": {
+					Type:        jsonschema.Number,
+					Description: "Hue from 0 to 1. 0 is red, 0.33 is green, 0.66 is blue.",
+				},
+				"saturation": {
+					Type:        jsonschema.Number,
+					Description: "Saturation from 0 to 1.",
+				},
+			},
+			Required: []string{"hue", "saturation"},
+		},
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			hue, err := argNumber(args, "hue")
+			if err != nil {
+				return "", err
+			}
+			sat, err := argNumber(args, "saturation")
+			if err != nil {
+				return "", err
+			}
+			_, err = tc.robot.Conn.SetEyeColor(context.Background(), &vectorpb.SetEyeColorRequest{
+				Hue:        float32(clamp(hue, 0, 1)),
+				Saturation: float32(clamp(sat, 0, 1)),
+			})
+			return "eye color set", err
+		},
+	},
+	{
+		Name:        "playAnimation",
+		Description: "Plays an animation to show an emotion. This interrupts speech.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"animation": {
+					Type: jsonschema.String,
+					Enum: animationNames(),
+				},
+			},
+			Required: []string{"animation"},
+		},
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			anim, _ := args["animation"].(string)
+			DoPlayAnimation(anim, tc.robot)
+			return "played " + anim, nil
+		},
+	},
+	{
+		Name:        "getImage",
+		Description: "Takes a photo with the robot's camera, which is in its face. The photo is given to you in the next message. Use this whenever you are asked what you see, take a new photo every time.",
+		Parameters: jsonschema.Definition{
+			Type:       jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{},
+		},
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			resp, err := tc.robot.Conn.CaptureSingleImage(context.Background(), &vectorpb.CaptureSingleImageRequest{
+				EnableHighResolution: true,
+			})
+			if err != nil {
+				return "", err
+			}
+			tc.extraMessages = append(tc.extraMessages, openai.ChatCompletionMessage{
+				Role: openai.ChatMessageRoleUser,
+				MultiContent: []openai.ChatMessagePart{
+					{
+						Type: openai.ChatMessagePartTypeImageURL,
+						ImageURL: &openai.ChatMessageImageURL{
+							URL:    "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(resp.Data),
+							Detail: openai.ImageURLDetailLow,
+						},
+					},
+				},
+			})
+			return "photo taken, it is in the next message", nil
+		},
+	},
+}
+
+func animationNames() []string {
+	var names []string
+	for _, anim := range animationMap {
+		names = append(names, anim[0])
+	}
+	return names
+}
+
+// LLMTools returns the SDK actions, the memory tools if chats are saved, then a tool for each loaded plugin and custom intent
+func LLMTools() []LLMTool {
+	tools := append([]LLMTool{}, sdkTools...)
+	if vars.APIConfig.Knowledge.SaveChat {
+		tools = append(tools, memoryTools...)
+	}
+	for _, p := range Plugins() {
+		p := p
+		tools = append(tools, LLMTool{
+			Name:        toolName("plugin_", p.Name),
+			Description: "Runs the \"" + p.Name + "\" plugin. It normally runs when the user says something like: " + strings.Join(*p.Utterances, ", "),
+			Parameters:  textParam("What the user wants, phrased like one of the example utterances."),
+			Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+				text, _ := args["text"].(string)
+				_, response := p.Action(strings.ToLower(text), tc.esn, tc.guid, tc.target)
+				if response == "" {
+					return "done", nil
+				}
+				return response, nil
+			},
+		})
+	}
+	for _, c := range vars.CustomIntents() {
+		c := c
+		description := c.Description
+		if description == "" {
+			description = "Runs the custom intent \"" + c.Name + "\"."
+		}
+		tools = append(tools, LLMTool{
+			Name:        toolName("intent_", c.Name),
+			Description: description + " It normally runs when the user says something like: " + strings.Join(c.Utterances, ", "),
+			Parameters:  textParam("What the user wants, phrased like one of the example utterances."),
+			Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+				text, _ := args["text"].(string)
+				out := strings.TrimSpace(string(execCustomIntent(c, strings.ToLower(text), tc.esn, "")))
+				if !c.IsSystemIntent && c.Intent != "" {
+					// the intent has already been sent for this request, so ask the robot to do it through the SDK
+					_, err := tc.robot.Conn.AppIntent(context.Background(), &vectorpb.AppIntentRequest{
+						Intent: c.Intent,
+						Param:  c.Params.ParamValue,
+					})
+					if err != nil {
+						return "", err
+					}
+				}
+				if out == "" {
+					return "done", nil
+				}
+				return out, nil
+			},
+		})
+	}
+	return tools
+}
+
+func openAITools(tools []LLMTool) []openai.Tool {
+	var oaiTools []openai.Tool
+	for _, tool := range tools {
+		tool := tool
+		oaiTools = append(oaiTools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return oaiTools
+}
+
+// tool calls come in pieces when streaming, with an index saying which call a piece belongs to
+func mergeToolCallDeltas(calls []openai.ToolCall, deltas []openai.ToolCall) []openai.ToolCall {
+	for _, delta := range deltas {
+		index := len(calls)
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		for len(calls) <= index {
+			calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+		if delta.ID != "" {
+			calls[index].ID = delta.ID
+		}
+		if delta.Function.Name != "" {
+			calls[index].Function.Name = delta.Function.Name
+		}
+		calls[index].Function.Arguments += delta.Function.Arguments
+	}
+	return calls
+}
+
+// runs the tool calls from one LLM response and returns the messages which continue the conversation
+func runToolCalls(tools []LLMTool, calls []openai.ToolCall, content string, robot *vector.Vector, esn string) []openai.ChatCompletionMessage {
+	tc := &toolCallContext{
+		robot: robot,
+		esn:   esn,
+	}
+	if bot, ok := vars.BotInfo.Get(esn); ok {
+		tc.guid = bot.GUID
+		tc.target = bot.IPAddress + ":443"
+	}
+	// the calls have to be in the conversation before their results
+	for i := range calls {
+		calls[i].Index = nil
+	}
+	msgs := []openai.ChatCompletionMessage{
+		{
+			Role:      openai.ChatMessageRoleAssistant,
+			Content:   content,
+			ToolCalls: calls,
+		},
+	}
+	for _, call := range calls {
+		result := runToolCall(tools, call, tc)
+		logger.Println("LLM tool " + call.Function.Name + "(" + call.Function.Arguments + "): " + result)
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Content:    result,
+			Name:       call.Function.Name,
+			ToolCallID: call.ID,
+		})
+	}
+	return append(msgs, tc.extraMessages...)
+}
+
+func runToolCall(tools []LLMTool, call openai.ToolCall, tc *toolCallContext) string {
+	args := make(map[string]interface{})
+	if strings.TrimSpace(call.Function.Arguments) != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			return "error: the arguments aren't valid json: " + err.Error()
+		}
+	}
+	for _, tool := range tools {
+		if tool.Name == call.Function.Name {
+			result, err := tool.Run(tc, args)
+			if err != nil {
+				return "error: " + err.Error()
+			}
+			return result
+		}
+	}
+	return "error: there is no tool called " + call.Function.Name
+}
@@ -0,0 +1,336 @@
+package wirepod_ttr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/sashabaranov/go-openai"
+)
+
+// the LLM behind knowledge graph and intent graph requests.
+// requests and streamed responses are go-openai's types no matter what the provider speaks,
+// so kgsim only has to know about one format. openai, together and custom all speak that API already,
+// ollama and llamacpp translate to and from their own.
+
+// KnowledgeStream is a streamed response. Recv returns io.EOF once the response is done
+type KnowledgeStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// KnowledgeProvider is an LLM which can stream a chat completion
+type KnowledgeProvider interface {
+	Name() string
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (KnowledgeStream, error)
+}
+
+// openai, together and custom
+type openAIProvider struct {
+	name   string
+	client *openai.Client
+}
+
+func (p *openAIProvider) Name() string {
+	return p.name
+}
+
+func (p *openAIProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (KnowledgeStream, error) {
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// used instead of the one in the config if set, so tests don't go over the network
+var providerOverride KnowledgeProvider
+
+// GetKnowledgeProvider returns the provider set in the config
+func GetKnowledgeProvider() (KnowledgeProvider, error) {
+	if providerOverride != nil {
+		return providerOverride, nil
+	}
+	switch vars.APIConfig.Knowledge.Provider {
+	case "together":
+		if vars.APIConfig.Knowledge.Model == "" {
+			vars.APIConfig.Knowledge.Model = "meta-llama/Llama-3-70b-chat-hf"
+			vars.WriteConfigToDisk()
+		}
+		conf := openai.DefaultConfig(vars.APIConfig.Knowledge.Key)
+		conf.BaseURL = "https://api.together.xyz/v1"
+		return &openAIProvider{name: "together", client: openai.NewClientWithConfig(conf)}, nil
+	case "custom":
+		conf := openai.DefaultConfig(vars.APIConfig.Knowledge.Key)
+		conf.BaseURL = vars.APIConfig.Knowledge.Endpoint
+		return &openAIProvider{name: "custom", client: openai.NewClientWithConfig(conf)}, nil
+	case "openai":
+		conf := openai.DefaultConfig(vars.APIConfig.Knowledge.Key)
+		if v := os.Getenv("OPENAI_BASE"); v != "" {
+			conf.BaseURL = v
+		} else if vars.APIConfig.Knowledge.Endpoint != "" {
+			conf.BaseURL = vars.APIConfig.Knowledge.Endpoint
+		}
+		return &openAIProvider{name: "openai", client: openai.NewClientWithConfig(conf)}, nil
+	case "ollama":
+		return NewOllamaProvider(vars.APIConfig.Knowledge.Endpoint), nil
+	case "llamacpp":
+		return NewLlamaCppProvider(vars.APIConfig.Knowledge.Endpoint)
+	}
+	return nil, errors.New("knowledge provider " + vars.APIConfig.Knowledge.Provider + " can't stream responses")
+}
+
+// LLMRequest gets a whole response to the text, for when it doesn't have to be spoken as it streams in
+func LLMRequest(transcribedText, esn string) (string, error) {
+	provider, err := GetKnowledgeProvider()
+	if err != nil {
+		return "", err
+	}
+	aireq := CreateAIReq(transcribedText, esn, false)
+	stream, err := provider.CreateChatCompletionStream(context.Background(), aireq)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	split := &sentenceSplitter{}
+	if _, err := readLLMResponse(stream, split, nil); err != nil {
+		return "", err
+	}
+	split.finish()
+	resp := split.text()
+	if resp == "" {
+		return "", errors.New("llm returned no response")
+	}
+	if vars.APIConfig.Knowledge.SaveChat {
+		Remember(openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: transcribedText,
+		},
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: resp,
+			},
+			esn)
+	}
+	logger.Println(provider.Name() + " response: " + resp)
+	return resp, nil
+}
+
+// splits a streamed response into sentences, so the robot can start talking before the whole response is there.
+// the stream is read on one goroutine while the sentences are said on another
+type sentenceSplitter struct {
+	mu sync.Mutex
+	// whatever came after the last complete sentence
+	pending   string
+	sentences []string
+	// true once the response has ended
+	done bool
+}
+
+// the first one wins if two start at the same place, so "..." has to come before "."
+var sentenceEnds = []string{"...", ".'", ".\"", ".", "?", "!"}
+
+// adds a bit of the response and returns the sentences it completed
+func (s *sentenceSplitter) add(delta string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = s.pending + removeSpecialCharacters(delta)
+	var done []string
+	for {
+		var sepStr string
+		sepIndex := -1
+		for _, end := range sentenceEnds {
+			if i := strings.Index(s.pending, end); i != -1 && (sepIndex == -1 || i < sepIndex) {
+				sepStr = end
+				sepIndex = i
+			}
+		}
+		if sepStr == "" {
+			return done
+		}
+		splitResp := strings.SplitN(strings.TrimSpace(s.pending), sepStr, 2)
+		s.pending = splitResp[1]
+		if strings.TrimSpace(splitResp[0]) == "" {
+			// "..." split up across two deltas
+			continue
+		}
+		sentence := strings.TrimSpace(splitResp[0]) + sepStr
+		s.sentences = append(s.sentences, sentence)
+		done = append(done, sentence)
+	}
+}
+
+// adds whatever came after the last punctuation mark as a sentence of its own
+func (s *sentenceSplitter) finish() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rest := strings.TrimSpace(s.pending)
+	s.pending = ""
+	if rest == "" {
+		return "", false
+	}
+	s.sentences = append(s.sentences, rest)
+	return rest, true
+}
+
+func (s *sentenceSplitter) text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Join(s.sentences, " ")
+}
+
+// the sentences so far, and true if there won't be any more
+func (s *sentenceSplitter) snapshot() ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.sentences...), s.done
+}
+
+func (s *sentenceSplitter) setDone(done bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = done
+}
+
+// one response from the LLM
+type llmResponse struct {
+	// as it came in, with special characters
+	Text      string
+	ToolCalls []openai.ToolCall
+	// when the first text or tool call came in. zero if nothing did
+	FirstToken time.Time
+}
+
+// reads a response until the stream ends. onSentence (if not nil) gets every sentence as soon as it's complete
+func readLLMResponse(stream KnowledgeStream, split *sentenceSplitter, onSentence func(string)) (llmResponse, error) {
+	var resp llmResponse
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return resp, nil
+		}
+		if err != nil {
+			return resp, err
+		}
+		if len(response.Choices) == 0 {
+			continue
+		}
+		delta := response.Choices[0].Delta
+		if resp.FirstToken.IsZero() && (delta.Content != "" || len(delta.ToolCalls) > 0) {
+			resp.FirstToken = time.Now()
+		}
+		resp.ToolCalls = mergeToolCallDeltas(resp.ToolCalls, delta.ToolCalls)
+		resp.Text = resp.Text + delta.Content
+		for _, sentence := range split.add(delta.Content) {
+			if onSentence != nil {
+				onSentence(sentence)
+			}
+		}
+	}
+}
+
+// a streamed response with one JSON object per line, for the providers which don't speak the OpenAI API.
+// parse turns a line into a response. done is true for the line which ends the response
+type lineStream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	done   bool
+	parse  func(line []byte) (resp openai.ChatCompletionStreamResponse, done bool, err error)
+}
+
+func newLineStream(body io.ReadCloser, parse func(line []byte) (openai.ChatCompletionStreamResponse, bool, error)) *lineStream {
+	return &lineStream{
+		body:   body,
+		reader: bufio.NewReader(body),
+		parse:  parse,
+	}
+}
+
+func (s *lineStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for !s.done {
+		line, readErr := s.reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			resp, done, err := s.parse(line)
+			if err != nil {
+				return openai.ChatCompletionStreamResponse{}, err
+			}
+			s.done = done
+			if len(resp.Choices) > 0 {
+				return resp, nil
+			}
+		}
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) {
+				break
+			}
+			return openai.ChatCompletionStreamResponse{}, readErr
+		}
+	}
+	s.done = true
+	return openai.ChatCompletionStreamResponse{}, io.EOF
+}
+
+func (s *lineStream) Close() error {
+	return s.body.Close()
+}
+
+func contentResponse(content string) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{
+			{
+				Delta: openai.ChatCompletionStreamChoiceDelta{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: content,
+				},
+			},
+		},
+	}
+}
+
+// posts the request and returns the body if the status is OK
+func postLLMRequest(ctx context.Context, url string, body interface{}) (io.ReadCloser, error) {
+	marshalled, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(marshalled))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if vars.APIConfig.Knowledge.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(vars.APIConfig.Knowledge.Key))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, errors.New(url + " returned " + resp.Status + ": " + strings.TrimSpace(string(errBody)))
+	}
+	return resp.Body, nil
+}
+
+// endpoint from the config without a trailing slash or /v1 (which is where the OpenAI-compatible API lives)
+func nativeEndpoint(endpoint string, def string) string {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return def
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	endpoint = strings.TrimSuffix(endpoint, "/v1")
+	return endpoint
+}
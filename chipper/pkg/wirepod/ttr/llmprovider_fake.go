@@ -0,0 +1,88 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// a provider which plays back canned responses, for tests. nothing goes over the network
+
+// FakeResponse is one scripted response
+type FakeResponse struct {
+	// streamed one after another, like the bits of a real response
+	Deltas []string
+	// sent after the deltas, each as a delta of its own
+	ToolCalls []openai.ToolCall
+	// returned by CreateChatCompletionStream instead of a stream
+	Err error
+	// returned by Recv after the deltas, instead of io.EOF
+	StreamErr error
+}
+
+// FakeProvider answers each request with the next response in Script
+type FakeProvider struct {
+	Script []FakeResponse
+	// every request it got, in order
+	Requests []openai.ChatCompletionRequest
+	mu       sync.Mutex
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (KnowledgeStream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Requests = append(p.Requests, req)
+	if len(p.Script) == 0 {
+		return nil, errors.New("fake provider: script is out of responses")
+	}
+	resp := p.Script[0]
+	p.Script = p.Script[1:]
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	var chunks []openai.ChatCompletionStreamResponse
+	for _, delta := range resp.Deltas {
+		chunks = append(chunks, contentResponse(delta))
+	}
+	for i, call := range resp.ToolCalls {
+		index := i
+		call.Index = &index
+		chunk := contentResponse("")
+		chunk.Choices[0].Delta.ToolCalls = []openai.ToolCall{call}
+		chunks = append(chunks, chunk)
+	}
+	return &fakeStream{chunks: chunks, err: resp.StreamErr}, nil
+}
+
+type fakeStream struct {
+	chunks []openai.ChatCompletionStreamResponse
+	err    error
+	closed bool
+}
+
+func (s *fakeStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if s.closed {
+		return openai.ChatCompletionStreamResponse{}, errors.New("fake provider: stream is closed")
+	}
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return openai.ChatCompletionStreamResponse{}, s.err
+		}
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *fakeStream) Close() error {
+	s.closed = true
+	return nil
+}
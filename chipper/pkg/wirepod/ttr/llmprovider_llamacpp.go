@@ -0,0 +1,126 @@
+package wirepod_ttr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/sashabaranov/go-openai"
+)
+
+// llama.cpp's server, through its /completion endpoint. that one takes a plain prompt,
+// so the chat gets written out as a transcript and the model continues it.
+// no tool calling and no images
+
+type llamaCppProvider struct {
+	endpoint string
+}
+
+type llamaCppRequest struct {
+	Prompt      string   `json:"prompt"`
+	NPredict    int      `json:"n_predict,omitempty"`
+	Temperature float32  `json:"temperature"`
+	TopP        float32  `json:"top_p"`
+	Stop        []string `json:"stop"`
+	Stream      bool     `json:"stream"`
+	CachePrompt bool     `json:"cache_prompt"`
+}
+
+type llamaCppResponse struct {
+	Content string `json:"content"`
+	Stop    bool   `json:"stop"`
+	Error   *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewLlamaCppProvider returns a provider for the llama.cpp server at endpoint. there's no default,
+// llama.cpp's own (http://localhost:8080) is where wire-pod's web interface usually is
+func NewLlamaCppProvider(endpoint string) (KnowledgeProvider, error) {
+	if strings.TrimSpace(endpoint) == "" {
+		return nil, errors.New("llama.cpp needs an endpoint, like http://localhost:8081")
+	}
+	if vars.IsWebServer(endpoint) {
+		return nil, errors.New("the llama.cpp endpoint " + endpoint + " is wire-pod's web interface, start llama.cpp's server on another port (--port 8081)")
+	}
+	return &llamaCppProvider{
+		endpoint: nativeEndpoint(endpoint, ""),
+	}, nil
+}
+
+func (p *llamaCppProvider) Name() string {
+	return "llamacpp"
+}
+
+func (p *llamaCppProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (KnowledgeStream, error) {
+	if len(req.Tools) > 0 {
+		logger.Println("llama.cpp's /completion endpoint can't call tools, the LLM won't get any")
+	}
+	body, err := postLLMRequest(ctx, p.endpoint+"/completion", llamaCppRequest{
+		Prompt:      llamaCppPrompt(req.Messages),
+		NPredict:    req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stop:        []string{"\nUser:", "\nSystem:"},
+		Stream:      true,
+		CachePrompt: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newLlamaCppStream(body), nil
+}
+
+func llamaCppPrompt(msgs []openai.ChatCompletionMessage) string {
+	var prompt strings.Builder
+	for _, msg := range msgs {
+		content := msg.Content
+		for _, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeText {
+				content = strings.TrimSpace(content + " " + part.Text)
+			}
+		}
+		switch msg.Role {
+		case openai.ChatMessageRoleSystem:
+			prompt.WriteString(content + "\n\n")
+		case openai.ChatMessageRoleUser:
+			prompt.WriteString("User: " + content + "\n")
+		case openai.ChatMessageRoleAssistant:
+			prompt.WriteString("Assistant: " + content + "\n")
+		}
+	}
+	prompt.WriteString("Assistant:")
+	return prompt.String()
+}
+
+// server-sent events, a "data: " line per bit of the response
+func newLlamaCppStream(body io.ReadCloser) KnowledgeStream {
+	// the first bit has the space after "Assistant:"
+	first := true
+	return newLineStream(body, func(line []byte) (openai.ChatCompletionStreamResponse, bool, error) {
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			return openai.ChatCompletionStreamResponse{}, false, nil
+		}
+		var lresp llamaCppResponse
+		if err := json.Unmarshal(bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:"))), &lresp); err != nil {
+			return openai.ChatCompletionStreamResponse{}, false, err
+		}
+		if lresp.Error != nil {
+			return openai.ChatCompletionStreamResponse{}, false, errors.New("llama.cpp: " + lresp.Error.Message)
+		}
+		content := lresp.Content
+		if first && content != "" {
+			content = strings.TrimLeft(content, " ")
+			first = false
+		}
+		if content == "" {
+			return openai.ChatCompletionStreamResponse{}, lresp.Stop, nil
+		}
+		return contentResponse(content), lresp.Stop, nil
+	})
+}
@@ -0,0 +1,148 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ollama's native API (/api/chat). the OpenAI-compatible one at /v1 works through "custom" too,
+// but this one takes images, keeps the model loaded and reports errors properly
+
+const defaultOllamaEndpoint = "http://localhost:11434"
+
+const defaultOllamaModel = "llama3"
+
+type ollamaProvider struct {
+	endpoint string
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Tools    []openai.Tool          `json:"tools,omitempty"`
+	Stream   bool                   `json:"stream"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error"`
+}
+
+// NewOllamaProvider returns a provider for the ollama server at endpoint (http://localhost:11434 if empty)
+func NewOllamaProvider(endpoint string) KnowledgeProvider {
+	return &ollamaProvider{
+		endpoint: nativeEndpoint(endpoint, defaultOllamaEndpoint),
+	}
+}
+
+func (p *ollamaProvider) Name() string {
+	return "ollama"
+}
+
+func (p *ollamaProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (KnowledgeStream, error) {
+	body, err := postLLMRequest(ctx, p.endpoint+"/api/chat", ollamaRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return newOllamaStream(body), nil
+}
+
+func ollamaRequest(req openai.ChatCompletionRequest) ollamaChatRequest {
+	oreq := ollamaChatRequest{
+		Model:  req.Model,
+		Tools:  req.Tools,
+		Stream: true,
+		Options: map[string]interface{}{
+			"temperature": req.Temperature,
+			"top_p":       req.TopP,
+		},
+	}
+	if oreq.Model == "" {
+		oreq.Model = defaultOllamaModel
+	}
+	if req.MaxTokens > 0 {
+		oreq.Options["num_predict"] = req.MaxTokens
+	}
+	for _, msg := range req.Messages {
+		omsg := ollamaMessage{
+			Role:     msg.Role,
+			Content:  msg.Content,
+			ToolName: msg.Name,
+		}
+		for _, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeText {
+				omsg.Content = strings.TrimSpace(omsg.Content + " " + part.Text)
+			} else if part.ImageURL != nil {
+				// ollama wants plain base64, not a data URL
+				_, data, found := strings.Cut(part.ImageURL.URL, "base64,")
+				if found {
+					omsg.Images = append(omsg.Images, data)
+				}
+			}
+		}
+		for _, call := range msg.ToolCalls {
+			var otc ollamaToolCall
+			otc.Function.Name = call.Function.Name
+			json.Unmarshal([]byte(call.Function.Arguments), &otc.Function.Arguments)
+			omsg.ToolCalls = append(omsg.ToolCalls, otc)
+		}
+		oreq.Messages = append(oreq.Messages, omsg)
+	}
+	return oreq
+}
+
+// one JSON object per line. tool calls come whole, not in bits
+func newOllamaStream(body io.ReadCloser) KnowledgeStream {
+	var calls int
+	return newLineStream(body, func(line []byte) (openai.ChatCompletionStreamResponse, bool, error) {
+		var oresp ollamaChatResponse
+		if err := json.Unmarshal(line, &oresp); err != nil {
+			return openai.ChatCompletionStreamResponse{}, false, err
+		}
+		if oresp.Error != "" {
+			return openai.ChatCompletionStreamResponse{}, false, errors.New("ollama: " + oresp.Error)
+		}
+		resp := contentResponse(oresp.Message.Content)
+		for _, otc := range oresp.Message.ToolCalls {
+			args, _ := json.Marshal(otc.Function.Arguments)
+			index := calls
+			calls++
+			resp.Choices[0].Delta.ToolCalls = append(resp.Choices[0].Delta.ToolCalls, openai.ToolCall{
+				Index: &index,
+				ID:    "call_" + strconv.Itoa(index),
+				Type:  openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      otc.Function.Name,
+					Arguments: string(args),
+				},
+			})
+		}
+		if resp.Choices[0].Delta.Content == "" && len(resp.Choices[0].Delta.ToolCalls) == 0 {
+			resp.Choices = nil
+		}
+		return resp, oresp.Done, nil
+	})
+}
@@ -0,0 +1,446 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/sashabaranov/go-openai"
+)
+
+// streams the next scripted response through the sentence splitter, like StreamingKGSim does
+func fakeResponse(t *testing.T, p *FakeProvider) (early []string, split *sentenceSplitter, resp llmResponse) {
+	t.Helper()
+	stream, err := p.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	split = &sentenceSplitter{}
+	resp, err = readLLMResponse(stream, split, func(sentence string) {
+		early = append(early, sentence)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	split.finish()
+	return early, split, resp
+}
+
+func TestSentenceSplitting(t *testing.T) {
+	tests := []struct {
+		name      string
+		deltas    []string
+		early     []string
+		sentences []string
+	}{
+		{
+			name:      "sentence per delta",
+			deltas:    []string{"Hello there.", " How are you?", " I'm great!"},
+			early:     []string{"Hello there.", "How are you?", "I'm great!"},
+			sentences: []string{"Hello there.", "How are you?", "I'm great!"},
+		},
+		{
+			name:      "sentences across deltas",
+			deltas:    []string{"Hel", "lo there", ". How are", " you? I'm", " great"},
+			early:     []string{"Hello there.", "How are you?"},
+			sentences: []string{"Hello there.", "How are you?", "I'm great"},
+		},
+		{
+			name:      "several sentences in one delta",
+			deltas:    []string{"One. Two? Three! Fo", "ur."},
+			early:     []string{"One.", "Two?", "Three!", "Four."},
+			sentences: []string{"One.", "Two?", "Three!", "Four."},
+		},
+		{
+			name:      "ellipsis",
+			deltas:    []string{"Well", "... let me think", "."},
+			early:     []string{"Well...", "let me think."},
+			sentences: []string{"Well...", "let me think."},
+		},
+		{
+			name:      "ellipsis split up",
+			deltas:    []string{"Hmm.", "..", " Okay."},
+			early:     []string{"Hmm.", "Okay."},
+			sentences: []string{"Hmm.", "Okay."},
+		},
+		{
+			name:      "quotes",
+			deltas:    []string{"She said \"hi.\" Then she left."},
+			early:     []string{"She said \"hi.\"", "Then she left."},
+			sentences: []string{"She said \"hi.\"", "Then she left."},
+		},
+		{
+			name:      "special characters",
+			deltas:    []string{"Café & crème #1. ", "Done"},
+			early:     []string{"Cafe  creme 1."},
+			sentences: []string{"Cafe  creme 1.", "Done"},
+		},
+		{
+			name:      "no punctuation",
+			deltas:    []string{"just ", "some words"},
+			sentences: []string{"just some words"},
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &FakeProvider{Script: []FakeResponse{{Deltas: test.deltas}}}
+			early, split, resp := fakeResponse(t, p)
+			if !reflect.DeepEqual(early, test.early) {
+				t.Errorf("sentences while streaming = %q, want %q", early, test.early)
+			}
+			if !reflect.DeepEqual(split.sentences, test.sentences) {
+				t.Errorf("sentences = %q, want %q", split.sentences, test.sentences)
+			}
+			if resp.Text != strings.Join(test.deltas, "") {
+				t.Errorf("text = %q, want the deltas as they came", resp.Text)
+			}
+		})
+	}
+}
+
+func TestToolCallsFromStream(t *testing.T) {
+	p := &FakeProvider{Script: []FakeResponse{{
+		Deltas: []string{"Looking up."},
+		ToolCalls: []openai.ToolCall{
+			{ID: "call_0", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "move_head", Arguments: `{"angle":`}},
+			{ID: "call_1", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "set_eye_color", Arguments: `{"color":"blue"}`}},
+		},
+	}}}
+	_, split, resp := fakeResponse(t, p)
+	if len(resp.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2", len(resp.ToolCalls))
+	}
+	if resp.ToolCalls[1].Function.Name != "set_eye_color" || resp.ToolCalls[1].Function.Arguments != `{"color":"blue"}` {
+		t.Errorf("second tool call = %+v", resp.ToolCalls[1])
+	}
+	if split.text() != "Looking up." {
+		t.Errorf("text = %q", split.text())
+	}
+}
+
+// the sentences are said while the stream is still being read, like in StreamingKGSim. run with -race
+func TestSentencesWhileStreaming(t *testing.T) {
+	var deltas []string
+	for i := 0; i < 200; i++ {
+		deltas = append(deltas, fmt.Sprintf("Sentence %d. ", i))
+	}
+	p := &FakeProvider{Script: []FakeResponse{{Deltas: deltas}}}
+	stream, err := p.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	split := &sentenceSplitter{}
+	go func() {
+		defer stream.Close()
+		readLLMResponse(stream, split, nil)
+		split.finish()
+		split.setDone(true)
+	}()
+	said := 0
+	for {
+		sentences, done := split.snapshot()
+		if said < len(sentences) {
+			if sentences[said] != fmt.Sprintf("Sentence %d.", said) {
+				t.Fatalf("sentence %d is %q", said, sentences[said])
+			}
+			said++
+			continue
+		}
+		if done {
+			break
+		}
+	}
+	if said != len(deltas) || split.text() == "" {
+		t.Errorf("said %d sentences, want %d", said, len(deltas))
+	}
+}
+
+func TestFakeProvider(t *testing.T) {
+	failed := errors.New("no")
+	p := &FakeProvider{Script: []FakeResponse{
+		{Err: failed},
+		{Deltas: []string{"Half a sen"}, StreamErr: failed},
+	}}
+	req := openai.ChatCompletionRequest{Model: "fake-model"}
+	if _, err := p.CreateChatCompletionStream(context.Background(), req); err != failed {
+		t.Errorf("first request: err = %v, want %v", err, failed)
+	}
+	stream, err := p.CreateChatCompletionStream(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readLLMResponse(stream, &sentenceSplitter{}, nil); err != failed {
+		t.Errorf("stream: err = %v, want %v", err, failed)
+	}
+	if _, err := p.CreateChatCompletionStream(context.Background(), req); err == nil {
+		t.Error("expected an error once the script is done")
+	}
+	if len(p.Requests) != 3 || p.Requests[0].Model != "fake-model" {
+		t.Errorf("requests = %+v", p.Requests)
+	}
+}
+
+func TestRemember(t *testing.T) {
+	oldDir, oldSaveChat, oldCompact := vars.MemoryDir, vars.APIConfig.Knowledge.SaveChat, compactMemoryAsync
+	defer func() {
+		vars.MemoryDir, vars.APIConfig.Knowledge.SaveChat, compactMemoryAsync = oldDir, oldSaveChat, oldCompact
+		providerOverride = nil
+	}()
+	vars.MemoryDir = t.TempDir()
+	vars.APIConfig.Knowledge.SaveChat = true
+	var compactErr error
+	compactMemoryAsync = func(esn string) {
+		compactErr = CompactMemory(esn)
+	}
+	summarizer := &FakeProvider{Script: []FakeResponse{{Deltas: []string{"The user asked ", "a lot of questions."}}}}
+	providerOverride = summarizer
+
+	var script []FakeResponse
+	for i := 1; i <= 13; i++ {
+		script = append(script, FakeResponse{Deltas: []string{"Answer ", fmt.Sprint(i), "."}})
+	}
+	p := &FakeProvider{Script: script}
+	for i := 1; i <= 13; i++ {
+		_, split, _ := fakeResponse(t, p)
+		Remember(openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: "question " + fmt.Sprint(i),
+		},
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: split.text(),
+			},
+			"00e20100")
+	}
+	if compactErr != nil {
+		t.Fatal(compactErr)
+	}
+	Remember(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "hi"},
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "Other robot."},
+		"00e20200")
+
+	mem, err := vars.GetMemory("00e20100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing gets dropped from the history
+	if len(mem.History) != 26 || mem.History[1].Content != "Answer 1." || mem.History[25].Content != "Answer 13." {
+		t.Fatalf("history has %d messages, want all 26", len(mem.History))
+	}
+	// summarized once 24 messages weren't covered, leaving the last 16 out
+	if mem.Summarized != 8 || mem.Summary != "The user asked a lot of questions." {
+		t.Errorf("summary covers %d messages: %q", mem.Summarized, mem.Summary)
+	}
+	if len(summarizer.Requests) != 1 {
+		t.Fatalf("summarized %d times, want once", len(summarizer.Requests))
+	}
+	transcript := summarizer.Requests[0].Messages[1].Content
+	if !strings.Contains(transcript, "User: question 1\n") || !strings.Contains(transcript, "Answer 4.") || strings.Contains(transcript, "Answer 5.") {
+		t.Errorf("summarized the wrong messages: %q", transcript)
+	}
+	if other, _ := vars.GetMemory("00e20200"); len(other.History) != 2 {
+		t.Error("chats from the two robots got mixed up")
+	}
+	if robots := vars.MemoryRobots(); !reflect.DeepEqual(robots, []string{"00e20100", "00e20200"}) {
+		t.Errorf("robots = %v", robots)
+	}
+
+	DoRememberFact("owner's name: Sam", "00e20100")
+	DoRememberFact("no colon here", "00e20100")
+	aireq := CreateAIReq("what's my name?", "00e20100", false)
+	system := aireq.Messages[0].Content
+	if !strings.Contains(system, "owner's name: Sam") || !strings.Contains(system, "The user asked a lot of questions.") {
+		t.Errorf("system prompt is missing the facts or the summary: %q", system)
+	}
+	// system prompt, the 18 messages the summary doesn't cover, then the question
+	if len(aireq.Messages) != 20 || aireq.Messages[1].Content != "question 5" || aireq.Messages[19].Content != "what's my name?" {
+		t.Errorf("got %d messages, starting with %q", len(aireq.Messages), aireq.Messages[1].Content)
+	}
+
+	file, err := os.ReadFile(filepath.Join(vars.MemoryDir, "00e20100", "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(file), "\n"); lines != 26 {
+		t.Errorf("history.jsonl has %d lines, want 26", lines)
+	}
+}
+
+func TestActionParsing(t *testing.T) {
+	p := &FakeProvider{Script: []FakeResponse{{Deltas: []string{
+		"{{playAnimationWI||happy}} I'm so ha",
+		"ppy to see you! I'll show you.",
+		" {{playAnimation||celebrate}} {{getIm",
+		"age||front}}",
+	}}}}
+	_, split, _ := fakeResponse(t, p)
+	var actions [][]RobotAction
+	for _, sentence := range split.sentences {
+		actions = append(actions, GetActionsFromString(sentence))
+	}
+	want := [][]RobotAction{
+		{
+			{Action: ActionPlayAnimationWI, Parameter: "happy"},
+			{Action: ActionSayText, Parameter: "I'm so happy to see you!"},
+		},
+		{
+			{Action: ActionSayText, Parameter: "I'll show you."},
+		},
+		{
+			{Action: ActionPlayAnimation, Parameter: "celebrate"},
+			{Action: ActionGetImage, Parameter: "front"},
+		},
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions = %+v, want %+v", actions, want)
+	}
+}
+
+func TestActionParsingBadCommands(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []RobotAction
+	}{
+		{"no commands here", []RobotAction{{Action: ActionSayText, Parameter: "no commands here"}}},
+		{"{{makeCoffee||now}} Sorry.", []RobotAction{{Action: ActionSayText, Parameter: "Sorry."}}},
+		{"{{playAnimation}} Hi.", []RobotAction{{Action: ActionPlayAnimation}, {Action: ActionSayText, Parameter: "Hi."}}},
+		{"Unfinished {{playAnim", []RobotAction{{Action: ActionSayText, Parameter: "Unfinished"}, {Action: ActionSayText, Parameter: "playAnim"}}},
+	}
+	for _, test := range tests {
+		got := GetActionsFromString(test.input)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetActionsFromString(%q) = %+v, want %+v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	body := `{"message":{"role":"assistant","content":"Hi"},"done":false}
+{"message":{"role":"assistant","content":" there."},"done":false}
+{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"move_lift","arguments":{"height":1}}}]},"done":false}
+{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}
+`
+	split := &sentenceSplitter{}
+	resp, err := readLLMResponse(newOllamaStream(io.NopCloser(strings.NewReader(body))), split, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Hi there." {
+		t.Errorf("text = %q", resp.Text)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Function.Name != "move_lift" || resp.ToolCalls[0].Function.Arguments != `{"height":1}` {
+		t.Errorf("tool calls = %+v", resp.ToolCalls)
+	}
+
+	_, err = readLLMResponse(newOllamaStream(io.NopCloser(strings.NewReader(`{"error":"model 'llama9' not found"}`+"\n"))), split, nil)
+	if err == nil || !strings.Contains(err.Error(), "llama9") {
+		t.Errorf("err = %v, want ollama's error", err)
+	}
+}
+
+func TestOllamaRequest(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "be nice"},
+			{Role: openai.ChatMessageRoleUser, MultiContent: []openai.ChatMessagePart{
+				{Type: openai.ChatMessagePartTypeText, Text: "what's this?"},
+				{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: "data:image/jpeg;base64,AAAA"}},
+			}},
+			{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+				{ID: "call_0", Function: openai.FunctionCall{Name: "get_image", Arguments: `{"angle":10}`}},
+			}},
+			{Role: openai.ChatMessageRoleTool, Name: "get_image", ToolCallID: "call_0", Content: "done"},
+		},
+		MaxTokens: 100,
+	}
+	oreq := ollamaRequest(req)
+	if oreq.Model != defaultOllamaModel || oreq.Options["num_predict"] != 100 {
+		t.Errorf("model %q, options %v", oreq.Model, oreq.Options)
+	}
+	if oreq.Messages[1].Content != "what's this?" || !reflect.DeepEqual(oreq.Messages[1].Images, []string{"AAAA"}) {
+		t.Errorf("user message = %+v", oreq.Messages[1])
+	}
+	if len(oreq.Messages[2].ToolCalls) != 1 || oreq.Messages[2].ToolCalls[0].Function.Arguments["angle"] != float64(10) {
+		t.Errorf("assistant message = %+v", oreq.Messages[2])
+	}
+	if oreq.Messages[3].Role != "tool" || oreq.Messages[3].ToolName != "get_image" {
+		t.Errorf("tool message = %+v", oreq.Messages[3])
+	}
+}
+
+func TestLlamaCppStream(t *testing.T) {
+	body := `data: {"content":" Sure","stop":false}
+
+data: {"content":", here you go.","stop":false}
+
+data: {"content":"","stop":true,"stopped_eos":true}
+
+`
+	split := &sentenceSplitter{}
+	resp, err := readLLMResponse(newLlamaCppStream(io.NopCloser(strings.NewReader(body))), split, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Sure, here you go." {
+		t.Errorf("text = %q", resp.Text)
+	}
+
+	_, err = readLLMResponse(newLlamaCppStream(io.NopCloser(strings.NewReader(`data: {"error":{"code":500,"message":"context full"}}`+"\n"))), split, nil)
+	if err == nil || !strings.Contains(err.Error(), "context full") {
+		t.Errorf("err = %v, want llama.cpp's error", err)
+	}
+}
+
+func TestLlamaCppPrompt(t *testing.T) {
+	prompt := llamaCppPrompt([]openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "be nice"},
+		{Role: openai.ChatMessageRoleUser, Content: "hi"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "Hello."},
+		{Role: openai.ChatMessageRoleUser, Content: "how are you"},
+	})
+	want := "be nice\n\nUser: hi\nAssistant: Hello.\nUser: how are you\nAssistant:"
+	if prompt != want {
+		t.Errorf("prompt = %q, want %q", prompt, want)
+	}
+}
+
+func TestNativeEndpoint(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                          defaultOllamaEndpoint,
+		"http://192.168.1.5:11434/": "http://192.168.1.5:11434",
+		"http://localhost:11434/v1": "http://localhost:11434",
+	} {
+		if got := nativeEndpoint(in, defaultOllamaEndpoint); got != want {
+			t.Errorf("nativeEndpoint(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLlamaCppEndpoint(t *testing.T) {
+	port := vars.WebPort
+	t.Cleanup(func() { vars.WebPort = port })
+	vars.WebPort = "8080"
+	for endpoint, ok := range map[string]bool{
+		"":                           false,
+		"http://localhost:8080":      false,
+		"http://127.0.0.1:8080/v1":   false,
+		"http://localhost:8081":      true,
+		"http://192.0.2.10:8080":     true,
+		"https://llm.example.com/v1": true,
+	} {
+		if _, err := NewLlamaCppProvider(endpoint); (err == nil) != ok {
+			t.Errorf("%q: err = %v", endpoint, err)
+		}
+	}
+}
@@ -6,35 +6,55 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+	"time"
 
 	pb "github.com/digital-dream-labs/api/go/chipperpb"
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/metrics"
 	"github.com/kercre123/wire-pod/chipper/pkg/vars"
 	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/history"
 )
 
 type systemIntentResponseStruct struct {
 	Status       string `json:"status"`
 	ReturnIntent string `json:"returnIntent"`
+	// slot name, if the response is a question and the answer should come back to this intent
+	Ask      string `json:"ask,omitempty"`
+	Question string `json:"question,omitempty"`
 }
 
 func IntentPass(req interface{}, intentThing string, speechText string, intentParams map[string]string, isParam bool) (interface{}, error) {
 	var esn string
+	var session string
 	var req1 *vtt.IntentRequest
 	var req2 *vtt.IntentGraphRequest
+	var req3 *vtt.TextRequest
+	var req4 *vtt.KnowledgeGraphRequest
 	var isIntentGraph bool
 	if str, ok := req.(*vtt.IntentRequest); ok {
 		req1 = str
 		esn = req1.Device
+		session = req1.Session
 		isIntentGraph = false
 	} else if str, ok := req.(*vtt.IntentGraphRequest); ok {
 		req2 = str
 		esn = req2.Device
+		session = req2.Session
 		isIntentGraph = true
+	} else if str, ok := req.(*vtt.TextRequest); ok {
+		req3 = str
+		esn = req3.Device
+		session = req3.Session
+	} else if str, ok := req.(*vtt.KnowledgeGraphRequest); ok {
+		// a follow-up in a conversation (conversation.go). there is no intent to send, just end the robot's question
+		req4 = str
+		esn = req4.Device
+		session = req4.Session
 	}
 
-	// intercept if not intent graph but intent graph is enabled
-	if !isIntentGraph && vars.APIConfig.Knowledge.IntentGraph && intentThing == "intent_system_unmatched" {
+	// intercept if not intent graph but intent graph is enabled. a dry run shows that it didn't match
+	if req1 != nil && !req1.DryRun && vars.APIConfig.Knowledge.IntentGraph && intentThing == "intent_system_unmatched" {
 		intentThing = "intent_greeting_hello"
 	}
 
@@ -51,10 +71,13 @@ func IntentPass(req interface{}, intentThing string, speechText string, intentPa
 			Action:    intentThing,
 		}
 	}
-	logger.LogUI("Intent matched: " + intentThing + ", transcribed text: '" + speechText + "', device: " + esn)
+	log := logger.With(logger.KeyESN, esn, logger.KeyIntent, intentThing)
 	if isParam {
-		logger.LogUI("Parameters sent: " + fmt.Sprint(intentParams))
+		log = log.With("params", fmt.Sprint(intentParams))
 	}
+	log.Info("Intent matched", logger.UI, "text", speechText)
+	metrics.Intents.Inc(intentThing, esn)
+	history.SetIntent(session, intentThing, intentParams)
 	intent := pb.IntentResponse{
 		IsFinal:      true,
 		IntentResult: &intentResult,
@@ -65,6 +88,32 @@ func IntentPass(req interface{}, intentThing string, speechText string, intentPa
 		IntentResult: &intentResult,
 		CommandType:  pb.RobotMode_VOICE_COMMAND.String(),
 	}
+	if req3 != nil {
+		// text requests get their response returned by the rpc, not streamed
+		intent.Session = req3.Session
+		intent.DeviceId = req3.Device
+		req3.Response = &intent
+		r := &vtt.TextResponse{
+			Intent: &intent,
+		}
+		log.Debug("Text intent result returned")
+		return r, nil
+	}
+	if req4 != nil {
+		kg := pb.KnowledgeGraphResponse{
+			Session:     req4.Session,
+			DeviceId:    req4.Device,
+			CommandType: "NoResultCommand",
+			QueryText:   speechText,
+		}
+		if err := req4.Stream.Send(&kg); err != nil {
+			return nil, err
+		}
+		log.Debug("Knowledge graph response sent")
+		return &vtt.KnowledgeGraphResponse{
+			Intent: &kg,
+		}, nil
+	}
 	if !isIntentGraph {
 		if err := req1.Stream.Send(&intent); err != nil {
 			return nil, err
@@ -72,12 +121,7 @@ func IntentPass(req interface{}, intentThing string, speechText string, intentPa
 		r := &vtt.IntentResponse{
 			Intent: &intent,
 		}
-		logger.Println("Bot " + esn + " Intent Sent: " + intentThing)
-		if isParam {
-			logger.Println("Bot "+esn+" Parameters Sent:", intentParams)
-		} else {
-			logger.Println("No Parameters Sent")
-		}
+		log.Debug("Intent sent")
 		return r, nil
 	} else {
 		if err := req2.Stream.Send(&intentGraphSend); err != nil {
@@ -86,153 +130,228 @@ func IntentPass(req interface{}, intentThing string, speechText string, intentPa
 		r := &vtt.IntentGraphResponse{
 			Intent: &intentGraphSend,
 		}
-		logger.Println("Bot " + esn + " Intent Sent: " + intentThing)
-		if isParam {
-			logger.Println("Bot "+esn+" Parameters Sent:", intentParams)
-		} else {
-			logger.Println("No Parameters Sent")
-		}
+		log.Debug("Intent sent")
 		return r, nil
 	}
 }
 
 func customIntentHandler(req interface{}, voiceText string, botSerial string) bool {
-	var successMatched bool = false
-	if vars.CustomIntentsExist {
-		for _, c := range vars.CustomIntents {
-			for _, v := range c.Utterances {
-				//if strings.Contains(voiceText, strings.ToLower(strings.TrimSpace(v))) {
-				// Check whether the custom sentence is either at the end of the spoken text or space-separated...
-				var seekText = strings.ToLower(strings.TrimSpace(v))
-				// System intents can also match any utterances (*)
-				if (c.IsSystemIntent && strings.HasPrefix(seekText, "*")) ||
-					strings.HasSuffix(voiceText, seekText) || strings.Contains(voiceText, seekText+" ") {
-					logger.Println("Bot " + botSerial + " Custom Intent Matched: " + c.Name + " - " + c.Description + " - " + c.Intent)
-					var intentParams map[string]string
-					var isParam bool = false
-					if c.Params.ParamValue != "" {
-						logger.Println("Bot " + botSerial + " Custom Intent Parameter: " + c.Params.ParamName + " - " + c.Params.ParamValue)
-						intentParams = map[string]string{c.Params.ParamName: c.Params.ParamValue}
-						isParam = true
-					}
-					var args []string
-					for _, arg := range c.ExecArgs {
-						if arg == "!botSerial" {
-							arg = botSerial
-						} else if arg == "!speechText" {
-							arg = "\"" + voiceText + "\""
-						} else if arg == "!intentName" {
-							arg = c.Name
-						} else if arg == "!locale" {
-							arg = vars.APIConfig.STT.Language
-						}
-						args = append(args, arg)
-					}
-					var customIntentExec *exec.Cmd
-					if len(args) == 0 {
-						logger.Println("Bot " + botSerial + " Executing: " + c.Exec)
-						customIntentExec = exec.Command(c.Exec)
-					} else {
-						logger.Println("Bot " + botSerial + " Executing: " + c.Exec + " " + strings.Join(args, " "))
-						customIntentExec = exec.Command(c.Exec, args...)
-					}
-					var out bytes.Buffer
-					var stderr bytes.Buffer
-					customIntentExec.Stdout = &out
-					customIntentExec.Stderr = &stderr
-					err := customIntentExec.Run()
-					if err != nil {
-						fmt.Println(fmt.Sprint(err) + ": " + stderr.String())
-					}
-					logger.Println("Bot " + botSerial + " Custom Intent Exec Output: " + strings.TrimSpace(string(out.String())))
+	for _, c := range vars.CustomIntents() {
+		if customIntentMatches(c, voiceText) && runCustomIntent(req, c, voiceText, botSerial, "") {
+			return true
+		}
+	}
+	return false
+}
 
-					if c.IsSystemIntent {
-						// A system intent returns its output in json format
-						var resp systemIntentResponseStruct
-						err := json.Unmarshal(out.Bytes(), &resp)
-						if err == nil && resp.Status == "ok" {
-							logger.Println("Bot " + botSerial + " System intent parsed and executed successfully")
-							IntentPass(req, resp.ReturnIntent, voiceText, intentParams, isParam)
-							successMatched = true
-						}
-					} else {
-						IntentPass(req, c.Intent, voiceText, intentParams, isParam)
-						successMatched = true
-					}
-					break
-				}
-				if successMatched {
-					break
-				}
+func customIntentMatches(c vars.CustomIntent, voiceText string) bool {
+	for _, v := range c.Utterances {
+		//if strings.Contains(voiceText, strings.ToLower(strings.TrimSpace(v))) {
+		// Check whether the custom sentence is either at the end of the spoken text or space-separated...
+		var seekText = strings.ToLower(strings.TrimSpace(v))
+		// System intents can also match any utterances (*)
+		if (c.IsSystemIntent && strings.HasPrefix(seekText, "*")) ||
+			strings.HasSuffix(voiceText, seekText) || strings.Contains(voiceText, seekText+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// slot is the slot the custom intent asked for last time, if this is the answer
+func runCustomIntent(req interface{}, c vars.CustomIntent, voiceText string, botSerial string, slot string) bool {
+	logger.Info("Custom intent matched", logger.KeyESN, botSerial, logger.KeyIntent, c.Intent, "name", c.Name)
+	var intentParams map[string]string
+	var isParam bool = false
+	if c.Params.ParamValue != "" {
+		logger.Println("Bot " + botSerial + " Custom Intent Parameter: " + c.Params.ParamName + " - " + c.Params.ParamValue)
+		intentParams = map[string]string{c.Params.ParamName: c.Params.ParamValue}
+		isParam = true
+	}
+	out := execCustomIntent(c, voiceText, botSerial, slot)
+
+	if c.IsSystemIntent {
+		// A system intent returns its output in json format
+		var resp systemIntentResponseStruct
+		err := json.Unmarshal(out, &resp)
+		if err == nil && resp.Status == "ok" {
+			logger.Println("Bot " + botSerial + " System intent parsed and executed successfully")
+			if resp.Ask != "" {
+				askSlot(botSerial, PendingSlot{Kind: handlerCustomIntent, Handler: c.Name, Slot: resp.Ask, Question: resp.Question})
 			}
-			if successMatched {
-				break
+			if resp.Question != "" {
+				sayResponse(req, resp.ReturnIntent, voiceText, resp.Question, botSerial)
+			} else {
+				IntentPass(req, resp.ReturnIntent, voiceText, intentParams, isParam)
 			}
+			return true
 		}
+		return false
 	}
-	return successMatched
+	IntentPass(req, c.Intent, voiceText, intentParams, isParam)
+	return true
+}
+
+// runs the custom intent's exec and returns its output
+func execCustomIntent(c vars.CustomIntent, voiceText string, botSerial string, slot string) []byte {
+	var args []string
+	for _, arg := range c.ExecArgs {
+		if arg == "!botSerial" {
+			arg = botSerial
+		} else if arg == "!speechText" {
+			arg = "\"" + voiceText + "\""
+		} else if arg == "!intentName" {
+			arg = c.Name
+		} else if arg == "!locale" {
+			arg = vars.APIConfig.STT.Language
+		} else if arg == "!slot" {
+			arg = slot
+		}
+		args = append(args, arg)
+	}
+	var customIntentExec *exec.Cmd
+	if len(args) == 0 {
+		logger.Println("Bot " + botSerial + " Executing: " + c.Exec)
+		customIntentExec = exec.Command(c.Exec)
+	} else {
+		logger.Println("Bot " + botSerial + " Executing: " + c.Exec + " " + strings.Join(args, " "))
+		customIntentExec = exec.Command(c.Exec, args...)
+	}
+	var out bytes.Buffer
+	var stderr bytes.Buffer
+	customIntentExec.Stdout = &out
+	customIntentExec.Stderr = &stderr
+	err := customIntentExec.Run()
+	if err != nil {
+		fmt.Println(fmt.Sprint(err) + ": " + stderr.String())
+	}
+	logger.Println("Bot " + botSerial + " Custom Intent Exec Output: " + strings.TrimSpace(string(out.String())))
+	return out.Bytes()
 }
 
 func pluginFunctionHandler(req interface{}, voiceText string, botSerial string) bool {
-	matched := false
-	var intent string
-	var igr *vtt.IntentGraphRequest
-	if str, ok := req.(*vtt.IntentGraphRequest); ok {
-		igr = str
-	}
-	var pluginResponse string
-	for num, array := range PluginUtterances {
-		array := array
-		for _, str := range *array {
-			if strings.Contains(voiceText, str) || str == "*" {
-				logger.Println("Bot " + botSerial + " matched plugin " + PluginNames[num] + ", executing function")
-				var guid string
-				var target string
-				for _, bot := range vars.BotInfo.Robots {
-					if bot.Esn == botSerial {
-						guid = bot.GUID
-						target = bot.IPAddress + ":443"
-					}
-				}
-				intent, pluginResponse = PluginFunctions[num](voiceText, botSerial, guid, target)
-				if intent == "" && pluginResponse == "" {
-					break
-				}
-				if intent == "" {
-					intent = "intent_imperative_praise"
-				}
-				logger.Println("Bot " + botSerial + " plugin " + PluginNames[num] + ", response " + pluginResponse)
-				if pluginResponse != "" && igr != nil {
-					response := &pb.IntentGraphResponse{
-						Session:      igr.Session,
-						DeviceId:     igr.Device,
-						ResponseType: pb.IntentGraphMode_KNOWLEDGE_GRAPH,
-						SpokenText:   pluginResponse,
-						QueryText:    voiceText,
-						IsFinal:      true,
-					}
-					igr.Stream.Send(response)
-				} else if pluginResponse != "" {
-					KGSim(botSerial, pluginResponse)
-				} else {
-					IntentPass(req, intent, voiceText, make(map[string]string), false)
-				}
-				matched = true
-				break
-			}
+	for _, p := range Plugins() {
+		if pluginMatches(p, voiceText) && runPlugin(req, p, voiceText, botSerial, "") {
+			return true
+		}
+	}
+	return false
+}
+
+func pluginMatches(p Plugin, voiceText string) bool {
+	for _, str := range *p.Utterances {
+		if strings.Contains(voiceText, str) || str == "*" {
+			return true
 		}
-		if matched {
-			break
+	}
+	return false
+}
+
+// a request replayed from the history (vtt.IntentRequest.DryRun)
+func isDryRun(req interface{}) bool {
+	r, ok := req.(*vtt.IntentRequest)
+	return ok && r.DryRun
+}
+
+// what a dry run would have handed the text to instead of the built-in intents (handlerPlugin and the plugin's name,
+// handlerCustomIntent and the custom intent's, or handlerReminder). they can do anything, so they aren't run
+func dryRunHandler(voiceText string) (kind string, name string) {
+	for _, p := range Plugins() {
+		if pluginMatches(p, voiceText) {
+			return handlerPlugin, p.Name
 		}
 	}
-	return matched
+	for _, c := range vars.CustomIntents() {
+		if customIntentMatches(c, voiceText) {
+			return handlerCustomIntent, c.Name
+		}
+	}
+	if _, _, ok := afterReminderPhrase(voiceText); ok {
+		return handlerReminder, ""
+	}
+	return "", ""
+}
+
+// slot is the slot the plugin asked for last time, if this is the answer
+func runPlugin(req interface{}, p Plugin, voiceText string, botSerial string, slot string) bool {
+	var guid string
+	var target string
+	if bot, ok := vars.BotInfo.Get(botSerial); ok {
+		guid = bot.GUID
+		target = bot.IPAddress + ":443"
+	}
+	var intent, pluginResponse string
+	if slot != "" && p.Continue != nil {
+		logger.Println("Bot " + botSerial + " continuing plugin " + p.Name + " with " + slot)
+		intent, pluginResponse = p.Continue(slot, voiceText, botSerial, guid, target)
+	} else {
+		logger.Println("Bot " + botSerial + " matched plugin " + p.Name + ", executing function")
+		intent, pluginResponse = p.Action(voiceText, botSerial, guid, target)
+	}
+	if intent == "" && pluginResponse == "" {
+		return false
+	}
+	if strings.HasPrefix(intent, AskIntentPrefix) {
+		if p.Continue == nil {
+			logger.Println("Bot " + botSerial + " plugin " + p.Name + " asked a question, but has no Continue func. The answer will go to Action.")
+		}
+		askSlot(botSerial, PendingSlot{Kind: handlerPlugin, Handler: p.Name, Slot: strings.TrimPrefix(intent, AskIntentPrefix), Question: pluginResponse})
+		intent = ""
+	}
+	if intent == "" {
+		intent = "intent_imperative_praise"
+	}
+	logger.Println("Bot " + botSerial + " plugin " + p.Name + ", response " + pluginResponse)
+	if pluginResponse != "" {
+		sayResponse(req, intent, voiceText, pluginResponse, botSerial)
+	} else {
+		IntentPass(req, intent, voiceText, make(map[string]string), false)
+	}
+	return true
+}
+
+// speaks a response from a plugin or custom intent, however the request type allows
+func sayResponse(req interface{}, intent string, voiceText string, response string, botSerial string) {
+	if igr, ok := req.(*vtt.IntentGraphRequest); ok {
+		resp := &pb.IntentGraphResponse{
+			Session:      igr.Session,
+			DeviceId:     igr.Device,
+			ResponseType: pb.IntentGraphMode_KNOWLEDGE_GRAPH,
+			SpokenText:   response,
+			QueryText:    voiceText,
+			IsFinal:      true,
+		}
+		igr.Stream.Send(resp)
+	} else if tr, ok := req.(*vtt.TextRequest); ok {
+		IntentPass(req, intent, voiceText, make(map[string]string), false)
+		AttachKGResponse(tr, voiceText, response)
+	} else {
+		KGSim(botSerial, response)
+	}
+}
+
+// AttachKGResponse embeds a spoken response in the result of a text request
+func AttachKGResponse(req *vtt.TextRequest, queryText string, spokenText string) {
+	if req.Response == nil || req.Response.IntentResult == nil {
+		return
+	}
+	req.Response.IntentResult.Kgresponse = &pb.KnowledgeGraphResponse{
+		Session:    req.Session,
+		DeviceId:   req.Device,
+		QueryText:  queryText,
+		SpokenText: spokenText,
+	}
 }
 
 func ProcessTextAll(req interface{}, voiceText string, intents []vars.JsonIntent, isOpus bool) bool {
+	start := time.Now()
+	// what it ended up as, for the metrics
+	matchedIntent := "unmatched"
 	var botSerial string
 	var req2 *vtt.IntentRequest
 	var req1 *vtt.KnowledgeGraphRequest
 	var req3 *vtt.IntentGraphRequest
+	var req4 *vtt.TextRequest
 	if str, ok := req.(*vtt.IntentRequest); ok {
 		req2 = str
 		botSerial = req2.Device
@@ -242,63 +361,81 @@ func ProcessTextAll(req interface{}, voiceText string, intents []vars.JsonIntent
 	} else if str, ok := req.(*vtt.IntentGraphRequest); ok {
 		req3 = str
 		botSerial = req3.Device
+	} else if str, ok := req.(*vtt.TextRequest); ok {
+		req4 = str
+		botSerial = req4.Device
 	}
-	var matched int = 0
-	var intentNum int = 0
+	defer func() {
+		metrics.ObserveStage(metrics.StageIntent, "", matchedIntent, botSerial, time.Since(start))
+	}()
 	var successMatched bool = false
 	voiceText = strings.ToLower(voiceText)
-	pluginMatched := pluginFunctionHandler(req, voiceText, botSerial)
-	customIntentMatched := customIntentHandler(req, voiceText, botSerial)
+	dryRun := isDryRun(req)
+	if dryRun {
+		// stops at matching, and leaves the robot's dialog as it is
+		if kind, name := dryRunHandler(voiceText); kind != "" {
+			// shown as what matched, like "plugin: weather"
+			handler := kind
+			if name != "" {
+				handler += ": " + name
+			}
+			logger.Println("Bot " + botSerial + " Dry run, not running " + handler)
+			IntentPass(req, handler, voiceText, map[string]string{"": ""}, false)
+			matchedIntent = kind
+			return true
+		}
+	} else if pending, ok := takePendingSlot(botSerial); ok {
+		// the answer to a question a plugin or custom intent asked goes back to it
+		if answerPendingSlot(req, pending, voiceText, botSerial) {
+			matchedIntent = pending.Kind
+			return true
+		}
+	}
+	pluginMatched := !dryRun && pluginFunctionHandler(req, voiceText, botSerial)
+	customIntentMatched := !dryRun && customIntentHandler(req, voiceText, botSerial)
+	if !customIntentMatched && !pluginMatched && !dryRun && reminderHandler(req, voiceText, botSerial) {
+		matchedIntent = handlerReminder
+		return true
+	}
 	if !customIntentMatched && !pluginMatched {
 		logger.Println("Not a custom intent")
-		// Look for a perfect match first
-		for _, b := range intents {
-			for _, c := range b.Keyphrases {
-				if voiceText == strings.ToLower(c) {
-					logger.Println("Bot " + botSerial + " Perfect match for intent " + b.Name + " (" + strings.ToLower(c) + ")")
-					if isOpus {
-						ParamChecker(req, b.Name, voiceText, botSerial)
-					} else {
-						prehistoricParamChecker(req, b.Name, voiceText)
-					}
-					successMatched = true
-					matched = 1
-					break
-				}
+		// score every intent rather than taking the first one which contains a keyphrase
+		best, ok := MatchIntent(voiceText, intents, botSerial)
+		last, hasLast := GetDialog(botSerial)
+		if hasLast && last.LastIntent != "" && isFollowUp(voiceText) && (!ok || best.Intent == last.LastIntent) {
+			logger.Println("Bot " + botSerial + " Follow-up to intent " + last.LastIntent + " (" + last.LastText + ")")
+			best = IntentCandidate{Intent: last.LastIntent}
+			ok = true
+		} else if ok {
+			if best.Exact {
+				logger.Println("Bot " + botSerial + " Perfect match for intent " + best.Intent + " (" + best.Keyphrase + ")")
+			} else {
+				logger.Println("Bot " + botSerial + " Partial match for intent " + best.Intent + " (" + best.Keyphrase + ")")
 			}
-			if matched == 1 {
-				matched = 0
-				break
-			}
-			intentNum = intentNum + 1
+			last.LastParams = nil
 		}
-		// Not found? Then let's be happy with a bare substring search
-		if !successMatched {
-			intentNum = 0
-			matched = 0
-			for _, b := range intents {
-				for _, c := range b.Keyphrases {
-					if strings.Contains(voiceText, strings.ToLower(c)) && !b.RequireExactMatch {
-						logger.Println("Bot " + botSerial + " Partial match for intent " + b.Name + " (" + strings.ToLower(c) + ")")
-						if isOpus {
-							ParamChecker(req, b.Name, voiceText, botSerial)
-						} else {
-							prehistoricParamChecker(req, b.Name, voiceText)
-						}
-						successMatched = true
-						matched = 1
-						break
-					}
-				}
-				if matched == 1 {
-					matched = 0
-					break
-				}
-				intentNum = intentNum + 1
+		if ok {
+			var params map[string]string
+			if isOpus {
+				params = ParamChecker(req, best.Intent, voiceText, botSerial, last.LastParams)
+			} else {
+				params = prehistoricParamChecker(req, best.Intent, voiceText, last.LastParams)
+			}
+			if !dryRun {
+				rememberIntent(botSerial, best.Intent, params, voiceText)
 			}
+			matchedIntent = best.Intent
+			successMatched = true
+		} else if !dryRun {
+			ClearDialog(botSerial)
 		}
 	} else {
 		logger.Println("This is a custom intent or plugin!")
+		if pluginMatched {
+			matchedIntent = handlerPlugin
+		} else {
+			matchedIntent = handlerCustomIntent
+		}
 		successMatched = true
 	}
 	return successMatched
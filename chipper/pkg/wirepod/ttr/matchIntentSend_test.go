@@ -0,0 +1,75 @@
+package wirepod_ttr
+
+import (
+	"testing"
+
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/kercre123/wire-pod/chipper/pkg/vtt"
+	"google.golang.org/grpc"
+)
+
+// stands in for a robot's voice stream, keeping what's sent to it
+type sentIntents struct {
+	grpc.ServerStream
+	sent []*pb.IntentResponse
+}
+
+func (s *sentIntents) Send(resp *pb.IntentResponse) error {
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func (s *sentIntents) Recv() (*pb.StreamingIntentRequest, error) {
+	return nil, nil
+}
+
+func TestDryRun(t *testing.T) {
+	intents := vars.Intents()
+	language := vars.APIConfig.STT.Language
+	pluginsMu.Lock()
+	loaded := plugins
+	ran := false
+	plugins = []Plugin{{
+		Name:       "lights",
+		Utterances: &[]string{"lights on"},
+		Action: func(string, string, string, string) (string, string) {
+			ran = true
+			return "intent_imperative_praise", ""
+		},
+	}}
+	pluginsMu.Unlock()
+	t.Cleanup(func() {
+		vars.SetIntents(intents)
+		vars.APIConfig.STT.Language = language
+		pluginsMu.Lock()
+		plugins = loaded
+		pluginsMu.Unlock()
+		ClearDialog("00e20000")
+	})
+	vars.APIConfig.STT.Language = "en-US"
+	vars.SetIntents([]vars.JsonIntent{{Name: "intent_clock_time", Keyphrases: []string{"what time is it"}}})
+
+	tests := map[string]string{
+		"turn the lights on":             "plugin: lights",
+		"remind me to call mom at 8":     "reminder",
+		"what time is it":                "intent_clock_time",
+		"something nothing is listening": "intent_system_unmatched",
+	}
+	for text, want := range tests {
+		stream := &sentIntents{}
+		req := &vtt.IntentRequest{Stream: stream, Device: "00e20000", Session: "rerun-1", DryRun: true}
+		if !ProcessTextAll(req, text, vars.Intents(), false) {
+			IntentPass(req, "intent_system_unmatched", text, map[string]string{"": ""}, false)
+		}
+		if len(stream.sent) == 0 || stream.sent[len(stream.sent)-1].IntentResult.Action != want {
+			t.Errorf("%q: sent %v, want %s", text, stream.sent, want)
+		}
+	}
+	if ran {
+		t.Error("a dry run ran the plugin")
+	}
+	if _, ok := GetDialog("00e20000"); ok {
+		t.Error("a dry run changed the robot's dialog")
+	}
+}
@@ -0,0 +1,265 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// conversation memory, if Knowledge.SaveChat is set. the store is in vars/memory.go.
+//
+// the LLM gets the facts and a summary of older conversations in its system prompt, then the latest messages as they are.
+// once enough messages pile up past memoryRecentMessages, the older ones get summarized in the background.
+// facts get set in the web interface, or by the LLM itself through the rememberFact tool or command.
+
+// how many of the latest messages go to the LLM as they are
+const memoryRecentMessages = 16
+
+// how many messages past memoryRecentMessages there can be before they get summarized
+const memoryCompactBatch = 8
+
+var compacting = make(map[string]bool)
+var compactingMu sync.Mutex
+
+// replaced in tests, so summaries happen before Remember returns
+var compactMemoryAsync = func(esn string) {
+	go func() {
+		if err := CompactMemory(esn); err != nil {
+			logger.Println("Bot " + esn + " couldn't summarize the chat history: " + err.Error())
+		}
+	}()
+}
+
+func messageText(msg openai.ChatCompletionMessage) string {
+	if msg.Content != "" || len(msg.MultiContent) == 0 {
+		return msg.Content
+	}
+	var parts []string
+	for _, part := range msg.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeText {
+			parts = append(parts, part.Text)
+		} else {
+			parts = append(parts, "[a picture from the camera]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Remember adds what the user said and the LLM's response to the robot's history
+func Remember(user, ai openai.ChatCompletionMessage, esn string) {
+	now := time.Now()
+	err := vars.AppendMemory(esn,
+		vars.MemoryMessage{
+			Role:    user.Role,
+			Content: messageText(user),
+			Time:    now,
+		},
+		vars.MemoryMessage{
+			Role:    ai.Role,
+			Content: messageText(ai),
+			Time:    now,
+		})
+	if err != nil {
+		logger.Println("Bot " + esn + " couldn't save the chat: " + err.Error())
+		return
+	}
+	mem, err := vars.GetMemory(esn)
+	if err == nil && len(mem.History)-mem.Summarized >= memoryRecentMessages+memoryCompactBatch {
+		compactMemoryAsync(esn)
+	}
+}
+
+// CompactMemory summarizes everything but the latest messages into the robot's summary
+func CompactMemory(esn string) error {
+	compactingMu.Lock()
+	if compacting[esn] {
+		compactingMu.Unlock()
+		return nil
+	}
+	compacting[esn] = true
+	compactingMu.Unlock()
+	defer func() {
+		compactingMu.Lock()
+		delete(compacting, esn)
+		compactingMu.Unlock()
+	}()
+
+	mem, err := vars.GetMemory(esn)
+	if err != nil {
+		return err
+	}
+	end := len(mem.History) - memoryRecentMessages
+	if end <= mem.Summarized {
+		return nil
+	}
+	summary, err := summarizeMemory(mem.Summary, mem.History[mem.Summarized:end])
+	if err != nil {
+		return err
+	}
+	logger.Println("Bot " + esn + " summarized " + fmt.Sprint(end-mem.Summarized) + " more messages of chat history")
+	return vars.SetMemorySummary(esn, summary, end)
+}
+
+func summarizeMemory(summary string, msgs []vars.MemoryMessage) (string, error) {
+	robotName := "Vector"
+	if vars.APIConfig.Knowledge.RobotName != "" {
+		robotName = vars.APIConfig.Knowledge.RobotName
+	}
+	if summary == "" {
+		summary = "(nothing yet)"
+	}
+	var transcript []string
+	for _, msg := range msgs {
+		if msg.Role == openai.ChatMessageRoleAssistant {
+			transcript = append(transcript, robotName+": "+msg.Content)
+		} else {
+			transcript = append(transcript, "User: "+msg.Content)
+		}
+	}
+	provider, err := GetKnowledgeProvider()
+	if err != nil {
+		return "", err
+	}
+	stream, err := provider.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+		Model:       knowledgeModel(),
+		MaxTokens:   512,
+		Temperature: 0.3,
+		TopP:        1,
+		Stream:      true,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "You keep the long-term memory of " + robotName + ", a small robot. Rewrite the summary you are given so it also covers the new conversation. Keep anything worth knowing later: names, preferences, plans, and what was talked about. Leave out small talk. Write it in plain sentences, under 150 words. Reply with only the summary.",
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: "Summary so far:\n" + summary + "\n\nNew conversation:\n" + strings.Join(transcript, "\n"),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	resp, err := readLLMResponse(stream, &sentenceSplitter{}, nil)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(resp.Text) == "" {
+		return "", errors.New("llm returned no summary")
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
+
+// what gets added to the system prompt. empty if nothing is remembered
+func memoryPrompt(mem vars.RobotMemory) string {
+	var prompt string
+	if len(mem.Facts) > 0 {
+		var names []string
+		for name := range mem.Facts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		prompt = prompt + "\n\nFacts you have been told to remember:"
+		for _, name := range names {
+			prompt = prompt + "\n" + name + ": " + mem.Facts[name]
+		}
+	}
+	if mem.Summary != "" {
+		prompt = prompt + "\n\nA summary of your earlier conversations with this user: " + mem.Summary
+	}
+	return prompt
+}
+
+// the messages the summary doesn't cover
+func memoryMessages(mem vars.RobotMemory) []openai.ChatCompletionMessage {
+	recent := mem.History[mem.Summarized:]
+	// if summarizing hasn't been working, don't send the whole history
+	if len(recent) > memoryRecentMessages+memoryCompactBatch {
+		recent = recent[len(recent)-memoryRecentMessages:]
+	}
+	var msgs []openai.ChatCompletionMessage
+	for _, msg := range recent {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+	return msgs
+}
+
+// "owner's name: Sam" from the rememberFact command
+func DoRememberFact(param string, esn string) {
+	name, value, found := strings.Cut(param, ":")
+	if !found {
+		logger.Println("LLM tried to remember a fact without a name: " + param)
+		return
+	}
+	if err := vars.SetMemoryFact(esn, name, value); err != nil {
+		logger.Println("Bot " + esn + " couldn't remember a fact: " + err.Error())
+		return
+	}
+	logger.Println("Bot " + esn + " remembered " + strings.TrimSpace(name) + ": " + strings.TrimSpace(value))
+}
+
+var memoryTools = []LLMTool{
+	{
+		Name:        "rememberFact",
+		Description: "Remembers a fact for all future conversations, like the user's name or their favorite color. Use this when the user tells you something about themselves or asks you to remember something. Using a name which already exists replaces the fact.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"name": {
+					Type:        jsonschema.String,
+					Description: "Short name for the fact, like \"owner's name\".",
+				},
+				"value": {
+					Type:        jsonschema.String,
+					Description: "The fact itself, like \"Sam\".",
+				},
+			},
+			Required: []string{"name", "value"},
+		},
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			name, _ := args["name"].(string)
+			value, _ := args["value"].(string)
+			if strings.TrimSpace(value) == "" {
+				return "", errors.New("value is empty")
+			}
+			if err := vars.SetMemoryFact(tc.esn, name, value); err != nil {
+				return "", err
+			}
+			return "remembered", nil
+		},
+	},
+	{
+		Name:        "forgetFact",
+		Description: "Forgets a fact which was remembered with rememberFact.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"name": {
+					Type:        jsonschema.String,
+					Description: "Name of the fact.",
+				},
+			},
+			Required: []string{"name"},
+		},
+		Run: func(tc *toolCallContext, args map[string]interface{}) (string, error) {
+			name, _ := args["name"].(string)
+			if err := vars.SetMemoryFact(tc.esn, name, ""); err != nil {
+				return "", err
+			}
+			return "forgotten", nil
+		},
+	},
+}
@@ -1,81 +1,149 @@
 package wirepod_ttr
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"plugin"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kercre123/wire-pod/chipper/pkg/logger"
 )
 
-var PluginList []*plugin.Plugin
-var PluginUtterances []*[]string
-var PluginFunctions []func(string, string, string, string) (string, string)
-var PluginNames []string
+var PluginsDir = "./plugins"
 
-func LoadPlugins() {
+// Plugin is a Go plugin from PluginsDir
+type Plugin struct {
+	Name       string
+	File       string
+	Utterances *[]string
+	Action     func(string, string, string, string) (string, string)
+	// optional, called with the answer when the plugin asked a question (see dialog.go). nil if the plugin has none
+	Continue func(string, string, string, string, string) (string, string)
+	modTime  time.Time
+}
+
+// swapped by LoadPlugins, never changed in place
+var plugins []Plugin
+var pluginsMu sync.RWMutex
+
+// held while LoadPlugins runs
+var loadPluginsMu sync.Mutex
+
+// Plugins returns the loaded plugins
+func Plugins() []Plugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	return plugins
+}
+
+func pluginByName(name string) (Plugin, bool) {
+	for _, p := range Plugins() {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Plugin{}, false
+}
+
+// LoadPlugins loads the plugins in PluginsDir which haven't been loaded yet, and stops using ones which were removed.
+// Go can't unload a plugin, so one which changed after it was loaded keeps running until wire-pod restarts.
+// it returns the names of the plugins it loaded, and what went wrong with the others
+func LoadPlugins() ([]string, []error) {
+	loadPluginsMu.Lock()
+	defer loadPluginsMu.Unlock()
 	logger.Println("Loading plugins")
-	entries, err := os.ReadDir("./plugins")
+	entries, err := os.ReadDir(PluginsDir)
 	if err != nil {
 		logger.Println("Unable to load plugins:")
 		logger.Println(err)
-		return
+		return nil, []error{err}
 	}
+	old := make(map[string]Plugin)
+	for _, p := range Plugins() {
+		old[p.File] = p
+	}
+	var list []Plugin
+	var loaded []string
+	var errs []error
 	for _, file := range entries {
-		if strings.Contains(file.Name(), ".so") {
-			plugin, err := plugin.Open("./plugins/" + file.Name())
-			if err != nil {
-				logger.Println("Error loading plugin: " + file.Name())
-				logger.Println(err)
-				continue
-			} else {
-				logger.Println("Loading plugin: " + file.Name())
-			}
-			u, err := plugin.Lookup("Utterances")
-			if err != nil {
-				logger.Println("Error loading Utterances []string from plugin file " + file.Name())
-				logger.Println(err)
-				continue
-			} else {
-				if _, ok := u.(*[]string); ok {
-					logger.Println("Utterances []string in plugin " + file.Name() + " are OK")
-				} else {
-					logger.Println("Error: Utterances in plugin " + file.Name() + " are not of type []string")
-					continue
-				}
-			}
-			a, err := plugin.Lookup("Action")
-			if err != nil {
-				logger.Println("Error loading Action func from plugin file " + file.Name())
-				continue
-			} else {
-				if _, ok := a.(func(string, string, string, string) (string, string)); ok {
-					logger.Println("Action func in plugin " + file.Name() + " is OK")
-				} else {
-					logger.Println("Error: Action func in plugin " + file.Name() + " is not of type func(string, string) string")
-					continue
-				}
-			}
-			n, err := plugin.Lookup("Name")
-			if err != nil {
-				logger.Println("Error loading Name string from plugin file " + file.Name())
-				continue
-			} else {
-				if _, ok := n.(*string); ok {
-					logger.Println("Name string in plugin " + *n.(*string) + " is OK")
-				} else {
-					logger.Println("Error: Name string in plugin " + file.Name() + " is not of type string")
-					continue
-				}
+		if !strings.Contains(file.Name(), ".so") {
+			// logger.Println("Not loading " + file.Name() + ". Plugins must be built with 'go build -buildmode=plugin' and must end in '.so'.")
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		if p, ok := old[file.Name()]; ok {
+			if !info.ModTime().Equal(p.modTime) {
+				err := errors.New("plugin " + file.Name() + " changed, restart wire-pod to use the new version")
+				logger.Warn("Plugin changed", logger.UI, "file", file.Name(), "error", err)
+				errs = append(errs, err)
 			}
-			PluginUtterances = append(PluginUtterances, u.(*[]string))
-			PluginFunctions = append(PluginFunctions, a.(func(string, string, string, string) (string, string)))
-			PluginNames = append(PluginNames, *n.(*string))
-			PluginList = append(PluginList, plugin)
-			logger.Println(file.Name() + " loaded successfully")
+			list = append(list, p)
+			continue
+		}
+		p, err := openPlugin(file.Name())
+		if err != nil {
+			logger.Println("Error loading plugin: " + file.Name())
+			logger.Println(err)
+			errs = append(errs, errors.New(file.Name()+": "+err.Error()))
+			continue
+		}
+		p.modTime = info.ModTime()
+		list = append(list, p)
+		loaded = append(loaded, p.Name)
+		logger.Println(file.Name() + " loaded successfully")
+	}
+	pluginsMu.Lock()
+	plugins = list
+	pluginsMu.Unlock()
+	return loaded, errs
+}
+
+func openPlugin(name string) (Plugin, error) {
+	logger.Println("Loading plugin: " + name)
+	plug, err := plugin.Open(filepath.Join(PluginsDir, name))
+	if err != nil {
+		return Plugin{}, err
+	}
+	p := Plugin{File: name}
+	u, err := plug.Lookup("Utterances")
+	if err != nil {
+		return p, errors.New("no Utterances []string: " + err.Error())
+	}
+	if p.Utterances, _ = u.(*[]string); p.Utterances == nil {
+		return p, errors.New("Utterances is not of type []string")
+	}
+	logger.Println("Utterances []string in plugin " + name + " are OK")
+	a, err := plug.Lookup("Action")
+	if err != nil {
+		return p, errors.New("no Action func: " + err.Error())
+	}
+	if p.Action, _ = a.(func(string, string, string, string) (string, string)); p.Action == nil {
+		return p, errors.New("Action func is not of type func(string, string, string, string) (string, string)")
+	}
+	logger.Println("Action func in plugin " + name + " is OK")
+	n, err := plug.Lookup("Name")
+	if err != nil {
+		return p, errors.New("no Name string: " + err.Error())
+	}
+	pluginName, ok := n.(*string)
+	if !ok {
+		return p, errors.New("Name is not of type string")
+	}
+	p.Name = *pluginName
+	logger.Println("Name string in plugin " + p.Name + " is OK")
+	if c, err := plug.Lookup("Continue"); err == nil {
+		if f, ok := c.(func(string, string, string, string, string) (string, string)); ok {
+			logger.Println("Continue func in plugin " + name + " is OK")
+			p.Continue = f
+		} else {
+			logger.Println("Error: Continue func in plugin " + name + " is not of type func(string, string, string, string, string) (string, string), ignoring it")
 		}
-		// else {
-		//	logger.Println("Not loading " + file.Name() + ". Plugins must be built with 'go build -buildmode=plugin' and must end in '.so'.")
-		//}
 	}
+	return p, nil
 }
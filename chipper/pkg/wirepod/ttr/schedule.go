@@ -0,0 +1,192 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	lcztn "github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
+	"github.com/kercre123/wire-pod/chipper/pkg/wirepod/scheduler"
+)
+
+// what a reminder said by voice counts as, for the metrics
+const handlerReminder = "reminder"
+
+// a reminder for a day without a time goes off at this hour
+const reminderDefaultHour = 9
+
+// ScheduleRunner does the scheduler's jobs on the robots
+type ScheduleRunner struct{}
+
+func (ScheduleRunner) Check(action scheduler.Action) error {
+	switch action.Type {
+	case scheduler.ActionAnimation:
+		for _, anim := range animationMap {
+			if anim[0] == action.Value {
+				return nil
+			}
+		}
+		return errors.New("unknown animation " + action.Value)
+	case scheduler.ActionCustomIntent:
+		_, err := scheduledCustomIntent(action.Value)
+		return err
+	}
+	return nil
+}
+
+func (ScheduleRunner) Run(job scheduler.Job) error {
+	switch job.Action.Type {
+	case scheduler.ActionSay:
+		return KGSim(job.ESN, job.Action.Value)
+	case scheduler.ActionCustomIntent:
+		c, err := scheduledCustomIntent(job.Action.Value)
+		if err != nil {
+			return err
+		}
+		execCustomIntent(c, job.Name, job.ESN, "")
+		return nil
+	}
+	robot, err := vars.GetRobot(job.ESN)
+	if err != nil {
+		return err
+	}
+	switch job.Action.Type {
+	case scheduler.ActionAnimation:
+		return DoPlayAnimation(job.Action.Value, robot)
+	case scheduler.ActionCloudIntent:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := robot.Conn.AppIntent(ctx, &vectorpb.AppIntentRequest{Intent: job.Action.Value})
+		return err
+	}
+	return errors.New("unknown action " + job.Action.Type)
+}
+
+func (ScheduleRunner) TimeZone(esn string) *time.Location {
+	return RobotTimeZone(esn)
+}
+
+// the custom intent called name, if it has something to run
+func scheduledCustomIntent(name string) (vars.CustomIntent, error) {
+	for _, c := range vars.CustomIntents() {
+		if c.Name == name {
+			if c.Exec == "" {
+				return c, errors.New("the custom intent " + name + " has no exec")
+			}
+			return c, nil
+		}
+	}
+	return vars.CustomIntent{}, errors.New("no custom intent called " + name)
+}
+
+// "remind me to take out the trash at 8" makes a job which says "reminder: take out the trash" at 8
+func reminderHandler(req interface{}, voiceText string, botSerial string) bool {
+	text, connectives, ok := afterReminderPhrase(voiceText)
+	if !ok {
+		return false
+	}
+	loc := RobotTimeZone(botSerial)
+	now := time.Now().In(loc)
+	when, ok := ParseTimeExpression(text, now)
+	if !ok {
+		logger.Println("Bot " + botSerial + " asked for a reminder without a time: " + voiceText)
+		sayResponse(req, "intent_imperative_praise", voiceText, lcztn.GetText(lcztn.STR_REMINDER_NO_TIME), botSerial)
+		return true
+	}
+	at := when.Time
+	if !when.HasTime {
+		at = at.Add(reminderDefaultHour * time.Hour)
+	}
+	// "remind me at 8 to ..." leaves the "to"
+	what := when.Rest
+	for _, word := range connectives {
+		if rest, found := strings.CutPrefix(what, word+" "); found || what == word {
+			what = rest
+			break
+		}
+	}
+	what = strings.TrimSpace(what)
+	say := lcztn.GetText(lcztn.STR_REMINDER)
+	if what != "" {
+		say += " " + what
+	}
+	job, err := scheduler.Add(scheduler.Job{
+		ESN:    botSerial,
+		Name:   what,
+		At:     at,
+		Action: scheduler.Action{Type: scheduler.ActionSay, Value: say},
+	})
+	if err != nil {
+		logger.Warn("Couldn't set a reminder", logger.UI, logger.KeyESN, botSerial, "error", err)
+		sayResponse(req, "intent_imperative_praise", voiceText, lcztn.GetText(lcztn.STR_REMINDER_FAILED), botSerial)
+		return true
+	}
+	sayResponse(req, "intent_imperative_praise", voiceText, lcztn.GetText(lcztn.STR_REMINDER_SET)+" "+spokenTime(job.Next.In(loc), now), botSerial)
+	return true
+}
+
+// the text without the "remind me" phrase, and the words the longer phrases have after it ("to")
+func afterReminderPhrase(voiceText string) (string, []string, bool) {
+	phrases := lcztn.GetTexts(lcztn.STR_REMIND_ME)
+	padded := " " + voiceText + " "
+	for _, phrase := range phrases {
+		var i int
+		if strings.IndexFunc(phrase, func(r rune) bool { return unicode.Is(unicode.Han, r) }) != -1 {
+			// no spaces around it in Chinese
+			i = strings.Index(padded, phrase)
+		} else {
+			i = strings.Index(padded, " "+phrase+" ")
+			if i != -1 {
+				i++
+			}
+		}
+		if i == -1 {
+			continue
+		}
+		var connectives []string
+		for _, longer := range phrases {
+			if extra, ok := strings.CutPrefix(longer, phrase+" "); ok {
+				connectives = append(connectives, extra)
+			}
+		}
+		text := strings.TrimSpace(padded[:i]) + " " + strings.TrimSpace(padded[i+len(phrase):])
+		return strings.TrimSpace(text), connectives, true
+	}
+	return "", nil, false
+}
+
+// "tomorrow at 08:00", in the words the STT language has
+func spokenTime(t time.Time, now time.Time) string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	days := int(day.Sub(today).Hours()/24 + 0.5)
+	var words []string
+	switch {
+	case days == 0:
+	case days == 1:
+		words = append(words, firstText(lcztn.STR_WEATHER_TOMORROW))
+	case days < 7:
+		words = append(words, firstText(timeWeekdays[t.Weekday()]))
+	default:
+		words = append(words, t.Format("2006-01-02"))
+	}
+	// Chinese has no word for "at"
+	if at := firstText(lcztn.STR_TIME_AT); at != "" {
+		words = append(words, at)
+	}
+	words = append(words, t.Format("15:04"))
+	return strings.Join(words, " ")
+}
+
+// the first way of saying key
+func firstText(key string) string {
+	if texts := lcztn.GetTexts(key); len(texts) > 0 {
+		return texts[0]
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package wirepod_ttr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+func TestReminderPhrase(t *testing.T) {
+	language := vars.APIConfig.STT.Language
+	t.Cleanup(func() { vars.APIConfig.STT.Language = language })
+	tests := []struct {
+		language, text, rest, connectives string
+	}{
+		{"en-US", "remind me to take out the trash at 8", "take out the trash at 8", ""},
+		{"en-US", "remind me at 8 to call mom", "at 8 to call mom", "to"},
+		{"en-US", "please remind me tomorrow", "please tomorrow", "to"},
+		{"de-DE", "erinnere mich morgen an den müll", "morgen an den müll", "daran"},
+		{"zh-CN", "明天下午3点提醒我开会", "明天下午3点 开会", ""},
+	}
+	for _, test := range tests {
+		vars.APIConfig.STT.Language = test.language
+		rest, connectives, ok := afterReminderPhrase(test.text)
+		if !ok || rest != test.rest || strings.Join(connectives, ",") != test.connectives {
+			t.Errorf("%q: got %v %q %v, want %q %v", test.text, ok, rest, connectives, test.rest, test.connectives)
+		}
+	}
+	vars.APIConfig.STT.Language = "en-US"
+	for _, text := range []string{"what's the weather", "do you remember me"} {
+		if _, _, ok := afterReminderPhrase(text); ok {
+			t.Errorf("%q was taken for a reminder", text)
+		}
+	}
+}
+
+func TestSpokenTime(t *testing.T) {
+	language := vars.APIConfig.STT.Language
+	t.Cleanup(func() { vars.APIConfig.STT.Language = language })
+	// a Wednesday
+	now := time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		language string
+		t        time.Time
+		want     string
+	}{
+		{"en-US", time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC), "at 20:00"},
+		{"en-US", time.Date(2024, 5, 2, 8, 5, 0, 0, time.UTC), "tomorrow at 08:05"},
+		{"en-US", time.Date(2024, 5, 4, 9, 0, 0, 0, time.UTC), "saturday at 09:00"},
+		{"en-US", time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC), "2024-06-01 at 09:00"},
+		{"zh-CN", time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC), "明天 08:00"},
+	}
+	for _, test := range tests {
+		vars.APIConfig.STT.Language = test.language
+		if got := spokenTime(test.t, now); got != test.want {
+			t.Errorf("%s %v: got %q, want %q", test.language, test.t, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package wirepod_ttr
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	lcztn "github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
+)
+
+// finds when someone is talking about ("on saturday", "in three hours", "tomorrow at 7 pm") in the STT language.
+// the words are the STR_TIME_* ones in the localization, so every language the intents are in works the same way
+
+// TimeExpression is the time a text talks about
+type TimeExpression struct {
+	// in the time zone of the now it was parsed with
+	Time time.Time
+	// false if only a day was said, then Time is the start of it
+	HasTime bool
+	// the text without the words about the time, so "the weather on saturday in paris" still ends with the place
+	Rest string
+}
+
+// ParseTimeExpression finds the time the text talks about, from now. false if it doesn't talk about one
+func ParseTimeExpression(text string, now time.Time) (TimeExpression, bool) {
+	p := newTimeParser(text)
+	if d, ok := p.relative(); ok {
+		return p.result(now.Add(d), true), true
+	}
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dayGiven := false
+	setDay := func(days int) {
+		day = day.AddDate(0, 0, days)
+		dayGiven = true
+	}
+	if days, ok := p.relativeDays(); ok {
+		setDay(days)
+	}
+	// the longer ones first, "day after tomorrow" has "tomorrow" in it and "por la mañana" has "mañana"
+	partHour := -1
+	evening := false
+	if !dayGiven && p.takeDay(lcztn.STR_WEATHER_THE_DAY_AFTER_TOMORROW) {
+		setDay(2)
+	}
+	if p.takeDay(lcztn.STR_WEATHER_TONIGHT) {
+		partHour, evening = 20, true
+	}
+	parts := []struct {
+		key     string
+		hour    int
+		evening bool
+	}{
+		{lcztn.STR_TIME_MORNING, 9, false},
+		{lcztn.STR_TIME_NOON, 12, false},
+		{lcztn.STR_WEATHER_THIS_AFTERNOON, 14, true},
+		{lcztn.STR_TIME_EVENING, 19, true},
+		// the one that's coming
+		{lcztn.STR_TIME_MIDNIGHT, 24, false},
+	}
+	for _, part := range parts {
+		if partHour == -1 && p.takeDay(part.key) {
+			partHour, evening = part.hour, part.evening
+		}
+	}
+	if !dayGiven {
+		switch {
+		case p.takeDay(lcztn.STR_TIME_NEXT_WEEK):
+			setDay(daysUntil(now.Weekday(), time.Monday, false))
+		case p.takeDay(lcztn.STR_WEATHER_TOMORROW):
+			setDay(1)
+		case p.takeDay(lcztn.STR_TIME_TODAY):
+			setDay(0)
+		case p.takeDay(lcztn.STR_TIME_WEEKEND):
+			if now.Weekday() != time.Sunday {
+				setDay(daysUntil(now.Weekday(), time.Saturday, true))
+			} else {
+				setDay(0)
+			}
+		default:
+			for i, key := range timeWeekdays {
+				if p.takeDay(key) {
+					setDay(daysUntil(now.Weekday(), time.Weekday(i), true))
+					break
+				}
+			}
+		}
+	}
+
+	if hour, minute, meridiem, ok := p.clock(); ok {
+		if meridiem == "" && evening && hour < 12 {
+			hour += 12
+		} else if meridiem == "" && dayGiven && partHour == -1 && hour >= 1 && hour <= 6 {
+			// "on saturday at 3" isn't in the night
+			hour += 12
+		}
+		t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+		if !dayGiven && t.Before(now) {
+			if meridiem == "" && partHour == -1 && hour < 12 && t.Add(12*time.Hour).After(now) {
+				// "at 7" in the afternoon is the evening
+				t = t.Add(12 * time.Hour)
+			} else {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		return p.result(t, true), true
+	}
+	if partHour != -1 {
+		return p.result(time.Date(day.Year(), day.Month(), day.Day(), partHour, 0, 0, 0, now.Location()), true), true
+	}
+	if dayGiven {
+		return p.result(day, false), true
+	}
+	return TimeExpression{}, false
+}
+
+// RobotTimeZone is the time zone the robot is set to, or the server's if it hasn't got one
+func RobotTimeZone(esn string) *time.Location {
+	jdoc, ok := vars.GetJdoc("vic:"+esn, "vic.RobotSettings")
+	if !ok {
+		return time.Local
+	}
+	var settings struct {
+		TimeZone string `json:"time_zone"`
+	}
+	if err := json.Unmarshal([]byte(jdoc.JsonDoc), &settings); err != nil || settings.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(settings.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Sunday first, like time.Weekday
+var timeWeekdays = []string{
+	lcztn.STR_TIME_SUNDAY,
+	lcztn.STR_TIME_MONDAY,
+	lcztn.STR_TIME_TUESDAY,
+	lcztn.STR_TIME_WEDNESDAY,
+	lcztn.STR_TIME_THURSDAY,
+	lcztn.STR_TIME_FRIDAY,
+	lcztn.STR_TIME_SATURDAY,
+}
+
+// days from one weekday to the next one. 0 if it's today and today counts
+func daysUntil(from time.Weekday, to time.Weekday, todayCounts bool) int {
+	days := (int(to) - int(from) + 7) % 7
+	if days == 0 && !todayCounts {
+		days = 7
+	}
+	return days
+}
+
+type timeToken struct {
+	// as it was said
+	text string
+	// lowercase, without punctuation around it
+	word string
+	used bool
+}
+
+type timeParser struct {
+	tokens []timeToken
+}
+
+// "7pm" is "7 pm", "3天" is "3 天", "7:30" stays
+var timeNumberSuffix = regexp.MustCompile(`^(\d{1,2}(?:[:.h]\d{2})?)(\D.*)$`)
+var timeClock = regexp.MustCompile(`^(\d{1,2})[:.h](\d{2})$`)
+
+func newTimeParser(text string) *timeParser {
+	p := &timeParser{}
+	for _, field := range strings.Fields(splitCJK(text)) {
+		if timeClock.MatchString(field) {
+			p.add(field)
+			continue
+		}
+		if m := timeNumberSuffix.FindStringSubmatch(field); m != nil {
+			p.add(m[1])
+			p.add(m[2])
+			continue
+		}
+		p.add(field)
+	}
+	return p
+}
+
+func (p *timeParser) add(text string) {
+	p.tokens = append(p.tokens, timeToken{text: text, word: normalizeTimeWord(text)})
+}
+
+func normalizeTimeWord(text string) string {
+	return strings.TrimFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsPunct(r) && r != '\''
+	})
+}
+
+// every word the parser knows
+func timeVocabulary() []string {
+	keys := []string{lcztn.STR_WEATHER_THE_DAY_AFTER_TOMORROW, lcztn.STR_WEATHER_TONIGHT, lcztn.STR_WEATHER_THIS_AFTERNOON, lcztn.STR_WEATHER_TOMORROW}
+	for _, key := range lcztn.ALL_STR {
+		if strings.HasPrefix(key, "str_time_") {
+			keys = append(keys, key)
+		}
+	}
+	var words []string
+	for _, key := range keys {
+		for _, slot := range strings.Fields(lcztn.GetText(key)) {
+			words = append(words, strings.Split(slot, "|")...)
+		}
+	}
+	return words
+}
+
+// Chinese doesn't always come with spaces (whisper.cpp), so the time words are cut out of it, the longest first
+func splitCJK(text string) string {
+	hasCJK := false
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			hasCJK = true
+			break
+		}
+	}
+	if !hasCJK {
+		return text
+	}
+	var vocabulary []string
+	for _, word := range timeVocabulary() {
+		if strings.IndexFunc(word, func(r rune) bool { return unicode.Is(unicode.Han, r) }) != -1 {
+			vocabulary = append(vocabulary, word)
+		}
+	}
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		longest := ""
+		for _, word := range vocabulary {
+			if len(word) > len(longest) && strings.HasPrefix(text[i:], word) {
+				longest = word
+			}
+		}
+		if longest != "" {
+			out.WriteString(" " + longest + " ")
+			i += len(longest)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if unicode.Is(unicode.Han, r) && i+size < len(text) {
+			// a digit after a character ("下午3点") gets its own token
+			next, _ := utf8.DecodeRuneInString(text[i+size:])
+			if unicode.IsDigit(next) {
+				out.WriteString(text[i:i+size] + " ")
+				i += size
+				continue
+			}
+		}
+		out.WriteString(text[i : i+size])
+		i += size
+	}
+	return out.String()
+}
+
+// whether the phrase's words are the unused tokens from i on. returns how many
+func (p *timeParser) matchAt(i int, phrase string) int {
+	words := strings.Fields(phrase)
+	if len(words) == 0 || i < 0 || i+len(words) > len(p.tokens) {
+		return 0
+	}
+	for j, word := range words {
+		t := p.tokens[i+j]
+		if t.used || t.word != normalizeTimeWord(word) {
+			return 0
+		}
+	}
+	return len(words)
+}
+
+// the length of the first of key's phrases at i, 0 if none are there
+func (p *timeParser) matchKeyAt(i int, key string) int {
+	for _, phrase := range lcztn.GetTexts(key) {
+		if n := p.matchAt(i, phrase); n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// the length of one of key's phrases which ends right before i
+func (p *timeParser) matchKeyBefore(i int, key string) int {
+	for _, phrase := range lcztn.GetTexts(key) {
+		n := len(strings.Fields(phrase))
+		if n > 0 && p.matchAt(i-n, phrase) == n {
+			return n
+		}
+	}
+	return 0
+}
+
+func (p *timeParser) use(from int, n int) {
+	for i := from; i < from+n; i++ {
+		p.tokens[i].used = true
+	}
+}
+
+// takes the first of key's phrases, with the "on", "this", "for" or "at" before it
+func (p *timeParser) takeDay(key string) bool {
+	for i := range p.tokens {
+		if n := p.matchKeyAt(i, key); n > 0 {
+			p.use(i, n)
+			for {
+				before := max(p.matchKeyBefore(i, lcztn.STR_TIME_BEFORE_DAY), p.matchKeyBefore(i, lcztn.STR_TIME_AT))
+				if before == 0 {
+					break
+				}
+				i -= before
+				p.use(i, before)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// a number at i, in digits or words ("7", "twenty five", "dreißig"). returns it and how many tokens it took.
+// strict leaves out the words which are also articles, "a" or "ein"
+func (p *timeParser) number(i int, strict bool) (int, int, bool) {
+	if i < 0 || i >= len(p.tokens) || p.tokens[i].used {
+		return 0, 0, false
+	}
+	word := p.tokens[i].word
+	if n, err := strconv.Atoi(word); err == nil {
+		return n, 1, true
+	}
+	n, ok := localWordToNumber(word, strict)
+	if !ok {
+		return 0, 0, false
+	}
+	// "twenty five", "on bir", "二十 五"
+	if n >= 10 && n%10 == 0 {
+		if i+1 < len(p.tokens) && !p.tokens[i+1].used {
+			if ones, ok := localWordToNumber(p.tokens[i+1].word, true); ok && ones > 0 && ones < 10 {
+				return n + ones, 2, true
+			}
+		}
+	}
+	return n, 1, true
+}
+
+// "in three hours", "3 saat sonra", "через час": minutes and hours from now
+func (p *timeParser) relative() (time.Duration, bool) {
+	units := []struct {
+		key  string
+		unit time.Duration
+	}{
+		{lcztn.STR_TIME_MINUTES, time.Minute},
+		{lcztn.STR_TIME_HOURS, time.Hour},
+	}
+	for _, u := range units {
+		if n, ok := p.takeRelative(u.key); ok {
+			return time.Duration(n) * u.unit, true
+		}
+	}
+	return 0, false
+}
+
+// "in three days", "in a week": days from today
+func (p *timeParser) relativeDays() (int, bool) {
+	if n, ok := p.takeRelative(lcztn.STR_TIME_DAYS); ok {
+		return n, true
+	}
+	if n, ok := p.takeRelative(lcztn.STR_TIME_WEEKS); ok {
+		return n * 7, true
+	}
+	return 0, false
+}
+
+// a number and one of unitKey's words, with an "in" before it or a "later" after it. a unit on its own is one ("через час")
+func (p *timeParser) takeRelative(unitKey string) (int, bool) {
+	for i := range p.tokens {
+		unitLen := p.matchKeyAt(i, unitKey)
+		if unitLen == 0 {
+			continue
+		}
+		// the number is right before the unit
+		n, start := 1, i
+		for j := i - 1; j >= 0 && j >= i-2; j-- {
+			if num, length, ok := p.number(j, false); ok && j+length == i {
+				n, start = num, j
+			}
+		}
+		in := p.matchKeyBefore(start, lcztn.STR_TIME_IN)
+		later := p.matchKeyAt(i+unitLen, lcztn.STR_TIME_LATER)
+		if in == 0 && (later == 0 || start == i) {
+			continue
+		}
+		p.use(start-in, in+(i-start)+unitLen+later)
+		return n, true
+	}
+	return 0, false
+}
+
+// "at 7", "7 pm", "19:30", "um 8 uhr", "下午3点". meridiem is am or pm if it was said
+func (p *timeParser) clock() (hour int, minute int, meridiem string, ok bool) {
+	for i := range p.tokens {
+		if p.tokens[i].used {
+			continue
+		}
+		at := p.matchKeyBefore(i, lcztn.STR_TIME_AT)
+		k := i
+		m := timeClock.FindStringSubmatch(p.tokens[i].word)
+		clockWord := m != nil
+		if clockWord {
+			hour, _ = strconv.Atoi(m[1])
+			minute, _ = strconv.Atoi(m[2])
+			k++
+		} else if n, length, isNumber := p.number(i, true); isNumber {
+			hour, minute = n, 0
+			k += length
+		} else {
+			continue
+		}
+		oclock := p.matchKeyAt(k, lcztn.STR_TIME_OCLOCK)
+		k += oclock
+		// "at seven thirty", "8 uhr 30", "7点30分"
+		if m, length, isNumber := p.number(k, true); isNumber && m < 60 && !clockWord {
+			end := k + length
+			if oclock > 0 || at > 0 || p.matchKeyAt(end, lcztn.STR_TIME_AM) > 0 || p.matchKeyAt(end, lcztn.STR_TIME_PM) > 0 {
+				minute = m
+				k = end + p.matchKeyAt(end, lcztn.STR_TIME_MINUTES)
+			}
+		}
+		meridiem = ""
+		if n := p.matchKeyAt(k, lcztn.STR_TIME_AM); n > 0 {
+			meridiem = "am"
+			k += n
+		} else if n := p.matchKeyAt(k, lcztn.STR_TIME_PM); n > 0 {
+			meridiem = "pm"
+			k += n
+		}
+		if at == 0 && oclock == 0 && meridiem == "" && !clockWord {
+			continue
+		}
+		if hour > 24 || minute > 59 || meridiem != "" && (hour == 0 || hour > 12) {
+			continue
+		}
+		if meridiem == "pm" && hour < 12 {
+			hour += 12
+		} else if meridiem == "am" && hour == 12 {
+			hour = 0
+		}
+		p.use(i-at, k-i+at)
+		return hour, minute, meridiem, true
+	}
+	return 0, 0, "", false
+}
+
+func (p *timeParser) result(t time.Time, hasTime bool) TimeExpression {
+	var rest []string
+	for _, token := range p.tokens {
+		if !token.used {
+			rest = append(rest, token.text)
+		}
+	}
+	return TimeExpression{Time: t, HasTime: hasTime, Rest: strings.Join(rest, " ")}
+}
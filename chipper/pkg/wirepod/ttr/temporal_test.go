@@ -0,0 +1,90 @@
+package wirepod_ttr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+func TestParseTimeExpression(t *testing.T) {
+	language := vars.APIConfig.STT.Language
+	t.Cleanup(func() { vars.APIConfig.STT.Language = language })
+	// a Wednesday afternoon, somewhere the robot is
+	zone := time.FixedZone("robot", -7*3600)
+	now := time.Date(2024, 5, 1, 15, 30, 0, 0, zone)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, zone)
+	}
+	tests := []struct {
+		language string
+		text     string
+		time     time.Time
+		hasTime  bool
+		rest     string
+	}{
+		{"en-US", "what's the weather on saturday in paris", at(4, 0, 0), false, "what's the weather in paris"},
+		{"en-US", "what's the weather in paris on Saturday?", at(4, 0, 0), false, "what's the weather in paris"},
+		{"en-US", "remind me in three hours", at(1, 18, 30), true, "remind me"},
+		{"en-US", "in an hour", at(1, 16, 30), true, ""},
+		{"en-US", "in twenty five minutes", at(1, 15, 55), true, ""},
+		{"en-US", "3 hours from now", at(1, 18, 30), true, ""},
+		{"en-US", "at 7 pm", at(1, 19, 0), true, ""},
+		{"en-US", "at 7pm", at(1, 19, 0), true, ""},
+		// in the afternoon, "at 7" is the evening and "at 2" is tomorrow's night
+		{"en-US", "at 7", at(1, 19, 0), true, ""},
+		{"en-US", "at 2 am", at(2, 2, 0), true, ""},
+		{"en-US", "tomorrow at 7:30 am", at(2, 7, 30), true, ""},
+		{"en-US", "at seven thirty tomorrow", at(2, 7, 30), true, ""},
+		{"en-US", "on friday at 3", at(3, 15, 0), true, ""},
+		{"en-US", "tomorrow morning", at(2, 9, 0), true, ""},
+		{"en-US", "this afternoon", at(1, 14, 0), true, ""},
+		{"en-US", "tonight", at(1, 20, 0), true, ""},
+		{"en-US", "at midnight", at(2, 0, 0), true, ""},
+		{"en-US", "next week", at(6, 0, 0), false, ""},
+		{"en-US", "the day after tomorrow", at(3, 0, 0), false, ""},
+		{"en-US", "this weekend", at(4, 0, 0), false, ""},
+		{"en-US", "today", at(1, 0, 0), false, ""},
+		{"en-US", "wednesday", at(1, 0, 0), false, ""},
+		{"en-US", "in two days at noon", at(3, 12, 0), true, ""},
+		{"en-US", "in a week", at(8, 0, 0), false, ""},
+		{"de-DE", "wie ist das wetter am samstag in berlin", at(4, 0, 0), false, "wie ist das wetter in berlin"},
+		{"de-DE", "in drei stunden", at(1, 18, 30), true, ""},
+		{"de-DE", "morgen um 8 uhr", at(2, 8, 0), true, ""},
+		{"fr-FR", "quel temps fera-t-il demain à 19h30", at(2, 19, 30), true, "quel temps fera-t-il"},
+		{"fr-FR", "dans deux heures", at(1, 17, 30), true, ""},
+		{"es-ES", "mañana por la mañana", at(2, 9, 0), true, ""},
+		{"es-ES", "dentro de tres horas", at(1, 18, 30), true, ""},
+		{"it-IT", "che tempo fa sabato a roma", at(4, 0, 0), false, "che tempo fa a roma"},
+		{"it-IT", "tra due ore", at(1, 17, 30), true, ""},
+		{"ru-RU", "через час", at(1, 16, 30), true, ""},
+		{"ru-RU", "в пятницу", at(3, 0, 0), false, ""},
+		{"zh-CN", "明天下午3点", at(2, 15, 0), true, ""},
+		{"zh-CN", "三个小时后", at(1, 18, 30), true, ""},
+		{"tr-TR", "3 saat sonra", at(1, 18, 30), true, ""},
+		{"tr-TR", "cumartesi", at(4, 0, 0), false, ""},
+		{"pl-PL", "za dwie godziny", at(1, 17, 30), true, ""},
+		{"nt-NL", "over drie uur", at(1, 18, 30), true, ""},
+		{"nt-NL", "zaterdag", at(4, 0, 0), false, ""},
+		{"pt-BR", "daqui a duas horas", at(1, 17, 30), true, ""},
+		{"pt-BR", "amanhã às 8", at(2, 8, 0), true, ""},
+	}
+	for _, test := range tests {
+		vars.APIConfig.STT.Language = test.language
+		got, ok := ParseTimeExpression(test.text, now)
+		if !ok {
+			t.Errorf("%s %q: no time found", test.language, test.text)
+			continue
+		}
+		if !got.Time.Equal(test.time) || got.Time.Location() != zone || got.HasTime != test.hasTime || got.Rest != test.rest {
+			t.Errorf("%s %q: got %v %v %q, want %v %v %q", test.language, test.text, got.Time, got.HasTime, got.Rest, test.time, test.hasTime, test.rest)
+		}
+	}
+
+	vars.APIConfig.STT.Language = "en-US"
+	for _, text := range []string{"what's the weather in paris", "set a timer for ten minutes", "what's the weather at a beach", "hello"} {
+		if got, ok := ParseTimeExpression(text, now); ok {
+			t.Errorf("%q: found %v", text, got.Time)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package wirepod_ttr
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fforchino/vector-go-sdk/pkg/vector"
+	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/metrics"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	"github.com/sashabaranov/go-openai"
+)
+
+// the voice spoken responses are in. vector is the robot's own, which just gets the text.
+// the other engines (piper, an HTTP server, OpenAI) turn the text into audio, which is streamed to the robot with
+// ExternalAudioStreamPlayback. the text is split into sentences, and the next one is synthesized while the one
+// before it plays. synthesized sentences are cached, so the ones which come up again ("reminder: ...") are instant.
+//
+// which voice a robot gets: its own in tts.robot_voices, then the STT language's in tts.language_voices,
+// then tts.provider and tts.voice
+
+const ttsVector = "vector"
+
+// TTSEngine turns text into 16-bit mono PCM
+type TTSEngine interface {
+	Name() string
+	// returns the audio and its sample rate. an empty voice is the engine's default
+	Synthesize(ctx context.Context, text string, voice string) ([]byte, int, error)
+}
+
+// used instead of the one in the config if set, so tests don't need piper or a server
+var ttsEngineOverride TTSEngine
+
+// GetTTSEngine returns the engine for a provider. there is none for vector
+func GetTTSEngine(provider string) (TTSEngine, error) {
+	if ttsEngineOverride != nil {
+		return ttsEngineOverride, nil
+	}
+	tts := vars.APIConfig.TTS
+	switch provider {
+	case "piper":
+		path := tts.PiperPath
+		if path == "" {
+			path = "piper"
+		}
+		return &piperEngine{path: path}, nil
+	case "http":
+		return &httpTTSEngine{endpoint: tts.Endpoint}, nil
+	case "openai":
+		key := tts.Key
+		if key == "" {
+			key = vars.APIConfig.Knowledge.Key
+		}
+		conf := openai.DefaultConfig(key)
+		if v := os.Getenv("OPENAI_BASE"); v != "" {
+			conf.BaseURL = v
+		}
+		return &openAITTSEngine{client: openai.NewClientWithConfig(conf)}, nil
+	}
+	return nil, errors.New("unknown TTS provider " + provider)
+}
+
+// RobotVoice is the voice a robot speaks with
+func RobotVoice(esn string) vars.TTSVoice {
+	tts := vars.APIConfig.TTS
+	if voice, ok := tts.RobotVoices[esn]; ok && voice.Provider != "" {
+		return voice
+	}
+	if voice, ok := tts.LanguageVoices[vars.APIConfig.STT.Language]; ok && voice.Provider != "" {
+		return voice
+	}
+	if tts.Provider != "" {
+		return vars.TTSVoice{Provider: tts.Provider, Voice: tts.Voice}
+	}
+	// how it was before there was a setting
+	if (vars.APIConfig.STT.Language != "en-US" && vars.APIConfig.Knowledge.Provider == "openai") || os.Getenv("USE_OPENAI_VOICE") == "true" {
+		return vars.TTSVoice{Provider: "openai", Voice: vars.APIConfig.Knowledge.OpenAIVoice}
+	}
+	return vars.TTSVoice{Provider: ttsVector}
+}
+
+// speaks the text in the voice, false if it couldn't (nothing was played then), so the robot's voice can say it instead
+func sayWithVoice(robot *vector.Vector, voice vars.TTSVoice, text string) bool {
+	esn := robot.Cfg.SerialNo
+	engine, err := GetTTSEngine(voice.Provider)
+	if err != nil {
+		logger.Warn("Couldn't use the TTS engine, using the robot's voice", logger.KeyESN, esn, "provider", voice.Provider, "error", err)
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	stream, err := robot.Conn.ExternalAudioStreamPlayback(ctx)
+	if err != nil {
+		logger.Warn("Couldn't stream audio to the robot, using its voice", logger.KeyESN, esn, "error", err)
+		return false
+	}
+	played, err := streamSpeech(ctx, stream, engine, voice.Voice, text)
+	if err != nil {
+		logger.Warn("TTS failed", logger.KeyESN, esn, "provider", engine.Name(), "error", err)
+	}
+	if played {
+		metrics.ObserveStage(metrics.StageTTS, engine.Name(), "", esn, time.Since(start))
+	}
+	return played
+}
+
+// what the audio is sent to, the robot's ExternalAudioStreamPlayback
+type audioSink interface {
+	Send(*vectorpb.ExternalAudioStreamRequest) error
+}
+
+// a chunk is 512 samples (32 ms), and they're sent a bit faster than that so the robot doesn't run out
+const (
+	ttsChunkSize     = 1024
+	ttsChunkInterval = 25 * time.Millisecond
+)
+
+type synthesized struct {
+	pcm []byte
+	err error
+}
+
+// sends the text's sentences to the robot as they're synthesized, and waits for it to finish saying them.
+// false if nothing could be played
+func streamSpeech(ctx context.Context, sink audioSink, engine TTSEngine, voice string, text string) (bool, error) {
+	sentences := splitSentences(text)
+	if len(sentences) == 0 {
+		return true, nil
+	}
+	// the next sentence is synthesized while this one plays
+	ready := make(chan synthesized, 1)
+	go func() {
+		defer close(ready)
+		for _, sentence := range sentences {
+			pcm, err := synthesizeCached(ctx, engine, voice, sentence)
+			select {
+			case ready <- synthesized{pcm, err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var played bool
+	var lastErr error
+	// when the robot will be done with what it has been sent
+	var end time.Time
+	for audio := range ready {
+		if audio.err != nil {
+			// the rest might still work
+			lastErr = audio.err
+			continue
+		}
+		if !played {
+			err := sink.Send(&vectorpb.ExternalAudioStreamRequest{
+				AudioRequestType: &vectorpb.ExternalAudioStreamRequest_AudioStreamPrepare{
+					AudioStreamPrepare: &vectorpb.ExternalAudioStreamPrepare{
+						AudioFrameRate: 16000,
+						AudioVolume:    100,
+					},
+				},
+			})
+			if err != nil {
+				return false, err
+			}
+			played = true
+		}
+		if now := time.Now(); end.Before(now) {
+			end = now
+		}
+		end = end.Add(pcmLength(audio.pcm))
+		for _, chunk := range chunkPCM(audio.pcm, ttsChunkSize) {
+			err := sink.Send(&vectorpb.ExternalAudioStreamRequest{
+				AudioRequestType: &vectorpb.ExternalAudioStreamRequest_AudioStreamChunk{
+					AudioStreamChunk: &vectorpb.ExternalAudioStreamChunk{
+						AudioChunkSizeBytes: ttsChunkSize,
+						AudioChunkSamples:   chunk,
+					},
+				},
+			})
+			if err != nil {
+				return played, err
+			}
+			time.Sleep(ttsChunkInterval)
+		}
+	}
+	if !played {
+		return false, lastErr
+	}
+	err := sink.Send(&vectorpb.ExternalAudioStreamRequest{
+		AudioRequestType: &vectorpb.ExternalAudioStreamRequest_AudioStreamComplete{
+			AudioStreamComplete: &vectorpb.ExternalAudioStreamComplete{},
+		},
+	})
+	time.Sleep(time.Until(end) + 50*time.Millisecond)
+	if err != nil {
+		return true, err
+	}
+	return true, lastErr
+}
+
+// a sentence ends at punctuation followed by a space (so 3.5 isn't two), or at Chinese punctuation
+var ttsSentence = regexp.MustCompile(`(?s).+?(?:[.?!]+["']?(?:\s+|$)|[。？！]+|$)`)
+
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, sentence := range ttsSentence.FindAllString(text, -1) {
+		if sentence = strings.TrimSpace(sentence); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+	return sentences
+}
+
+// PrefetchSpeech synthesizes the text in the robot's voice ahead of time, so it's in the cache when it's said
+func PrefetchSpeech(esn string, text string) {
+	voice := RobotVoice(esn)
+	if voice.Provider == ttsVector {
+		return
+	}
+	engine, err := GetTTSEngine(voice.Provider)
+	if err != nil {
+		return
+	}
+	for _, sentence := range splitSentences(text) {
+		go synthesizeCached(context.Background(), engine, voice.Voice, sentence)
+	}
+}
+
+// synthesizes what the actions will say
+func prefetchActions(esn string, actions []RobotAction) {
+	for _, action := range actions {
+		if action.Action == ActionSayText {
+			PrefetchSpeech(esn, action.Parameter)
+		}
+	}
+}
+
+// recently said sentences, as 16 kHz PCM ready for the robot
+var ttsCache = &speechCache{entries: make(map[string]*list.Element), order: list.New()}
+
+const defaultTTSCacheSize = 100
+
+type speechCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// most recently used first
+	order *list.List
+}
+
+type speechCacheEntry struct {
+	key string
+	// closed once pcm and err are set, so a sentence being synthesized isn't synthesized twice
+	done chan struct{}
+	pcm  []byte
+	err  error
+}
+
+func synthesizeCached(ctx context.Context, engine TTSEngine, voice string, text string) ([]byte, error) {
+	key := engine.Name() + "\x00" + voice + "\x00" + text
+	c := ttsCache
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		entry := elem.Value.(*speechCacheEntry)
+		select {
+		case <-entry.done:
+			return entry.pcm, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	entry := &speechCacheEntry{key: key, done: make(chan struct{})}
+	c.entries[key] = c.order.PushFront(entry)
+	size := vars.APIConfig.TTS.CacheSize
+	if size == 0 {
+		size = defaultTTSCacheSize
+	}
+	for c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*speechCacheEntry).key)
+	}
+	c.mu.Unlock()
+
+	// not ctx, the sentence is still wanted in the cache if whoever asked for it is gone
+	synthCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pcm, rate, err := engine.Synthesize(synthCtx, text, voice)
+	if err == nil {
+		pcm, err = prepareSpeech(pcm, rate)
+	}
+	entry.pcm, entry.err = pcm, err
+	close(entry.done)
+	if err != nil {
+		// so it's tried again next time
+		c.mu.Lock()
+		if elem, ok := c.entries[key]; ok && elem.Value == entry {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	return pcm, err
+}
+
+// ClearSpeechCache empties the cache, for when the voices change
+func ClearSpeechCache() {
+	ttsCache.mu.Lock()
+	defer ttsCache.mu.Unlock()
+	ttsCache.entries = make(map[string]*list.Element)
+	ttsCache.order.Init()
+}
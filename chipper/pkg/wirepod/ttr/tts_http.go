@@ -0,0 +1,55 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// any server which returns a WAV for GET ?text=&voice=&language=, like OpenTTS, MaryTTS's or a Coqui TTS server
+// behind a small proxy. the language is the STT language (en-US)
+
+type httpTTSEngine struct {
+	endpoint string
+}
+
+func (h *httpTTSEngine) Name() string {
+	return "http"
+}
+
+func (h *httpTTSEngine) Synthesize(ctx context.Context, text string, voice string) ([]byte, int, error) {
+	u, err := url.Parse(h.endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, 0, errors.New("the TTS endpoint isn't an http:// or https:// URL: " + h.endpoint)
+	}
+	query := u.Query()
+	query.Set("text", text)
+	if voice != "" {
+		query.Set("voice", voice)
+	}
+	query.Set("language", vars.APIConfig.STT.Language)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New("the TTS server returned " + strconv.Itoa(resp.StatusCode) + ": " + strings.TrimSpace(string(body)))
+	}
+	return parseWAV(body)
+}
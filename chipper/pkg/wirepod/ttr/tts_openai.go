@@ -0,0 +1,38 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"io"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenAI's speech API. its PCM is 24 kHz
+
+const openAISpeechSampleRate = 24000
+
+type openAITTSEngine struct {
+	client *openai.Client
+}
+
+func (o *openAITTSEngine) Name() string {
+	return "openai"
+}
+
+func (o *openAITTSEngine) Synthesize(ctx context.Context, text string, voice string) ([]byte, int, error) {
+	if voice == "" {
+		voice = string(openai.VoiceFable)
+	}
+	resp, err := o.client.CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model:          openai.TTSModel1,
+		Input:          text,
+		Voice:          openai.SpeechVoice(voice),
+		ResponseFormat: openai.SpeechResponseFormatPcm,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Close()
+	pcm, err := io.ReadAll(resp)
+	return pcm, openAISpeechSampleRate, err
+}
@@ -0,0 +1,56 @@
+package wirepod_ttr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Piper (github.com/rhasspy/piper), run locally. the voice is the path to a model (.onnx),
+// with its config (.onnx.json) next to it
+
+const piperDefaultSampleRate = 22050
+
+type piperEngine struct {
+	path string
+}
+
+func (p *piperEngine) Name() string {
+	return "piper"
+}
+
+func (p *piperEngine) Synthesize(ctx context.Context, text string, voice string) ([]byte, int, error) {
+	if voice == "" {
+		return nil, 0, errors.New("piper needs a voice model (.onnx)")
+	}
+	cmd := exec.CommandContext(ctx, p.path, "--model", voice, "--output-raw")
+	cmd.Stdin = strings.NewReader(text)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, 0, errors.New("piper: " + err.Error() + ": " + strings.TrimSpace(stderr.String()))
+	}
+	return out.Bytes(), piperSampleRate(voice), nil
+}
+
+// the model's config says what sample rate it speaks at
+func piperSampleRate(model string) int {
+	data, err := os.ReadFile(model + ".json")
+	if err != nil {
+		return piperDefaultSampleRate
+	}
+	var config struct {
+		Audio struct {
+			SampleRate int `json:"sample_rate"`
+		} `json:"audio"`
+	}
+	if json.Unmarshal(data, &config) != nil || config.Audio.SampleRate <= 0 {
+		return piperDefaultSampleRate
+	}
+	return config.Audio.SampleRate
+}
@@ -0,0 +1,233 @@
+package wirepod_ttr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fforchino/vector-go-sdk/pkg/vectorpb"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// a tone, at the sample rate
+func tone(samples int, rate int) []byte {
+	pcm := make([]int16, samples)
+	for i := range pcm {
+		pcm[i] = int16(3000 * math.Sin(2*math.Pi*440*float64(i)/float64(rate)))
+	}
+	return int16sToBytes(pcm)
+}
+
+func wav(pcm []byte, rate int, channels int) []byte {
+	var b bytes.Buffer
+	write := func(v interface{}) { binary.Write(&b, binary.LittleEndian, v) }
+	b.WriteString("RIFF")
+	write(uint32(36 + len(pcm)))
+	b.WriteString("WAVE")
+	// a chunk which isn't read, with an odd size
+	b.WriteString("LIST")
+	write(uint32(3))
+	b.WriteString("abc\x00")
+	b.WriteString("fmt ")
+	write(uint32(16))
+	write(uint16(1))
+	write(uint16(channels))
+	write(uint32(rate))
+	write(uint32(rate * channels * 2))
+	write(uint16(channels * 2))
+	write(uint16(16))
+	b.WriteString("data")
+	write(uint32(len(pcm)))
+	b.Write(pcm)
+	return b.Bytes()
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := map[string][]string{
+		"Hello there. It's 3.5 degrees!  Bye": {"Hello there.", "It's 3.5 degrees!", "Bye"},
+		"Really?! \"Yes.\" Okay...":           {"Really?!", "\"Yes.\"", "Okay..."},
+		"你好。今天天气很好！":                          {"你好。", "今天天气很好！"},
+		"   ":                                 nil,
+		"no punctuation":                      {"no punctuation"},
+	}
+	for text, want := range tests {
+		if got := splitSentences(text); strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("%q: got %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestParseWAV(t *testing.T) {
+	// stereo, with the right channel silent
+	stereo := make([]int16, 200)
+	for i := 0; i < len(stereo); i += 2 {
+		stereo[i] = 1000
+	}
+	pcm, rate, err := parseWAV(wav(int16sToBytes(stereo), 22050, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := bytesToInt16s(pcm)
+	if rate != 22050 || len(samples) != 100 || samples[0] != 500 {
+		t.Errorf("got %d samples at %d Hz, the first is %d", len(samples), rate, samples[0])
+	}
+	if _, _, err := parseWAV([]byte("ID3 this is an mp3")); err == nil {
+		t.Error("an mp3 was taken for a WAV")
+	}
+
+	// resampled to 16 kHz, and louder
+	speech, err := prepareSpeech(tone(2400, 24000), 24000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peak int16
+	for _, s := range bytesToInt16s(speech) {
+		peak = max(peak, s)
+	}
+	if len(speech) != 1600*2 || peak < 10000 {
+		t.Errorf("24 kHz became %d samples, peaking at %d", len(speech)/2, peak)
+	}
+	if chunks := chunkPCM(speech, ttsChunkSize); len(chunks) != 4 || len(chunks[3]) != ttsChunkSize {
+		t.Errorf("%d chunks", len(chunks))
+	}
+}
+
+func TestRobotVoice(t *testing.T) {
+	config := vars.APIConfig
+	t.Cleanup(func() { vars.APIConfig = config })
+	t.Setenv("USE_OPENAI_VOICE", "")
+	vars.APIConfig.STT.Language = "de-DE"
+	vars.APIConfig.Knowledge.Provider = "openai"
+	vars.APIConfig.Knowledge.OpenAIVoice = "nova"
+	vars.APIConfig.TTS.Provider = ""
+	vars.APIConfig.TTS.LanguageVoices = nil
+	vars.APIConfig.TTS.RobotVoices = nil
+	// like before there was a setting
+	if v := RobotVoice("00e20000"); v.Provider != "openai" || v.Voice != "nova" {
+		t.Errorf("without a setting, got %+v", v)
+	}
+	vars.APIConfig.TTS.Provider = "vector"
+	if v := RobotVoice("00e20000"); v.Provider != "vector" {
+		t.Errorf("with the robot's voice set, got %+v", v)
+	}
+	vars.APIConfig.TTS.LanguageVoices = map[string]vars.TTSVoice{"de-DE": {Provider: "piper", Voice: "de_DE-thorsten-medium.onnx"}}
+	vars.APIConfig.TTS.RobotVoices = map[string]vars.TTSVoice{"00e20001": {Provider: "http", Voice: "larynx"}}
+	if v := RobotVoice("00e20000"); v.Provider != "piper" || v.Voice != "de_DE-thorsten-medium.onnx" {
+		t.Errorf("with a voice for the language, got %+v", v)
+	}
+	if v := RobotVoice("00e20001"); v.Provider != "http" || v.Voice != "larynx" {
+		t.Errorf("with a voice for the robot, got %+v", v)
+	}
+}
+
+type fakeTTSEngine struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeTTSEngine) Name() string {
+	return "fake"
+}
+
+func (f *fakeTTSEngine) Synthesize(ctx context.Context, text string, voice string) ([]byte, int, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, text)
+	f.mu.Unlock()
+	if strings.Contains(text, "fail") {
+		return nil, 0, errors.New("can't say that")
+	}
+	// a tenth of a second
+	return tone(2205, 22050), 22050, nil
+}
+
+type fakeSink struct {
+	prepared, chunks, completed int
+}
+
+func (f *fakeSink) Send(req *vectorpb.ExternalAudioStreamRequest) error {
+	switch {
+	case req.GetAudioStreamPrepare() != nil:
+		f.prepared++
+	case req.GetAudioStreamChunk() != nil:
+		f.chunks++
+	case req.GetAudioStreamComplete() != nil:
+		f.completed++
+	}
+	return nil
+}
+
+func TestStreamSpeech(t *testing.T) {
+	ClearSpeechCache()
+	t.Cleanup(ClearSpeechCache)
+	engine := &fakeTTSEngine{}
+	ctx := context.Background()
+
+	sink := &fakeSink{}
+	played, err := streamSpeech(ctx, sink, engine, "", "Hello there. How are you?")
+	if !played || err != nil {
+		t.Fatalf("played %v, %v", played, err)
+	}
+	// 1600 samples a sentence is 4 chunks
+	if sink.prepared != 1 || sink.chunks != 8 || sink.completed != 1 {
+		t.Errorf("sent %+v", sink)
+	}
+	// said again, from the cache
+	if _, err := streamSpeech(ctx, &fakeSink{}, engine, "", "How are you?"); err != nil {
+		t.Fatal(err)
+	}
+	if len(engine.calls) != 2 {
+		t.Errorf("synthesized %q", engine.calls)
+	}
+
+	// the sentence which fails is left out, and tried again next time
+	sink = &fakeSink{}
+	played, err = streamSpeech(ctx, sink, engine, "", "Hello there. This will fail.")
+	if !played || err == nil || sink.chunks != 4 {
+		t.Errorf("with a failed sentence: played %v, %v, %+v", played, err, sink)
+	}
+	if played, _ := streamSpeech(ctx, &fakeSink{}, engine, "", "This will fail."); played {
+		t.Error("nothing could be synthesized, but it played")
+	}
+	if len(engine.calls) != 4 {
+		t.Errorf("synthesized %q", engine.calls)
+	}
+}
+
+func TestHTTPTTSEngine(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		if r.URL.Query().Get("voice") == "missing" {
+			http.Error(w, "no such voice", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write(wav(tone(100, 16000), 16000, 1))
+	}))
+	t.Cleanup(srv.Close)
+	language := vars.APIConfig.STT.Language
+	t.Cleanup(func() { vars.APIConfig.STT.Language = language })
+	vars.APIConfig.STT.Language = "fr-FR"
+
+	engine := &httpTTSEngine{endpoint: srv.URL + "/api/tts?format=wav"}
+	pcm, rate, err := engine.Synthesize(context.Background(), "bonjour à tous", "siwis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 16000 || len(pcm) != 200 {
+		t.Errorf("got %d bytes at %d Hz", len(pcm), rate)
+	}
+	if query != "format=wav&language=fr-FR&text=bonjour+%C3%A0+tous&voice=siwis" {
+		t.Errorf("asked for %s", query)
+	}
+	if _, _, err := engine.Synthesize(context.Background(), "bonjour", "missing"); err == nil || !strings.Contains(err.Error(), "no such voice") {
+		t.Errorf("got %v for a missing voice", err)
+	}
+}
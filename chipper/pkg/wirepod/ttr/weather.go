@@ -1,16 +1,18 @@
 package wirepod_ttr
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
-	"net/url"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -19,80 +21,159 @@ import (
 	lcztn "github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
 )
 
-/* TODO:
-Create seperate functions for weatherAPI and openweathermap,
-create a standard for how weather functions should be created
-*/
+// the weather for intent_weather_extend. every provider (weather_*.go) gives back the same WeatherReport,
+// and getWeather turns it into the parameters the robot wants.
 
-// *** WEATHERAPI.COM ***
+// WeatherConditions is the weather at one time, or over a whole day
+type WeatherConditions struct {
+	// in the place's time zone. midnight for days
+	Time time.Time `json:"time"`
+	// what the robot can show: Sunny, Stars, Cloudy, Rain, Snow, Thunderstorms or Windy
+	Condition string `json:"condition"`
+	// what the provider called it
+	Description string `json:"description"`
+	// the high for days
+	Temperature float64 `json:"temperature"`
+	// only for days
+	High float64 `json:"high,omitempty"`
+	Low  float64 `json:"low,omitempty"`
+	// percent
+	PrecipitationChance int `json:"precipitation_chance"`
+	// km/h for C, mph for F. the strongest it gets for days
+	WindSpeed float64 `json:"wind_speed"`
+	// degrees, where the wind comes from
+	WindDirection int `json:"wind_direction"`
+}
 
-type weatherAPIResponseStruct struct {
-	Location struct {
-		Name      string `json:"name"`
-		Localtime string `json:"localtime"`
-	} `json:"location"`
-	Current struct {
-		LastUpdatedEpoch int     `json:"last_updated_epoch"`
-		LastUpdated      string  `json:"last_updated"`
-		TempC            float64 `json:"temp_c"`
-		TempF            float64 `json:"temp_f"`
-		Condition        struct {
-			Text string `json:"text"`
-			Icon string `json:"icon"`
-			Code int    `json:"code"`
-		} `json:"condition"`
-	} `json:"current"`
+// WeatherReport is the weather for a place, now and coming up
+type WeatherReport struct {
+	// the place, the way it should be said
+	Location string `json:"location"`
+	// F or C
+	Unit    string            `json:"unit"`
+	Current WeatherConditions `json:"current"`
+	// the forecast in the provider's steps (an hour, or three for OpenWeatherMap), as far as it goes
+	Hours []WeatherConditions `json:"hours,omitempty"`
+	// today first
+	Days []WeatherConditions `json:"days,omitempty"`
 }
-type weatherAPICladStruct []struct {
-	APIValue string `json:"APIValue"`
-	CladType string `json:"CladType"`
+
+// At returns the forecast for t: the hour it's in if the forecast goes that far, otherwise its day.
+// false if the forecast doesn't go out to t
+func (r WeatherReport) At(t time.Time) (WeatherConditions, bool) {
+	for i, hour := range r.Hours {
+		step := 3 * time.Hour
+		if i+1 < len(r.Hours) {
+			step = r.Hours[i+1].Time.Sub(hour.Time)
+		}
+		if !t.Before(hour.Time) && t.Before(hour.Time.Add(step)) {
+			return hour, true
+		}
+	}
+	for _, day := range r.Days {
+		y1, m1, d1 := day.Time.Date()
+		y2, m2, d2 := t.In(day.Time.Location()).Date()
+		if y1 == y2 && m1 == m2 && d1 == d2 {
+			return day, true
+		}
+	}
+	return WeatherConditions{}, false
+}
+
+// WeatherProvider gets the weather for a place
+type WeatherProvider interface {
+	Name() string
+	// unit is F or C. days is how many days of forecast to get, today included
+	GetWeather(ctx context.Context, location string, unit string, days int) (WeatherReport, error)
+}
+
+// used instead of the one in the config if set, so tests don't go over the network
+var weatherProviderOverride WeatherProvider
+
+// GetWeatherProvider returns the provider set in the config
+func GetWeatherProvider() (WeatherProvider, error) {
+	if weatherProviderOverride != nil {
+		return weatherProviderOverride, nil
+	}
+	conf := vars.APIConfig.Weather
+	if !conf.Enable {
+		return nil, errors.New("weather isn't enabled")
+	}
+	switch conf.Provider {
+	case "weatherapi.com":
+		if conf.Key == "" {
+			return nil, errors.New("weatherapi.com needs an API key")
+		}
+		return &weatherAPIProvider{key: conf.Key, baseURL: "https://api.weatherapi.com/v1"}, nil
+	case "openweathermap.org":
+		if conf.Key == "" {
+			return nil, errors.New("openweathermap.org needs an API key")
+		}
+		return &openWeatherMapProvider{key: conf.Key, baseURL: "https://api.openweathermap.org"}, nil
+	case "open-meteo.com":
+		return &openMeteoProvider{geocodeURL: "https://geocoding-api.open-meteo.com/v1/search", forecastURL: "https://api.open-meteo.com/v1/forecast"}, nil
+	}
+	return nil, errors.New("unknown weather provider " + conf.Provider)
 }
 
-// *** OPENWEATHERMAP.ORG ***
+// how far ahead the forecast can be asked for, today included. the providers give back fewer days if they don't go that far
+const weatherForecastMaxDays = 14
+
+var weatherClient = &http.Client{Timeout: 10 * time.Second}
 
-type openWeatherMapAPIGeoCodingStruct struct {
-	Name       string            `json:"name"`
-	LocalNames map[string]string `json:"local_names"`
-	Lat        float64           `json:"lat"`
-	Lon        float64           `json:"lon"`
-	Country    string            `json:"country"`
-	State      string            `json:"state"`
+// gets a provider's JSON response into v
+func getWeatherJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := weatherClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > 200 {
+			msg = msg[:200]
+		}
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return json.Unmarshal(body, v)
 }
 
-/*
-//3.0 API, requires your credit card even to get 1k free requests per day
+// *** the robot's conditions ***
 
-type openWeatherMapAPIResponseStruct struct {
-    Lat      			float64 `json:"lat"`
-	Lon					float64 `json:"lon"`
-	timezone		  	string `json:"timezone"`
-	timezone_offset	  	string `json:"timezone_offset"`
-	Current struct {
-		DT	 	 	int     `json:"dt"`
-		Sunrise	 	int     `json:"sunrise"`
-		Sunset	 	int     `json:"sunset"`
-		Temp	    float64 `json:"temp"`
-		FeelsLike   float64 `json:"feels_like"`
-		Pressure	int     `json:"pressure"`
-		Humidity	int     `json:"humidity"`
-		DewPoint	float64 `json:"dew_point"`
-		UVI	        float64 `json:"uvi"`
-		Clouds	 	int     `json:"clouds"`
-		Visibility	int     `json:"visibility"`
-		WindSpeed	float64 `json:"wind_speed"`
-		WindDeg	 	int     `json:"wid_deg"`
-		WindGust	float64 `json:"wind_gust"`
-		Weather        struct {
-			Id	 		int    `json:"id"`
-			Main 		string `json:"main"`
-			Description string `json:"description"`
-			Icon 		string `json:"icon"`
-		} `json:"weather"`
-	} `json:"current"`
+type weatherAPICladStruct []struct {
+	APIValue string `json:"APIValue"`
+	CladType string `json:"CladType"`
 }
-*/
 
-//2.5 API
+var weatherMap map[string]string
+var weatherMapOnce sync.Once
+
+// the robot's condition for one of weatherapi.com's (weather-map.json). ok is false if it isn't in there
+func cladCondition(description string) (condition string, ok bool) {
+	weatherMapOnce.Do(func() {
+		mapPath := "./weather-map.json"
+		if runtime.GOOS == "android" || runtime.GOOS == "ios" {
+			mapPath = vars.AndroidPath + "/static/weather-map.json"
+		}
+		var cladMap weatherAPICladStruct
+		jsonFile, _ := os.ReadFile(mapPath)
+		json.Unmarshal(jsonFile, &cladMap)
+		weatherMap = make(map[string]string)
+		for _, b := range cladMap {
+			weatherMap[strings.ToLower(b.APIValue)] = b.CladType
+		}
+	})
+	condition, ok = weatherMap[strings.ToLower(strings.TrimSpace(description))]
+	return condition, ok
+}
 
 func removeEndPunctuation(s string) string {
 	if s == "" {
@@ -109,275 +190,85 @@ func removeEndPunctuation(s string) string {
 	return s
 }
 
-type WeatherStruct struct {
-	Id          int    `json:"id"`
-	Main        string `json:"main"`
-	Description string `json:"description"`
-	Icon        string `json:"icon"`
-}
-
-type openWeatherMapAPIResponseStruct struct {
-	Coord struct {
-		Lat float64 `json:"lat"`
-		Lon float64 `json:"lon"`
-	} `json:"coord"`
-	Weather []WeatherStruct `json:"weather"`
-	Base    string          `json:"base"`
-	Main    struct {
-		Temp      float64 `json:"temp"`
-		FeelsLike float64 `json:"feels_like"`
-		TempMin   float64 `json:"temp_min"`
-		TempMax   float64 `json:"temp_max"`
-		Pressure  int     `json:"pressure"`
-		Humidity  int     `json:"humidity"`
-	} `json:"main"`
-	Visibility int `json:"visibility"`
-	Wind       struct {
-		Speed float64 `json:"speed"`
-		Deg   int     `json:"deg"`
-	} `json:"wind"`
-	Clouds struct {
-		All int `json:"all"`
-	} `json:"clouds"`
-	DT  int `json:"dt"`
-	Sys struct {
-		Type    int    `json:"type"`
-		Id      int    `json:"id"`
-		Country string `json:"country"`
-		Sunrise int    `json:"sunrise"`
-		Sunset  int    `json:"sunset"`
-	} `json:"sys"`
-	Timezone int    `json:"timezone"`
-	Id       int    `json:"id"`
-	Name     string `json:"name"`
-	Cod      int    `json:"cod"`
-}
-
-type openWeatherMapForecastAPIResponseStruct struct {
-	Cod     string                            `json:"cod"`
-	Message int                               `json:"message"`
-	Cnt     int                               `json:"cnt"`
-	List    []openWeatherMapAPIResponseStruct `json:"list"`
+// the units the robot is set to win over the config
+func weatherUnit(botUnits string) string {
+	if botUnits == "F" || botUnits == "C" {
+		return botUnits
+	}
+	if vars.APIConfig.Weather.Unit == "C" {
+		return "C"
+	}
+	return "F"
 }
 
-func getWeather(location string, botUnits string, hoursFromNow int) (string, string, string, string, string, string) {
-	var weatherEnabled bool
-	var condition string
-	var is_forecast string
-	var local_datetime string
-	var speakable_location_string string
-	var temperature string
-	var temperature_unit string
-	weatherAPIEnabled := vars.APIConfig.Weather.Enable
-	weatherAPIKey := vars.APIConfig.Weather.Key
-	weatherAPIUnit := vars.APIConfig.Weather.Unit
-	weatherAPIProvider := vars.APIConfig.Weather.Provider
-	if weatherAPIEnabled && weatherAPIKey != "" {
-		weatherEnabled = true
-		logger.Println("Weather API enabled")
-	} else {
-		weatherEnabled = false
-		logger.Println("Weather API not enabled, using placeholder")
-		if weatherAPIEnabled && weatherAPIKey == "" {
-			logger.Println("Weather API enabled, but Weather API key not set")
-		}
+// the weather at when, or now if it's zero
+func getWeather(location string, botUnits string, when time.Time) (string, string, string, string, string, string) {
+	provider, err := GetWeatherProvider()
+	if err != nil {
+		logger.Println("Weather API not enabled, using placeholder: " + err.Error())
+		// preferably local time in UTC ISO 8601 format ("2022-06-15 12:21:22.123")
+		return "Snow", "false", "test", location, "120", "C"
 	}
-	if weatherEnabled {
-		if botUnits != "" {
-			if botUnits == "F" {
-				logger.Println("Weather units set to F")
-				weatherAPIUnit = "F"
-			} else if botUnits == "C" {
-				logger.Println("Weather units set to C")
-				weatherAPIUnit = "C"
-			}
-		} else if weatherAPIUnit != "F" && weatherAPIUnit != "C" {
-			logger.Println("Weather API unit not set, using F")
-			weatherAPIUnit = "F"
+	unit := weatherUnit(botUnits)
+	now := time.Now()
+	days := 1
+	if when.After(now) {
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, when.Location())
+		days = int(when.Sub(today).Hours()/24) + 1
+	}
+	if days > weatherForecastMaxDays {
+		logger.Println("Can't get the weather for " + when.Format("2006-01-02") + ", it's too far ahead")
+		return "undefined", "false", "test", location, "120", "C"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	report, err := provider.GetWeather(ctx, location, unit, days)
+	if err != nil {
+		logger.Println("Couldn't get the weather from " + provider.Name() + ": " + err.Error())
+		// "test" makes the robot say it couldn't get the weather
+		return "undefined", "false", "test", location, "120", "C"
+	}
+	weather := report.Current
+	if when.After(now) {
+		var ok bool
+		weather, ok = report.At(when)
+		if !ok {
+			logger.Println("The forecast from " + provider.Name() + " doesn't go out to " + when.Format("2006-01-02 15:04"))
+			return "undefined", "false", "test", location, "120", "C"
 		}
 	}
+	logger.Println("Weather for " + report.Location + " (" + provider.Name() + "): " + weather.Condition + " (" + weather.Description + "), " + strconv.FormatFloat(weather.Temperature, 'f', 1, 64) + report.Unit)
+	temperature := strconv.Itoa(int(math.Round(weather.Temperature)))
+	return weather.Condition, "false", weather.Time.Format("2006-01-02 15:04:05"), report.Location, temperature, report.Unit
+}
 
-	if weatherEnabled {
-		if weatherAPIProvider == "weatherapi.com" {
-			params := url.Values{}
-			params.Add("key", weatherAPIKey)
-			params.Add("q", location)
-			params.Add("aqi", "no")
-			url := "http://api.weatherapi.com/v1/current.json"
-			resp, err := http.PostForm(url, params)
-			if err != nil {
-				panic(err)
-			}
-			defer resp.Body.Close()
-			body, _ := io.ReadAll(resp.Body)
-			weatherResponse := string(body)
-			var weatherAPICladMap weatherAPICladStruct
-			mapPath := ""
-			if runtime.GOOS == "android" || runtime.GOOS == "ios" {
-				mapPath = vars.AndroidPath + "/static/weather-map.json"
-			} else {
-				mapPath = "./weather-map.json"
-			}
-			jsonFile, _ := os.ReadFile(mapPath)
-			json.Unmarshal(jsonFile, &weatherAPICladMap)
-			var weatherStruct weatherAPIResponseStruct
-			json.Unmarshal([]byte(weatherResponse), &weatherStruct)
-			var matchedValue bool
-			for _, b := range weatherAPICladMap {
-				if b.APIValue == weatherStruct.Current.Condition.Text {
-					condition = b.CladType
-					logger.Println("API Value: " + b.APIValue + ", Clad Type: " + b.CladType)
-					matchedValue = true
-					break
-				}
-			}
-			if !matchedValue {
-				condition = weatherStruct.Current.Condition.Text
-			}
-			is_forecast = "false"
-			local_datetime = weatherStruct.Current.LastUpdated
-			speakable_location_string = weatherStruct.Location.Name
-			if weatherAPIUnit == "C" {
-				temperature = strconv.Itoa(int(weatherStruct.Current.TempC))
-				temperature_unit = "C"
-			} else {
-				temperature = strconv.Itoa(int(weatherStruct.Current.TempF))
-				temperature_unit = "F"
-			}
-		} else if weatherAPIProvider == "openweathermap.org" {
-			// First use geocoding api to convert location into coordinates
-			// E.G. http://api.openweathermap.org/geo/1.0/direct?q={city name},{state code},{country code}&limit={limit}&appid={API key}
-			url := "http://api.openweathermap.org/geo/1.0/direct?q=" + url.QueryEscape(location) + "&limit=1&appid=" + weatherAPIKey
-			resp, err := http.Get(url)
-			if err != nil {
-				logger.Println(err)
-			}
-			defer resp.Body.Close()
-			body, _ := io.ReadAll(resp.Body)
-			geoCodingResponse := string(body)
-
-			var geoCodingInfoStruct []openWeatherMapAPIGeoCodingStruct
-
-			err = json.Unmarshal([]byte(geoCodingResponse), &geoCodingInfoStruct)
-			if err != nil {
-				logger.Println(err)
-				logger.Println("Geolocation API error: " + geoCodingResponse)
-			}
-			if len(geoCodingInfoStruct) == 0 {
-				logger.Println("Geo provided no response.")
-				condition = "undefined"
-				is_forecast = "false"
-				local_datetime = "test"              // preferably local time in UTC ISO 8601 format ("2022-06-15 12:21:22.123")
-				speakable_location_string = location // preferably the processed location
-				temperature = "120"
-				temperature_unit = "C"
-				return condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit
-			}
-			Lat := fmt.Sprintf("%f", geoCodingInfoStruct[0].Lat)
-			Lon := fmt.Sprintf("%f", geoCodingInfoStruct[0].Lon)
-
-			logger.Println("Lat: " + Lat + ", Lon: " + Lon)
-			logger.Println("Name: " + geoCodingInfoStruct[0].Name)
-			logger.Println("Country: " + geoCodingInfoStruct[0].Country)
-
-			// Now that we have Lat and Lon, let's query the weather
-			units := "metric"
-			if weatherAPIUnit == "F" {
-				units = "imperial"
-			}
-			if hoursFromNow == 0 {
-				url = "https://api.openweathermap.org/data/2.5/weather?lat=" + Lat + "&lon=" + Lon + "&units=" + units + "&appid=" + weatherAPIKey
-			} else {
-				url = "https://api.openweathermap.org/data/2.5/forecast?lat=" + Lat + "&lon=" + Lon + "&units=" + units + "&appid=" + weatherAPIKey
-			}
-			resp, err = http.Get(url)
-			if err != nil {
-				panic(err)
-			}
-			defer resp.Body.Close()
-			body, _ = io.ReadAll(resp.Body)
-			weatherResponse := string(body)
-			var openWeatherMapAPIResponse openWeatherMapAPIResponseStruct
-
-			if hoursFromNow > 0 {
-				// Forecast request: free API results are returned in 3 hours slots
-				var openWeatherMapForecastAPIResponse openWeatherMapForecastAPIResponseStruct
-				err = json.Unmarshal([]byte(weatherResponse), &openWeatherMapForecastAPIResponse)
-				openWeatherMapAPIResponse = openWeatherMapForecastAPIResponse.List[hoursFromNow/3]
-			} else {
-				// Current weather request
-				err = json.Unmarshal([]byte(weatherResponse), &openWeatherMapAPIResponse)
-			}
-
-			if err != nil {
-				panic(err)
-			}
-
-			conditionCode := openWeatherMapAPIResponse.Weather[0].Id
-			logger.Println(conditionCode)
-
-			if conditionCode < 300 {
-				// Thunderstorm
-				condition = "Thunderstorms"
-			} else if conditionCode < 400 {
-				// Drizzle
-				condition = "Rain"
-			} else if conditionCode < 600 {
-				// Rain
-				condition = "Rain"
-			} else if conditionCode < 700 {
-				// Snow
-				condition = "Snow"
-			} else if conditionCode < 800 {
-				// Athmosphere
-				if openWeatherMapAPIResponse.Weather[0].Main == "Mist" ||
-					openWeatherMapAPIResponse.Weather[0].Main == "Fog" {
-					condition = "Rain"
-				} else {
-					condition = "Windy"
-				}
-			} else if conditionCode == 800 {
-				// Clear
-				if openWeatherMapAPIResponse.DT < openWeatherMapAPIResponse.Sys.Sunset {
-					condition = "Sunny"
-				} else {
-					condition = "Stars"
-				}
-			} else if conditionCode < 900 {
-				// Cloud
-				condition = "Cloudy"
-			} else {
-				condition = openWeatherMapAPIResponse.Weather[0].Main
-			}
-
-			is_forecast = "false"
-			t := time.Unix(int64(openWeatherMapAPIResponse.DT), 0)
-			local_datetime = t.Format(time.RFC850)
-			logger.Println(local_datetime)
-			speakable_location_string = openWeatherMapAPIResponse.Name
-			temperature = fmt.Sprintf("%f", math.Round(openWeatherMapAPIResponse.Main.Temp))
-			if weatherAPIUnit == "C" {
-				temperature_unit = "C"
-			} else {
-				temperature_unit = "F"
-			}
+// when the weather is asked for, and the text without the words that said so. zero is now
+func weatherTime(speechText string, now time.Time) (time.Time, string) {
+	if when, ok := ParseTimeExpression(speechText, now); ok {
+		if !when.HasTime {
+			// a day's weather is what it's like in the morning
+			return when.Time.Add(9 * time.Hour), when.Rest
 		}
-	} else {
-		condition = "Snow"
-		is_forecast = "false"
-		local_datetime = "test"              // preferably local time in UTC ISO 8601 format ("2022-06-15 12:21:22.123")
-		speakable_location_string = location // preferably the processed location
-		temperature = "120"
-		temperature_unit = "C"
+		return when.Time, when.Rest
 	}
-	return condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit
+	if strings.Contains(speechText, lcztn.GetText(lcztn.STR_WEATHER_FORECAST)) {
+		return time.Date(now.Year(), now.Month(), now.Day()+1, 9, 0, 0, 0, now.Location()), speechText
+	}
+	return time.Time{}, speechText
 }
 
-func weatherParser(speechText string, botLocation string, botUnits string) (string, string, string, string, string, string) {
+// whether a place is named in the text, not counting the "in" of "in three hours"
+func mentionsLocation(speechText string) bool {
+	_, rest := weatherTime(speechText, time.Now())
+	return strings.Contains(rest, lcztn.GetText(lcztn.STR_WEATHER_IN))
+}
+
+// loc is the robot's time zone, which "tomorrow" and "at 7" are in
+func weatherParser(speechText string, botLocation string, botUnits string, loc *time.Location) (string, string, string, string, string, string) {
 	var specificLocation bool
 	var apiLocation string
 	var speechLocation string
-	var hoursFromNow int
+	when, speechText := weatherTime(speechText, time.Now().In(loc))
 	if strings.Contains(speechText, lcztn.GetText(lcztn.STR_WEATHER_IN)) {
 		splitPhrase := strings.SplitAfter(removeEndPunctuation(speechText), lcztn.GetText(lcztn.STR_WEATHER_IN))
 		speechLocation = strings.TrimSpace(splitPhrase[1])
@@ -402,23 +293,11 @@ func weatherParser(speechText string, botLocation string, botUnits string) (stri
 		logger.Println("No location parsed from speech")
 		specificLocation = false
 	}
-	hoursFromNow = 0
-	hours, _, _ := time.Now().Clock()
-	if strings.Contains(speechText, lcztn.GetText(lcztn.STR_WEATHER_THIS_AFTERNOON)) {
-		if hours < 14 {
-			hoursFromNow = 14 - hours
-		}
-	} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_WEATHER_TONIGHT)) {
-		if hours < 20 {
-			hoursFromNow = 20 - hours
-		}
-	} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_WEATHER_THE_DAY_AFTER_TOMORROW)) {
-		hoursFromNow = 24 - hours + 24 + 9
-	} else if strings.Contains(speechText, lcztn.GetText(lcztn.STR_WEATHER_FORECAST)) ||
-		strings.Contains(speechText, lcztn.GetText(lcztn.STR_WEATHER_TOMORROW)) {
-		hoursFromNow = 24 - hours + 9
+	if when.IsZero() {
+		logger.Println("Looking for the current weather...")
+	} else {
+		logger.Println("Looking for the forecast for " + when.Format("2006-01-02 15:04 MST") + "...")
 	}
-	logger.Println("Looking for forecast " + strconv.Itoa(hoursFromNow) + " hours from now...")
 
 	if specificLocation {
 		apiLocation = speechLocation
@@ -426,6 +305,6 @@ func weatherParser(speechText string, botLocation string, botUnits string) (stri
 		apiLocation = botLocation
 	}
 	// call to weather API
-	condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit := getWeather(apiLocation, botUnits, hoursFromNow)
+	condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit := getWeather(apiLocation, botUnits, when)
 	return condition, is_forecast, local_datetime, speakable_location_string, temperature, temperature_unit
 }
@@ -0,0 +1,72 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a weather provider which answers from a fixture, for tests. nothing goes over the network
+
+// FakeWeatherProvider answers with the report in Reports for the location
+type FakeWeatherProvider struct {
+	// by location, lowercased. the dates are moved so the current weather is from today
+	Reports map[string]WeatherReport
+	// every location it was asked about, in order
+	Requests []string
+	mu       sync.Mutex
+}
+
+// LoadFakeWeatherProvider reads the reports from a JSON file (a map of locations to reports)
+func LoadFakeWeatherProvider(path string) (*FakeWeatherProvider, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &FakeWeatherProvider{}
+	if err := json.Unmarshal(file, &p.Reports); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *FakeWeatherProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeWeatherProvider) GetWeather(ctx context.Context, location string, unit string, days int) (WeatherReport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Requests = append(p.Requests, location)
+	report, ok := p.Reports[strings.ToLower(location)]
+	if !ok {
+		return WeatherReport{}, errors.New("fake weather provider: no report for " + location)
+	}
+	if report.Unit != unit {
+		return WeatherReport{}, errors.New("fake weather provider: the report for " + location + " is in " + report.Unit)
+	}
+	// whole days, so the hours and days still line up
+	then := report.Current.Time
+	now := time.Now().In(then.Location())
+	shift := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, then.Location()).Sub(time.Date(then.Year(), then.Month(), then.Day(), 0, 0, 0, 0, then.Location()))
+	moved := report
+	moved.Current.Time = report.Current.Time.Add(shift)
+	moved.Hours = nil
+	for _, hour := range report.Hours {
+		hour.Time = hour.Time.Add(shift)
+		moved.Hours = append(moved.Hours, hour)
+	}
+	moved.Days = nil
+	for i, day := range report.Days {
+		if i == days {
+			break
+		}
+		day.Time = day.Time.Add(shift)
+		moved.Days = append(moved.Days, day)
+	}
+	return moved, nil
+}
@@ -0,0 +1,86 @@
+package wirepod_ttr
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+// OpenWeatherMap and Open-Meteo need coordinates, so a place has to be looked up before its weather.
+// places don't move, so every lookup is kept in GeocodeCachePath and only done once.
+// the providers share it, a place found by one is found for the other too
+
+type geoLocation struct {
+	Name    string    `json:"name"`
+	Lat     float64   `json:"lat"`
+	Lon     float64   `json:"lon"`
+	Country string    `json:"country"`
+	Found   time.Time `json:"found"`
+}
+
+// loaded the first time it's needed. by the location as it was asked for, lowercased
+var geocodeCache map[string]geoLocation
+var geocodeMu sync.Mutex
+
+func geocodeKey(location string) string {
+	return strings.ToLower(strings.Join(strings.Fields(location), " "))
+}
+
+// geocode returns where location is, from the cache or from lookup
+func geocode(location string, lookup func() (geoLocation, error)) (geoLocation, error) {
+	key := geocodeKey(location)
+	geocodeMu.Lock()
+	loadGeocodeCache()
+	loc, ok := geocodeCache[key]
+	geocodeMu.Unlock()
+	if ok {
+		return loc, nil
+	}
+	loc, err := lookup()
+	if err != nil {
+		return loc, err
+	}
+	loc.Found = time.Now()
+	geocodeMu.Lock()
+	defer geocodeMu.Unlock()
+	geocodeCache[key] = loc
+	if err := saveGeocodeCache(); err != nil {
+		logger.Println("Couldn't save the geocoding cache: " + err.Error())
+	}
+	return loc, nil
+}
+
+// geocodeMu has to be held
+func loadGeocodeCache() {
+	if geocodeCache != nil {
+		return
+	}
+	geocodeCache = make(map[string]geoLocation)
+	file, err := os.ReadFile(vars.GeocodeCachePath)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(file, &geocodeCache); err != nil {
+		logger.Println("Couldn't read the geocoding cache, starting a new one: " + err.Error())
+		geocodeCache = make(map[string]geoLocation)
+	}
+}
+
+// geocodeMu has to be held
+func saveGeocodeCache() error {
+	data, err := json.MarshalIndent(geocodeCache, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write then rename, so it's never half-written
+	tmp := vars.GeocodeCachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, vars.GeocodeCachePath)
+}
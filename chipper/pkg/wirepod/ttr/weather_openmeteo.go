@@ -0,0 +1,221 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+)
+
+// *** OPEN-METEO.COM ***
+// free without a key. places are found with its geocoding API first, which only takes a name,
+// so "Paris, Texas" looks up Paris and picks the one in Texas
+
+type openMeteoProvider struct {
+	geocodeURL  string
+	forecastURL string
+}
+
+type openMeteoGeocodingResponse struct {
+	Results []struct {
+		Name        string  `json:"name"`
+		Latitude    float64 `json:"latitude"`
+		Longitude   float64 `json:"longitude"`
+		Country     string  `json:"country"`
+		CountryCode string  `json:"country_code"`
+		Admin1      string  `json:"admin1"`
+	} `json:"results"`
+}
+
+// times are unix time. probabilities can be null, which leaves them at 0
+type openMeteoResponse struct {
+	UTCOffsetSeconds int `json:"utc_offset_seconds"`
+	Current          struct {
+		Time          int64   `json:"time"`
+		Temperature   float64 `json:"temperature_2m"`
+		WeatherCode   int     `json:"weather_code"`
+		WindSpeed     float64 `json:"wind_speed_10m"`
+		WindDirection float64 `json:"wind_direction_10m"`
+		IsDay         int     `json:"is_day"`
+	} `json:"current"`
+	Hourly struct {
+		Time                     []int64   `json:"time"`
+		Temperature              []float64 `json:"temperature_2m"`
+		WeatherCode              []int     `json:"weather_code"`
+		PrecipitationProbability []float64 `json:"precipitation_probability"`
+		WindSpeed                []float64 `json:"wind_speed_10m"`
+		WindDirection            []float64 `json:"wind_direction_10m"`
+		IsDay                    []int     `json:"is_day"`
+	} `json:"hourly"`
+	Daily struct {
+		Time                        []int64   `json:"time"`
+		WeatherCode                 []int     `json:"weather_code"`
+		TemperatureMax              []float64 `json:"temperature_2m_max"`
+		TemperatureMin              []float64 `json:"temperature_2m_min"`
+		PrecipitationProbabilityMax []float64 `json:"precipitation_probability_max"`
+		WindSpeedMax                []float64 `json:"wind_speed_10m_max"`
+		WindDirectionDominant       []float64 `json:"wind_direction_10m_dominant"`
+	} `json:"daily"`
+}
+
+func (p *openMeteoProvider) Name() string {
+	return "open-meteo.com"
+}
+
+func (p *openMeteoProvider) geocode(ctx context.Context, location string) (geoLocation, error) {
+	return geocode(location, func() (geoLocation, error) {
+		parts := strings.Split(location, ",")
+		name := strings.TrimSpace(parts[0])
+		var resp openMeteoGeocodingResponse
+		err := getWeatherJSON(ctx, p.geocodeURL+"?count=10&language=en&format=json&name="+url.QueryEscape(name), &resp)
+		if err != nil {
+			return geoLocation{}, err
+		}
+		if len(resp.Results) == 0 {
+			return geoLocation{}, errors.New("couldn't find " + location)
+		}
+		// the first one is the biggest place with the name, unless the rest of the location says which
+		best := resp.Results[0]
+		for _, result := range resp.Results {
+			if matchesAll(parts[1:], result.Admin1, result.Country, result.CountryCode) {
+				best = result
+				break
+			}
+		}
+		logger.Println("Found " + location + ": " + best.Name + ", " + best.Admin1 + ", " + best.Country)
+		return geoLocation{Name: best.Name, Lat: best.Latitude, Lon: best.Longitude, Country: best.Country}, nil
+	})
+}
+
+// whether every one of wanted is (the start of) one of names
+func matchesAll(wanted []string, names ...string) bool {
+	for _, w := range wanted {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w == "" {
+			continue
+		}
+		found := false
+		for _, name := range names {
+			if name != "" && strings.HasPrefix(strings.ToLower(name), w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *openMeteoProvider) GetWeather(ctx context.Context, location string, unit string, days int) (WeatherReport, error) {
+	geo, err := p.geocode(ctx, location)
+	if err != nil {
+		return WeatherReport{}, err
+	}
+	params := url.Values{}
+	params.Add("latitude", strconv.FormatFloat(geo.Lat, 'f', 4, 64))
+	params.Add("longitude", strconv.FormatFloat(geo.Lon, 'f', 4, 64))
+	params.Add("current", "temperature_2m,weather_code,wind_speed_10m,wind_direction_10m,is_day")
+	params.Add("hourly", "temperature_2m,weather_code,precipitation_probability,wind_speed_10m,wind_direction_10m,is_day")
+	params.Add("daily", "weather_code,temperature_2m_max,temperature_2m_min,precipitation_probability_max,wind_speed_10m_max,wind_direction_10m_dominant")
+	params.Add("forecast_days", strconv.Itoa(days))
+	params.Add("timezone", "auto")
+	params.Add("timeformat", "unixtime")
+	if unit == "F" {
+		params.Add("temperature_unit", "fahrenheit")
+		params.Add("wind_speed_unit", "mph")
+	}
+	var resp openMeteoResponse
+	if err := getWeatherJSON(ctx, p.forecastURL+"?"+params.Encode(), &resp); err != nil {
+		return WeatherReport{}, err
+	}
+	loc := time.FixedZone("", resp.UTCOffsetSeconds)
+	at := func(values []float64, i int) float64 {
+		if i < len(values) {
+			return values[i]
+		}
+		return 0
+	}
+
+	report := WeatherReport{Location: geo.Name, Unit: unit}
+	report.Current = WeatherConditions{
+		Time:          time.Unix(resp.Current.Time, 0).In(loc),
+		Temperature:   resp.Current.Temperature,
+		WindSpeed:     resp.Current.WindSpeed,
+		WindDirection: int(math.Round(resp.Current.WindDirection)),
+	}
+	report.Current.Condition, report.Current.Description = openMeteoCondition(resp.Current.WeatherCode, resp.Current.IsDay == 1)
+	h := resp.Hourly
+	for i, t := range h.Time {
+		if i >= len(h.WeatherCode) || i >= len(h.IsDay) {
+			break
+		}
+		hour := WeatherConditions{
+			Time:                time.Unix(t, 0).In(loc),
+			Temperature:         at(h.Temperature, i),
+			PrecipitationChance: int(at(h.PrecipitationProbability, i)),
+			WindSpeed:           at(h.WindSpeed, i),
+			WindDirection:       int(math.Round(at(h.WindDirection, i))),
+		}
+		hour.Condition, hour.Description = openMeteoCondition(h.WeatherCode[i], h.IsDay[i] == 1)
+		report.Hours = append(report.Hours, hour)
+	}
+	d := resp.Daily
+	for i, t := range d.Time {
+		if i >= len(d.WeatherCode) {
+			break
+		}
+		day := WeatherConditions{
+			Time:                time.Unix(t, 0).In(loc),
+			High:                at(d.TemperatureMax, i),
+			Low:                 at(d.TemperatureMin, i),
+			PrecipitationChance: int(at(d.PrecipitationProbabilityMax, i)),
+			WindSpeed:           at(d.WindSpeedMax, i),
+			WindDirection:       int(math.Round(at(d.WindDirectionDominant, i))),
+		}
+		day.Temperature = day.High
+		day.Condition, day.Description = openMeteoCondition(d.WeatherCode[i], true)
+		report.Days = append(report.Days, day)
+	}
+	return report, nil
+}
+
+// WMO weather codes, https://open-meteo.com/en/docs
+func openMeteoCondition(code int, isDay bool) (condition string, description string) {
+	switch {
+	case code == 0 || code == 1:
+		description = "Clear"
+		if code == 1 {
+			description = "Mainly clear"
+		}
+		if !isDay {
+			return "Stars", description
+		}
+		return "Sunny", description
+	case code == 2:
+		return "Cloudy", "Partly cloudy"
+	case code == 3:
+		return "Cloudy", "Overcast"
+	case code == 45 || code == 48:
+		return "Cloudy", "Fog"
+	case code >= 51 && code <= 57:
+		return "Rain", "Drizzle"
+	case code >= 61 && code <= 67:
+		return "Rain", "Rain"
+	case code >= 71 && code <= 77:
+		return "Snow", "Snow"
+	case code >= 80 && code <= 82:
+		return "Rain", "Rain showers"
+	case code == 85 || code == 86:
+		return "Snow", "Snow showers"
+	case code >= 95:
+		return "Thunderstorms", "Thunderstorm"
+	}
+	return "Cloudy", "Unknown (" + strconv.Itoa(code) + ")"
+}
@@ -0,0 +1,257 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/logger"
+)
+
+// *** OPENWEATHERMAP.ORG ***
+// the free 2.5 API: the current weather, and a five day forecast in three hour steps.
+// places are found with its geocoding API first
+
+type openWeatherMapProvider struct {
+	key     string
+	baseURL string
+}
+
+type openWeatherMapAPIGeoCodingStruct struct {
+	Name       string            `json:"name"`
+	LocalNames map[string]string `json:"local_names"`
+	Lat        float64           `json:"lat"`
+	Lon        float64           `json:"lon"`
+	Country    string            `json:"country"`
+	State      string            `json:"state"`
+}
+
+/*
+//3.0 API, requires your credit card even to get 1k free requests per day
+
+type openWeatherMapAPIResponseStruct struct {
+    Lat      			float64 `json:"lat"`
+	Lon					float64 `json:"lon"`
+	timezone		  	string `json:"timezone"`
+	timezone_offset	  	string `json:"timezone_offset"`
+	Current struct {
+		DT	 	 	int     `json:"dt"`
+		Sunrise	 	int     `json:"sunrise"`
+		Sunset	 	int     `json:"sunset"`
+		Temp	    float64 `json:"temp"`
+		FeelsLike   float64 `json:"feels_like"`
+		Pressure	int     `json:"pressure"`
+		Humidity	int     `json:"humidity"`
+		DewPoint	float64 `json:"dew_point"`
+		UVI	        float64 `json:"uvi"`
+		Clouds	 	int     `json:"clouds"`
+		Visibility	int     `json:"visibility"`
+		WindSpeed	float64 `json:"wind_speed"`
+		WindDeg	 	int     `json:"wid_deg"`
+		WindGust	float64 `json:"wind_gust"`
+		Weather        struct {
+			Id	 		int    `json:"id"`
+			Main 		string `json:"main"`
+			Description string `json:"description"`
+			Icon 		string `json:"icon"`
+		} `json:"weather"`
+	} `json:"current"`
+}
+*/
+
+//2.5 API
+
+type WeatherStruct struct {
+	Id          int    `json:"id"`
+	Main        string `json:"main"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+}
+
+type openWeatherMapAPIResponseStruct struct {
+	Coord struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	} `json:"coord"`
+	Weather []WeatherStruct `json:"weather"`
+	Base    string          `json:"base"`
+	Main    struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		TempMin   float64 `json:"temp_min"`
+		TempMax   float64 `json:"temp_max"`
+		Pressure  int     `json:"pressure"`
+		Humidity  int     `json:"humidity"`
+	} `json:"main"`
+	Visibility int `json:"visibility"`
+	Wind       struct {
+		Speed float64 `json:"speed"`
+		Deg   int     `json:"deg"`
+	} `json:"wind"`
+	Clouds struct {
+		All int `json:"all"`
+	} `json:"clouds"`
+	// chance of precipitation, 0 to 1. only in the forecast
+	Pop float64 `json:"pop"`
+	DT  int     `json:"dt"`
+	Sys struct {
+		Type    int    `json:"type"`
+		Id      int    `json:"id"`
+		Country string `json:"country"`
+		Sunrise int    `json:"sunrise"`
+		Sunset  int    `json:"sunset"`
+		// d or n, only in the forecast
+		Pod string `json:"pod"`
+	} `json:"sys"`
+	Timezone int    `json:"timezone"`
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Cod      int    `json:"cod"`
+}
+
+type openWeatherMapForecastAPIResponseStruct struct {
+	Cod     string                            `json:"cod"`
+	Message int                               `json:"message"`
+	Cnt     int                               `json:"cnt"`
+	List    []openWeatherMapAPIResponseStruct `json:"list"`
+	City    struct {
+		Name     string `json:"name"`
+		Timezone int    `json:"timezone"`
+	} `json:"city"`
+}
+
+func (p *openWeatherMapProvider) Name() string {
+	return "openweathermap.org"
+}
+
+func (p *openWeatherMapProvider) geocode(ctx context.Context, location string) (geoLocation, error) {
+	return geocode(location, func() (geoLocation, error) {
+		// E.G. http://api.openweathermap.org/geo/1.0/direct?q={city name},{state code},{country code}&limit={limit}&appid={API key}
+		var geoCodingInfoStruct []openWeatherMapAPIGeoCodingStruct
+		err := getWeatherJSON(ctx, p.baseURL+"/geo/1.0/direct?q="+url.QueryEscape(location)+"&limit=1&appid="+p.key, &geoCodingInfoStruct)
+		if err != nil {
+			return geoLocation{}, err
+		}
+		if len(geoCodingInfoStruct) == 0 {
+			return geoLocation{}, errors.New("couldn't find " + location)
+		}
+		geo := geoCodingInfoStruct[0]
+		logger.Println("Found " + location + ": " + geo.Name + ", " + geo.Country + fmt.Sprintf(" (%f, %f)", geo.Lat, geo.Lon))
+		return geoLocation{Name: geo.Name, Lat: geo.Lat, Lon: geo.Lon, Country: geo.Country}, nil
+	})
+}
+
+func (p *openWeatherMapProvider) GetWeather(ctx context.Context, location string, unit string, days int) (WeatherReport, error) {
+	geo, err := p.geocode(ctx, location)
+	if err != nil {
+		return WeatherReport{}, err
+	}
+	units := "metric"
+	if unit == "F" {
+		units = "imperial"
+	}
+	query := fmt.Sprintf("?lat=%f&lon=%f&units=%s&appid=%s", geo.Lat, geo.Lon, units, p.key)
+	var current openWeatherMapAPIResponseStruct
+	if err := getWeatherJSON(ctx, p.baseURL+"/data/2.5/weather"+query, &current); err != nil {
+		return WeatherReport{}, err
+	}
+	var forecast openWeatherMapForecastAPIResponseStruct
+	if err := getWeatherJSON(ctx, p.baseURL+"/data/2.5/forecast"+query, &forecast); err != nil {
+		return WeatherReport{}, err
+	}
+	loc := time.FixedZone("", current.Timezone)
+
+	report := WeatherReport{Location: current.Name, Unit: unit}
+	if report.Location == "" {
+		report.Location = geo.Name
+	}
+	night := current.DT < current.Sys.Sunrise || current.DT >= current.Sys.Sunset
+	report.Current = openWeatherMapWeather(current, unit, night)
+	report.Current.Time = time.Unix(int64(current.DT), 0).In(loc)
+
+	// the days are made from the three hour steps
+	for _, step := range forecast.List {
+		hour := openWeatherMapWeather(step, unit, step.Sys.Pod == "n")
+		hour.Time = time.Unix(int64(step.DT), 0).In(loc)
+		report.Hours = append(report.Hours, hour)
+
+		year, month, day := hour.Time.Date()
+		date := time.Date(year, month, day, 0, 0, 0, 0, loc)
+		if len(report.Days) == 0 || !report.Days[len(report.Days)-1].Time.Equal(date) {
+			if len(report.Days) == days {
+				break
+			}
+			report.Days = append(report.Days, WeatherConditions{Time: date, High: math.Inf(-1), Low: math.Inf(1)})
+		}
+		d := &report.Days[len(report.Days)-1]
+		d.High = math.Max(d.High, step.Main.TempMax)
+		d.Low = math.Min(d.Low, step.Main.TempMin)
+		d.PrecipitationChance = max(d.PrecipitationChance, hour.PrecipitationChance)
+		if hour.WindSpeed >= d.WindSpeed {
+			d.WindSpeed, d.WindDirection = hour.WindSpeed, hour.WindDirection
+		}
+		// what it's like around midday
+		if d.Condition == "" || hour.Time.Hour() <= 13 {
+			d.Condition, d.Description = hour.Condition, hour.Description
+			if d.Condition == "Stars" {
+				d.Condition = "Sunny"
+			}
+		}
+		d.Temperature = d.High
+	}
+	return report, nil
+}
+
+func openWeatherMapWeather(w openWeatherMapAPIResponseStruct, unit string, night bool) WeatherConditions {
+	c := WeatherConditions{
+		Temperature:         w.Main.Temp,
+		PrecipitationChance: int(math.Round(w.Pop * 100)),
+		WindSpeed:           w.Wind.Speed,
+		WindDirection:       w.Wind.Deg,
+	}
+	if unit == "C" {
+		// m/s
+		c.WindSpeed = w.Wind.Speed * 3.6
+	}
+	if len(w.Weather) > 0 {
+		c.Description = w.Weather[0].Description
+		c.Condition = openWeatherMapCondition(w.Weather[0], night)
+	}
+	return c
+}
+
+func openWeatherMapCondition(weather WeatherStruct, night bool) string {
+	conditionCode := weather.Id
+	if conditionCode < 300 {
+		// Thunderstorm
+		return "Thunderstorms"
+	} else if conditionCode < 400 {
+		// Drizzle
+		return "Rain"
+	} else if conditionCode < 600 {
+		// Rain
+		return "Rain"
+	} else if conditionCode < 700 {
+		// Snow
+		return "Snow"
+	} else if conditionCode < 800 {
+		// Athmosphere
+		if weather.Main == "Mist" || weather.Main == "Fog" {
+			return "Rain"
+		}
+		return "Windy"
+	} else if conditionCode == 800 {
+		// Clear
+		if night {
+			return "Stars"
+		}
+		return "Sunny"
+	} else if conditionCode < 900 {
+		// Cloud
+		return "Cloudy"
+	}
+	return weather.Main
+}
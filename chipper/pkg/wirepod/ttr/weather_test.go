@@ -0,0 +1,206 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+)
+
+func useFakeWeather(t *testing.T) *FakeWeatherProvider {
+	t.Helper()
+	p, err := LoadFakeWeatherProvider("testdata/weather/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// weatherParser works out "tomorrow" from the local time, the fixture is in UTC
+	local := time.Local
+	language := vars.APIConfig.STT.Language
+	t.Cleanup(func() {
+		weatherProviderOverride = nil
+		time.Local = local
+		vars.APIConfig.STT.Language = language
+	})
+	weatherProviderOverride = p
+	time.Local = time.UTC
+	vars.APIConfig.STT.Language = "en-US"
+	return p
+}
+
+func TestWeatherParser(t *testing.T) {
+	p := useFakeWeather(t)
+	t.Setenv("STT_SERVICE", "vosk")
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	tests := []struct {
+		speech, condition, datetime, location, temperature string
+	}{
+		// the robot's location, now
+		{"what's the weather", "Sunny", today + " 10:00:00", "San Francisco", "61"},
+		// the forecast's hour
+		{"what's the weather tomorrow in london", "Windy", tomorrow + " 09:00:00", "London", "53"},
+		// past the hours, so the day
+		{"what's the weather the day after tomorrow in london", "Cloudy", time.Now().UTC().AddDate(0, 0, 2).Format("2006-01-02") + " 00:00:00", "London", "59"},
+		// the day by its name, with the place before it
+		{"what's the weather in london on " + strings.ToLower(time.Now().UTC().AddDate(0, 0, 2).Weekday().String()), "Cloudy", time.Now().UTC().AddDate(0, 0, 2).Format("2006-01-02") + " 00:00:00", "London", "59"},
+		// further than the forecast goes
+		{"what's the weather in london in ten days", "undefined", "test", "london", "120"},
+		// the provider couldn't find it
+		{"what's the weather in atlantis", "undefined", "test", "atlantis", "120"},
+	}
+	for _, test := range tests {
+		condition, isForecast, datetime, location, temperature, unit := weatherParser(test.speech, "San Francisco, California", "F", time.UTC)
+		if condition != test.condition || datetime != test.datetime || location != test.location || temperature != test.temperature {
+			t.Errorf("%q: got %s, %s, %s, %s, want %s, %s, %s, %s", test.speech, condition, datetime, location, temperature, test.condition, test.datetime, test.location, test.temperature)
+		}
+		if isForecast != "false" || (datetime != "test" && unit != "F") {
+			t.Errorf("%q: is_forecast %s, unit %s", test.speech, isForecast, unit)
+		}
+	}
+	want := []string{"San Francisco, California", "london", "london", "london", "london", "atlantis"}
+	if strings.Join(p.Requests, "|") != strings.Join(want, "|") {
+		t.Errorf("asked for %v, want %v", p.Requests, want)
+	}
+
+	// a follow-up's "in three hours" isn't a place
+	if mentionsLocation("and in three hours?") || !mentionsLocation("and in paris?") {
+		t.Error("mentionsLocation took the time for a place, or the place for a time")
+	}
+
+	// without a provider, the robot gets the placeholder
+	weatherProviderOverride = nil
+	vars.APIConfig.Weather.Enable = false
+	if _, _, datetime, _, _, _ := weatherParser("what's the weather", "San Francisco", "F", time.UTC); datetime != "test" {
+		t.Errorf("got %s without a weather provider", datetime)
+	}
+}
+
+// serves the fixtures the way the providers' APIs would, and counts the requests
+func weatherServer(t *testing.T) (*httptest.Server, map[string]int, map[string]string) {
+	t.Helper()
+	fixtures := map[string]string{
+		"/v1/forecast.json":  "weatherapi.json",
+		"/geo/1.0/direct":    "openweathermap_geo.json",
+		"/data/2.5/weather":  "openweathermap_weather.json",
+		"/data/2.5/forecast": "openweathermap_forecast.json",
+		"/om/search":         "openmeteo_geo.json",
+		"/om/forecast":       "openmeteo_forecast.json",
+	}
+	counts := make(map[string]int)
+	queries := make(map[string]string)
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[r.URL.Path]++
+		queries[r.URL.Path] = r.URL.RawQuery
+		mu.Unlock()
+		fixture, ok := fixtures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join("testdata/weather", fixture))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, counts, queries
+}
+
+func useGeocodeCache(t *testing.T) {
+	t.Helper()
+	path := vars.GeocodeCachePath
+	t.Cleanup(func() {
+		vars.GeocodeCachePath = path
+		geocodeCache = nil
+	})
+	vars.GeocodeCachePath = filepath.Join(t.TempDir(), "geocode.json")
+	geocodeCache = nil
+}
+
+func checkDay(t *testing.T, provider string, day WeatherConditions, condition string, high, low float64, precipitation int) {
+	t.Helper()
+	if day.Condition != condition || math.Abs(day.High-high) > 0.01 || math.Abs(day.Low-low) > 0.01 || day.PrecipitationChance != precipitation || day.Temperature != day.High {
+		t.Errorf("%s: got %+v, want %s, high %v, low %v, %d%%", provider, day, condition, high, low, precipitation)
+	}
+}
+
+func TestWeatherProviders(t *testing.T) {
+	srv, counts, queries := weatherServer(t)
+	useGeocodeCache(t)
+	ctx := context.Background()
+
+	weatherAPI := &weatherAPIProvider{key: "k", baseURL: srv.URL + "/v1"}
+	report, err := weatherAPI.GetWeather(ctx, "London", "C", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Location != "London" || report.Current.Condition != "Sunny" || report.Current.Temperature != 17 || len(report.Days) != 2 || len(report.Hours) != 3 {
+		t.Errorf("weatherapi.com: got %+v", report)
+	}
+	if day := report.Days[1]; day.Description != "Moderate rain" || day.High != 14.6 || day.PrecipitationChance != 86 || day.WindSpeed != 28.1 {
+		t.Errorf("weatherapi.com: tomorrow is %+v", day)
+	}
+	if !strings.Contains(queries["/v1/forecast.json"], "days=2") {
+		t.Errorf("weatherapi.com: asked for %s", queries["/v1/forecast.json"])
+	}
+
+	owm := &openWeatherMapProvider{key: "k", baseURL: srv.URL}
+	report, err = owm.GetWeather(ctx, "Paris", "C", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// it's after sunset
+	if report.Location != "Paris" || report.Current.Condition != "Stars" || report.Current.Temperature != 12.3 || len(report.Hours) != 6 || len(report.Days) != 3 {
+		t.Errorf("openweathermap.org: got %+v", report)
+	}
+	if math.Abs(report.Current.WindSpeed-11.16) > 0.01 {
+		t.Errorf("openweathermap.org: the wind is %v km/h, want 11.16", report.Current.WindSpeed)
+	}
+	// the days are in Paris time, so the 22:00 UTC step is the next day
+	checkDay(t, "openweathermap.org", report.Days[1], "Rain", 16.9, 9.1, 64)
+	checkDay(t, "openweathermap.org", report.Days[2], "Sunny", 19.7, 8.9, 0)
+
+	openMeteo := &openMeteoProvider{geocodeURL: srv.URL + "/om/search", forecastURL: srv.URL + "/om/forecast"}
+	report, err = openMeteo.GetWeather(ctx, "Paris, Texas", "F", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(queries["/om/forecast"], "latitude=33.6609") || !strings.Contains(queries["/om/forecast"], "temperature_unit=fahrenheit") {
+		t.Errorf("open-meteo.com: asked for %s, want Paris, Texas in fahrenheit", queries["/om/forecast"])
+	}
+	if report.Location != "Paris" || report.Current.Condition != "Cloudy" || report.Current.Temperature != 79.6 || len(report.Hours) != 3 || len(report.Days) != 2 {
+		t.Errorf("open-meteo.com: got %+v", report)
+	}
+	checkDay(t, "open-meteo.com", report.Days[1], "Thunderstorms", 77.5, 63.9, 75)
+	if report.Days[1].Time.Hour() != 0 {
+		t.Errorf("open-meteo.com: tomorrow starts at %v", report.Days[1].Time)
+	}
+	if hour, ok := report.At(report.Hours[2].Time.Add(30 * time.Minute)); !ok || hour.Condition != "Thunderstorms" || hour.PrecipitationChance != 60 {
+		t.Errorf("open-meteo.com: At picked %+v", hour)
+	}
+	if _, ok := report.At(report.Days[1].Time.AddDate(0, 0, 1)); ok {
+		t.Error("open-meteo.com: At went past the forecast")
+	}
+
+	// the places are only looked up once, even after a restart
+	geocodeCache = nil
+	if _, err := owm.GetWeather(ctx, "paris", "C", 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openMeteo.GetWeather(ctx, "Paris,  Texas", "F", 2); err != nil {
+		t.Fatal(err)
+	}
+	if counts["/geo/1.0/direct"] != 1 || counts["/om/search"] != 1 {
+		t.Errorf("geocoding requests: %v", counts)
+	}
+	if _, err := os.Stat(vars.GeocodeCachePath); err != nil {
+		t.Error("the geocoding cache wasn't saved")
+	}
+}
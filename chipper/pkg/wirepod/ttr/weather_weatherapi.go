@@ -0,0 +1,145 @@
+package wirepod_ttr
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// *** WEATHERAPI.COM ***
+// one request gets the current weather and the forecast, and it finds places by name itself
+
+type weatherAPIProvider struct {
+	key     string
+	baseURL string
+}
+
+// the fields the current weather and the forecast's hours have in common
+type weatherAPIConditions struct {
+	TempC     float64 `json:"temp_c"`
+	TempF     float64 `json:"temp_f"`
+	IsDay     int     `json:"is_day"`
+	Condition struct {
+		Text string `json:"text"`
+		Code int    `json:"code"`
+	} `json:"condition"`
+	WindKph      float64 `json:"wind_kph"`
+	WindMph      float64 `json:"wind_mph"`
+	WindDegree   int     `json:"wind_degree"`
+	ChanceOfRain int     `json:"chance_of_rain"`
+	ChanceOfSnow int     `json:"chance_of_snow"`
+}
+
+type weatherAPIResponseStruct struct {
+	Location struct {
+		Name string `json:"name"`
+		TzID string `json:"tz_id"`
+	} `json:"location"`
+	Current struct {
+		LastUpdatedEpoch int64 `json:"last_updated_epoch"`
+		weatherAPIConditions
+	} `json:"current"`
+	Forecast struct {
+		Forecastday []struct {
+			Date string `json:"date"`
+			Day  struct {
+				MaxtempC          float64 `json:"maxtemp_c"`
+				MaxtempF          float64 `json:"maxtemp_f"`
+				MintempC          float64 `json:"mintemp_c"`
+				MintempF          float64 `json:"mintemp_f"`
+				MaxwindKph        float64 `json:"maxwind_kph"`
+				MaxwindMph        float64 `json:"maxwind_mph"`
+				DailyChanceOfRain int     `json:"daily_chance_of_rain"`
+				DailyChanceOfSnow int     `json:"daily_chance_of_snow"`
+				Condition         struct {
+					Text string `json:"text"`
+				} `json:"condition"`
+			} `json:"day"`
+			Hour []struct {
+				TimeEpoch int64 `json:"time_epoch"`
+				weatherAPIConditions
+			} `json:"hour"`
+		} `json:"forecastday"`
+	} `json:"forecast"`
+}
+
+func (p *weatherAPIProvider) Name() string {
+	return "weatherapi.com"
+}
+
+func (p *weatherAPIProvider) GetWeather(ctx context.Context, location string, unit string, days int) (WeatherReport, error) {
+	params := url.Values{}
+	params.Add("key", p.key)
+	params.Add("q", location)
+	params.Add("days", strconv.Itoa(days))
+	params.Add("aqi", "no")
+	params.Add("alerts", "no")
+	var resp weatherAPIResponseStruct
+	if err := getWeatherJSON(ctx, p.baseURL+"/forecast.json?"+params.Encode(), &resp); err != nil {
+		return WeatherReport{}, err
+	}
+	loc, err := time.LoadLocation(resp.Location.TzID)
+	if err != nil {
+		loc = time.UTC
+	}
+	report := WeatherReport{
+		Location: resp.Location.Name,
+		Unit:     unit,
+		Current:  weatherAPIWeather(resp.Current.weatherAPIConditions, unit),
+	}
+	report.Current.Time = time.Unix(resp.Current.LastUpdatedEpoch, 0).In(loc)
+	for _, day := range resp.Forecast.Forecastday {
+		date, err := time.ParseInLocation("2006-01-02", day.Date, loc)
+		if err != nil {
+			continue
+		}
+		d := WeatherConditions{
+			Time:                date,
+			Description:         day.Day.Condition.Text,
+			High:                day.Day.MaxtempF,
+			Low:                 day.Day.MintempF,
+			PrecipitationChance: max(day.Day.DailyChanceOfRain, day.Day.DailyChanceOfSnow),
+			WindSpeed:           day.Day.MaxwindMph,
+		}
+		if unit == "C" {
+			d.High, d.Low, d.WindSpeed = day.Day.MaxtempC, day.Day.MintempC, day.Day.MaxwindKph
+		}
+		d.Temperature = d.High
+		d.Condition = weatherAPICondition(d.Description, true)
+		report.Days = append(report.Days, d)
+		for _, hour := range day.Hour {
+			h := weatherAPIWeather(hour.weatherAPIConditions, unit)
+			h.Time = time.Unix(hour.TimeEpoch, 0).In(loc)
+			report.Hours = append(report.Hours, h)
+		}
+	}
+	return report, nil
+}
+
+func weatherAPIWeather(c weatherAPIConditions, unit string) WeatherConditions {
+	w := WeatherConditions{
+		Description:         c.Condition.Text,
+		Condition:           weatherAPICondition(c.Condition.Text, c.IsDay == 1),
+		Temperature:         c.TempF,
+		PrecipitationChance: max(c.ChanceOfRain, c.ChanceOfSnow),
+		WindSpeed:           c.WindMph,
+		WindDirection:       c.WindDegree,
+	}
+	if unit == "C" {
+		w.Temperature, w.WindSpeed = c.TempC, c.WindKph
+	}
+	return w
+}
+
+// weather-map.json has weatherapi.com's conditions
+func weatherAPICondition(text string, isDay bool) string {
+	condition, ok := cladCondition(text)
+	if !ok {
+		return text
+	}
+	if condition == "Sunny" && !isDay {
+		return "Stars"
+	}
+	return condition
+}
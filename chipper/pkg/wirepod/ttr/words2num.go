@@ -5,6 +5,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/kercre123/wire-pod/chipper/pkg/vars"
+	lcztn "github.com/kercre123/wire-pod/chipper/pkg/wirepod/localization"
 )
 
 // This file contains words2num. It is given the spoken text and returns a string which contains the true number.
@@ -94,3 +97,64 @@ func mapTextToNumber(text string) int {
 	}
 	return sum
 }
+
+// returns the first number in the text, written as digits or as words ("twenty five", "forty-two")
+func firstNumber(input string) (int, bool) {
+	total := 0
+	found := false
+	for _, word := range strings.Fields(strings.ToLower(input)) {
+		word = strings.Trim(word, ".,!?")
+		if num, err := strconv.Atoi(word); err == nil {
+			if found {
+				break
+			}
+			return num, true
+		}
+		if num, ok := wordToNumber(word); ok {
+			total += num
+			found = true
+		} else if found {
+			break
+		}
+	}
+	return total, found
+}
+
+func wordToNumber(word string) (int, bool) {
+	sum := 0
+	for _, part := range strings.Split(word, "-") {
+		val, ok := textToNumber[part]
+		if !ok {
+			return 0, false
+		}
+		sum += val
+	}
+	return sum, true
+}
+
+// the number a word is in the STT language, from the localization's number lists ("tre", "dreißig", "两个").
+// strict only takes the first way of saying each, so not "a" or "ein". English has the words above too
+func localWordToNumber(word string, strict bool) (int, bool) {
+	word = strings.ToLower(word)
+	lists := []struct {
+		key   string
+		first int
+		step  int
+	}{
+		{lcztn.STR_TIME_NUMBERS, 0, 1},
+		{lcztn.STR_TIME_TENS, 10, 10},
+	}
+	for _, list := range lists {
+		for i, slot := range strings.Fields(lcztn.GetText(list.key)) {
+			for j, w := range strings.Split(slot, "|") {
+				if w == word && (j == 0 || !strict) {
+					return list.first + i*list.step, true
+				}
+			}
+		}
+	}
+	if vars.APIConfig.STT.Language == "en-US" || vars.APIConfig.STT.Language == "" {
+		return wordToNumber(word)
+	}
+	return 0, false
+}
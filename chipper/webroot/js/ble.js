@@ -9,12 +9,13 @@ externalSetup.innerHTML = vectorEpodSetup;
 
 function showBotAuth() {
   GetLog = false;
+  stopLogStream();
   toggleSections("section-botauth", "icon-BotAuth");
   checkBLECapability();
 }
 
 function toggleSections(showSection, icon) {
-  const sections = ["section-intents", "section-language", "section-log", "section-botauth", "section-version", "section-uicustomizer"];
+  const sections = ["section-intents", "section-language", "section-log", "section-botauth", "section-version", "section-uicustomizer", "section-history", "section-schedule"];
   sections.forEach((section) => (document.getElementById(section).style.display = "none"));
   document.getElementById(showSection).style.display = "block";
   updateColor(icon);
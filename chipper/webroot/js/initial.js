@@ -101,7 +101,9 @@ function initKGAPIKey() {
     setConn();
     return
   }
-  const key = getE(`${provider}Key`).value;
+  // ollama and llama.cpp use the custom fields. they run locally, so the key and model are optional, and so is ollama's endpoint
+  const localLLM = provider === "ollama" || provider === "llamacpp";
+  const key = getE(localLLM ? "customKey" : `${provider}Key`).value;
   let doEnable = true;
   let model = "";
   let openAIPrompt = "";
@@ -109,9 +111,10 @@ function initKGAPIKey() {
   let intentgraph = getE("intentyes").checked
   let saveChat = getE("saveChatYes").checked
   let doCommands = getE("commandYes").checked
+  let doTools = getE("toolYes").checked
   let endpoint = "";
 
-  if (!key) {
+  if (!key && !localLLM) {
     alert("You must provide an API key.");
     return;
   }
@@ -129,6 +132,14 @@ function initKGAPIKey() {
       alert("You must provide an LLM endpoint.");
       return;
     }
+  } else if (localLLM) {
+    model = getE("customModel").value;
+    openAIPrompt = getE("customAIPrompt").value;
+    endpoint = getE("customAIEndpoint").value;
+    if (!endpoint && provider === "llamacpp") {
+      alert("You must provide the llama.cpp server's endpoint.");
+      return;
+    }
   } else if (provider === "together") {
     model = getE("togetherModel").value;
     openAIPrompt = getE("togetherAIPrompt").value;
@@ -155,6 +166,7 @@ function initKGAPIKey() {
     openai_prompt: openAIPrompt,
     save_chat: saveChat,
     commands_enable: doCommands,
+    tools_enable: doTools,
     endpoint,
   };
 
@@ -3,6 +3,7 @@ const intentsJson = JSON.parse(
 );
 
 var GetLog = false;
+var LogStream = null;
 
 const getE = (element) => document.getElementById(element);
 
@@ -200,7 +201,9 @@ function sendIntentAdd() {
 }
 
 function checkWeather() {
-  getE("apiKeySpan").style.display = getE("weatherProvider").value ? "block" : "none";
+  const provider = getE("weatherProvider").value;
+  // Open-Meteo doesn't need a key
+  getE("apiKeySpan").style.display = provider && provider != "open-meteo.com" ? "block" : "none";
 }
 
 function sendWeatherAPIKey() {
@@ -221,6 +224,7 @@ function sendWeatherAPIKey() {
     .then((response) => response.text())
     .then((response) => {
       displayMessage("addWeatherProviderAPIStatus", response);
+      checkConfigStatus();
     });
 }
 
@@ -261,7 +265,7 @@ function checkKG() {
       getE("togetherInput").style.display = "block";
       getE("saveChatInput").style.display = "block";
       getE("llmCommandInput").style.display = "block";
-    } else if (provider === "custom") {
+    } else if (provider === "custom" || provider === "ollama" || provider === "llamacpp") {
       getE("intentGraphInput").style.display = "block";
       getE("customAIInput").style.display = "block";
       getE("saveChatInput").style.display = "block";
@@ -284,6 +288,7 @@ function sendKGAPIKey() {
     openai_voice: "",
     save_chat: false,
     commands_enable: false,
+    tools_enable: false,
     endpoint: "",
   };
   if (provider === "openai") {
@@ -292,9 +297,10 @@ function sendKGAPIKey() {
     data.intentgraph = getE("intentyes").checked
     data.save_chat = getE("saveChatYes").checked
     data.commands_enable = getE("commandYes").checked
+    data.tools_enable = getE("toolYes").checked
     data.openai_voice = getE("openaiVoice").value
     data.endpoint = getE("customAIEndpoint").value;
-  } else if (provider === "custom") {
+  } else if (provider === "custom" || provider === "ollama" || provider === "llamacpp") {
     data.key = getE("customKey").value;
     data.model = getE("customModel").value;
     data.openai_prompt = getE("customAIPrompt").value;
@@ -302,6 +308,7 @@ function sendKGAPIKey() {
     data.intentgraph = getE("intentyes").checked
     data.save_chat = getE("saveChatYes").checked
     data.commands_enable = getE("commandYes").checked
+    data.tools_enable = getE("toolYes").checked
   } else if (provider === "together") {
     data.key = getE("togetherKey").value;
     data.model = getE("togetherModel").value;
@@ -309,12 +316,20 @@ function sendKGAPIKey() {
     data.intentgraph = getE("intentyes").checked;
     data.save_chat = getE("saveChatYes").checked
     data.commands_enable = getE("commandYes").checked
+    data.tools_enable = getE("toolYes").checked
   } else if (provider === "houndify") {
     data.key = getE("houndKey").value;
     data.id = getE("houndID").value;
   } else {
     data.enable = false;
   }
+  if (data.intentgraph) {
+    data.conversation_robots = getE("conversationRobots").value
+      .split(",")
+      .map((esn) => esn.trim())
+      .filter((esn) => esn !== "");
+    data.conversation_timeout = parseInt(getE("conversationTimeout").value) || 0;
+  }
 
   fetch("/api/set_kg_api", {
     method: "POST",
@@ -326,6 +341,7 @@ function sendKGAPIKey() {
     .then((response) => response.text())
     .then((response) => {
       displayMessage("addKGProviderAPIStatus", response);
+      checkConfigStatus();
       alert(response);
     });
 }
@@ -336,7 +352,122 @@ function deleteSavedChats() {
       .then((response) => response.text())
       .then(() => {
         alert("Successfully deleted all saved chats.");
+        updateMemoryRobots();
+      });
+  }
+}
+
+// the history being edited, so messages can be removed before saving
+let memoryHistory = [];
+
+function updateMemoryRobots() {
+  fetch("/api/get_memory_robots")
+    .then((response) => response.json())
+    .then((robots) => {
+      const select = getE("memoryRobot");
+      select.innerHTML = "";
+      if (robots.length === 0) {
+        const option = document.createElement("option");
+        option.text = "No saved chats yet";
+        option.value = "";
+        select.appendChild(option);
+      }
+      robots.forEach((robot) => {
+        const option = document.createElement("option");
+        option.value = robot.esn;
+        option.text = `${robot.esn} (${robot.messages} messages, ${robot.facts} facts)`;
+        select.appendChild(option);
       });
+      loadMemory();
+    });
+}
+
+function loadMemory() {
+  const esn = getE("memoryRobot").value;
+  if (!esn) {
+    getE("memoryEditor").style.display = "none";
+    return;
+  }
+  fetch("/api/get_memory?esn=" + encodeURIComponent(esn))
+    .then((response) => response.json())
+    .then((mem) => {
+      getE("memoryFacts").value = Object.entries(mem.facts || {})
+        .map(([name, value]) => `${name}: ${value}`)
+        .join("\n");
+      getE("memorySummary").value = mem.summary;
+      getE("memorySummaryDesc").innerHTML = `(covers the first ${mem.summarized} messages)`;
+      memoryHistory = mem.history;
+      renderMemoryHistory();
+      getE("memoryEditor").style.display = "block";
+    });
+}
+
+function renderMemoryHistory() {
+  const container = getE("memoryHistory");
+  container.innerHTML = "";
+  memoryHistory.forEach((msg, i) => {
+    const row = document.createElement("div");
+    const remove = document.createElement("button");
+    remove.innerHTML = "x";
+    remove.title = "Remove this message";
+    remove.onclick = () => {
+      memoryHistory.splice(i, 1);
+      renderMemoryHistory();
+    };
+    const text = document.createElement("span");
+    text.textContent = ` ${msg.role === "user" ? "User" : "Robot"}: ${msg.content}`;
+    row.appendChild(remove);
+    row.appendChild(text);
+    container.appendChild(row);
+  });
+}
+
+function saveMemory() {
+  const esn = getE("memoryRobot").value;
+  const facts = {};
+  getE("memoryFacts")
+    .value.split("\n")
+    .forEach((line) => {
+      const sep = line.indexOf(":");
+      if (sep > 0 && line.slice(sep + 1).trim()) {
+        facts[line.slice(0, sep).trim()] = line.slice(sep + 1).trim();
+      }
+    });
+  fetch("/api/get_memory?esn=" + encodeURIComponent(esn))
+    .then((response) => response.json())
+    .then((mem) => {
+      // if messages were removed, the summary can't tell which ones it covered anymore
+      const removed = mem.history.length - memoryHistory.length;
+      mem.summarized = Math.max(0, Math.min(mem.summarized - removed, memoryHistory.length));
+      mem.summary = getE("memorySummary").value;
+      mem.facts = facts;
+      mem.history = memoryHistory;
+      return fetch("/api/set_memory", {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify(mem),
+      });
+    })
+    .then((response) => response.text())
+    .then((response) => {
+      displayMessage("memoryStatus", response);
+      updateMemoryRobots();
+    });
+}
+
+function exportMemory(format) {
+  const esn = getE("memoryRobot").value;
+  window.location.href = `/api/export_memory?esn=${encodeURIComponent(esn)}&format=${format}`;
+}
+
+function deleteMemory() {
+  const esn = getE("memoryRobot").value;
+  if (confirm(`Are you sure? This will delete everything remembered for ${esn}.`)) {
+    fetch("/api/delete_memory?esn=" + encodeURIComponent(esn))
+      .then((response) => response.text())
+      .then(() => updateMemoryRobots());
   }
 }
 
@@ -351,6 +482,7 @@ function updateKGAPI() {
         getE("openaiVoice").value = data.openai_voice;
         getE("customAIEndpoint").value = data.endpoint;
         getE("commandYes").checked = data.commands_enable
+        getE("toolYes").checked = data.tools_enable
         getE("intentyes").checked = data.intentgraph
         getE("saveChatYes").checked = data.save_chat
       } else if (data.provider === "together") {
@@ -358,20 +490,26 @@ function updateKGAPI() {
         getE("togetherModel").value = data.model;
         getE("togetherAIPrompt").value = data.openai_prompt;
         getE("commandYes").checked = data.commands_enable
+        getE("toolYes").checked = data.tools_enable
         getE("intentyes").checked = data.intentgraph
         getE("saveChatYes").checked = data.save_chat
-      } else if (data.provider === "custom") {
+      } else if (data.provider === "custom" || data.provider === "ollama" || data.provider === "llamacpp") {
         getE("customKey").value = data.key;
         getE("customModel").value = data.model;
         getE("customAIPrompt").value = data.openai_prompt;
         getE("customAIEndpoint").value = data.endpoint;
         getE("commandYes").checked = data.commands_enable
+        getE("toolYes").checked = data.tools_enable
         getE("intentyes").checked = data.intentgraph
         getE("saveChatYes").checked = data.save_chat
       } else if (data.provider === "houndify") {
         getE("houndKey").value = data.key;
         getE("houndID").value = data.id;
       }
+      if (getE("conversationRobots")) {
+        getE("conversationRobots").value = (data.conversation_robots || []).join(", ");
+        getE("conversationTimeout").value = data.conversation_timeout || 0;
+      }
       checkKG();
     });
 }
@@ -485,26 +623,66 @@ function updateColor(id) {
 
 
 function showLog() {
-  toggleVisibility(["section-intents", "section-log", "section-botauth", "section-version", "section-uicustomizer"], "section-log", "icon-Logs");
-  logDivArea = getE("botTranscriptedTextArea");
+  toggleVisibility(["section-intents", "section-log", "section-botauth", "section-version", "section-uicustomizer", "section-history", "section-schedule"], "section-log", "icon-Logs");
   getE("logscrollbottom").checked = true;
-  logP = document.createElement("p");
-  GetLog = true
-  const interval = setInterval(() => {
-    if (!GetLog) {
-      clearInterval(interval);
-      return;
+  GetLog = true;
+  streamLogs();
+}
+
+// like logger.Entry.String() in wire-pod
+function formatLogEntry(entry) {
+  const t = new Date(entry.time);
+  const pad = (n) => String(n).padStart(2, "0");
+  let line = `${t.getFullYear()}.${pad(t.getMonth() + 1)}.${pad(t.getDate())} ${pad(t.getHours())}:${pad(t.getMinutes())}:${pad(t.getSeconds())}: `;
+  if (entry.level !== "INFO") {
+    line += entry.level + " ";
+  }
+  line += entry.msg;
+  Object.keys(entry.attrs || {})
+    .sort()
+    .forEach((key) => {
+      line += ` ${key}=${entry.attrs[key]}`;
+    });
+  return line;
+}
+
+function stopLogStream() {
+  if (LogStream) {
+    LogStream.close();
+    LogStream = null;
+  }
+}
+
+// started again whenever the filters change
+function streamLogs() {
+  stopLogStream();
+  if (!GetLog) {
+    return;
+  }
+  const params = new URLSearchParams();
+  if (getE("logdebug").checked) {
+    params.set("debug", "true");
+  }
+  if (getE("logesn").value.trim()) {
+    params.set("esn", getE("logesn").value.trim());
+  }
+  if (getE("loglevel").value) {
+    params.set("level", getE("loglevel").value);
+  }
+  const logDivArea = getE("botTranscriptedTextArea");
+  const lines = [];
+  logDivArea.value = "No logs yet, you must say a command to Vector. (this updates automatically)";
+  LogStream = new EventSource("/api/stream_logs?" + params.toString());
+  LogStream.onmessage = (event) => {
+    lines.push(formatLogEntry(JSON.parse(event.data)));
+    if (lines.length > 1000) {
+      lines.shift();
     }
-    const url = getE("logdebug").checked ? "/api/get_debug_logs" : "/api/get_logs";
-    fetch(url)
-      .then((response) => response.text())
-      .then((logs) => {
-        logDivArea.innerHTML = logs || "No logs yet, you must say a command to Vector. (this updates automatically)";
-        if (getE("logscrollbottom").checked) {
-          logDivArea.scrollTop = logDivArea.scrollHeight;
-        }
-      });
-  }, 500);
+    logDivArea.value = lines.join("\n");
+    if (getE("logscrollbottom").checked) {
+      logDivArea.scrollTop = logDivArea.scrollHeight;
+    }
+  };
 }
 
 function checkUpdate() {
@@ -559,7 +737,7 @@ function checkUpdate() {
 }
 
 function showLanguage() {
-  toggleVisibility(["section-weather", "section-restart", "section-kg", "section-language"], "section-language", "icon-Language");
+  toggleVisibility(["section-weather", "section-restart", "section-kg", "section-tts", "section-security", "section-config", "section-language"], "section-language", "icon-Language");
   fetch("/api/get_stt_info")
     .then((response) => response.json())
     .then((parsed) => {
@@ -574,25 +752,723 @@ function showLanguage() {
 }
 
 function showVersion() {
-  toggleVisibility(["section-log", "section-language", "section-botauth", "section-intents", "section-version", "section-uicustomizer"], "section-version", "icon-Version");
+  toggleVisibility(["section-log", "section-language", "section-botauth", "section-intents", "section-version", "section-uicustomizer", "section-history", "section-schedule"], "section-version", "icon-Version");
   checkUpdate();
 }
 
 function showIntents() {
-  toggleVisibility(["section-log", "section-botauth", "section-intents", "section-version", "section-uicustomizer"], "section-intents", "icon-Intents");
+  toggleVisibility(["section-log", "section-botauth", "section-intents", "section-version", "section-uicustomizer", "section-history", "section-schedule"], "section-intents", "icon-Intents");
+  updateReloadStatus();
+}
+
+function reloadIntents() {
+  displayMessage("reloadStatus", "Reloading...");
+  fetch("/api/reload_intents", { method: "POST" })
+    .then((response) => response.json())
+    .then((result) => {
+      renderReloadResult(result);
+      updateIntentSelection("editSelect");
+      updateIntentSelection("deleteSelect");
+    });
+}
+
+function updateReloadStatus() {
+  fetch("/api/get_reload_status")
+    .then((response) => response.json())
+    .then((result) => {
+      if (result) {
+        renderReloadResult(result);
+      }
+    })
+    .catch(() => {});
+}
+
+function renderReloadResult(result) {
+  const element = getE("reloadStatus");
+  element.innerHTML = "";
+  const p = document.createElement("p");
+  p.textContent = `Last reloaded ${new Date(result.time).toLocaleString()}: ${result.intents} intents, ${result.custom_intents} custom intents.`;
+  if (result.plugins && result.plugins.length > 0) {
+    p.textContent += ` New plugins: ${result.plugins.join(", ")}.`;
+  }
+  element.appendChild(p);
+  if (!result.errors || result.errors.length === 0) {
+    return;
+  }
+  const ul = document.createElement("ul");
+  result.errors.forEach((error) => {
+    const li = document.createElement("li");
+    li.textContent = error;
+    ul.appendChild(li);
+  });
+  element.appendChild(ul);
+}
+
+function showHistory() {
+  toggleVisibility(["section-log", "section-botauth", "section-intents", "section-version", "section-uicustomizer", "section-history", "section-schedule"], "section-history", "icon-History");
+  updateHistory();
+  updateHistorySettings();
+}
+
+function updateHistory() {
+  const esn = getE("historyesn").value.trim();
+  fetch("/api/list_history?limit=100&esn=" + encodeURIComponent(esn))
+    .then((response) => response.json())
+    .then((reqs) => renderHistory(reqs));
+}
+
+function renderHistory(reqs) {
+  const container = getE("historyList");
+  container.innerHTML = "";
+  if (reqs.length === 0) {
+    container.textContent = "No requests have been recorded yet.";
+    return;
+  }
+  reqs.forEach((req) => {
+    const row = document.createElement("div");
+    const text = document.createElement("p");
+    let line = `${new Date(req.time).toLocaleString()} ${req.esn}: "${req.transcript}" -> ${req.intent || "(no intent)"}`;
+    if (req.params) {
+      line += " " + JSON.stringify(req.params);
+    }
+    if (req.llm_response) {
+      line += `\nLLM: ${req.llm_response}`;
+    }
+    if (req.error) {
+      line += `\nError: ${req.error}`;
+    }
+    text.style.whiteSpace = "pre-wrap";
+    text.textContent = line;
+    row.appendChild(text);
+    const result = document.createElement("p");
+    if (req.has_audio) {
+      const audio = document.createElement("audio");
+      audio.controls = true;
+      audio.preload = "none";
+      audio.src = "/api/get_history_audio?id=" + req.id;
+      row.appendChild(audio);
+      const rerun = document.createElement("button");
+      rerun.innerHTML = "Re-run";
+      rerun.onclick = () => rerunHistory(req.id, result);
+      row.appendChild(rerun);
+    }
+    const remove = document.createElement("button");
+    remove.innerHTML = "Delete";
+    remove.onclick = () => deleteHistory(req.id);
+    row.appendChild(remove);
+    row.appendChild(result);
+    container.appendChild(row);
+  });
+}
+
+function rerunHistory(id, resultElement) {
+  resultElement.textContent = "Running...";
+  fetch("/api/rerun_history?id=" + id)
+    .then((response) => (response.ok ? response.json() : response.text().then((text) => Promise.reject(text))))
+    .then((result) => {
+      if (result.error) {
+        resultElement.textContent = "Error: " + result.error;
+        return;
+      }
+      let line = `Now: "${result.transcribed}" -> ${result.got}`;
+      if (result.params) {
+        line += " " + JSON.stringify(result.params);
+      }
+      resultElement.textContent = line + (result.correct ? " (same as before)" : ` (was ${result.expected || "no intent"})`);
+    })
+    .catch((error) => (resultElement.textContent = "Error: " + error));
+}
+
+function deleteHistory(id) {
+  fetch("/api/delete_history?id=" + id)
+    .then((response) => response.text())
+    .then((response) => {
+      displayMessage("historyStatus", response);
+      updateHistory();
+    });
+}
+
+function deleteAllHistory() {
+  const esn = getE("historyesn").value.trim();
+  if (confirm(`Are you sure? This will delete every recorded request${esn ? " from " + esn : ""}.`)) {
+    fetch("/api/delete_history?all=true&esn=" + encodeURIComponent(esn))
+      .then((response) => response.text())
+      .then((response) => {
+        displayMessage("historyStatus", response);
+        updateHistory();
+      });
+  }
+}
+
+function updateHistorySettings() {
+  fetch("/api/get_history_settings")
+    .then((response) => response.json())
+    .then((settings) => {
+      getE("historyEnable").checked = settings.enable;
+      getE("historyAudio").checked = !settings.no_audio;
+      getE("historyMaxDays").value = settings.max_days || 0;
+      getE("historyMaxRequests").value = settings.max_requests || 0;
+    });
+}
+
+function setHistorySettings() {
+  fetch("/api/set_history_settings", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({
+      enable: getE("historyEnable").checked,
+      no_audio: !getE("historyAudio").checked,
+      max_days: parseInt(getE("historyMaxDays").value) || 0,
+      max_requests: parseInt(getE("historyMaxRequests").value) || 0,
+    }),
+  })
+    .then((response) => response.text())
+    .then((response) => {
+      displayMessage("historySettingsStatus", response);
+      updateHistory();
+    });
+}
+
+function showSchedule() {
+  toggleVisibility(["section-log", "section-botauth", "section-intents", "section-version", "section-uicustomizer", "section-history", "section-schedule"], "section-schedule", "icon-Schedule");
+  updateSchedule();
+}
+
+function updateSchedule() {
+  const esn = getE("scheduleesn").value.trim();
+  fetch("/api/list_schedule?esn=" + encodeURIComponent(esn))
+    .then((response) => response.json())
+    .then((jobs) => renderSchedule(jobs));
+}
+
+function renderSchedule(jobs) {
+  const container = getE("scheduleList");
+  container.innerHTML = "";
+  if (jobs.length === 0) {
+    container.textContent = "Nothing is scheduled.";
+    return;
+  }
+  jobs.forEach((job) => {
+    const row = document.createElement("div");
+    const text = document.createElement("p");
+    const when = job.cron ? `on "${job.cron}"${job.time_zone ? " (" + job.time_zone + ")" : ""}` : "once";
+    const next = new Date(job.next).getFullYear() > 1 ? new Date(job.next).toLocaleString() : "never";
+    let line = `${job.name || "(no name)"} - ${job.esn}: ${job.action.type} "${job.action.value}", ${when}. Next: ${next}`;
+    if (new Date(job.last_run).getFullYear() > 1) {
+      line += `\nLast ran ${new Date(job.last_run).toLocaleString()}` + (job.last_error ? `, error: ${job.last_error}` : "");
+    }
+    text.style.whiteSpace = "pre-wrap";
+    text.textContent = line;
+    row.appendChild(text);
+    const run = document.createElement("button");
+    run.innerHTML = "Run Now";
+    run.onclick = () => runSchedule(job.id);
+    row.appendChild(run);
+    const remove = document.createElement("button");
+    remove.innerHTML = "Delete";
+    remove.onclick = () => deleteSchedule(job.id);
+    row.appendChild(remove);
+    container.appendChild(row);
+  });
+}
+
+function addSchedule() {
+  const job = {
+    esn: getE("scheduleJobEsn").value.trim(),
+    name: getE("scheduleJobName").value.trim(),
+    action: {
+      type: getE("scheduleJobAction").value,
+      value: getE("scheduleJobValue").value.trim(),
+    },
+    time_zone: getE("scheduleJobTimeZone").value.trim(),
+    missed: getE("scheduleJobMissed").value,
+  };
+  if (getE("scheduleJobRepeat").checked) {
+    job.cron = getE("scheduleJobCron").value.trim();
+  } else {
+    if (!getE("scheduleJobAt").value) {
+      displayMessage("scheduleAddStatus", "Pick a time.");
+      return;
+    }
+    // the browser's time zone
+    job.at = new Date(getE("scheduleJobAt").value).toISOString();
+  }
+  fetch("/api/add_schedule", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(job),
+  })
+    .then((response) => (response.ok ? response.json() : response.text().then((text) => Promise.reject(text))))
+    .then((added) => {
+      displayMessage("scheduleAddStatus", "Added, it runs next at " + new Date(added.next).toLocaleString() + ".");
+      updateSchedule();
+    })
+    .catch((error) => displayMessage("scheduleAddStatus", "Error: " + error));
+}
+
+function runSchedule(id) {
+  displayMessage("scheduleStatus", "Running...");
+  fetch("/api/run_schedule?id=" + id)
+    .then((response) => response.text())
+    .then((response) => {
+      displayMessage("scheduleStatus", response);
+      updateSchedule();
+    });
+}
+
+function deleteSchedule(id) {
+  fetch("/api/delete_schedule?id=" + id)
+    .then((response) => response.text())
+    .then((response) => {
+      displayMessage("scheduleStatus", response);
+      updateSchedule();
+    });
 }
 
 function showWeather() {
-  toggleVisibility(["section-weather", "section-stt", "section-restart", "section-language", "section-kg"], "section-weather", "icon-Weather");
+  toggleVisibility(["section-weather", "section-stt", "section-restart", "section-language", "section-kg", "section-tts", "section-security", "section-config"], "section-weather", "icon-Weather");
 }
 
 function showKG() {
-  toggleVisibility(["section-weather", "section-stt", "section-restart", "section-language", "section-kg"], "section-kg", "icon-KG");
+  toggleVisibility(["section-weather", "section-stt", "section-restart", "section-language", "section-kg", "section-tts", "section-security", "section-config"], "section-kg", "icon-KG");
+  updateMemoryRobots();
+}
+
+function showSecurity() {
+  toggleVisibility(["section-weather", "section-stt", "section-restart", "section-language", "section-kg", "section-tts", "section-security", "section-config"], "section-security", "icon-Security");
+  updateAuth();
+  updateRobotTokens();
+  updateCertStatus();
+}
+
+function showConfig() {
+  toggleVisibility(["section-weather", "section-stt", "section-restart", "section-language", "section-kg", "section-tts", "section-security", "section-config"], "section-config", "icon-Config");
+  updateConfigStatus();
+}
+
+function showTTS() {
+  toggleVisibility(["section-weather", "section-stt", "section-restart", "section-language", "section-kg", "section-tts", "section-security", "section-config"], "section-tts", "icon-TTS");
+  updateTTSSettings();
+}
+
+function updateTTSSettings() {
+  fetch("/api/get_tts_settings")
+    .then((response) => response.json())
+    .then((settings) => {
+      getE("ttsProvider").value = settings.provider || "";
+      getE("ttsVoice").value = settings.voice || "";
+      getE("ttsPiperPath").value = settings.piper_path || "";
+      getE("ttsEndpoint").value = settings.endpoint || "";
+      getE("ttsKey").value = settings.key || "";
+      getE("ttsCacheSize").value = settings.cache_size || 0;
+      getE("ttsLanguageVoices").innerHTML = "";
+      getE("ttsRobotVoices").innerHTML = "";
+      Object.entries(settings.language_voices || {}).forEach(([language, voice]) => {
+        addTTSVoiceRow("ttsLanguageVoices", "Language (like de-DE)", language, voice);
+      });
+      Object.entries(settings.robot_voices || {}).forEach(([esn, voice]) => {
+        addTTSVoiceRow("ttsRobotVoices", "ESN", esn, voice);
+      });
+      checkTTSProvider();
+    });
+}
+
+// only the inputs the chosen engine uses, though the rows can use any of them
+function checkTTSProvider() {
+  const providers = [getE("ttsProvider").value];
+  document.querySelectorAll("#section-tts .ttsVoiceProvider").forEach((select) => providers.push(select.value));
+  getE("ttsPiperInput").style.display = providers.includes("piper") ? "inline" : "none";
+  getE("ttsEndpointInput").style.display = providers.includes("http") ? "inline" : "none";
+  getE("ttsKeyInput").style.display = providers.includes("openai") ? "inline" : "none";
+}
+
+function addTTSVoiceRow(listId, placeholder, key = "", voice = { provider: "piper", voice: "" }) {
+  const row = document.createElement("p");
+  const keyInput = document.createElement("input");
+  keyInput.type = "text";
+  keyInput.className = "ttsVoiceKey";
+  keyInput.placeholder = placeholder;
+  keyInput.value = key;
+  const provider = document.createElement("select");
+  provider.className = "ttsVoiceProvider";
+  [["vector", "Robot"], ["piper", "Piper"], ["http", "HTTP server"], ["openai", "OpenAI"]].forEach(([value, text]) => {
+    const option = document.createElement("option");
+    option.value = value;
+    option.text = text;
+    provider.appendChild(option);
+  });
+  provider.value = voice.provider;
+  provider.onchange = checkTTSProvider;
+  const voiceInput = document.createElement("input");
+  voiceInput.type = "text";
+  voiceInput.className = "ttsVoiceName";
+  voiceInput.placeholder = "Voice";
+  voiceInput.value = voice.voice || "";
+  const button = document.createElement("button");
+  button.textContent = "Remove";
+  button.onclick = () => {
+    row.remove();
+    checkTTSProvider();
+  };
+  row.append(keyInput, " ", provider, " ", voiceInput, " ", button);
+  getE(listId).appendChild(row);
+  checkTTSProvider();
+}
+
+function readTTSVoices(listId) {
+  const voices = {};
+  getE(listId).querySelectorAll("p").forEach((row) => {
+    const key = row.querySelector(".ttsVoiceKey").value.trim();
+    if (key !== "") {
+      voices[key] = {
+        provider: row.querySelector(".ttsVoiceProvider").value,
+        voice: row.querySelector(".ttsVoiceName").value.trim(),
+      };
+    }
+  });
+  return voices;
+}
+
+function setTTSSettings() {
+  fetch("/api/set_tts_settings", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({
+      provider: getE("ttsProvider").value,
+      voice: getE("ttsVoice").value.trim(),
+      piper_path: getE("ttsPiperPath").value.trim(),
+      endpoint: getE("ttsEndpoint").value.trim(),
+      key: getE("ttsKey").value.trim(),
+      cache_size: parseInt(getE("ttsCacheSize").value) || 0,
+      language_voices: readTTSVoices("ttsLanguageVoices"),
+      robot_voices: readTTSVoices("ttsRobotVoices"),
+    }),
+  })
+    .then((response) => response.text())
+    .then((response) => {
+      displayMessage("ttsStatus", response);
+      checkConfigStatus();
+    });
+}
+
+function testTTS() {
+  const esn = getE("ttsTestESN").value.trim();
+  const text = getE("ttsTestText").value;
+  displayMessage("ttsTestStatus", "Saying it...");
+  fetch("/api/test_tts?esn=" + encodeURIComponent(esn) + "&text=" + encodeURIComponent(text))
+    .then((response) => response.text())
+    .then((response) => displayMessage("ttsTestStatus", response));
+}
+
+// problems with the settings, shown at the top of the page until they're fixed
+function checkConfigStatus() {
+  fetch("/api/get_config_status")
+    .then((response) => response.json())
+    .then((status) => {
+      renderConfigErrors("configErrors", status.errors);
+    })
+    .catch(() => {});
+}
+
+function renderConfigErrors(elementId, errors) {
+  const element = getE(elementId);
+  element.innerHTML = "";
+  if (!errors || errors.length === 0) {
+    return;
+  }
+  const p = document.createElement("p");
+  p.textContent = "There are problems with wire-pod's settings:";
+  element.appendChild(p);
+  const ul = document.createElement("ul");
+  errors.forEach((error) => {
+    const li = document.createElement("li");
+    li.textContent = error.field ? `${error.field}: ${error.message}` : error.message;
+    ul.appendChild(li);
+  });
+  element.appendChild(ul);
+}
+
+function updateConfigStatus() {
+  fetch("/api/get_config_status")
+    .then((response) => response.json())
+    .then((status) => {
+      let message = `Config version ${status.version}.`;
+      if (status.env_overrides && status.env_overrides.length > 0) {
+        message += ` Set by environment variables (changes to these here aren't saved): ${status.env_overrides.join(", ")}.`;
+      }
+      displayMessage("configStatus", message);
+    });
+}
+
+function importConfig() {
+  const file = getE("configImportFile").files[0];
+  if (!file) {
+    displayMessage("configImportStatus", "Pick an exported config first.");
+    return;
+  }
+  if (!confirm("Replace all of wire-pod's settings, custom intents and bot info with the ones in this file?")) {
+    return;
+  }
+  displayMessage("configImportStatus", "Importing...");
+  file.text().then((body) =>
+    fetch("/api/import_config", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: body,
+    })
+  )
+    .then((response) => {
+      if (response.headers.get("Content-Type") === "application/json") {
+        return response.json().then((status) => {
+          renderConfigErrors("configImportStatus", status.errors);
+        });
+      }
+      return response.text().then((text) => {
+        displayMessage("configImportStatus", text);
+        checkConfigStatus();
+        updateConfigStatus();
+        updateWeatherAPI();
+        updateKGAPI();
+      });
+    });
+}
+
+function updateAuth() {
+  fetch("/api-auth/status")
+    .then((response) => response.json())
+    .then((status) => {
+      if (status.enabled) {
+        displayMessage("authStatus", `Authentication is on. You are logged in as ${status.role}.`);
+        getE("authEnabledButtons").style.display = "inline";
+      } else {
+        displayMessage("authStatus", "Authentication is off. Anyone on your network can use wire-pod.");
+        getE("authEnabledButtons").style.display = "none";
+      }
+    });
+  fetch("/api-auth/get_tokens")
+    .then((response) => (response.ok ? response.json() : []))
+    .then((tokens) => {
+      const list = getE("authTokens");
+      list.innerHTML = "";
+      tokens.forEach((token) => {
+        const row = document.createElement("p");
+        const lastUsed = token.last_used.startsWith("0001") ? "never used" : "last used " + new Date(token.last_used).toLocaleString();
+        row.textContent = `${token.name} (${token.role}, ${lastUsed}) `;
+        const button = document.createElement("button");
+        button.textContent = "Delete";
+        button.onclick = () => deleteAuthToken(token.id, token.name);
+        row.appendChild(button);
+        list.appendChild(row);
+      });
+    });
+  fetch("/api-auth/get_cors")
+    .then((response) => (response.ok ? response.json() : []))
+    .then((origins) => {
+      getE("authCORS").value = (origins || []).join("\n");
+    });
+}
+
+function setAuthPassword() {
+  const password = getE("authPassword").value;
+  if (password !== getE("authPasswordConfirm").value) {
+    displayMessage("authPasswordStatus", "The passwords don't match.");
+    return;
+  }
+  fetch("/api-auth/set_password", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ password }),
+  })
+    .then((response) => response.text().then((text) => ({ ok: response.ok, text })))
+    .then((result) => {
+      displayMessage("authPasswordStatus", result.text);
+      if (result.ok) {
+        window.location.href = "/login.html?next=" + encodeURIComponent("/setup.html");
+      }
+    });
+}
+
+function disableAuth() {
+  if (confirm("Are you sure? Anyone on your network will be able to use wire-pod.")) {
+    fetch("/api-auth/disable")
+      .then((response) => response.text())
+      .then((response) => {
+        displayMessage("authPasswordStatus", response);
+        updateAuth();
+      });
+  }
+}
+
+function logout() {
+  fetch("/api-auth/logout").then(() => {
+    window.location.href = "/login.html";
+  });
+}
+
+function createAuthToken() {
+  const data = {
+    name: getE("authTokenName").value,
+    role: getE("authTokenRole").value,
+  };
+  fetch("/api-auth/create_token", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(data),
+  })
+    .then((response) => (response.ok ? response.json() : response.text()))
+    .then((result) => {
+      if (typeof result === "string") {
+        displayMessage("authTokenStatus", result);
+        return;
+      }
+      displayMessage("authTokenStatus", `Token for ${result.name}: ${result.token} (copy it now, it won't be shown again)`);
+      getE("authTokenName").value = "";
+      updateAuth();
+    });
+}
+
+function deleteAuthToken(id, name) {
+  if (confirm(`Are you sure? Anything using the token ${name} will stop working.`)) {
+    fetch("/api-auth/delete_token?id=" + encodeURIComponent(id))
+      .then((response) => response.text())
+      .then((response) => {
+        displayMessage("authTokenStatus", response);
+        updateAuth();
+      });
+  }
+}
+
+function setAuthCORS() {
+  const origins = getE("authCORS").value
+    .split("\n")
+    .map((origin) => origin.trim())
+    .filter((origin) => origin !== "");
+  fetch("/api-auth/set_cors", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(origins),
+  })
+    .then((response) => response.text())
+    .then((response) => displayMessage("authCORSStatus", response));
+}
+
+function updateRobotTokens() {
+  fetch("/api/get_robot_tokens")
+    .then((response) => response.json())
+    .then((config) => {
+      getE("requireRobotTokens").checked = config.require;
+    });
+}
+
+function setRobotTokens() {
+  fetch("/api/set_robot_tokens", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ require: getE("requireRobotTokens").checked }),
+  })
+    .then((response) => response.text())
+    .then((response) => displayMessage("robotTokensStatus", response));
+}
+
+function describeCert(name, cert) {
+  if (!cert) {
+    return `${name}: none`;
+  }
+  return `${name}: ${cert.subject} (${cert.key_type}), expires ${new Date(cert.not_after).toLocaleDateString()} (${cert.days_left} days)\n` +
+    `  SHA-256 ${cert.sha256_fingerprint}` +
+    (cert.sans ? `\n  covers ${cert.sans.join(", ")}` : "");
+}
+
+function updateCertStatus() {
+  fetch("/api/get_cert_status")
+    .then((response) => response.json())
+    .then((status) => {
+      const lines = [];
+      if (status.escape_pod) {
+        lines.push("wire-pod is in escape pod mode, which uses its own certificate.");
+        lines.push(describeCert("Certificate", status.server));
+      } else {
+        lines.push(describeCert("CA", status.ca));
+        lines.push(describeCert("Server certificate", status.server));
+        if (status.server && !status.signed_by_ca) {
+          lines.push("The server certificate was made before wire-pod had a CA. It will be replaced by a CA-signed one when it's next reissued.");
+        }
+        if (!status.last_reissue.startsWith("0001")) {
+          lines.push(`Last reissued ${new Date(status.last_reissue).toLocaleString()}, because ${status.reissue_reason}.`);
+        }
+        if (!status.push_needed_since.startsWith("0001")) {
+          lines.push(`Robots set up before ${new Date(status.push_needed_since).toLocaleString()} need the new server config and certificate.`);
+        }
+      }
+      const element = getE("certStatus");
+      element.innerHTML = "";
+      const pre = document.createElement("pre");
+      pre.style.whiteSpace = "pre-wrap";
+      pre.textContent = lines.join("\n");
+      element.appendChild(pre);
+      getE("certSANs").value = (status.extra_sans || []).join("\n");
+    });
+}
+
+function setCertSANs() {
+  const sans = getE("certSANs").value
+    .split("\n")
+    .map((san) => san.trim())
+    .filter((san) => san !== "");
+  fetch("/api/set_cert_sans", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(sans),
+  })
+    .then((response) => response.text())
+    .then((response) => {
+      displayMessage("certActionStatus", response);
+      updateCertStatus();
+    });
+}
+
+function reissueCert() {
+  fetch("/api/generate_certs")
+    .then((response) => response.text())
+    .then((response) => {
+      displayMessage("certActionStatus", response);
+      updateCertStatus();
+    });
+}
+
+function rotateCA() {
+  if (confirm("Are you sure? Every robot will need the new certificate pushed to it, or be set up again.")) {
+    fetch("/api/rotate_ca")
+      .then((response) => response.text())
+      .then((response) => {
+        displayMessage("certActionStatus", response);
+        updateCertStatus();
+      });
+  }
 }
 
 function toggleVisibility(sections, sectionToShow, iconId) {
   if (sectionToShow != "section-log") {
     GetLog = false;
+    stopLogStream();
   }
   sections.forEach((section) => {
     getE(section).style.display = "none";
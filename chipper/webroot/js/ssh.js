@@ -7,6 +7,21 @@ function updateSSHStatus(statusString) {
 }
 
 function doSSHSetup() {
+  sshRequest(
+    "/api-ssh/setup",
+    "File transfer complete! Use the above section to complete bot setup. The bot should eventually be on the onboarding screen."
+  );
+}
+
+// for bots which are already set up, after wire-pod's IP changed or its CA was rotated
+function doSSHPush() {
+  sshRequest(
+    "/api-ssh/push_certs",
+    "The new server config and cert are on the bot. Its services are restarting, and it should connect to wire-pod in a minute or so."
+  );
+}
+
+function sshRequest(endpoint, doneMessage) {
   const ip = document.getElementById("sshIp").value;
   const key = document.getElementById("sshKeyFile").files[0];
 
@@ -15,7 +30,7 @@ function doSSHSetup() {
     formData.append("key", key);
     formData.append("ip", ip);
 
-    fetch("/api-ssh/setup", {
+    fetch(endpoint, {
       method: "POST",
       body: formData,
     })
@@ -23,7 +38,7 @@ function doSSHSetup() {
       .then((response) => {
         if (response.includes("running")) {
           document.getElementById("oskrSetup").style.display = "none";
-          updateSSHSetup();
+          updateSSHSetup(doneMessage);
           return;
         } else {
           updateSSHStatus(response);
@@ -34,16 +49,14 @@ function doSSHSetup() {
   }
 }
 
-function updateSSHSetup() {
+function updateSSHSetup(doneMessage) {
   interval = setInterval(function () {
     fetch("/api-ssh/get_setup_status")
       .then((response) => response.text())
       .then((response) => {
         statusText = response;
         if (response.includes("done")) {
-          updateSSHStatus(
-            "File transfer complete! Use the above section to complete bot setup. The bot should eventually be on the onboarding screen."
-          );
+          updateSSHStatus(doneMessage);
           document.getElementById("oskrSetup").style.display = "block";
           clearInterval(interval);
         } else if (response.includes("error")) {
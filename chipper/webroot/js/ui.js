@@ -35,7 +35,7 @@ var colorMap = {
 };
 
 function showUICustomizer() {
-    toggleVisibility(["section-log", "section-botauth", "section-intents", "section-version", "section-intents"], "section-uicustomizer", "icon-Customizer");
+    toggleVisibility(["section-log", "section-botauth", "section-intents", "section-version", "section-intents", "section-history", "section-schedule"], "section-uicustomizer", "icon-Customizer");
 }
 
 function setUIFont() {
@@ -76,3 +76,14 @@ function loadSettings() {
 // call loadSettings
 loadSettings();
 
+
+// if authentication is on and the session runs out, go to the login page instead of failing quietly
+const unauthenticatedFetch = window.fetch;
+window.fetch = function (...args) {
+    return unauthenticatedFetch(...args).then((response) => {
+        if (response.status === 401 && !window.location.pathname.endsWith("/login.html")) {
+            window.location.href = "/login.html?next=" + encodeURIComponent(window.location.pathname + window.location.search);
+        }
+        return response;
+    });
+};